@@ -0,0 +1,184 @@
+// Package codes issues and checks the one-time codes emailed to users,
+// such as email verification codes and password reset tokens.
+//
+// Only a keyed hash of each code is stored: an HMAC-SHA256 of the purpose,
+// email and code, keyed with a key derived from the data_encryption keys.
+// Issuing a code supersedes the earlier codes of its purpose and email,
+// a code is deleted in the same transaction that accepts it, and a code
+// guessed wrong MAX_ATTEMPTS times is deleted as well.
+//
+//	service, err := codes.NewService(store, kr.Keys(keyring.DATA_ENCRYPTION), time.Now)
+//	code, err := service.Issue(codes.EMAIL_VERIFICATION, "alice@example.com")
+//	...
+//	err = service.Consume(codes.EMAIL_VERIFICATION, "alice@example.com", code)
+package codes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/keyring"
+	"github.com/caleb-mwasikira/tap_gopay/utils"
+)
+
+// Purpose is what a code may be used for; a code of one purpose is never
+// accepted for another
+type Purpose string
+
+const (
+	EMAIL_VERIFICATION Purpose = "email_verification"
+	PASSWORD_RESET     Purpose = "password_reset"
+	PIN_RESET          Purpose = "pin_reset" // setting or resetting a card PIN
+	LOGIN              Purpose = "login"     // emailed sign-in codes; short lived
+
+	// confirming a new email address or phone number; issued for the
+	// current email address of the user
+	EMAIL_CHANGE Purpose = "email_change"
+	PHONE_CHANGE Purpose = "phone_change"
+)
+
+const (
+	// Wrong guesses after which a code is deleted
+	MAX_ATTEMPTS int = 5
+
+	// label the code hashing key is derived with
+	HASH_KEY_LABEL string = "tap_gopay one-time codes"
+)
+
+type settings struct {
+	digits int
+	ttl    time.Duration
+}
+
+var purposes = map[Purpose]settings{
+	EMAIL_VERIFICATION: {digits: 4, ttl: time.Hour},
+	PASSWORD_RESET:     {digits: 6, ttl: time.Hour},
+	PIN_RESET:          {digits: 4, ttl: time.Hour},
+	LOGIN:              {digits: 6, ttl: 10 * time.Minute},
+	EMAIL_CHANGE:       {digits: 6, ttl: time.Hour},
+	PHONE_CHANGE:       {digits: 6, ttl: time.Hour},
+}
+
+// Errors returned by a Store
+var (
+	ErrInvalid         error = errors.New("invalid one-time code")
+	ErrExpired         error = errors.New("one-time code has expired")
+	ErrTooManyAttempts error = errors.New("one-time code guessed wrong too many times")
+)
+
+// Code is a stored one-time code
+type Code struct {
+	Id        int
+	Purpose   Purpose
+	Email     string
+	CodeHash  string
+	Attempts  int // wrong guesses so far
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type Store interface {
+	// Saves a new code, deleting the earlier codes of its purpose and email
+	SaveCode(code Code) error
+
+	// Deletes and accepts the code of the purpose and email if its hash is
+	// one of codeHashes, atomically so that a code is only ever accepted once.
+	// Otherwise counts a wrong guess, deleting the code after maxAttempts.
+	// Returns ErrInvalid, ErrExpired or ErrTooManyAttempts when the code is not accepted.
+	ConsumeCode(purpose Purpose, email string, codeHashes []string, now time.Time, maxAttempts int) error
+
+	// Deletes the codes that expired by now and returns how many were deleted
+	DeleteExpiredCodes(now time.Time) (int, error)
+}
+
+type Service struct {
+	store Store
+	keys  [][]byte // hashing keys, newest first
+	now   func() time.Time
+}
+
+// Creates a service hashing codes with keys derived from the data
+// encryption keys. Codes hashed with older keys are still accepted.
+func NewService(store Store, dataKeys []keyring.Key, now func() time.Time) (*Service, error) {
+	if len(dataKeys) == 0 {
+		return nil, fmt.Errorf("no key to hash one-time codes with")
+	}
+	if now == nil {
+		now = time.Now
+	}
+
+	keys := [][]byte{}
+	for _, key := range dataKeys {
+		mac := hmac.New(sha256.New, key.Secret)
+		mac.Write([]byte(HASH_KEY_LABEL))
+		keys = append(keys, mac.Sum(nil))
+	}
+
+	return &Service{store: store, keys: keys, now: now}, nil
+}
+
+func (s *Service) hash(key []byte, purpose Purpose, email, code string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(string(purpose) + "\x00" + email + "\x00" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Creates a new code for the purpose and email, replacing any earlier one
+func (s *Service) Issue(purpose Purpose, email string) (string, error) {
+	settings, ok := purposes[purpose]
+	if !ok {
+		return "", fmt.Errorf("unknown one-time code purpose %q", purpose)
+	}
+
+	code := utils.RandNumbers(settings.digits)
+	if code == "" {
+		return "", fmt.Errorf("error generating %v code", purpose)
+	}
+
+	now := s.now()
+	err := s.store.SaveCode(Code{
+		Purpose:   purpose,
+		Email:     email,
+		CodeHash:  s.hash(s.keys[0], purpose, email, code),
+		CreatedAt: now,
+		ExpiresAt: now.Add(settings.ttl),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error saving %v code; %v", purpose, err)
+	}
+	return code, nil
+}
+
+// Accepts the code of the purpose and email, which cannot be used again.
+// Returns the domain error of the purpose when the code is not accepted.
+func (s *Service) Consume(purpose Purpose, email, code string) error {
+	codeHashes := []string{}
+	for _, key := range s.keys {
+		codeHashes = append(codeHashes, s.hash(key, purpose, email, code))
+	}
+
+	err := s.store.ConsumeCode(purpose, email, codeHashes, s.now(), MAX_ATTEMPTS)
+	if err == nil {
+		return nil
+	}
+
+	invalid, expired := domain.ErrOtpInvalid, domain.ErrOtpExpired
+	if purpose == PASSWORD_RESET {
+		invalid, expired = domain.ErrResetTokenInvalid, domain.ErrResetTokenExpired
+	}
+
+	switch {
+	case errors.Is(err, ErrInvalid):
+		return invalid
+	case errors.Is(err, ErrExpired):
+		return expired
+	case errors.Is(err, ErrTooManyAttempts):
+		return invalid.WithMessage("Too many wrong codes. Please request a new one")
+	}
+	return err
+}
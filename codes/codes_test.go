@@ -0,0 +1,276 @@
+package codes_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/codes"
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/keyring"
+	"github.com/caleb-mwasikira/tap_gopay/luhn"
+)
+
+const email string = "alice@example.com"
+
+func dataKey(version int) keyring.Key {
+	return keyring.Key{
+		Purpose: keyring.DATA_ENCRYPTION,
+		Version: version,
+		Secret:  bytes.Repeat([]byte{byte(version + 1)}, 32),
+	}
+}
+
+// savedCodes records the codes saved to the store it wraps
+type savedCodes struct {
+	codes.Store
+	saved []codes.Code
+}
+
+func (s *savedCodes) SaveCode(code codes.Code) error {
+	s.saved = append(s.saved, code)
+	return s.Store.SaveCode(code)
+}
+
+type testService struct {
+	*codes.Service
+	store *savedCodes
+	now   time.Time
+}
+
+func newService(t *testing.T, store codes.Store, keys ...keyring.Key) *testService {
+	t.Helper()
+
+	service := &testService{
+		store: &savedCodes{Store: store},
+		now:   time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	var err error
+	service.Service, err = codes.NewService(service.store, keys, func() time.Time { return service.now })
+	if err != nil {
+		t.Fatalf("NewService() error = %v", err)
+	}
+	return service
+}
+
+func (s *testService) issue(t *testing.T, purpose codes.Purpose, email string) string {
+	t.Helper()
+
+	code, err := s.Issue(purpose, email)
+	if err != nil {
+		t.Fatalf("Issue(%v) error = %v", purpose, err)
+	}
+	return code
+}
+
+func TestIssue(t *testing.T) {
+	tests := []struct {
+		purpose codes.Purpose
+		digits  int
+		ttl     time.Duration
+	}{
+		{codes.EMAIL_VERIFICATION, 4, time.Hour},
+		{codes.PASSWORD_RESET, 6, time.Hour},
+		{codes.PIN_RESET, 4, time.Hour},
+		{codes.LOGIN, 6, 10 * time.Minute},
+		{codes.EMAIL_CHANGE, 6, time.Hour},
+		{codes.PHONE_CHANGE, 6, time.Hour},
+	}
+
+	service := newService(t, db.NewMemoryStore(), dataKey(1))
+
+	for _, test := range tests {
+		code := service.issue(t, test.purpose, email)
+		if len(code) != test.digits || !luhn.IsNumeric(code) {
+			t.Errorf("Issue(%v) = %q, want %d digits", test.purpose, code, test.digits)
+		}
+
+		// only a hash of the code is stored
+		saved := service.store.saved[len(service.store.saved)-1]
+		if saved.Purpose != test.purpose || saved.Email != email || strings.Contains(saved.CodeHash, code) || len(saved.CodeHash) != 64 {
+			t.Errorf("Issue(%v) saved %+v", test.purpose, saved)
+		}
+		if ttl := saved.ExpiresAt.Sub(saved.CreatedAt); ttl != test.ttl {
+			t.Errorf("Issue(%v) code lives for %v, want %v", test.purpose, ttl, test.ttl)
+		}
+	}
+
+	for _, purpose := range []codes.Purpose{"sign_up", ""} {
+		if _, err := service.Issue(purpose, email); err == nil {
+			t.Errorf("Issue(%q) succeeded", purpose)
+		}
+	}
+}
+
+func TestConsume(t *testing.T) {
+	tests := []struct {
+		name    string
+		purpose codes.Purpose
+		consume func(s *testService, code string) error
+		err     error
+	}{
+		{
+			name:    "right code",
+			purpose: codes.EMAIL_VERIFICATION,
+			consume: func(s *testService, code string) error {
+				return s.Consume(codes.EMAIL_VERIFICATION, email, code)
+			},
+		},
+		{
+			name:    "used twice",
+			purpose: codes.EMAIL_VERIFICATION,
+			consume: func(s *testService, code string) error {
+				s.Consume(codes.EMAIL_VERIFICATION, email, code)
+				return s.Consume(codes.EMAIL_VERIFICATION, email, code)
+			},
+			err: domain.ErrOtpInvalid,
+		},
+		{
+			name:    "wrong code",
+			purpose: codes.PIN_RESET,
+			consume: func(s *testService, code string) error {
+				return s.Consume(codes.PIN_RESET, email, wrong(code))
+			},
+			err: domain.ErrOtpInvalid,
+		},
+		{
+			name:    "other purpose",
+			purpose: codes.EMAIL_CHANGE,
+			consume: func(s *testService, code string) error {
+				return s.Consume(codes.PHONE_CHANGE, email, code)
+			},
+			err: domain.ErrOtpInvalid,
+		},
+		{
+			name:    "other email",
+			purpose: codes.EMAIL_VERIFICATION,
+			consume: func(s *testService, code string) error {
+				return s.Consume(codes.EMAIL_VERIFICATION, "bob@example.com", code)
+			},
+			err: domain.ErrOtpInvalid,
+		},
+		{
+			name:    "expired",
+			purpose: codes.EMAIL_VERIFICATION,
+			consume: func(s *testService, code string) error {
+				s.now = s.now.Add(time.Hour)
+				return s.Consume(codes.EMAIL_VERIFICATION, email, code)
+			},
+			err: domain.ErrOtpExpired,
+		},
+		{
+			name:    "just before expiry",
+			purpose: codes.EMAIL_VERIFICATION,
+			consume: func(s *testService, code string) error {
+				s.now = s.now.Add(time.Hour - time.Second)
+				return s.Consume(codes.EMAIL_VERIFICATION, email, code)
+			},
+		},
+		{
+			name:    "login code expired",
+			purpose: codes.LOGIN,
+			consume: func(s *testService, code string) error {
+				s.now = s.now.Add(10 * time.Minute)
+				return s.Consume(codes.LOGIN, email, code)
+			},
+			err: domain.ErrOtpExpired,
+		},
+		{
+			name:    "superseded",
+			purpose: codes.PASSWORD_RESET,
+			consume: func(s *testService, code string) error {
+				s.Issue(codes.PASSWORD_RESET, email)
+				return s.Consume(codes.PASSWORD_RESET, email, code)
+			},
+			err: domain.ErrResetTokenInvalid,
+		},
+		{
+			name:    "password reset expired",
+			purpose: codes.PASSWORD_RESET,
+			consume: func(s *testService, code string) error {
+				s.now = s.now.Add(2 * time.Hour)
+				return s.Consume(codes.PASSWORD_RESET, email, code)
+			},
+			err: domain.ErrResetTokenExpired,
+		},
+		{
+			name:    "right code after wrong guesses",
+			purpose: codes.PASSWORD_RESET,
+			consume: func(s *testService, code string) error {
+				for i := 0; i < codes.MAX_ATTEMPTS-1; i++ {
+					s.Consume(codes.PASSWORD_RESET, email, wrong(code))
+				}
+				return s.Consume(codes.PASSWORD_RESET, email, code)
+			},
+		},
+		{
+			name:    "right code after too many wrong guesses",
+			purpose: codes.PASSWORD_RESET,
+			consume: func(s *testService, code string) error {
+				for i := 0; i < codes.MAX_ATTEMPTS; i++ {
+					s.Consume(codes.PASSWORD_RESET, email, wrong(code))
+				}
+				return s.Consume(codes.PASSWORD_RESET, email, code)
+			},
+			err: domain.ErrResetTokenInvalid,
+		},
+	}
+
+	for _, test := range tests {
+		service := newService(t, db.NewMemoryStore(), dataKey(1))
+		code := service.issue(t, test.purpose, email)
+
+		err := test.consume(service, code)
+		if !errors.Is(err, test.err) || (test.err == nil && err != nil) {
+			t.Errorf("%v: Consume() error = %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestTooManyAttempts(t *testing.T) {
+	service := newService(t, db.NewMemoryStore(), dataKey(1))
+	code := service.issue(t, codes.EMAIL_VERIFICATION, email)
+
+	var err error
+	for i := 0; i < codes.MAX_ATTEMPTS; i++ {
+		err = service.Consume(codes.EMAIL_VERIFICATION, email, wrong(code))
+	}
+
+	var domainErr *domain.Error
+	if !errors.As(err, &domainErr) || domainErr.Code != domain.ErrOtpInvalid.Code || !strings.Contains(domainErr.Message, "request a new one") {
+		t.Errorf("Consume() after %d wrong guesses error = %v", codes.MAX_ATTEMPTS, err)
+	}
+}
+
+// Codes issued before the data encryption keys were rotated are still accepted
+func TestKeyRotation(t *testing.T) {
+	store := db.NewMemoryStore()
+	code := newService(t, store, dataKey(1)).issue(t, codes.EMAIL_VERIFICATION, email)
+
+	rotated := newService(t, store, dataKey(2), dataKey(1))
+	if err := rotated.Consume(codes.EMAIL_VERIFICATION, email, code); err != nil {
+		t.Errorf("Consume() of a code hashed with the older key error = %v", err)
+	}
+
+	code = newService(t, store, dataKey(1)).issue(t, codes.EMAIL_VERIFICATION, email)
+	retired := newService(t, store, dataKey(2))
+	if err := retired.Consume(codes.EMAIL_VERIFICATION, email, code); !errors.Is(err, domain.ErrOtpInvalid) {
+		t.Errorf("Consume() once the older key is removed error = %v, want %v", err, domain.ErrOtpInvalid)
+	}
+
+	if _, err := codes.NewService(store, nil, nil); err == nil {
+		t.Error("NewService() without keys succeeded")
+	}
+}
+
+// Returns a code of the same length that is not code
+func wrong(code string) string {
+	if code[0] == '0' {
+		return "1" + code[1:]
+	}
+	return "0" + code[1:]
+}
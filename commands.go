@@ -0,0 +1,343 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/encryption"
+	"github.com/caleb-mwasikira/tap_gopay/handlers"
+	"github.com/caleb-mwasikira/tap_gopay/keyring"
+	"github.com/caleb-mwasikira/tap_gopay/passwords"
+)
+
+const usage = `usage: tap_gopay [command]
+
+Starts the HTTP server when no command is given.
+
+commands:
+  migrate up          apply all pending schema migrations
+  migrate down [n]    roll back the last n applied migrations (default 1)
+  migrate status      list migrations and whether they have been applied
+  trial-balance       print the balance of every ledger account
+  encrypt-cvvs        encrypt card CVVs stored in plain text or with an older
+                      data encryption key, using the newest data encryption key
+  keys list           list the keys in the keyring, without their secrets
+  keys generate       print a new random key secret, usable with any algorithm
+                      (HS256, EdDSA or ES256 for jwt keys)
+  set-role <email> <role> <reason>
+                      give a user the role user, agent or admin; the change
+                      is audited and the user is logged out everywhere
+  jobs list           list the background jobs the server runs
+  jobs run <name>     run a background job now
+  benchmark-passwords [n]
+                      time n password hashes (default 5) with the configured parameters`
+
+// Runs a command-line subcommand instead of starting the HTTP server
+func runCommand(cfg db.Config, args []string) {
+	switch args[0] {
+	case "migrate":
+		migrate(cfg, args[1:])
+
+	case "trial-balance":
+		store := openStore(cfg)
+		defer store.Close()
+
+		trialBalance(store)
+
+	case "encrypt-cvvs":
+		store := openStore(cfg)
+		defer store.Close()
+
+		encryptCvvs(store, loadKeyring())
+
+	case "keys":
+		keys(args[1:])
+
+	case "set-role":
+		store := openStore(cfg)
+		defer store.Close()
+
+		setRole(store, args[1:])
+
+	case "jobs":
+		runJobs(cfg, args[1:])
+
+	case "benchmark-passwords":
+		benchmarkPasswords(args[1:])
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", args[0], usage)
+		os.Exit(2)
+	}
+}
+
+func openStore(cfg db.Config) *db.SQLStore {
+	store, err := db.NewSQLStore(cfg)
+	if err != nil {
+		log.Fatalf("error opening database; %v\n", err)
+	}
+	return store
+}
+
+func migrate(cfg db.Config, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "missing migrate subcommand\n\n%s\n", usage)
+		os.Exit(2)
+	}
+
+	migrator, err := db.NewMigrator(cfg)
+	if err != nil {
+		log.Fatalf("error starting migrations; %v\n", err)
+	}
+	defer migrator.Close()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		if err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		log.Printf("applied %d migration(s)\n", len(applied))
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("invalid number of migrations to roll back %q\n", args[1])
+			}
+		}
+
+		rolledBack, err := migrator.Down(steps)
+		if err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		log.Printf("rolled back %d migration(s)\n", len(rolledBack))
+
+	case "status":
+		migrations, err := migrator.Status()
+		if err != nil {
+			log.Fatalf("error fetching migration status; %v\n", err)
+		}
+
+		for _, migration := range migrations {
+			status := "pending"
+			if migration.IsApplied() {
+				status = fmt.Sprintf("applied %v", migration.AppliedAt.Format("2006-01-02 15:04:05"))
+			}
+			fmt.Printf("%04d  %-45s %s\n", migration.Version, migration.Name, status)
+		}
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate subcommand %q\n\n%s\n", args[0], usage)
+		os.Exit(2)
+	}
+}
+
+// Prints the balance of every ledger account and exits with a non-zero
+// status if the ledger does not balance
+func trialBalance(store db.TransactionStore) {
+	trialBalance, err := store.GetTrialBalance()
+	if err != nil {
+		log.Fatalf("error computing trial balance; %v\n", err)
+	}
+
+	for _, account := range trialBalance.Accounts {
+		fmt.Printf("%-32s %-8s %18s\n", account.AccountCode, account.Kind, account.Balance)
+	}
+	fmt.Printf("%-32s %-8s %18s\n", "TOTAL", "", trialBalance.Total)
+
+	if !trialBalance.IsBalanced() {
+		log.Fatalln("trial balance does not sum to zero")
+	}
+}
+
+// Encrypts the CVVs of cards issued before CVVs were stored encrypted,
+// and re-encrypts CVVs sealed with an older data encryption key after a rotation.
+// Safe to run more than once; CVVs sealed with the newest key are left as is.
+func encryptCvvs(store *db.SQLStore, kr *keyring.Keyring) {
+	cvvCipher, err := encryption.NewCipher(kr.Keys(keyring.DATA_ENCRYPTION))
+	if err != nil {
+		log.Fatalf("error creating CVV cipher; %v\n", err)
+	}
+
+	updated, err := store.RewriteCvvs(func(cardNo, cvv string) (string, error) {
+		if !cvvCipher.NeedsReseal(cvv) {
+			return cvv, nil
+		}
+
+		plaintext := []byte(cvv)
+		if encryption.IsSealed(cvv) {
+			plaintext, err = cvvCipher.Open(cvv, []byte(cardNo))
+			if err != nil {
+				return "", fmt.Errorf("error decrypting CVV of card %v; %v", cardNo, err)
+			}
+		}
+		return cvvCipher.Seal(plaintext, []byte(cardNo))
+	})
+	if err != nil {
+		log.Fatalf("error encrypting CVVs; %v\n", err)
+	}
+
+	log.Printf("encrypted the CVVs of %d card(s)\n", updated)
+}
+
+// Changes the role of a user, e.g. to make the first admin
+func setRole(store db.Store, args []string) {
+	if len(args) < 3 {
+		fmt.Fprintf(os.Stderr, "set-role needs an email, a role and a reason\n\n%s\n", usage)
+		os.Exit(2)
+	}
+
+	role := domain.Role(args[1])
+	if !role.IsValid() {
+		log.Fatalf("invalid role %q; must be one of %v\n", args[1], domain.Roles)
+	}
+
+	user, err := store.GetUser(args[0])
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Fatalf("no user with email %v\n", args[0])
+		}
+		log.Fatalf("error fetching user; %v\n", err)
+	}
+
+	err = store.ChangeUserRole(db.RoleChange{
+		UserId: user.Id,
+		To:     role,
+		Reason: strings.Join(args[2:], " "),
+	})
+	if err != nil {
+		log.Fatalf("error changing role; %v\n", err)
+	}
+
+	// access tokens name the old role
+	sessions, err := store.RevokeAllSessions(user.Id)
+	if err != nil {
+		log.Fatalf("error logging out user; %v\n", err)
+	}
+
+	log.Printf("changed the role of %v from %v to %v and ended %d session(s)\n", user.Email, user.Role, role, sessions)
+}
+
+func runJobs(cfg db.Config, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "missing jobs subcommand\n\n%s\n", usage)
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "list":
+		for _, job := range janitorJobs(nil) {
+			fmt.Printf("%-28s every %-8v %s\n", job.Name, job.Interval, job.Description)
+		}
+
+	case "run":
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "missing job name\n\n%s\n", usage)
+			os.Exit(2)
+		}
+
+		store := openStore(cfg)
+		defer store.Close()
+
+		// only one run per process; a server running the same job at the
+		// same time does no harm as jobs only delete what nothing needs
+		_, err := newJobRunner(store).Run(args[1])
+		if err != nil {
+			log.Fatalf("%v\n", err)
+		}
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown jobs subcommand %q\n\n%s\n", args[0], usage)
+		os.Exit(2)
+	}
+}
+
+// Times password hashing with the parameters set in the environment,
+// to help tune them for the hardware the server runs on
+func benchmarkPasswords(args []string) {
+	runs := 5
+	if len(args) > 0 {
+		var err error
+		runs, err = strconv.Atoi(args[0])
+		if err != nil || runs < 1 {
+			log.Fatalf("invalid number of runs %q\n", args[0])
+		}
+	}
+
+	params := passwords.ParamsFromEnv()
+	hasher, err := passwords.NewHasher(params, nil, "")
+	if err != nil {
+		log.Fatalf("invalid password hashing parameters; %v\n", err)
+	}
+
+	var total time.Duration
+	for i := 0; i < runs; i++ {
+		start := time.Now()
+
+		_, err := hasher.Hash("correct horse battery staple")
+		if err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		total += time.Since(start)
+	}
+
+	fmt.Printf("%v: %v per hash over %d run(s)\n", params, total/time.Duration(runs), runs)
+	fmt.Println("aim for roughly 250ms-500ms per hash; every login pays this cost once")
+}
+
+func loadKeyring() *keyring.Keyring {
+	cfg, err := handlers.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	return cfg.Keyring
+}
+
+func keys(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintf(os.Stderr, "missing keys subcommand\n\n%s\n", usage)
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "list":
+		kr := loadKeyring()
+
+		for _, purpose := range keyring.Purposes {
+			for i, key := range kr.Keys(purpose) {
+				use := "verify only"
+				if i == 0 {
+					use = "primary"
+				}
+				algorithm := key.Algorithm
+				if algorithm == "" {
+					algorithm = "-"
+				}
+				fmt.Printf("%-20s %-12s %-6s %d bytes\n", key.Id(), use, algorithm, len(key.Secret))
+			}
+		}
+
+	case "generate":
+		secret := make([]byte, keyring.MIN_KEY_LEN)
+		_, err := rand.Read(secret)
+		if err != nil {
+			log.Fatalf("error generating key; %v\n", err)
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(secret))
+
+	default:
+		fmt.Fprintf(os.Stderr, "unknown keys subcommand %q\n\n%s\n", args[0], usage)
+		os.Exit(2)
+	}
+}
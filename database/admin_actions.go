@@ -0,0 +1,240 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+)
+
+// AdminActionType is what an admin did to a user or one of their cards
+type AdminActionType string
+
+const (
+	ActionSuspendUser      AdminActionType = "suspend_user"
+	ActionActivateUser     AdminActionType = "activate_user"
+	ActionBlockCard        AdminActionType = "block_card"
+	ActionUnblockCard      AdminActionType = "unblock_card"
+	ActionResetTotp        AdminActionType = "reset_totp"
+	ActionSendVerification AdminActionType = "send_verification_email"
+)
+
+// AdminAction is an entry in the audit trail of admin actions
+type AdminAction struct {
+	Id        int             `json:"-"`
+	AdminId   int             `json:"admin_id"`
+	Action    AdminActionType `json:"action"`
+	UserId    int             `json:"user_id"`
+	CardNo    string          `json:"card_no,omitempty"` // set for actions on cards
+	Reason    string          `json:"reason"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Suspends a user, or lifts their suspension, and records the action.
+// Returns domain.ErrUserNotFound if there is no such user and
+// domain.ErrUserAlreadySuspended or domain.ErrUserNotSuspended if there is
+// nothing to change.
+func (s *SQLStore) SetUserSuspended(action AdminAction, suspended bool) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current bool
+	err = tx.QueryRow("SELECT is_suspended FROM users WHERE id = ?"+tx.dialect.ForUpdate(), action.UserId).Scan(&current)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return domain.ErrUserNotFound
+		}
+		return err
+	}
+
+	err = checkSuspension(current, suspended)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE users SET is_suspended = ? WHERE id = ?", suspended, action.UserId)
+	if err != nil {
+		return err
+	}
+
+	err = insertAdminAction(tx, action)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Returns an error if a user, suspended or not, cannot be moved to suspended
+func checkSuspension(current, suspended bool) error {
+	if current == suspended {
+		if suspended {
+			return domain.ErrUserAlreadySuspended
+		}
+		return domain.ErrUserNotSuspended
+	}
+	return nil
+}
+
+// Blocks action.CardNo, or unblocks it, and records the action.
+// Unblocking also clears its wrong PIN attempts, so that a card blocked after
+// wrong PINs is not blocked again by the next one.
+// Returns domain.ErrCardNotFound if there is no such card and
+// domain.ErrInvalidCardTransition if it cannot be moved to the new status.
+func (s *SQLStore) SetCardBlocked(action AdminAction, blocked bool) error {
+	change := adminCardStatusChange(action, blocked)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	cards, err := lockCreditCards(tx, change.CardNo)
+	if err != nil {
+		return err
+	}
+
+	card, ok := cards[change.CardNo]
+	if !ok {
+		return domain.ErrCardNotFound
+	}
+
+	err = checkCardTransition(card.Status, change)
+	if err != nil {
+		return err
+	}
+
+	err = recordCardStatusChange(tx, card.Status, change)
+	if err != nil {
+		return err
+	}
+
+	if !blocked {
+		_, err = tx.Exec("UPDATE credit_cards SET pin_attempts = 0 WHERE card_no = ?", change.CardNo)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = insertAdminAction(tx, action)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Status change of an admin blocking or unblocking a card
+func adminCardStatusChange(action AdminAction, blocked bool) CardStatusChange {
+	change := CardStatusChange{
+		CardNo:    action.CardNo,
+		From:      domain.CardBlocked,
+		To:        domain.CardActive,
+		Reason:    action.Reason,
+		ChangedBy: action.AdminId,
+	}
+	if blocked {
+		change.From, change.To = "", domain.CardBlocked
+	}
+	return change
+}
+
+// Turns off two-factor authentication of action.UserId, deleting their
+// secret and recovery codes, and records the action.
+// Returns domain.ErrTotpNotEnrolled if they have not set it up.
+func (s *SQLStore) ResetTotp(action AdminAction) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", action.UserId)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("DELETE FROM user_totp WHERE user_id = ?", action.UserId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return domain.ErrTotpNotEnrolled
+	}
+
+	err = insertAdminAction(tx, action)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Records an action taken by an admin
+func (s *SQLStore) RecordAdminAction(action AdminAction) error {
+	return insertAdminAction(s.db, action)
+}
+
+func insertAdminAction(q querier, action AdminAction) error {
+	_, err := q.Exec(
+		`
+			INSERT INTO admin_actions(admin_id, action, user_id, card_no, reason)
+			VALUES(?, ?, ?, ?, ?)
+		`,
+		action.AdminId,
+		action.Action,
+		action.UserId,
+		sql.NullString{String: action.CardNo, Valid: action.CardNo != ""},
+		action.Reason,
+	)
+	return err
+}
+
+// Returns the admin actions taken on a user and their cards, oldest first
+func (s *SQLStore) GetAdminActions(userId int) ([]AdminAction, error) {
+	query := `
+		SELECT id, admin_id, action, user_id, card_no, reason, created_at
+		FROM admin_actions
+		WHERE user_id = ?
+		ORDER BY id
+	`
+
+	rows, err := s.db.Query(query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []AdminAction{}
+
+	for rows.Next() {
+		action := AdminAction{}
+		cardNo := sql.NullString{}
+
+		err = rows.Scan(
+			&action.Id,
+			&action.AdminId,
+			&action.Action,
+			&action.UserId,
+			&cardNo,
+			&action.Reason,
+			&action.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		action.CardNo = cardNo.String
+		actions = append(actions, action)
+	}
+	return actions, rows.Err()
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+)
+
+const (
+	PIN_BLOCKED_REASON string = "too many wrong PIN attempts"
+)
+
+// Saves a new PIN hash for the card and clears its wrong PIN attempts
+func (s *SQLStore) SetCardPin(cardNo, pinHash string) error {
+	result, err := s.db.Exec(
+		"UPDATE credit_cards SET pin_hash = ?, pin_attempts = 0 WHERE card_no = ?",
+		pinHash,
+		cardNo,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return domain.ErrCardNotFound
+	}
+	return nil
+}
+
+// Reserves an attempt at the card's PIN or CVV before it is checked, so that
+// concurrent requests cannot all get past the limit before any of them fails.
+// Returns the number of attempts made since the last correct one, this one
+// included, or domain.ErrCardBlocked once maxAttempts have been made.
+func (s *SQLStore) ReservePinAttempt(cardNo string, maxAttempts int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	cards, err := lockCreditCards(tx, cardNo)
+	if err != nil {
+		return 0, err
+	}
+
+	card, ok := cards[cardNo]
+	if !ok {
+		return 0, domain.ErrCardNotFound
+	}
+	if card.PinAttempts >= maxAttempts {
+		return card.PinAttempts, domain.ErrCardBlocked
+	}
+
+	attempts := card.PinAttempts + 1
+
+	_, err = tx.Exec("UPDATE credit_cards SET pin_attempts = ? WHERE card_no = ?", attempts, cardNo)
+	if err != nil {
+		return 0, err
+	}
+	return attempts, tx.Commit()
+}
+
+// Gives back an attempt reserved with ReservePinAttempt that could not be checked
+func (s *SQLStore) ReleasePinAttempt(cardNo string) error {
+	_, err := s.db.Exec("UPDATE credit_cards SET pin_attempts = pin_attempts - 1 WHERE card_no = ? AND pin_attempts > 0", cardNo)
+	return err
+}
+
+// Blocks the card once maxAttempts wrong attempts have been made in a row.
+// Cards that cannot be blocked, e.g. closed ones, are left as they are.
+func (s *SQLStore) BlockCardAfterPinAttempts(cardNo string, maxAttempts int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	cards, err := lockCreditCards(tx, cardNo)
+	if err != nil {
+		return err
+	}
+
+	card, ok := cards[cardNo]
+	if !ok {
+		return domain.ErrCardNotFound
+	}
+	if card.PinAttempts < maxAttempts || !card.Status.CanTransitionTo(domain.CardBlocked) {
+		return nil
+	}
+
+	err = recordCardStatusChange(tx, card.Status, CardStatusChange{
+		CardNo: cardNo,
+		To:     domain.CardBlocked,
+		Reason: PIN_BLOCKED_REASON,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) ResetPinAttempts(cardNo string) error {
+	_, err := s.db.Exec("UPDATE credit_cards SET pin_attempts = 0 WHERE card_no = ?", cardNo)
+	return err
+}
@@ -0,0 +1,245 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/ledger"
+	v "github.com/caleb-mwasikira/tap_gopay/validators"
+)
+
+// CardStatusChange moves a credit card from one status to another.
+// When requesting a change, From is the status the card is expected to be in
+// and may be left empty to accept any status that can move to To.
+// In the status history, From is the status the card was actually in.
+type CardStatusChange struct {
+	Id        int               `json:"-"`
+	CardNo    string            `json:"card_no"`
+	From      domain.CardStatus `json:"from_status"`
+	To        domain.CardStatus `json:"to_status"`
+	Reason    string            `json:"reason"`
+	ChangedBy int               `json:"-"` // 0 for changes made by the system
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// Cards can only send or receive money while active and not expired at now
+func isCardUsable(card v.CreditCardDto, now time.Time) bool {
+	return card.Status == domain.CardActive && !card.IsExpired(now)
+}
+
+// Returns an error if the change cannot be applied to a card in the current status
+func checkCardTransition(current domain.CardStatus, change CardStatusChange) error {
+	if change.From != "" && change.From != current {
+		return domain.ErrInvalidCardTransition.WithMessage("Credit card is %v, not %v", current, change.From)
+	}
+	if !current.CanTransitionTo(change.To) {
+		return domain.ErrInvalidCardTransition.WithMessage("Credit card cannot be moved from %v to %v", current, change.To)
+	}
+	return nil
+}
+
+// Locks the credit cards with SELECT ... FOR UPDATE in card number order,
+// the same way lockLedgerAccounts does for ledger accounts.
+// Cards that do not exist are left out of the returned map.
+func lockCreditCards(tx *sqlTx, cardNos ...string) (map[string]v.CreditCardDto, error) {
+	sorted := slices.Clone(cardNos)
+	slices.Sort(sorted)
+
+	cards := map[string]v.CreditCardDto{}
+	for _, cardNo := range slices.Compact(sorted) {
+		card := v.CreditCardDto{}
+
+		err := tx.QueryRow(
+			"SELECT id, user_id, card_no, expiry_month, expiry_year, status, pin_attempts FROM credit_cards WHERE card_no = ?"+tx.dialect.ForUpdate(),
+			cardNo,
+		).Scan(&card.Id, &card.UserId, &card.CardNo, &card.ExpiryMonth, &card.ExpiryYear, &card.Status, &card.PinAttempts)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		cards[cardNo] = card
+	}
+
+	return cards, nil
+}
+
+func recordCardStatusChange(tx *sqlTx, current domain.CardStatus, change CardStatusChange) error {
+	_, err := tx.Exec("UPDATE credit_cards SET status = ? WHERE card_no = ?", change.To, change.CardNo)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`
+			INSERT INTO card_status_history(card_no, from_status, to_status, reason, changed_by)
+			VALUES(?, ?, ?, ?, ?)
+		`,
+		change.CardNo,
+		current,
+		change.To,
+		change.Reason,
+		sql.NullInt64{Int64: int64(change.ChangedBy), Valid: change.ChangedBy != 0},
+	)
+	return err
+}
+
+// Moves a credit card to a new status and records the change in its history
+func (s *SQLStore) UpdateCardStatus(change CardStatusChange) error {
+	if change.To == domain.CardClosed {
+		return fmt.Errorf("credit cards must be closed with CloseCard")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	cards, err := lockCreditCards(tx, change.CardNo)
+	if err != nil {
+		return err
+	}
+
+	card, ok := cards[change.CardNo]
+	if !ok {
+		return domain.ErrCardNotFound
+	}
+
+	err = checkCardTransition(card.Status, change)
+	if err != nil {
+		return err
+	}
+
+	err = recordCardStatusChange(tx, card.Status, change)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Closes a credit card for good.
+// A card can only be closed with a zero balance; any money left on it is
+// first swept to sweepTo, which must be another active card of the same owner.
+// Returns the sweep transfer, or nil if the card was already empty.
+func (s *SQLStore) CloseCard(change CardStatusChange, sweepTo string, now time.Time) (*TransferResult, error) {
+	change.To = domain.CardClosed
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	cardNos := []string{change.CardNo}
+	if sweepTo != "" {
+		cardNos = append(cardNos, sweepTo)
+	}
+
+	cards, err := lockCreditCards(tx, cardNos...)
+	if err != nil {
+		return nil, err
+	}
+
+	card, ok := cards[change.CardNo]
+	if !ok {
+		return nil, domain.ErrCardNotFound
+	}
+
+	err = checkCardTransition(card.Status, change)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := []string{ledger.CardAccount(change.CardNo)}
+	if sweepTo != "" {
+		sweepCard, ok := cards[sweepTo]
+		if !ok || sweepTo == change.CardNo || sweepCard.UserId != card.UserId || !isCardUsable(sweepCard, now) {
+			return nil, domain.ErrInvalidSweepCard
+		}
+		accounts = append(accounts, ledger.CardAccount(sweepTo))
+	}
+
+	accountIds, err := lockLedgerAccounts(tx, accounts...)
+	if err != nil {
+		return nil, fmt.Errorf("error locking ledger accounts; %v", err)
+	}
+
+	balance, err := getLedgerBalance(tx, accountIds[ledger.CardAccount(change.CardNo)])
+	if err != nil {
+		return nil, err
+	}
+
+	var result *TransferResult
+	if !balance.IsZero() {
+		if sweepTo == "" {
+			return nil, domain.ErrCardHasBalance
+		}
+
+		result, err = postTransfer(tx, v.SendMoneyDto{
+			SendersCard:   change.CardNo,
+			ReceiversCard: sweepTo,
+			Amount:        balance,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error sweeping card balance; %v", err)
+		}
+	}
+
+	err = recordCardStatusChange(tx, card.Status, change)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (s *SQLStore) GetCardStatusHistory(cardNo string) ([]CardStatusChange, error) {
+	query := `
+		SELECT id, card_no, from_status, to_status, reason, changed_by, created_at
+		FROM card_status_history
+		WHERE card_no = ?
+		ORDER BY id
+	`
+
+	rows, err := s.db.Query(query, cardNo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []CardStatusChange{}
+
+	for rows.Next() {
+		change := CardStatusChange{}
+		changedBy := sql.NullInt64{}
+
+		err = rows.Scan(
+			&change.Id,
+			&change.CardNo,
+			&change.From,
+			&change.To,
+			&change.Reason,
+			&changedBy,
+			&change.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		change.ChangedBy = int(changedBy.Int64)
+		history = append(history, change)
+	}
+
+	return history, rows.Err()
+}
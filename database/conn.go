@@ -2,48 +2,180 @@ package database
 
 import (
 	"database/sql"
-	"log"
+	"fmt"
 	"os"
-	"time"
-
-	"github.com/caleb-mwasikira/tap_gopay/utils"
-	"github.com/go-sql-driver/mysql"
 )
 
-var (
-	db *sql.DB
-)
+type Config struct {
+	Driver   string // mysql, postgres or sqlite; defaults to mysql
+	DSN      string // overrides every other field when set
+	User     string
+	Password string
+	Address  string // host:port, defaults to the driver's default
+	Name     string // database name, or the database file path for SQLite
+}
 
-func init() {
-	var err error
+// Reads the database configuration from the DB_* environment variables
+func ConfigFromEnv() Config {
+	return Config{
+		Driver:   os.Getenv("DB_DRIVER"),
+		DSN:      os.Getenv("DB_DSN"),
+		User:     os.Getenv("DB_USER"),
+		Password: os.Getenv("DB_PASS"),
+		Address:  os.Getenv("DB_ADDR"),
+		Name:     os.Getenv("DB_NAME"),
+	}
+}
 
-	utils.LoadEnvVariables()
+// SQLStore implements every store interface on top of a SQL database.
+// The same queries run on MySQL, PostgreSQL and SQLite through a Dialect.
+type SQLStore struct {
+	db      *sqlDB
+	dialect Dialect
+}
 
-	db, err = connectToDatabase()
+func NewSQLStore(cfg Config) (*SQLStore, error) {
+	dialect, err := DialectFor(cfg.Driver)
 	if err != nil {
-		log.Fatalf("error connecting to database; %v\n", err)
+		return nil, err
 	}
 
-	log.Println("connected to database successfuly")
+	db, err := connectToDatabase(dialect, cfg, false)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database; %v", err)
+	}
+
+	return &SQLStore{db: db, dialect: dialect}, nil
 }
 
-func connectToDatabase() (*sql.DB, error) {
-	cfg := mysql.Config{
-		User:      os.Getenv("DB_USER"),
-		Passwd:    os.Getenv("DB_PASS"),
-		DBName:    os.Getenv("DB_NAME"),
-		ParseTime: true,
-		Loc:       time.Local,
-	}
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
 
-	db, err := sql.Open("mysql", cfg.FormatDSN())
+func (s *SQLStore) Dialect() Dialect {
+	return s.dialect
+}
+
+func connectToDatabase(dialect Dialect, cfg Config, multiStatements bool) (*sqlDB, error) {
+	db, err := sql.Open(dialect.DriverName(), dialect.DSN(cfg, multiStatements))
 	if err != nil {
 		return nil, err
 	}
 
+	if dialect.Name() == "sqlite" {
+		// SQLite allows a single writer at a time
+		db.SetMaxOpenConns(1)
+	}
+
 	err = db.Ping()
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
-	return db, nil
+	return &sqlDB{DB: db, dialect: dialect}, nil
+}
+
+// querier is satisfied by both *sqlDB and *sqlTx
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sqlRow
+	Insert(query string, args ...any) (int64, error)
+}
+
+// sqlDB rebinds every query for its dialect and translates driver errors
+type sqlDB struct {
+	*sql.DB
+	dialect Dialect
+}
+
+func (d *sqlDB) Exec(query string, args ...any) (sql.Result, error) {
+	result, err := d.DB.Exec(d.dialect.Rebind(query), args...)
+	return result, d.dialect.TranslateError(err)
+}
+
+func (d *sqlDB) Query(query string, args ...any) (*sql.Rows, error) {
+	rows, err := d.DB.Query(d.dialect.Rebind(query), args...)
+	return rows, d.dialect.TranslateError(err)
+}
+
+func (d *sqlDB) QueryRow(query string, args ...any) *sqlRow {
+	return &sqlRow{Row: d.DB.QueryRow(d.dialect.Rebind(query), args...), dialect: d.dialect}
+}
+
+func (d *sqlDB) Insert(query string, args ...any) (int64, error) {
+	return insert(d.DB, d.dialect, query, args...)
+}
+
+func (d *sqlDB) Begin() (*sqlTx, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return nil, d.dialect.TranslateError(err)
+	}
+	return &sqlTx{Tx: tx, dialect: d.dialect}, nil
+}
+
+type sqlTx struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+func (t *sqlTx) Exec(query string, args ...any) (sql.Result, error) {
+	result, err := t.Tx.Exec(t.dialect.Rebind(query), args...)
+	return result, t.dialect.TranslateError(err)
+}
+
+func (t *sqlTx) Query(query string, args ...any) (*sql.Rows, error) {
+	rows, err := t.Tx.Query(t.dialect.Rebind(query), args...)
+	return rows, t.dialect.TranslateError(err)
+}
+
+func (t *sqlTx) QueryRow(query string, args ...any) *sqlRow {
+	return &sqlRow{Row: t.Tx.QueryRow(t.dialect.Rebind(query), args...), dialect: t.dialect}
+}
+
+func (t *sqlTx) Insert(query string, args ...any) (int64, error) {
+	return insert(t.Tx, t.dialect, query, args...)
+}
+
+func (t *sqlTx) Commit() error {
+	return t.dialect.TranslateError(t.Tx.Commit())
+}
+
+// sqlRow translates driver errors once the row is scanned,
+// as query errors of QueryRow only surface from Scan
+type sqlRow struct {
+	*sql.Row
+	dialect Dialect
+}
+
+func (r *sqlRow) Scan(dest ...any) error {
+	return r.dialect.TranslateError(r.Row.Scan(dest...))
+}
+
+func (r *sqlRow) Err() error {
+	return r.dialect.TranslateError(r.Row.Err())
+}
+
+type rawQuerier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Runs an INSERT statement and returns the id of the new row
+func insert(q rawQuerier, dialect Dialect, query string, args ...any) (int64, error) {
+	query = dialect.Rebind(query)
+
+	if dialect.SupportsReturning() {
+		var id int64
+
+		err := q.QueryRow(query+" RETURNING id", args...).Scan(&id)
+		return id, dialect.TranslateError(err)
+	}
+
+	result, err := q.Exec(query, args...)
+	if err != nil {
+		return 0, dialect.TranslateError(err)
+	}
+	return result.LastInsertId()
 }
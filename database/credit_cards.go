@@ -5,50 +5,88 @@ import (
 	"strings"
 	"time"
 
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/ledger"
+	"github.com/caleb-mwasikira/tap_gopay/money"
 	v "github.com/caleb-mwasikira/tap_gopay/validators"
 )
 
 type CreditCardDetails struct {
 	v.CreditCardDto
-	Username       string  `json:"username,omitempty"`
-	Email          string  `json:"email,omitempty"`
-	CurrentBalance float64 `json:"current_balance"`
+	Username       string      `json:"username,omitempty"`
+	Email          string      `json:"email,omitempty"`
+	CurrentBalance money.Money `json:"current_balance"`
 }
 
 type TransactionDetails struct {
-	Id            int       `json:"-"`
-	SendersCard   string    `json:"senders_card"`
-	ReceiversCard string    `json:"receivers_card"`
-	Amount        float64   `json:"amount"`
-	Status        string    `json:"status"`
-	CreatedAt     time.Time `json:"created_at"`
+	Id            int         `json:"-"`
+	SendersCard   string      `json:"senders_card"`
+	ReceiversCard string      `json:"receivers_card"`
+	Amount        money.Money `json:"amount"`
+	Status        string      `json:"status"`
+	CreatedAt     time.Time   `json:"created_at"`
 
 	// details acquired by joining credit_cards and users tables
 	SendersUsername   string `json:"senders_username"`
 	ReceiversUsername string `json:"receivers_username"`
 }
 
-func CreateCreditCard(newCreditCard v.CreditCardDto) error {
+// Creates a new credit card together with its ledger account and records the
+// initial deposit as a journal entry from the system deposits account
+func (s *SQLStore) CreateCreditCard(newCreditCard v.CreditCardDto) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO credit_cards(user_id, card_no, cvv, initial_deposit)
-		VALUES(?, ?, ?, ?)
+		INSERT INTO credit_cards(user_id, card_no, cvv, expiry_month, expiry_year, initial_deposit)
+		VALUES(?, ?, ?, ?, ?, ?)
 	`
 
-	_, err := db.Exec(
+	_, err = tx.Exec(
 		query,
 		newCreditCard.UserId,
 		newCreditCard.CardNo,
 		newCreditCard.Cvv,
+		newCreditCard.ExpiryMonth,
+		newCreditCard.ExpiryYear,
 		newCreditCard.InitialDeposit,
 	)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	cardAccount := ledger.CardAccount(newCreditCard.CardNo)
+
+	_, err = ensureLedgerAccount(tx, cardAccount, ledger.KindCard, newCreditCard.CardNo)
+	if err != nil {
+		return fmt.Errorf("error creating ledger account for card; %v", err)
+	}
+
+	if newCreditCard.InitialDeposit.IsPositive() {
+		accountIds, err := lockLedgerAccounts(tx, ledger.DepositsAccount, cardAccount)
+		if err != nil {
+			return err
+		}
+
+		_, err = postJournalEntry(tx, ledger.NewTransfer(
+			fmt.Sprintf("deposit:%s", newCreditCard.CardNo),
+			"Initial deposit",
+			ledger.DepositsAccount,
+			cardAccount,
+			newCreditCard.InitialDeposit,
+		), accountIds)
+		if err != nil {
+			return fmt.Errorf("error recording initial deposit; %v", err)
+		}
+	}
+
+	return tx.Commit()
 }
 
-func GetCreditCardsAssocWith(phoneNos []string) ([]v.CreditCardDto, error) {
+func (s *SQLStore) GetCreditCardsAssocWith(phoneNos []string, now time.Time) ([]v.CreditCardDto, error) {
 	if len(phoneNos) == 0 {
 		return nil, fmt.Errorf("empty search parameter phone numbers")
 	}
@@ -68,14 +106,19 @@ func GetCreditCardsAssocWith(phoneNos []string) ([]v.CreditCardDto, error) {
 			SELECT cc.id, cc.user_id, cc.card_no
 			FROM credit_cards cc
 			INNER JOIN users u ON cc.user_id = u.id
-			WHERE u.phone_no IN (%s) AND cc.is_active = TRUE
+			WHERE u.phone_no IN (%s) AND cc.status = 'active'
+			AND (cc.expiry_year > ? OR (cc.expiry_year = ? AND cc.expiry_month >= ?))
 		`, placeholders,
 	)
 
-	rows, err := db.Query(query, args...)
+	// expired cards cannot receive money so they are left out
+	args = append(args, now.Year(), now.Year(), int(now.Month()))
+
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	creditCards := []v.CreditCardDto{}
 	creditCard := v.CreditCardDto{}
@@ -93,24 +136,27 @@ func GetCreditCardsAssocWith(phoneNos []string) ([]v.CreditCardDto, error) {
 		creditCards = append(creditCards, creditCard)
 	}
 
-	return creditCards, nil
+	return creditCards, rows.Err()
 }
 
-func GetCreditCardsFor(username string) ([]CreditCardDetails, error) {
+func (s *SQLStore) GetCreditCardsFor(username string) ([]CreditCardDetails, error) {
 	query := `
-		SELECT cc.id, cc.card_no, cc.is_active, cc.created_at,
+		SELECT cc.id, cc.card_no, cc.status, cc.expiry_month, cc.expiry_year, cc.created_at,
 		u.username, u.email,
-		b.balance
+		COALESCE(SUM(p.amount), 0) AS balance
 		FROM credit_cards cc
 		INNER JOIN users u ON u.id = cc.user_id
-		INNER JOIN balances b ON b.card_no = cc.card_no
+		LEFT JOIN ledger_accounts la ON la.card_no = cc.card_no
+		LEFT JOIN postings p ON p.account_id = la.id
 		WHERE username = ?
+		GROUP BY cc.id, cc.card_no, cc.status, cc.expiry_month, cc.expiry_year, cc.created_at, u.username, u.email
 	`
 
-	rows, err := db.Query(query, username)
+	rows, err := s.db.Query(query, username)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	creditCards := []CreditCardDetails{}
 	creditCard := CreditCardDetails{}
@@ -119,7 +165,9 @@ func GetCreditCardsFor(username string) ([]CreditCardDetails, error) {
 		err = rows.Scan(
 			&creditCard.Id,
 			&creditCard.CardNo,
-			&creditCard.IsActive,
+			&creditCard.Status,
+			&creditCard.ExpiryMonth,
+			&creditCard.ExpiryYear,
 			&creditCard.CreatedAt,
 			&creditCard.Username,
 			&creditCard.Email,
@@ -132,33 +180,48 @@ func GetCreditCardsFor(username string) ([]CreditCardDetails, error) {
 		creditCards = append(creditCards, creditCard)
 	}
 
-	return creditCards, nil
+	return creditCards, rows.Err()
 }
 
-func GetCreditCardWhere(username, cardNo string, isActive bool) (*v.CreditCardDto, error) {
+// Returns the credit card with the given number if it belongs to the user.
+// When statuses are given, the card must also be in one of them.
+func (s *SQLStore) GetCreditCardWhere(username, cardNo string, statuses ...domain.CardStatus) (*v.CreditCardDto, error) {
 	if strings.TrimSpace(username) == "" || strings.TrimSpace(cardNo) == "" {
 		return nil, fmt.Errorf("empty search parameter username or card_no")
 	}
 
 	query := `
-		SELECT cc.id, cc.user_id, cc.card_no
+		SELECT cc.id, cc.user_id, cc.card_no, cc.cvv, cc.expiry_month, cc.expiry_year, cc.status,
+		COALESCE(cc.pin_hash, ''), cc.pin_attempts
 		FROM credit_cards cc
 		INNER JOIN users u ON cc.user_id = u.id
 		WHERE u.username = ? 
 		AND cc.card_no = ?
 	`
+	args := []any{username, cardNo}
 
-	if isActive {
-		query += "AND cc.is_active = TRUE"
+	if len(statuses) > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(statuses)), ",")
+		query += fmt.Sprintf("AND cc.status IN (%s)", placeholders)
+
+		for _, status := range statuses {
+			args = append(args, status)
+		}
 	}
 
-	row := db.QueryRow(query, username, cardNo)
+	row := s.db.QueryRow(query, args...)
 	creditCard := v.CreditCardDto{}
 
 	err := row.Scan(
 		&creditCard.Id,
 		&creditCard.UserId,
 		&creditCard.CardNo,
+		&creditCard.Cvv,
+		&creditCard.ExpiryMonth,
+		&creditCard.ExpiryYear,
+		&creditCard.Status,
+		&creditCard.PinHash,
+		&creditCard.PinAttempts,
 	)
 	if err != nil {
 		return nil, err
@@ -167,25 +230,80 @@ func GetCreditCardWhere(username, cardNo string, isActive bool) (*v.CreditCardDt
 	return &creditCard, nil
 }
 
-func DeactivateCard(cardNo string) error {
-	query := "UPDATE credit_cards SET is_active = FALSE WHERE card_no = ?"
-	_, err := db.Exec(query, cardNo)
-	return err
+// Records that the card's CVV has been shown to its owner.
+// Returns domain.ErrCvvAlreadyRevealed if it had been shown before.
+func (s *SQLStore) MarkCvvRevealed(cardNo string) error {
+	result, err := s.db.Exec(
+		"UPDATE credit_cards SET cvv_revealed_at = ? WHERE card_no = ? AND cvv_revealed_at IS NULL",
+		time.Now(),
+		cardNo,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return domain.ErrCvvAlreadyRevealed
+	}
+	return nil
 }
 
-func CreateTransaction(transaction v.SendMoneyDto) error {
-	query := "INSERT INTO transactions(senders_card, receivers_card, amount) VALUES(?, ?, ?)"
+// Passes the stored CVV of every card through rewrite and saves the values
+// it changes, all in one transaction. Used to encrypt legacy plain text CVVs.
+// Returns the number of cards updated.
+func (s *SQLStore) RewriteCvvs(rewrite func(cardNo, cvv string) (string, error)) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
 
-	_, err := db.Exec(
-		query,
-		transaction.SendersCard,
-		transaction.ReceiversCard,
-		transaction.Amount,
-	)
-	return err
+	rows, err := tx.Query("SELECT card_no, cvv FROM credit_cards ORDER BY id")
+	if err != nil {
+		return 0, err
+	}
+
+	cvvs := map[string]string{}
+	for rows.Next() {
+		var cardNo, cvv string
+
+		err = rows.Scan(&cardNo, &cvv)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		cvvs[cardNo] = cvv
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for cardNo, cvv := range cvvs {
+		newCvv, err := rewrite(cardNo, cvv)
+		if err != nil {
+			return 0, fmt.Errorf("error rewriting CVV of card %v; %v", cardNo, err)
+		}
+		if newCvv == cvv {
+			continue
+		}
+
+		_, err = tx.Exec("UPDATE credit_cards SET cvv = ? WHERE card_no = ?", newCvv, cardNo)
+		if err != nil {
+			return 0, err
+		}
+		updated++
+	}
+
+	return updated, tx.Commit()
 }
 
-func GetTransactionsDetailsWhere(username string) ([]TransactionDetails, error) {
+func (s *SQLStore) GetTransactionsDetailsWhere(username string) ([]TransactionDetails, error) {
 	query := `
 		SELECT id, senders_card, receivers_card, amount, status, created_at,
 		senders_username, receivers_username
@@ -193,10 +311,11 @@ func GetTransactionsDetailsWhere(username string) ([]TransactionDetails, error)
 		WHERE senders_username = ? OR receivers_username = ?
 	`
 
-	rows, err := db.Query(query, username, username)
+	rows, err := s.db.Query(query, username, username)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	transactions := []TransactionDetails{}
 	transaction := TransactionDetails{}
@@ -219,5 +338,5 @@ func GetTransactionsDetailsWhere(username string) ([]TransactionDetails, error)
 		transactions = append(transactions, transaction)
 	}
 
-	return transactions, nil
+	return transactions, rows.Err()
 }
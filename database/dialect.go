@@ -0,0 +1,232 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var (
+	ErrDuplicateKey error = errors.New("duplicate key")
+)
+
+// Dialect hides the differences between the SQL databases the stores can run on.
+// Store queries are written with ? placeholders and rebound per dialect.
+type Dialect interface {
+	Name() string
+	DriverName() string
+	DSN(cfg Config, multiStatements bool) string
+
+	// Rewrites ? placeholders into the dialect's placeholder syntax
+	Rebind(query string) string
+
+	// Row locking clause appended to SELECT statements inside transactions
+	ForUpdate() string
+
+	// Whether INSERT ... RETURNING id is used instead of LastInsertId
+	SupportsReturning() bool
+
+	// Converts driver specific errors into the typed errors of this package
+	TranslateError(err error) error
+}
+
+func DialectFor(driver string) (Dialect, error) {
+	switch driver {
+	case "", "mysql":
+		return mysqlDialect{}, nil
+	case "postgres", "postgresql":
+		return postgresDialect{}, nil
+	case "sqlite", "sqlite3":
+		return sqliteDialect{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", driver)
+	}
+}
+
+// Business rules raised by triggers carry the message of the matching
+// domain error after the last ':' in their message, e.g.
+// 'Transaction error: insufficient funds to complete transaction'
+var ruleViolations = []*domain.Error{
+	domain.ErrInsufficientFunds,
+	domain.ErrInvalidSendersCard,
+	domain.ErrInvalidReceiversCard,
+	domain.ErrSendingToSameCard,
+	domain.ErrInvalidTransferAmount,
+}
+
+// Converts a message raised by a database trigger into a domain error
+func ruleViolation(message string) error {
+	fields := strings.Split(message, ":")
+	text := strings.TrimSpace(fields[len(fields)-1])
+
+	for _, err := range ruleViolations {
+		if strings.EqualFold(err.Message, text) {
+			return err
+		}
+	}
+	return domain.ErrRuleViolation.WithMessage("%s", text)
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string       { return "mysql" }
+func (mysqlDialect) DriverName() string { return "mysql" }
+
+func (mysqlDialect) DSN(cfg Config, multiStatements bool) string {
+	if cfg.DSN != "" {
+		return cfg.DSN
+	}
+
+	mysqlCfg := mysql.Config{
+		User:            cfg.User,
+		Passwd:          cfg.Password,
+		DBName:          cfg.Name,
+		ParseTime:       true,
+		Loc:             time.Local,
+		MultiStatements: multiStatements,
+	}
+
+	if cfg.Address != "" {
+		mysqlCfg.Net = "tcp"
+		mysqlCfg.Addr = cfg.Address
+	}
+	return mysqlCfg.FormatDSN()
+}
+
+func (mysqlDialect) Rebind(query string) string { return query }
+func (mysqlDialect) ForUpdate() string          { return " FOR UPDATE" }
+func (mysqlDialect) SupportsReturning() bool    { return false }
+
+func (mysqlDialect) TranslateError(err error) error {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return err
+	}
+
+	switch mysqlErr.Number {
+	case 1062: // ER_DUP_ENTRY
+		return fmt.Errorf("%w; %v", ErrDuplicateKey, mysqlErr.Message)
+	case 1644: // user-defined error from SIGNAL SQLSTATE '45000'
+		return ruleViolation(mysqlErr.Message)
+	}
+	return err
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string       { return "postgres" }
+func (postgresDialect) DriverName() string { return "pgx" }
+
+func (postgresDialect) DSN(cfg Config, multiStatements bool) string {
+	if cfg.DSN != "" {
+		return cfg.DSN
+	}
+
+	address := cfg.Address
+	if address == "" {
+		address = "localhost:5432"
+	}
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.User, cfg.Password),
+		Host:     address,
+		Path:     "/" + cfg.Name,
+		RawQuery: "sslmode=disable",
+	}
+	return dsn.String()
+}
+
+func (postgresDialect) Rebind(query string) string {
+	var (
+		builder  strings.Builder
+		inQuotes bool
+		n        int
+	)
+
+	for _, char := range query {
+		switch {
+		case char == '\'':
+			inQuotes = !inQuotes
+			builder.WriteRune(char)
+		case char == '?' && !inQuotes:
+			n++
+			fmt.Fprintf(&builder, "$%d", n)
+		default:
+			builder.WriteRune(char)
+		}
+	}
+	return builder.String()
+}
+
+func (postgresDialect) ForUpdate() string       { return " FOR UPDATE" }
+func (postgresDialect) SupportsReturning() bool { return true }
+
+func (postgresDialect) TranslateError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	switch pgErr.Code {
+	case "23505": // unique_violation
+		return fmt.Errorf("%w; %v", ErrDuplicateKey, pgErr.Message)
+	case "P0001": // raise_exception from a trigger
+		return ruleViolation(pgErr.Message)
+	}
+	return err
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string       { return "sqlite" }
+func (sqliteDialect) DriverName() string { return "sqlite" }
+
+// For SQLite, Config.Name is the path to the database file
+func (sqliteDialect) DSN(cfg Config, multiStatements bool) string {
+	if cfg.DSN != "" {
+		return cfg.DSN
+	}
+
+	// SQLite has no row locks; transactions take the write lock up front
+	// instead, which serialises writers just like SELECT ... FOR UPDATE would
+	params := url.Values{}
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_txlock", "immediate")
+	params.Add("_time_format", "sqlite")
+
+	return fmt.Sprintf("file:%s?%s", cfg.Name, params.Encode())
+}
+
+func (sqliteDialect) Rebind(query string) string { return query }
+func (sqliteDialect) ForUpdate() string          { return "" }
+func (sqliteDialect) SupportsReturning() bool    { return true }
+
+func (sqliteDialect) TranslateError(err error) error {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+
+	message := sqliteErr.Error()
+	switch sqliteErr.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		return fmt.Errorf("%w; %v", ErrDuplicateKey, message)
+
+	case sqlite3.SQLITE_CONSTRAINT_TRIGGER:
+		// messages look like "constraint failed: <RAISE message> (1811)"
+		message = strings.TrimSuffix(message, fmt.Sprintf(" (%d)", sqliteErr.Code()))
+		return ruleViolation(message)
+	}
+	return err
+}
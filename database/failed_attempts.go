@@ -0,0 +1,84 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/lockout"
+)
+
+const (
+	// Times ReserveAttempt retries when another request inserts the counter of the same key first
+	FAILED_ATTEMPT_RETRIES int = 3
+)
+
+// Counts an attempt as failed in the failed_attempts table, so that every
+// server instance sees the same counters. The counter is locked with
+// SELECT ... FOR UPDATE while it is checked and updated.
+func (s *SQLStore) ReserveAttempt(key string, now time.Time, window time.Duration, allow func(lockout.Attempts) bool) (lockout.Attempts, bool, error) {
+	for i := 0; i < FAILED_ATTEMPT_RETRIES; i++ {
+		attempts, reserved, err := s.reserveAttempt(key, now, window, allow)
+		if errors.Is(err, ErrDuplicateKey) {
+			continue
+		}
+		return attempts, reserved, err
+	}
+	return lockout.Attempts{}, false, fmt.Errorf("error counting failed attempt of %q; counter kept being created concurrently", key)
+}
+
+func (s *SQLStore) reserveAttempt(key string, now time.Time, window time.Duration, allow func(lockout.Attempts) bool) (lockout.Attempts, bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return lockout.Attempts{}, false, err
+	}
+	defer tx.Rollback()
+
+	attempts := lockout.Attempts{}
+	err = tx.QueryRow(
+		"SELECT failures, last_failure_at FROM failed_attempts WHERE attempt_key = ?"+tx.dialect.ForUpdate(), key,
+	).Scan(&attempts.Failures, &attempts.LastFailure)
+
+	exists := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		return lockout.Attempts{}, false, err
+	}
+
+	if now.Sub(attempts.LastFailure) > window {
+		attempts = lockout.Attempts{}
+	}
+	if !allow(attempts) {
+		return attempts, false, nil
+	}
+
+	attempts.Failures++
+	attempts.LastFailure = now
+
+	if exists {
+		_, err = tx.Exec(
+			"UPDATE failed_attempts SET failures = ?, last_failure_at = ? WHERE attempt_key = ?",
+			attempts.Failures, now, key,
+		)
+	} else {
+		_, err = tx.Exec(
+			"INSERT INTO failed_attempts(attempt_key, failures, last_failure_at) VALUES(?, ?, ?)",
+			key, attempts.Failures, now,
+		)
+	}
+	if err != nil {
+		return lockout.Attempts{}, false, err
+	}
+
+	return attempts, true, tx.Commit()
+}
+
+func (s *SQLStore) ReleaseAttempt(key string) error {
+	_, err := s.db.Exec("UPDATE failed_attempts SET failures = failures - 1 WHERE attempt_key = ? AND failures > 0", key)
+	return err
+}
+
+func (s *SQLStore) ResetAttempts(key string) error {
+	_, err := s.db.Exec("DELETE FROM failed_attempts WHERE attempt_key = ?", key)
+	return err
+}
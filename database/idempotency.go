@@ -0,0 +1,97 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrIdempotencyKeyExists error = errors.New("idempotency key has already been used")
+)
+
+type IdempotencyRecord struct {
+	Id           int       `json:"id"`
+	UserId       int       `json:"user_id"`
+	Key          string    `json:"key"`
+	RequestHash  string    `json:"request_hash"`
+	StatusCode   int       `json:"status_code"` // zero while the original request is still being processed
+	ResponseBody []byte    `json:"response_body"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (record IdempotencyRecord) IsComplete() bool {
+	return record.StatusCode != 0
+}
+
+// Reserves an idempotency key for a user.
+// Returns ErrIdempotencyKeyExists if the user has already used the key.
+func (s *SQLStore) CreateIdempotencyRecord(userId int, key, requestHash string) error {
+	query := "INSERT INTO idempotency_keys(user_id, idempotency_key, request_hash, created_at) VALUES(?, ?, ?, ?)"
+
+	_, err := s.db.Exec(query, userId, key, requestHash, time.Now())
+	if errors.Is(err, ErrDuplicateKey) {
+		return ErrIdempotencyKeyExists
+	}
+	return err
+}
+
+func (s *SQLStore) GetIdempotencyRecord(userId int, key string) (*IdempotencyRecord, error) {
+	query := `
+		SELECT id, user_id, idempotency_key, request_hash, status_code, response_body, created_at
+		FROM idempotency_keys
+		WHERE user_id = ? AND idempotency_key = ?
+	`
+
+	record := IdempotencyRecord{}
+	statusCode := sql.NullInt64{}
+
+	err := s.db.QueryRow(query, userId, key).Scan(
+		&record.Id,
+		&record.UserId,
+		&record.Key,
+		&record.RequestHash,
+		&statusCode,
+		&record.ResponseBody,
+		&record.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	record.StatusCode = int(statusCode.Int64)
+	return &record, nil
+}
+
+func (s *SQLStore) SaveIdempotencyResponse(userId int, key string, statusCode int, responseBody []byte) error {
+	query := `
+		UPDATE idempotency_keys SET status_code = ?, response_body = ?
+		WHERE user_id = ? AND idempotency_key = ?
+	`
+
+	_, err := s.db.Exec(query, statusCode, responseBody, userId, key)
+	return err
+}
+
+// Releases an idempotency key so that the request can be retried
+func (s *SQLStore) DeleteIdempotencyRecord(userId int, key string) error {
+	query := "DELETE FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ?"
+
+	_, err := s.db.Exec(query, userId, key)
+	return err
+}
+
+// Deletes the idempotency records created before the given time, after which
+// their keys can be used again, and returns how many were deleted
+func (s *SQLStore) DeleteIdempotencyRecordsBefore(before time.Time) (int, error) {
+	result, err := s.db.Exec("DELETE FROM idempotency_keys WHERE created_at < ?", before)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
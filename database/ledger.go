@@ -0,0 +1,203 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+
+	"github.com/caleb-mwasikira/tap_gopay/ledger"
+	"github.com/caleb-mwasikira/tap_gopay/money"
+)
+
+// Returns the id of the ledger account with the given code,
+// creating the account if it does not exist yet
+func ensureLedgerAccount(q querier, code string, kind ledger.AccountKind, cardNo string) (int64, error) {
+	var id int64
+
+	err := q.QueryRow("SELECT id FROM ledger_accounts WHERE code = ?", code).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	return q.Insert(
+		"INSERT INTO ledger_accounts(code, kind, card_no) VALUES(?, ?, ?)",
+		code,
+		kind,
+		sql.NullString{String: cardNo, Valid: cardNo != ""},
+	)
+}
+
+// Locks the ledger accounts with SELECT ... FOR UPDATE in code order so that
+// concurrent journal entries touching the same accounts cannot deadlock.
+// Returns a map of account code to account id.
+func lockLedgerAccounts(tx *sqlTx, codes ...string) (map[string]int64, error) {
+	sorted := slices.Clone(codes)
+	slices.Sort(sorted)
+
+	ids := map[string]int64{}
+	for _, code := range sorted {
+		var id int64
+
+		err := tx.QueryRow("SELECT id FROM ledger_accounts WHERE code = ?"+tx.dialect.ForUpdate(), code).Scan(&id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, fmt.Errorf("ledger account %v does not exist", code)
+			}
+			return nil, err
+		}
+		ids[code] = id
+	}
+
+	return ids, nil
+}
+
+// Balances are never stored; they are derived from the postings made to an account
+func getLedgerBalance(q querier, accountId int64) (money.Money, error) {
+	var balance money.Money
+
+	err := q.QueryRow(
+		"SELECT COALESCE(SUM(amount), 0) FROM postings WHERE account_id = ?",
+		accountId,
+	).Scan(&balance)
+	return balance, err
+}
+
+// Validates and records a journal entry together with its postings.
+// accountIds maps account codes to ids, as returned by lockLedgerAccounts;
+// every account posted to must be in it.
+func postJournalEntry(tx *sqlTx, entry ledger.JournalEntry, accountIds map[string]int64) (int64, error) {
+	err := entry.Validate()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, posting := range entry.Postings {
+		if _, ok := accountIds[posting.AccountCode]; !ok {
+			return 0, fmt.Errorf("error posting to account %v; account is not locked", posting.AccountCode)
+		}
+	}
+
+	entryId, err := tx.Insert(
+		"INSERT INTO journal_entries(reference, description) VALUES(?, ?)",
+		entry.Reference,
+		entry.Description,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, posting := range entry.Postings {
+		result, err := tx.Exec(
+			"INSERT INTO postings(journal_entry_id, account_id, amount) VALUES(?, ?, ?)",
+			entryId,
+			accountIds[posting.AccountCode],
+			posting.Amount,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("error posting to account %v; %v", posting.AccountCode, err)
+		}
+
+		// a skipped posting would leave the ledger unbalanced
+		inserted, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if inserted != 1 {
+			return 0, fmt.Errorf("error posting to account %v; %d postings inserted", posting.AccountCode, inserted)
+		}
+	}
+
+	return entryId, nil
+}
+
+func (s *SQLStore) GetJournalEntries(reference string) ([]ledger.JournalEntry, error) {
+	query := `
+		SELECT je.id, je.reference, je.description, je.created_at,
+		la.code, p.amount
+		FROM journal_entries je
+		INNER JOIN postings p ON p.journal_entry_id = je.id
+		INNER JOIN ledger_accounts la ON la.id = p.account_id
+		WHERE je.reference = ?
+		ORDER BY je.id, p.id
+	`
+
+	rows, err := s.db.Query(query, reference)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []ledger.JournalEntry{}
+
+	for rows.Next() {
+		entry := ledger.JournalEntry{}
+		posting := ledger.Posting{}
+
+		err = rows.Scan(
+			&entry.Id,
+			&entry.Reference,
+			&entry.Description,
+			&entry.CreatedAt,
+			&posting.AccountCode,
+			&posting.Amount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(entries) == 0 || entries[len(entries)-1].Id != entry.Id {
+			entries = append(entries, entry)
+		}
+
+		last := &entries[len(entries)-1]
+		last.Postings = append(last.Postings, posting)
+	}
+
+	return entries, rows.Err()
+}
+
+func (s *SQLStore) GetTrialBalance() (*ledger.TrialBalance, error) {
+	query := `
+		SELECT la.code, la.kind, COALESCE(SUM(p.amount), 0)
+		FROM ledger_accounts la
+		LEFT JOIN postings p ON p.account_id = la.id
+		GROUP BY la.id, la.code, la.kind
+		ORDER BY la.code
+	`
+
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	trialBalance := ledger.TrialBalance{
+		Accounts: []ledger.AccountBalance{},
+		Total:    money.Zero(money.DefaultCurrency),
+	}
+
+	for rows.Next() {
+		account := ledger.AccountBalance{}
+
+		err = rows.Scan(
+			&account.AccountCode,
+			&account.Kind,
+			&account.Balance,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		trialBalance.Accounts = append(trialBalance.Accounts, account)
+
+		trialBalance.Total, err = trialBalance.Total.Add(account.Balance)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &trialBalance, rows.Err()
+}
@@ -0,0 +1,1157 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/codes"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/ledger"
+	"github.com/caleb-mwasikira/tap_gopay/lockout"
+	"github.com/caleb-mwasikira/tap_gopay/money"
+	v "github.com/caleb-mwasikira/tap_gopay/validators"
+)
+
+// MemoryStore is an in-memory implementation of every store interface.
+// It mirrors the behaviour of SQLStore closely enough to be used in tests
+// and is safe for concurrent use.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	users         []User
+	cards         []v.CreditCardDto
+	oneTimeCodes  []codes.Code
+	transactions  []TransactionDetails
+	cardHistory   []CardStatusChange
+	roleChanges   []RoleChange
+	adminActions  []AdminAction
+	cvvsRevealed  map[string]bool
+	idempotency   []IdempotencyRecord
+	sessions      []Session
+	totps         map[int]Totp
+	recoveryCodes []recoveryCode
+	attempts      *lockout.MemoryStore
+
+	accounts       map[string]ledger.Account
+	journalEntries []ledger.JournalEntry
+
+	lastId int
+}
+
+func NewMemoryStore() *MemoryStore {
+	store := &MemoryStore{
+		accounts:     map[string]ledger.Account{},
+		cvvsRevealed: map[string]bool{},
+		totps:        map[int]Totp{},
+		attempts:     lockout.NewMemoryStore(),
+	}
+
+	for _, code := range ledger.SystemAccounts {
+		store.ensureLedgerAccount(code, ledger.KindSystem, "")
+	}
+	return store
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+func (s *MemoryStore) nextId() int {
+	s.lastId++
+	return s.lastId
+}
+
+func (s *MemoryStore) GetUser(email string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *MemoryStore) GetUserById(id int) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.findUserById(id)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) CreateUser(user v.RegisterDto) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.users {
+		if existing.Email == user.Email || existing.Username == user.Username {
+			return fmt.Errorf("%w; duplicate entry for user %v", ErrDuplicateKey, user.Email)
+		}
+	}
+
+	s.users = append(s.users, User{
+		Id:          s.nextId(),
+		Username:    user.Username,
+		Email:       user.Email,
+		Password:    user.Password,
+		PhoneNumber: sql.NullString{String: user.PhoneNumber, Valid: user.PhoneNumber != ""},
+		Role:        domain.RoleUser,
+		CreatedAt:   time.Now(),
+	})
+	return nil
+}
+
+func (s *MemoryStore) UpdateUser(email string, patch UserPatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := slices.IndexFunc(s.users, func(user User) bool { return user.Email == email })
+	if index == -1 {
+		return nil
+	}
+
+	user := s.users[index]
+	if patch.Email != nil {
+		for _, existing := range s.users {
+			if existing.Email == *patch.Email && existing.Id != user.Id {
+				return fmt.Errorf("%w; duplicate entry for user %v", ErrDuplicateKey, *patch.Email)
+			}
+		}
+		user.Email = *patch.Email
+	}
+	if patch.PhoneNumber != nil {
+		user.PhoneNumber = sql.NullString{String: *patch.PhoneNumber, Valid: true}
+	}
+	if patch.Password != nil {
+		user.Password = *patch.Password
+	}
+	if patch.IsActive != nil {
+		user.IsActive = *patch.IsActive
+	}
+	if patch.PendingEmail != nil {
+		user.PendingEmail = *patch.PendingEmail
+	}
+	if patch.PendingPhoneNumber != nil {
+		user.PendingPhoneNumber = *patch.PendingPhoneNumber
+	}
+
+	s.users[index] = user
+	return nil
+}
+
+func (s *MemoryStore) ChangeUserRole(change RoleChange) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := slices.IndexFunc(s.users, func(user User) bool { return user.Id == change.UserId })
+	if index == -1 {
+		return domain.ErrUserNotFound
+	}
+
+	current := s.users[index].Role
+	err := checkRoleChange(current, change)
+	if err != nil {
+		return err
+	}
+
+	s.users[index].Role = change.To
+
+	change.Id = s.nextId()
+	change.From = current
+	change.CreatedAt = time.Now()
+	s.roleChanges = append(s.roleChanges, change)
+	return nil
+}
+
+func (s *MemoryStore) GetRoleChanges(userId int) ([]RoleChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changes := []RoleChange{}
+	for _, change := range s.roleChanges {
+		if change.UserId == userId {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+func (s *MemoryStore) SearchUsers(query string, page, perPage int) ([]User, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query = strings.ToLower(query)
+	matches := []User{}
+	for _, user := range s.users {
+		if strings.Contains(strings.ToLower(user.Username), query) ||
+			strings.Contains(strings.ToLower(user.Email), query) ||
+			strings.Contains(user.PhoneNumber.String, query) {
+			matches = append(matches, user)
+		}
+	}
+
+	start := min((page-1)*perPage, len(matches))
+	end := min(start+perPage, len(matches))
+	return slices.Clone(matches[start:end]), len(matches), nil
+}
+
+func (s *MemoryStore) SetUserSuspended(action AdminAction, suspended bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := slices.IndexFunc(s.users, func(user User) bool { return user.Id == action.UserId })
+	if index == -1 {
+		return domain.ErrUserNotFound
+	}
+
+	err := checkSuspension(s.users[index].IsSuspended, suspended)
+	if err != nil {
+		return err
+	}
+
+	s.users[index].IsSuspended = suspended
+	s.recordAdminAction(action)
+	return nil
+}
+
+func (s *MemoryStore) SetCardBlocked(action AdminAction, blocked bool) error {
+	change := adminCardStatusChange(action, blocked)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	card, ok := s.findCard(change.CardNo)
+	if !ok {
+		return domain.ErrCardNotFound
+	}
+
+	err := checkCardTransition(card.Status, change)
+	if err != nil {
+		return err
+	}
+
+	s.recordCardStatusChange(card.Status, change)
+	if !blocked {
+		for i := range s.cards {
+			if s.cards[i].CardNo == change.CardNo {
+				s.cards[i].PinAttempts = 0
+			}
+		}
+	}
+	s.recordAdminAction(action)
+	return nil
+}
+
+func (s *MemoryStore) ResetTotp(action AdminAction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.totps[action.UserId]; !ok {
+		return domain.ErrTotpNotEnrolled
+	}
+
+	delete(s.totps, action.UserId)
+	s.replaceRecoveryCodes(action.UserId, nil)
+	s.recordAdminAction(action)
+	return nil
+}
+
+func (s *MemoryStore) RecordAdminAction(action AdminAction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.recordAdminAction(action)
+	return nil
+}
+
+func (s *MemoryStore) recordAdminAction(action AdminAction) {
+	action.Id = s.nextId()
+	action.CreatedAt = time.Now()
+	s.adminActions = append(s.adminActions, action)
+}
+
+func (s *MemoryStore) GetAdminActions(userId int) ([]AdminAction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	actions := []AdminAction{}
+	for _, action := range s.adminActions {
+		if action.UserId == userId {
+			actions = append(actions, action)
+		}
+	}
+	return actions, nil
+}
+
+func (s *MemoryStore) findUserById(id int) (User, bool) {
+	for _, user := range s.users {
+		if user.Id == id {
+			return user, true
+		}
+	}
+	return User{}, false
+}
+
+func (s *MemoryStore) findCard(cardNo string) (v.CreditCardDto, bool) {
+	for _, card := range s.cards {
+		if card.CardNo == cardNo {
+			return card, true
+		}
+	}
+	return v.CreditCardDto{}, false
+}
+
+func (s *MemoryStore) CreateCreditCard(newCreditCard v.CreditCardDto) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.findCard(newCreditCard.CardNo); exists {
+		return fmt.Errorf("%w; duplicate entry for credit card %v", ErrDuplicateKey, newCreditCard.CardNo)
+	}
+
+	newCreditCard.Id = s.nextId()
+	newCreditCard.Status = domain.CardActive
+	newCreditCard.CreatedAt = time.Now()
+	s.cards = append(s.cards, newCreditCard)
+
+	cardAccount := ledger.CardAccount(newCreditCard.CardNo)
+	s.ensureLedgerAccount(cardAccount, ledger.KindCard, newCreditCard.CardNo)
+
+	if newCreditCard.InitialDeposit.IsPositive() {
+		_, err := s.postJournalEntry(ledger.NewTransfer(
+			fmt.Sprintf("deposit:%s", newCreditCard.CardNo),
+			"Initial deposit",
+			ledger.DepositsAccount,
+			cardAccount,
+			newCreditCard.InitialDeposit,
+		))
+		if err != nil {
+			return fmt.Errorf("error recording initial deposit; %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) GetCreditCardsAssocWith(phoneNos []string, now time.Time) ([]v.CreditCardDto, error) {
+	if len(phoneNos) == 0 {
+		return nil, fmt.Errorf("empty search parameter phone numbers")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	creditCards := []v.CreditCardDto{}
+	for _, card := range s.cards {
+		user, ok := s.findUserById(card.UserId)
+		if !ok || !isCardUsable(card, now) || !slices.Contains(phoneNos, user.PhoneNumber.String) {
+			continue
+		}
+
+		creditCards = append(creditCards, v.CreditCardDto{
+			Id:     card.Id,
+			UserId: card.UserId,
+			CardNo: card.CardNo,
+		})
+	}
+
+	return creditCards, nil
+}
+
+func (s *MemoryStore) GetCreditCardsFor(username string) ([]CreditCardDetails, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	creditCards := []CreditCardDetails{}
+	for _, card := range s.cards {
+		user, ok := s.findUserById(card.UserId)
+		if !ok || user.Username != username {
+			continue
+		}
+
+		balance, err := s.ledgerBalance(ledger.CardAccount(card.CardNo))
+		if err != nil {
+			return nil, err
+		}
+
+		creditCards = append(creditCards, CreditCardDetails{
+			CreditCardDto: v.CreditCardDto{
+				Id:          card.Id,
+				CardNo:      card.CardNo,
+				Status:      card.Status,
+				ExpiryMonth: card.ExpiryMonth,
+				ExpiryYear:  card.ExpiryYear,
+				CreatedAt:   card.CreatedAt,
+			},
+			Username:       user.Username,
+			Email:          user.Email,
+			CurrentBalance: balance,
+		})
+	}
+
+	return creditCards, nil
+}
+
+func (s *MemoryStore) GetCreditCardWhere(username, cardNo string, statuses ...domain.CardStatus) (*v.CreditCardDto, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	card, ok := s.findCard(cardNo)
+	if !ok || (len(statuses) > 0 && !slices.Contains(statuses, card.Status)) {
+		return nil, sql.ErrNoRows
+	}
+
+	user, ok := s.findUserById(card.UserId)
+	if !ok || user.Username != username {
+		return nil, sql.ErrNoRows
+	}
+
+	return &v.CreditCardDto{
+		Id:          card.Id,
+		UserId:      card.UserId,
+		CardNo:      card.CardNo,
+		Cvv:         card.Cvv,
+		ExpiryMonth: card.ExpiryMonth,
+		ExpiryYear:  card.ExpiryYear,
+		Status:      card.Status,
+		PinHash:     card.PinHash,
+		PinAttempts: card.PinAttempts,
+	}, nil
+}
+
+func (s *MemoryStore) MarkCvvRevealed(cardNo string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cvvsRevealed[cardNo] {
+		return domain.ErrCvvAlreadyRevealed
+	}
+	s.cvvsRevealed[cardNo] = true
+	return nil
+}
+
+func (s *MemoryStore) SetCardPin(cardNo, pinHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.cards {
+		if s.cards[i].CardNo == cardNo {
+			s.cards[i].PinHash = pinHash
+			s.cards[i].PinAttempts = 0
+			return nil
+		}
+	}
+	return domain.ErrCardNotFound
+}
+
+func (s *MemoryStore) ReservePinAttempt(cardNo string, maxAttempts int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.cards {
+		if s.cards[i].CardNo != cardNo {
+			continue
+		}
+
+		if s.cards[i].PinAttempts >= maxAttempts {
+			return s.cards[i].PinAttempts, domain.ErrCardBlocked
+		}
+		s.cards[i].PinAttempts++
+		return s.cards[i].PinAttempts, nil
+	}
+	return 0, domain.ErrCardNotFound
+}
+
+func (s *MemoryStore) ReleasePinAttempt(cardNo string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.cards {
+		if s.cards[i].CardNo == cardNo && s.cards[i].PinAttempts > 0 {
+			s.cards[i].PinAttempts--
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) BlockCardAfterPinAttempts(cardNo string, maxAttempts int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.cards {
+		if s.cards[i].CardNo != cardNo {
+			continue
+		}
+
+		card := s.cards[i]
+		if card.PinAttempts >= maxAttempts && card.Status.CanTransitionTo(domain.CardBlocked) {
+			s.recordCardStatusChange(card.Status, CardStatusChange{
+				CardNo: cardNo,
+				To:     domain.CardBlocked,
+				Reason: PIN_BLOCKED_REASON,
+			})
+		}
+		return nil
+	}
+	return domain.ErrCardNotFound
+}
+
+func (s *MemoryStore) ResetPinAttempts(cardNo string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.cards {
+		if s.cards[i].CardNo == cardNo {
+			s.cards[i].PinAttempts = 0
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) recordCardStatusChange(current domain.CardStatus, change CardStatusChange) {
+	for i := range s.cards {
+		if s.cards[i].CardNo == change.CardNo {
+			s.cards[i].Status = change.To
+		}
+	}
+
+	change.Id = s.nextId()
+	change.From = current
+	change.CreatedAt = time.Now()
+	s.cardHistory = append(s.cardHistory, change)
+}
+
+func (s *MemoryStore) UpdateCardStatus(change CardStatusChange) error {
+	if change.To == domain.CardClosed {
+		return fmt.Errorf("credit cards must be closed with CloseCard")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	card, ok := s.findCard(change.CardNo)
+	if !ok {
+		return domain.ErrCardNotFound
+	}
+
+	err := checkCardTransition(card.Status, change)
+	if err != nil {
+		return err
+	}
+
+	s.recordCardStatusChange(card.Status, change)
+	return nil
+}
+
+func (s *MemoryStore) CloseCard(change CardStatusChange, sweepTo string, now time.Time) (*TransferResult, error) {
+	change.To = domain.CardClosed
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	card, ok := s.findCard(change.CardNo)
+	if !ok {
+		return nil, domain.ErrCardNotFound
+	}
+
+	err := checkCardTransition(card.Status, change)
+	if err != nil {
+		return nil, err
+	}
+
+	if sweepTo != "" {
+		sweepCard, ok := s.findCard(sweepTo)
+		if !ok || sweepTo == change.CardNo || sweepCard.UserId != card.UserId || !isCardUsable(sweepCard, now) {
+			return nil, domain.ErrInvalidSweepCard
+		}
+	}
+
+	balance, err := s.ledgerBalance(ledger.CardAccount(change.CardNo))
+	if err != nil {
+		return nil, err
+	}
+
+	var result *TransferResult
+	if !balance.IsZero() {
+		if sweepTo == "" {
+			return nil, domain.ErrCardHasBalance
+		}
+
+		result, err = s.postTransfer(v.SendMoneyDto{
+			SendersCard:   change.CardNo,
+			ReceiversCard: sweepTo,
+			Amount:        balance,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error sweeping card balance; %v", err)
+		}
+	}
+
+	s.recordCardStatusChange(card.Status, change)
+	return result, nil
+}
+
+func (s *MemoryStore) GetCardStatusHistory(cardNo string) ([]CardStatusChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history := []CardStatusChange{}
+	for _, change := range s.cardHistory {
+		if change.CardNo == cardNo {
+			history = append(history, change)
+		}
+	}
+	return history, nil
+}
+
+func (s *MemoryStore) SaveCode(code codes.Code) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// a new code supersedes the earlier ones
+	s.oneTimeCodes = slices.DeleteFunc(s.oneTimeCodes, func(record codes.Code) bool {
+		return record.Purpose == code.Purpose && record.Email == code.Email
+	})
+
+	code.Id = s.nextId()
+	code.Attempts = 0
+	s.oneTimeCodes = append(s.oneTimeCodes, code)
+	return nil
+}
+
+func (s *MemoryStore) ConsumeCode(purpose codes.Purpose, email string, codeHashes []string, now time.Time, maxAttempts int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.oneTimeCodes, func(record codes.Code) bool {
+		return record.Purpose == purpose && record.Email == email
+	})
+	if i == -1 {
+		return codes.ErrInvalid
+	}
+
+	result, err := consumeCode(s.oneTimeCodes[i], codeHashes, now, maxAttempts)
+	if result == codeKept {
+		s.oneTimeCodes[i].Attempts++
+	} else {
+		s.oneTimeCodes = slices.Delete(s.oneTimeCodes, i, i+1)
+	}
+	return err
+}
+
+func (s *MemoryStore) DeleteExpiredCodes(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := len(s.oneTimeCodes)
+	s.oneTimeCodes = slices.DeleteFunc(s.oneTimeCodes, func(code codes.Code) bool {
+		return !code.ExpiresAt.After(now)
+	})
+	return count - len(s.oneTimeCodes), nil
+}
+
+func (s *MemoryStore) Transfer(transaction v.SendMoneyDto, now time.Time) (*TransferResult, error) {
+	if transaction.SendersCard == transaction.ReceiversCard {
+		return nil, domain.ErrSendingToSameCard
+	}
+	if !transaction.Amount.IsPositive() {
+		return nil, domain.ErrInvalidTransferAmount
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sendersCard, ok := s.findCard(transaction.SendersCard)
+	if !ok || !isCardUsable(sendersCard, now) {
+		return nil, domain.ErrInvalidSendersCard
+	}
+
+	receiversCard, ok := s.findCard(transaction.ReceiversCard)
+	if !ok || !isCardUsable(receiversCard, now) {
+		return nil, domain.ErrInvalidReceiversCard
+	}
+
+	return s.postTransfer(transaction)
+}
+
+func (s *MemoryStore) postTransfer(transaction v.SendMoneyDto) (*TransferResult, error) {
+	sendersCard, _ := s.findCard(transaction.SendersCard)
+	receiversCard, _ := s.findCard(transaction.ReceiversCard)
+
+	sendersAccount := ledger.CardAccount(transaction.SendersCard)
+	receiversAccount := ledger.CardAccount(transaction.ReceiversCard)
+
+	sendersBalance, err := s.ledgerBalance(sendersAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	insufficientFunds, err := sendersBalance.LessThan(transaction.Amount)
+	if err != nil {
+		return nil, err
+	}
+	if insufficientFunds {
+		return nil, domain.ErrInsufficientFunds
+	}
+
+	newBalance, err := sendersBalance.Sub(transaction.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	transactionId := s.nextId()
+
+	entryId, err := s.postJournalEntry(ledger.NewTransfer(
+		fmt.Sprintf("transaction:%d", transactionId),
+		fmt.Sprintf("Transfer from %v to %v", transaction.SendersCard, transaction.ReceiversCard),
+		sendersAccount,
+		receiversAccount,
+		transaction.Amount,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	sender, _ := s.findUserById(sendersCard.UserId)
+	receiver, _ := s.findUserById(receiversCard.UserId)
+
+	s.transactions = append(s.transactions, TransactionDetails{
+		Id:                transactionId,
+		SendersCard:       transaction.SendersCard,
+		ReceiversCard:     transaction.ReceiversCard,
+		Amount:            transaction.Amount,
+		Status:            "completed",
+		CreatedAt:         time.Now(),
+		SendersUsername:   sender.Username,
+		ReceiversUsername: receiver.Username,
+	})
+
+	return &TransferResult{
+		TransactionId:  int64(transactionId),
+		JournalEntryId: entryId,
+		SendersCard:    transaction.SendersCard,
+		ReceiversCard:  transaction.ReceiversCard,
+		Amount:         transaction.Amount,
+		SendersBalance: newBalance,
+	}, nil
+}
+
+func (s *MemoryStore) GetTransactionsDetailsWhere(username string) ([]TransactionDetails, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transactions := []TransactionDetails{}
+	for _, transaction := range s.transactions {
+		if transaction.SendersUsername == username || transaction.ReceiversUsername == username {
+			transactions = append(transactions, transaction)
+		}
+	}
+	return transactions, nil
+}
+
+func (s *MemoryStore) ensureLedgerAccount(code string, kind ledger.AccountKind, cardNo string) {
+	if _, exists := s.accounts[code]; exists {
+		return
+	}
+
+	s.accounts[code] = ledger.Account{
+		Id:        int64(s.nextId()),
+		Code:      code,
+		Kind:      kind,
+		CardNo:    cardNo,
+		CreatedAt: time.Now(),
+	}
+}
+
+func (s *MemoryStore) postJournalEntry(entry ledger.JournalEntry) (int64, error) {
+	err := entry.Validate()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, posting := range entry.Postings {
+		if _, exists := s.accounts[posting.AccountCode]; !exists {
+			return 0, fmt.Errorf("error posting to account %v; account does not exist", posting.AccountCode)
+		}
+	}
+
+	entry.Id = int64(s.nextId())
+	entry.CreatedAt = time.Now()
+	s.journalEntries = append(s.journalEntries, entry)
+	return entry.Id, nil
+}
+
+func (s *MemoryStore) ledgerBalance(code string) (money.Money, error) {
+	balance := money.Zero(money.DefaultCurrency)
+
+	for _, entry := range s.journalEntries {
+		for _, posting := range entry.Postings {
+			if posting.AccountCode != code {
+				continue
+			}
+
+			var err error
+			balance, err = balance.Add(posting.Amount)
+			if err != nil {
+				return money.Money{}, err
+			}
+		}
+	}
+	return balance, nil
+}
+
+func (s *MemoryStore) GetJournalEntries(reference string) ([]ledger.JournalEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := []ledger.JournalEntry{}
+	for _, entry := range s.journalEntries {
+		if entry.Reference == reference {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (s *MemoryStore) GetTrialBalance() (*ledger.TrialBalance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	codes := []string{}
+	for code := range s.accounts {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+
+	trialBalance := ledger.TrialBalance{
+		Accounts: []ledger.AccountBalance{},
+		Total:    money.Zero(money.DefaultCurrency),
+	}
+
+	for _, code := range codes {
+		balance, err := s.ledgerBalance(code)
+		if err != nil {
+			return nil, err
+		}
+
+		trialBalance.Accounts = append(trialBalance.Accounts, ledger.AccountBalance{
+			AccountCode: code,
+			Kind:        s.accounts[code].Kind,
+			Balance:     balance,
+		})
+
+		trialBalance.Total, err = trialBalance.Total.Add(balance)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &trialBalance, nil
+}
+
+func (s *MemoryStore) CreateIdempotencyRecord(userId int, key, requestHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, record := range s.idempotency {
+		if record.UserId == userId && record.Key == key {
+			return ErrIdempotencyKeyExists
+		}
+	}
+
+	s.idempotency = append(s.idempotency, IdempotencyRecord{
+		Id:          s.nextId(),
+		UserId:      userId,
+		Key:         key,
+		RequestHash: requestHash,
+		CreatedAt:   time.Now(),
+	})
+	return nil
+}
+
+func (s *MemoryStore) GetIdempotencyRecord(userId int, key string) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, record := range s.idempotency {
+		if record.UserId == userId && record.Key == key {
+			return &record, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *MemoryStore) SaveIdempotencyResponse(userId int, key string, statusCode int, responseBody []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, record := range s.idempotency {
+		if record.UserId == userId && record.Key == key {
+			s.idempotency[i].StatusCode = statusCode
+			s.idempotency[i].ResponseBody = slices.Clone(responseBody)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteIdempotencyRecord(userId int, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.idempotency = slices.DeleteFunc(s.idempotency, func(record IdempotencyRecord) bool {
+		return record.UserId == userId && record.Key == key
+	})
+	return nil
+}
+
+func (s *MemoryStore) DeleteIdempotencyRecordsBefore(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := len(s.idempotency)
+	s.idempotency = slices.DeleteFunc(s.idempotency, func(record IdempotencyRecord) bool {
+		return record.CreatedAt.Before(before)
+	})
+	return count - len(s.idempotency), nil
+}
+
+func (s *MemoryStore) CreateSession(session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if slices.ContainsFunc(s.sessions, func(existing Session) bool { return existing.Id == session.Id }) {
+		return fmt.Errorf("%w; duplicate entry for session %v", ErrDuplicateKey, session.Id)
+	}
+
+	s.sessions = append(s.sessions, session)
+	return nil
+}
+
+func (s *MemoryStore) findSession(id string) int {
+	return slices.IndexFunc(s.sessions, func(session Session) bool { return session.Id == id })
+}
+
+func (s *MemoryStore) GetSession(id string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := s.findSession(id)
+	if index == -1 {
+		return nil, sql.ErrNoRows
+	}
+
+	session := s.sessions[index]
+	return &session, nil
+}
+
+func (s *MemoryStore) RotateRefreshToken(id, oldHash, newHash string, now, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := s.findSession(id)
+	if index == -1 {
+		return domain.ErrRefreshTokenInvalid
+	}
+
+	session := &s.sessions[index]
+	if session.RefreshTokenHash != oldHash || session.RevokedAt.Valid {
+		return domain.ErrRefreshTokenInvalid
+	}
+
+	session.PreviousRefreshTokenHash = session.RefreshTokenHash
+	session.RefreshTokenHash = newHash
+	session.LastUsedAt = now
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
+func (s *MemoryStore) GetActiveSessions(userId int, now time.Time) ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := []Session{}
+
+	for _, session := range s.sessions {
+		if session.UserId == userId && session.IsActive(now) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	slices.SortStableFunc(sessions, func(a, b Session) int {
+		return b.LastUsedAt.Compare(a.LastUsedAt)
+	})
+	return sessions, nil
+}
+
+func (s *MemoryStore) RevokeSession(userId int, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := s.findSession(id)
+	if index == -1 || s.sessions[index].UserId != userId || s.sessions[index].RevokedAt.Valid {
+		return domain.ErrSessionNotFound
+	}
+
+	s.sessions[index].RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	return nil
+}
+
+func (s *MemoryStore) RevokeAllSessions(userId int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revoked := 0
+	for i, session := range s.sessions {
+		if session.UserId == userId && !session.RevokedAt.Valid {
+			s.sessions[i].RevokedAt = sql.NullTime{Time: time.Now(), Valid: true}
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+func (s *MemoryStore) DeleteStaleSessions(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := len(s.sessions)
+	s.sessions = slices.DeleteFunc(s.sessions, func(session Session) bool {
+		return session.ExpiresAt.Before(before) || (session.RevokedAt.Valid && session.RevokedAt.Time.Before(before))
+	})
+	return count - len(s.sessions), nil
+}
+
+type recoveryCode struct {
+	userId   int
+	codeHash string
+	used     bool
+}
+
+func (s *MemoryStore) GetTotp(userId int) (*Totp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totps[userId]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &totp, nil
+}
+
+func (s *MemoryStore) SaveTotpSecret(userId int, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.totps[userId].Enabled {
+		return domain.ErrTotpAlreadyEnabled
+	}
+
+	s.totps[userId] = Totp{UserId: userId, Secret: secret, CreatedAt: time.Now()}
+	return nil
+}
+
+func (s *MemoryStore) EnableTotp(userId int, counter int64, recoveryCodeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totps[userId]
+	if !ok || totp.Enabled {
+		return domain.ErrTotpNotEnrolled
+	}
+
+	totp.Enabled = true
+	totp.LastCounter = counter
+	s.totps[userId] = totp
+	s.replaceRecoveryCodes(userId, recoveryCodeHashes)
+	return nil
+}
+
+func (s *MemoryStore) DeleteTotp(userId int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.totps, userId)
+	s.replaceRecoveryCodes(userId, nil)
+	return nil
+}
+
+func (s *MemoryStore) UseTotpCounter(userId int, counter int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totps[userId]
+	if !ok || !totp.Enabled || totp.LastCounter >= counter {
+		return domain.ErrTotpInvalid.WithMessage("Two-factor authentication code has already been used. Please wait for the next one")
+	}
+
+	totp.LastCounter = counter
+	s.totps[userId] = totp
+	return nil
+}
+
+func (s *MemoryStore) UseRecoveryCode(userId int, codeHash string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, code := range s.recoveryCodes {
+		if code.userId == userId && code.codeHash == codeHash && !code.used {
+			s.recoveryCodes[i].used = true
+			return nil
+		}
+	}
+	return domain.ErrTotpInvalid.WithMessage("Invalid recovery code")
+}
+
+func (s *MemoryStore) ReplaceRecoveryCodes(userId int, codeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replaceRecoveryCodes(userId, codeHashes)
+	return nil
+}
+
+func (s *MemoryStore) replaceRecoveryCodes(userId int, codeHashes []string) {
+	s.recoveryCodes = slices.DeleteFunc(s.recoveryCodes, func(code recoveryCode) bool {
+		return code.userId == userId
+	})
+
+	for _, codeHash := range codeHashes {
+		s.recoveryCodes = append(s.recoveryCodes, recoveryCode{userId: userId, codeHash: codeHash})
+	}
+}
+
+func (s *MemoryStore) CountRecoveryCodes(userId int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, code := range s.recoveryCodes {
+		if code.userId == userId && !code.used {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// failed attempts are kept in a lockout.MemoryStore, which has its own lock
+
+func (s *MemoryStore) ReserveAttempt(key string, now time.Time, window time.Duration, allow func(lockout.Attempts) bool) (lockout.Attempts, bool, error) {
+	return s.attempts.ReserveAttempt(key, now, window, allow)
+}
+
+func (s *MemoryStore) ReleaseAttempt(key string) error {
+	return s.attempts.ReleaseAttempt(key)
+}
+
+func (s *MemoryStore) ResetAttempts(key string) error {
+	return s.attempts.ResetAttempts(key)
+}
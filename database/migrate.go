@@ -0,0 +1,236 @@
+package database
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// each dialect has its own directory of migrations under migrations/
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migration files are named <version>_<name>.<up|down>.sql
+var migrationFileRegex = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version   int
+	Name      string
+	Up        string
+	Down      string
+	AppliedAt *time.Time
+}
+
+func (m Migration) IsApplied() bool {
+	return m.AppliedAt != nil
+}
+
+// Migrator applies and rolls back the embedded schema migrations.
+// It uses its own connection as migration files contain multiple statements.
+type Migrator struct {
+	db         *sqlDB
+	migrations []Migration
+}
+
+func NewMigrator(cfg Config) (*Migrator, error) {
+	dialect, err := DialectFor(cfg.Driver)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations, err := loadMigrations(dialect.Name())
+	if err != nil {
+		return nil, fmt.Errorf("error loading migrations; %v", err)
+	}
+
+	db, err := connectToDatabase(dialect, cfg, true)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to database; %v", err)
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT NOT NULL,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (version)
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating schema_migrations table; %v", err)
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func (m *Migrator) Close() error {
+	return m.db.Close()
+}
+
+func loadMigrations(dialect string) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+
+	for _, entry := range entries {
+		matches := migrationFileRegex.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			return nil, fmt.Errorf("invalid migration file name %v", entry.Name())
+		}
+
+		version, _ := strconv.Atoi(matches[1])
+		name, direction := matches[2], matches[3]
+
+		data, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+		if migration.Name != name {
+			return nil, fmt.Errorf("migration version %d has more than one name", version)
+		}
+
+		if direction == "up" {
+			migration.Up = string(data)
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s is missing its up or down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return a.Version - b.Version
+	})
+	return migrations, nil
+}
+
+// Returns every known migration along with when it was applied, if at all
+func (m *Migrator) Status() ([]Migration, error) {
+	rows, err := m.db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appliedAt := map[int]time.Time{}
+	for rows.Next() {
+		var (
+			version int
+			at      time.Time
+		)
+
+		err = rows.Scan(&version, &at)
+		if err != nil {
+			return nil, err
+		}
+		appliedAt[version] = at
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	migrations := slices.Clone(m.migrations)
+	for i := range migrations {
+		if at, ok := appliedAt[migrations[i].Version]; ok {
+			migrations[i].AppliedAt = &at
+		}
+	}
+	return migrations, nil
+}
+
+// Applies all pending migrations in version order.
+// Returns the migrations that were applied.
+func (m *Migrator) Up() ([]Migration, error) {
+	migrations, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []Migration{}
+	for _, migration := range migrations {
+		if migration.IsApplied() {
+			continue
+		}
+
+		log.Printf("applying migration %04d_%s\n", migration.Version, migration.Name)
+
+		// MySQL commits DDL statements implicitly so a migration cannot be
+		// wrapped in a transaction; the version is recorded once it succeeds.
+		// Migration files are run as-is, without placeholder rebinding.
+		_, err = m.db.DB.Exec(migration.Up)
+		if err != nil {
+			return applied, fmt.Errorf("error applying migration %04d_%s; %v", migration.Version, migration.Name, err)
+		}
+
+		_, err = m.db.Exec(
+			"INSERT INTO schema_migrations(version, name) VALUES(?, ?)",
+			migration.Version,
+			migration.Name,
+		)
+		if err != nil {
+			return applied, err
+		}
+
+		applied = append(applied, migration)
+	}
+
+	return applied, nil
+}
+
+// Rolls back the most recently applied migrations, at most steps of them.
+// Returns the migrations that were rolled back.
+func (m *Migrator) Down(steps int) ([]Migration, error) {
+	migrations, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	rolledBack := []Migration{}
+	for i := len(migrations) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+		migration := migrations[i]
+		if !migration.IsApplied() {
+			continue
+		}
+
+		log.Printf("rolling back migration %04d_%s\n", migration.Version, migration.Name)
+
+		_, err = m.db.DB.Exec(migration.Down)
+		if err != nil {
+			return rolledBack, fmt.Errorf("error rolling back migration %04d_%s; %v", migration.Version, migration.Name, err)
+		}
+
+		_, err = m.db.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version)
+		if err != nil {
+			return rolledBack, err
+		}
+
+		rolledBack = append(rolledBack, migration)
+	}
+
+	return rolledBack, nil
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/caleb-mwasikira/tap_gopay/money"
+)
+
+// Cards from before the ledger get an opening balance made of their initial
+// deposit and completed transfers only
+func TestBackfillCardLedgerAccounts(t *testing.T) {
+	cfg := Config{Driver: "sqlite", Name: filepath.Join(t.TempDir(), "tap_gopay.db")}
+
+	migrator, err := NewMigrator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migrator.Close()
+
+	_, err = migrator.Up()
+	if err != nil {
+		t.Fatalf("error migrating database; %v", err)
+	}
+	rolledBack, err := migrator.Down(1)
+	if err != nil || len(rolledBack) != 1 || rolledBack[0].Name != "backfill_card_ledger_accounts" {
+		t.Fatalf("Down(1) = %+v, %v", rolledBack, err)
+	}
+
+	s, err := NewSQLStore(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	alice, bob := createUser(t, s, "alice"), createUser(t, s, "bob")
+
+	// rows as they were written before the ledger
+	legacy := []struct {
+		query string
+		args  []any
+	}{
+		{"INSERT INTO credit_cards(user_id, card_no, cvv, initial_deposit) VALUES(?, ?, ?, ?)", []any{alice.Id, "4000000000000002", "123", "1000.00"}},
+		{"INSERT INTO credit_cards(user_id, card_no, cvv, initial_deposit) VALUES(?, ?, ?, ?)", []any{bob.Id, "4000000000000010", "456", "500.00"}},
+		{"INSERT INTO transactions(senders_card, receivers_card, amount, status) VALUES(?, ?, ?, ?)", []any{"4000000000000002", "4000000000000010", "250.00", "completed"}},
+		{"INSERT INTO transactions(senders_card, receivers_card, amount, status) VALUES(?, ?, ?, ?)", []any{"4000000000000002", "4000000000000010", "100.00", "failed"}},
+		{"INSERT INTO transactions(senders_card, receivers_card, amount, status) VALUES(?, ?, ?, ?)", []any{"4000000000000010", "4000000000000002", "50.00", "pending"}},
+	}
+	for _, row := range legacy {
+		if _, err := s.db.Exec(row.query, row.args...); err != nil {
+			t.Fatalf("error inserting legacy row; %v", err)
+		}
+	}
+
+	applied, err := migrator.Up()
+	if err != nil || len(applied) != 1 {
+		t.Fatalf("Up() = %+v, %v", applied, err)
+	}
+
+	if balance := balanceOf(t, s, alice, "4000000000000002"); balance != money.KES(750_00) {
+		t.Errorf("alice's opening balance = %v, want %v", balance, money.KES(750_00))
+	}
+	if balance := balanceOf(t, s, bob, "4000000000000010"); balance != money.KES(750_00) {
+		t.Errorf("bob's opening balance = %v, want %v", balance, money.KES(750_00))
+	}
+
+	trialBalance, err := s.GetTrialBalance()
+	if err != nil || !trialBalance.IsBalanced() {
+		t.Errorf("GetTrialBalance() = %+v, %v", trialBalance, err)
+	}
+}
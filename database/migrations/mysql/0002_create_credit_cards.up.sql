@@ -0,0 +1,12 @@
+CREATE TABLE credit_cards (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    card_no VARCHAR(20) NOT NULL,
+    cvv VARCHAR(4) NOT NULL,
+    initial_deposit DECIMAL(19, 2) NOT NULL DEFAULT 0,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY credit_cards_card_no_unique (card_no),
+    CONSTRAINT credit_cards_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id)
+);
@@ -0,0 +1,41 @@
+CREATE TABLE transactions (
+    id INT NOT NULL AUTO_INCREMENT,
+    senders_card VARCHAR(20) NOT NULL,
+    receivers_card VARCHAR(20) NOT NULL,
+    amount DECIMAL(19, 2) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY transactions_senders_card_index (senders_card),
+    KEY transactions_receivers_card_index (receivers_card),
+    CONSTRAINT transactions_senders_card_fk FOREIGN KEY (senders_card) REFERENCES credit_cards (card_no),
+    CONSTRAINT transactions_receivers_card_fk FOREIGN KEY (receivers_card) REFERENCES credit_cards (card_no)
+);
+
+-- Last line of defence for rules that are also enforced in Go.
+-- Messages raised with SIGNAL SQLSTATE '45000' surface as MySQL error 1644
+-- and the text after the last ':' is shown to API clients.
+CREATE TRIGGER transactions_before_insert
+BEFORE INSERT ON transactions
+FOR EACH ROW
+BEGIN
+    IF NEW.amount <= 0 THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Transaction error: transfer amount must be greater than zero';
+    END IF;
+
+    IF NEW.senders_card = NEW.receivers_card THEN
+        SIGNAL SQLSTATE '45000'
+        SET MESSAGE_TEXT = 'Transaction error: senders and receivers credit card cannot be the same';
+    END IF;
+END;
+
+CREATE VIEW transaction_details AS
+SELECT t.id, t.senders_card, t.receivers_card, t.amount, t.status, t.created_at,
+sender.username AS senders_username,
+receiver.username AS receivers_username
+FROM transactions t
+INNER JOIN credit_cards scc ON scc.card_no = t.senders_card
+INNER JOIN users sender ON sender.id = scc.user_id
+INNER JOIN credit_cards rcc ON rcc.card_no = t.receivers_card
+INNER JOIN users receiver ON receiver.id = rcc.user_id;
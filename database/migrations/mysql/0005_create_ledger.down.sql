@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS postings_before_delete;
+DROP TRIGGER IF EXISTS postings_before_update;
+DROP TABLE IF EXISTS postings;
+DROP TABLE IF EXISTS journal_entries;
+DROP TABLE IF EXISTS ledger_accounts;
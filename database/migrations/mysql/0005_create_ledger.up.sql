@@ -0,0 +1,55 @@
+-- Card balances are derived from postings; there is no balances table.
+CREATE TABLE ledger_accounts (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    code VARCHAR(64) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    card_no VARCHAR(20) NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY ledger_accounts_code_unique (code),
+    UNIQUE KEY ledger_accounts_card_no_unique (card_no),
+    CONSTRAINT ledger_accounts_card_no_fk FOREIGN KEY (card_no) REFERENCES credit_cards (card_no)
+);
+
+CREATE TABLE journal_entries (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    reference VARCHAR(64) NOT NULL,
+    description VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY journal_entries_reference_index (reference)
+);
+
+CREATE TABLE postings (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    journal_entry_id BIGINT NOT NULL,
+    account_id BIGINT NOT NULL,
+    amount DECIMAL(19, 2) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY postings_account_id_index (account_id),
+    CONSTRAINT postings_journal_entry_id_fk FOREIGN KEY (journal_entry_id) REFERENCES journal_entries (id),
+    CONSTRAINT postings_account_id_fk FOREIGN KEY (account_id) REFERENCES ledger_accounts (id)
+);
+
+-- the ledger is append-only; mistakes are corrected with reversing entries
+CREATE TRIGGER postings_before_update
+BEFORE UPDATE ON postings
+FOR EACH ROW
+BEGIN
+    SIGNAL SQLSTATE '45000'
+    SET MESSAGE_TEXT = 'Ledger error: postings cannot be modified';
+END;
+
+CREATE TRIGGER postings_before_delete
+BEFORE DELETE ON postings
+FOR EACH ROW
+BEGIN
+    SIGNAL SQLSTATE '45000'
+    SET MESSAGE_TEXT = 'Ledger error: postings cannot be deleted';
+END;
+
+INSERT INTO ledger_accounts(code, kind) VALUES
+    ('system:deposits', 'system'),
+    ('system:fees', 'system'),
+    ('system:suspense', 'system');
@@ -0,0 +1,12 @@
+CREATE TABLE idempotency_keys (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status_code INT NULL,
+    response_body MEDIUMBLOB NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY idempotency_keys_user_key_unique (user_id, idempotency_key),
+    CONSTRAINT idempotency_keys_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id)
+);
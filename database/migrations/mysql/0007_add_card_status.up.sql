@@ -0,0 +1,22 @@
+-- is_active is replaced by a card state machine; deactivated cards become frozen
+ALTER TABLE credit_cards
+    ADD COLUMN status VARCHAR(10) NOT NULL DEFAULT 'active' AFTER initial_deposit,
+    ADD CONSTRAINT credit_cards_status_check CHECK (status IN ('active', 'frozen', 'blocked', 'closed'));
+
+UPDATE credit_cards SET status = 'frozen' WHERE is_active = FALSE;
+
+ALTER TABLE credit_cards DROP COLUMN is_active;
+
+CREATE TABLE card_status_history (
+    id INT NOT NULL AUTO_INCREMENT,
+    card_no VARCHAR(20) NOT NULL,
+    from_status VARCHAR(10) NOT NULL,
+    to_status VARCHAR(10) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    changed_by INT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY card_status_history_card_no_index (card_no),
+    CONSTRAINT card_status_history_card_no_fk FOREIGN KEY (card_no) REFERENCES credit_cards (card_no),
+    CONSTRAINT card_status_history_changed_by_fk FOREIGN KEY (changed_by) REFERENCES users (id)
+);
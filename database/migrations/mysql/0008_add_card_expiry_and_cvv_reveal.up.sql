@@ -0,0 +1,11 @@
+-- CVVs are stored encrypted from now on. Existing plain text CVVs
+-- are encrypted in place with the encrypt-cvvs command.
+ALTER TABLE credit_cards
+    MODIFY cvv VARCHAR(255) NOT NULL,
+    ADD COLUMN expiry_month INT NOT NULL DEFAULT 0 AFTER cvv,
+    ADD COLUMN expiry_year INT NOT NULL DEFAULT 0 AFTER expiry_month,
+    ADD COLUMN cvv_revealed_at TIMESTAMP NULL AFTER expiry_year;
+
+-- existing cards expire four years after they were issued
+UPDATE credit_cards
+SET expiry_month = MONTH(created_at), expiry_year = YEAR(created_at) + 4;
@@ -0,0 +1,3 @@
+ALTER TABLE credit_cards
+    DROP COLUMN pin_attempts,
+    DROP COLUMN pin_hash;
@@ -0,0 +1,4 @@
+-- pin_hash is NULL until the card owner sets a PIN
+ALTER TABLE credit_cards
+    ADD COLUMN pin_hash VARCHAR(255) NULL AFTER cvv_revealed_at,
+    ADD COLUMN pin_attempts INT NOT NULL DEFAULT 0 AFTER pin_hash;
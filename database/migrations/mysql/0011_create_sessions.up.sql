@@ -0,0 +1,16 @@
+-- a login session per device; the refresh token is stored as its SHA-256 hash
+CREATE TABLE sessions (
+    id CHAR(32) NOT NULL,
+    user_id INT NOT NULL,
+    refresh_token_hash CHAR(64) NOT NULL,
+    previous_refresh_token_hash CHAR(64) NULL,
+    user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP NULL,
+    PRIMARY KEY (id),
+    KEY sessions_user_id_index (user_id),
+    CONSTRAINT sessions_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id)
+);
@@ -0,0 +1,21 @@
+-- TOTP two-factor authentication; the secret is sealed with a data encryption key
+CREATE TABLE user_totp (
+    user_id INT NOT NULL,
+    secret VARCHAR(255) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    last_counter BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id),
+    CONSTRAINT user_totp_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id)
+);
+
+-- single use codes for logging in without the authenticator app
+CREATE TABLE recovery_codes (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP NULL,
+    PRIMARY KEY (id),
+    KEY recovery_codes_user_id_index (user_id),
+    CONSTRAINT recovery_codes_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id)
+);
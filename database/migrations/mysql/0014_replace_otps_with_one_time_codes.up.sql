@@ -0,0 +1,16 @@
+-- one-time codes emailed to users, of every purpose; only a keyed hash of each code is stored
+CREATE TABLE one_time_codes (
+    id INT NOT NULL AUTO_INCREMENT,
+    purpose VARCHAR(32) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (id),
+    KEY one_time_codes_purpose_email_index (purpose, email)
+);
+
+-- codes in the old tables were stored in plain text; users request new ones
+DROP TABLE IF EXISTS password_reset_tokens;
+DROP TABLE IF EXISTS otps;
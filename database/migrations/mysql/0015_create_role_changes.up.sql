@@ -0,0 +1,14 @@
+-- audit trail of changes to the roles of users
+CREATE TABLE role_changes (
+    id INT NOT NULL AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    from_role VARCHAR(20) NOT NULL,
+    to_role VARCHAR(20) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    changed_by INT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY role_changes_user_id_index (user_id),
+    CONSTRAINT role_changes_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id),
+    CONSTRAINT role_changes_changed_by_fk FOREIGN KEY (changed_by) REFERENCES users (id)
+);
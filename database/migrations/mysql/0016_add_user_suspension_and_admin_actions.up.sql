@@ -0,0 +1,17 @@
+-- suspended users cannot log in; is_active only says the email is verified
+ALTER TABLE users ADD COLUMN is_suspended BOOLEAN NOT NULL DEFAULT FALSE AFTER is_active;
+
+-- audit trail of the actions admins take on users and their cards
+CREATE TABLE admin_actions (
+    id INT NOT NULL AUTO_INCREMENT,
+    admin_id INT NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    user_id INT NOT NULL,
+    card_no VARCHAR(20) NULL,
+    reason VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY admin_actions_user_id_index (user_id),
+    CONSTRAINT admin_actions_admin_id_fk FOREIGN KEY (admin_id) REFERENCES users (id),
+    CONSTRAINT admin_actions_user_id_fk FOREIGN KEY (user_id) REFERENCES users (id)
+);
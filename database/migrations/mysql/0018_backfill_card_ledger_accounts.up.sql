@@ -0,0 +1,41 @@
+-- Cards created before the ledger have no ledger account, which leaves them
+-- with a zero balance and unable to send or receive money.
+-- Their balance is worked out the way the old balances table kept it: the
+-- initial deposit plus the completed transfers received minus the completed
+-- transfers sent; failed and pending transfers never moved any money.
+-- The opening balances are posted from system:deposits as one journal entry.
+INSERT INTO ledger_accounts(code, kind, card_no)
+SELECT CONCAT('card:', cc.card_no), 'card', cc.card_no
+FROM credit_cards cc
+WHERE NOT EXISTS (SELECT 1 FROM ledger_accounts la WHERE la.card_no = cc.card_no);
+
+INSERT INTO journal_entries(reference, description)
+VALUES ('opening-balances', 'Opening balances of cards created before the ledger');
+
+-- card accounts without postings have never been part of the ledger
+INSERT INTO postings(journal_entry_id, account_id, amount)
+SELECT je.id, b.account_id, b.balance
+FROM (
+    SELECT la.id AS account_id,
+    cc.initial_deposit
+        + COALESCE((SELECT SUM(t.amount) FROM transactions t WHERE t.receivers_card = cc.card_no AND t.status = 'completed'), 0)
+        - COALESCE((SELECT SUM(t.amount) FROM transactions t WHERE t.senders_card = cc.card_no AND t.status = 'completed'), 0) AS balance
+    FROM ledger_accounts la
+    INNER JOIN credit_cards cc ON cc.card_no = la.card_no
+    WHERE NOT EXISTS (SELECT 1 FROM postings p WHERE p.account_id = la.id)
+) b
+INNER JOIN journal_entries je ON je.reference = 'opening-balances'
+WHERE b.balance <> 0;
+
+INSERT INTO postings(journal_entry_id, account_id, amount)
+SELECT p.journal_entry_id, d.id, -SUM(p.amount)
+FROM postings p
+INNER JOIN journal_entries je ON je.id = p.journal_entry_id
+INNER JOIN ledger_accounts d ON d.code = 'system:deposits'
+WHERE je.reference = 'opening-balances'
+GROUP BY p.journal_entry_id, d.id
+HAVING SUM(p.amount) <> 0;
+
+DELETE FROM journal_entries
+WHERE reference = 'opening-balances'
+AND NOT EXISTS (SELECT 1 FROM postings p WHERE p.journal_entry_id = journal_entries.id);
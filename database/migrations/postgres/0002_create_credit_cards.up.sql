@@ -0,0 +1,10 @@
+CREATE TABLE credit_cards (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users (id),
+    card_no VARCHAR(20) NOT NULL,
+    cvv VARCHAR(4) NOT NULL,
+    initial_deposit NUMERIC(19, 2) NOT NULL DEFAULT 0,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT credit_cards_card_no_unique UNIQUE (card_no)
+);
@@ -0,0 +1,3 @@
+DROP VIEW IF EXISTS transaction_details;
+DROP TABLE IF EXISTS transactions;
+DROP FUNCTION IF EXISTS transactions_before_insert();
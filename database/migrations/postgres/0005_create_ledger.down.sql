@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS postings;
+DROP FUNCTION IF EXISTS postings_immutable();
+DROP TABLE IF EXISTS journal_entries;
+DROP TABLE IF EXISTS ledger_accounts;
@@ -0,0 +1,45 @@
+-- Card balances are derived from postings; there is no balances table.
+CREATE TABLE ledger_accounts (
+    id BIGSERIAL PRIMARY KEY,
+    code VARCHAR(64) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    card_no VARCHAR(20) NULL REFERENCES credit_cards (card_no),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT ledger_accounts_code_unique UNIQUE (code),
+    CONSTRAINT ledger_accounts_card_no_unique UNIQUE (card_no)
+);
+
+CREATE TABLE journal_entries (
+    id BIGSERIAL PRIMARY KEY,
+    reference VARCHAR(64) NOT NULL,
+    description VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX journal_entries_reference_index ON journal_entries (reference);
+
+CREATE TABLE postings (
+    id BIGSERIAL PRIMARY KEY,
+    journal_entry_id BIGINT NOT NULL REFERENCES journal_entries (id),
+    account_id BIGINT NOT NULL REFERENCES ledger_accounts (id),
+    amount NUMERIC(19, 2) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX postings_account_id_index ON postings (account_id);
+
+-- the ledger is append-only; mistakes are corrected with reversing entries
+CREATE FUNCTION postings_immutable() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'Ledger error: postings cannot be modified or deleted';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER postings_before_update_or_delete
+BEFORE UPDATE OR DELETE ON postings
+FOR EACH ROW EXECUTE FUNCTION postings_immutable();
+
+INSERT INTO ledger_accounts(code, kind) VALUES
+    ('system:deposits', 'system'),
+    ('system:fees', 'system'),
+    ('system:suspense', 'system');
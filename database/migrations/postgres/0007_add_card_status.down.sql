@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS card_status_history;
+
+ALTER TABLE credit_cards ADD COLUMN is_active BOOLEAN NOT NULL DEFAULT TRUE;
+
+UPDATE credit_cards SET is_active = (status = 'active');
+
+ALTER TABLE credit_cards
+    DROP CONSTRAINT credit_cards_status_check,
+    DROP COLUMN status;
@@ -0,0 +1,20 @@
+-- is_active is replaced by a card state machine; deactivated cards become frozen
+ALTER TABLE credit_cards
+    ADD COLUMN status VARCHAR(10) NOT NULL DEFAULT 'active',
+    ADD CONSTRAINT credit_cards_status_check CHECK (status IN ('active', 'frozen', 'blocked', 'closed'));
+
+UPDATE credit_cards SET status = 'frozen' WHERE is_active = FALSE;
+
+ALTER TABLE credit_cards DROP COLUMN is_active;
+
+CREATE TABLE card_status_history (
+    id SERIAL PRIMARY KEY,
+    card_no VARCHAR(20) NOT NULL REFERENCES credit_cards (card_no),
+    from_status VARCHAR(10) NOT NULL,
+    to_status VARCHAR(10) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    changed_by INT NULL REFERENCES users (id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX card_status_history_card_no_index ON card_status_history (card_no);
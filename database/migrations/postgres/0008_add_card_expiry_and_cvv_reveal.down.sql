@@ -0,0 +1,5 @@
+-- cvv is left wide enough to hold the encrypted values
+ALTER TABLE credit_cards
+    DROP COLUMN cvv_revealed_at,
+    DROP COLUMN expiry_year,
+    DROP COLUMN expiry_month;
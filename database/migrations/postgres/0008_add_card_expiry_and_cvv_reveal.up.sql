@@ -0,0 +1,12 @@
+-- CVVs are stored encrypted from now on. Existing plain text CVVs
+-- are encrypted in place with the encrypt-cvvs command.
+ALTER TABLE credit_cards
+    ALTER COLUMN cvv TYPE VARCHAR(255),
+    ADD COLUMN expiry_month INT NOT NULL DEFAULT 0,
+    ADD COLUMN expiry_year INT NOT NULL DEFAULT 0,
+    ADD COLUMN cvv_revealed_at TIMESTAMP NULL;
+
+-- existing cards expire four years after they were issued
+UPDATE credit_cards
+SET expiry_month = EXTRACT(MONTH FROM created_at)::INT,
+    expiry_year = EXTRACT(YEAR FROM created_at)::INT + 4;
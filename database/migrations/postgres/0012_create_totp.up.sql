@@ -0,0 +1,18 @@
+-- TOTP two-factor authentication; the secret is sealed with a data encryption key
+CREATE TABLE user_totp (
+    user_id INT PRIMARY KEY REFERENCES users (id),
+    secret VARCHAR(255) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    last_counter BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- single use codes for logging in without the authenticator app
+CREATE TABLE recovery_codes (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users (id),
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP NULL
+);
+
+CREATE INDEX recovery_codes_user_id_index ON recovery_codes (user_id);
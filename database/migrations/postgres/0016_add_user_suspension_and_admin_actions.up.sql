@@ -0,0 +1,15 @@
+-- suspended users cannot log in; is_active only says the email is verified
+ALTER TABLE users ADD COLUMN is_suspended BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- audit trail of the actions admins take on users and their cards
+CREATE TABLE admin_actions (
+    id SERIAL PRIMARY KEY,
+    admin_id INT NOT NULL REFERENCES users (id),
+    action VARCHAR(50) NOT NULL,
+    user_id INT NOT NULL REFERENCES users (id),
+    card_no VARCHAR(20) NULL,
+    reason VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX admin_actions_user_id_index ON admin_actions (user_id);
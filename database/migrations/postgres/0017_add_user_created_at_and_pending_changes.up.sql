@@ -0,0 +1,6 @@
+-- users who signed up before this migration get the time it ran
+ALTER TABLE users ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+-- profile changes waiting for the user to enter the code sent to them
+ALTER TABLE users ADD COLUMN pending_email VARCHAR(255) NULL;
+ALTER TABLE users ADD COLUMN pending_phone_no VARCHAR(20) NULL;
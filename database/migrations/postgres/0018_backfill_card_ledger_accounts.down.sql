@@ -0,0 +1,3 @@
+-- The opening balances are part of the append-only ledger and every later
+-- transfer builds on them, so they are left in place.
+SELECT 1;
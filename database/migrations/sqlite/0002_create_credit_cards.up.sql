@@ -0,0 +1,9 @@
+CREATE TABLE credit_cards (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users (id),
+    card_no VARCHAR(20) NOT NULL UNIQUE,
+    cvv VARCHAR(4) NOT NULL,
+    initial_deposit DECIMAL(19, 2) NOT NULL DEFAULT 0,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
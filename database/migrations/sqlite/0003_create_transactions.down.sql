@@ -0,0 +1,3 @@
+DROP VIEW IF EXISTS transaction_details;
+DROP TRIGGER IF EXISTS transactions_before_insert;
+DROP TABLE IF EXISTS transactions;
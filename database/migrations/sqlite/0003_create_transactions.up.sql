@@ -0,0 +1,35 @@
+CREATE TABLE transactions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    senders_card VARCHAR(20) NOT NULL REFERENCES credit_cards (card_no),
+    receivers_card VARCHAR(20) NOT NULL REFERENCES credit_cards (card_no),
+    amount DECIMAL(19, 2) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX transactions_senders_card_index ON transactions (senders_card);
+CREATE INDEX transactions_receivers_card_index ON transactions (receivers_card);
+
+-- Last line of defence for rules that are also enforced in Go.
+-- RAISE(ABORT, ...) surfaces as SQLITE_CONSTRAINT_TRIGGER and the text after
+-- the last ':' is translated into the matching Go error.
+CREATE TRIGGER transactions_before_insert
+BEFORE INSERT ON transactions
+FOR EACH ROW
+BEGIN
+    SELECT RAISE(ABORT, 'Transaction error: transfer amount must be greater than zero')
+    WHERE NEW.amount <= 0;
+
+    SELECT RAISE(ABORT, 'Transaction error: senders and receivers credit card cannot be the same')
+    WHERE NEW.senders_card = NEW.receivers_card;
+END;
+
+CREATE VIEW transaction_details AS
+SELECT t.id, t.senders_card, t.receivers_card, t.amount, t.status, t.created_at,
+sender.username AS senders_username,
+receiver.username AS receivers_username
+FROM transactions t
+INNER JOIN credit_cards scc ON scc.card_no = t.senders_card
+INNER JOIN users sender ON sender.id = scc.user_id
+INNER JOIN credit_cards rcc ON rcc.card_no = t.receivers_card
+INNER JOIN users receiver ON receiver.id = rcc.user_id;
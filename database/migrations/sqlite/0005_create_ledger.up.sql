@@ -0,0 +1,45 @@
+-- Card balances are derived from postings; there is no balances table.
+CREATE TABLE ledger_accounts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code VARCHAR(64) NOT NULL UNIQUE,
+    kind VARCHAR(16) NOT NULL,
+    card_no VARCHAR(20) NULL UNIQUE REFERENCES credit_cards (card_no),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE journal_entries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    reference VARCHAR(64) NOT NULL,
+    description VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX journal_entries_reference_index ON journal_entries (reference);
+
+CREATE TABLE postings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    journal_entry_id INTEGER NOT NULL REFERENCES journal_entries (id),
+    account_id INTEGER NOT NULL REFERENCES ledger_accounts (id),
+    amount DECIMAL(19, 2) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX postings_account_id_index ON postings (account_id);
+
+-- the ledger is append-only; mistakes are corrected with reversing entries
+CREATE TRIGGER postings_before_update
+BEFORE UPDATE ON postings
+BEGIN
+    SELECT RAISE(ABORT, 'Ledger error: postings cannot be modified');
+END;
+
+CREATE TRIGGER postings_before_delete
+BEFORE DELETE ON postings
+BEGIN
+    SELECT RAISE(ABORT, 'Ledger error: postings cannot be deleted');
+END;
+
+INSERT INTO ledger_accounts(code, kind) VALUES
+    ('system:deposits', 'system'),
+    ('system:fees', 'system'),
+    ('system:suspense', 'system');
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS card_status_history;
+
+ALTER TABLE credit_cards ADD COLUMN is_active BOOLEAN NOT NULL DEFAULT TRUE;
+
+UPDATE credit_cards SET is_active = (status = 'active');
+
+ALTER TABLE credit_cards DROP COLUMN status;
@@ -0,0 +1,3 @@
+ALTER TABLE credit_cards DROP COLUMN cvv_revealed_at;
+ALTER TABLE credit_cards DROP COLUMN expiry_year;
+ALTER TABLE credit_cards DROP COLUMN expiry_month;
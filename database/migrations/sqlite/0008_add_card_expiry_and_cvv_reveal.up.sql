@@ -0,0 +1,11 @@
+-- CVVs are stored encrypted from now on. Existing plain text CVVs
+-- are encrypted in place with the encrypt-cvvs command.
+-- SQLite does not enforce VARCHAR lengths so cvv needs no change.
+ALTER TABLE credit_cards ADD COLUMN expiry_month INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE credit_cards ADD COLUMN expiry_year INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE credit_cards ADD COLUMN cvv_revealed_at TIMESTAMP NULL;
+
+-- existing cards expire four years after they were issued
+UPDATE credit_cards
+SET expiry_month = CAST(strftime('%m', created_at) AS INTEGER),
+    expiry_year = CAST(strftime('%Y', created_at) AS INTEGER) + 4;
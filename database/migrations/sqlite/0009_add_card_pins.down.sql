@@ -0,0 +1,2 @@
+ALTER TABLE credit_cards DROP COLUMN pin_attempts;
+ALTER TABLE credit_cards DROP COLUMN pin_hash;
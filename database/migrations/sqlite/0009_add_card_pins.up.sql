@@ -0,0 +1,3 @@
+-- pin_hash is NULL until the card owner sets a PIN
+ALTER TABLE credit_cards ADD COLUMN pin_hash VARCHAR(255) NULL;
+ALTER TABLE credit_cards ADD COLUMN pin_attempts INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,7 @@
+-- failed login and code attempts per account or IP address, shared by every server instance
+CREATE TABLE failed_attempts (
+    attempt_key VARCHAR(255) NOT NULL,
+    failures INT NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (attempt_key)
+);
@@ -0,0 +1,12 @@
+-- audit trail of changes to the roles of users
+CREATE TABLE role_changes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users (id),
+    from_role VARCHAR(20) NOT NULL,
+    to_role VARCHAR(20) NOT NULL,
+    reason VARCHAR(255) NOT NULL DEFAULT '',
+    changed_by INTEGER NULL REFERENCES users (id),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX role_changes_user_id_index ON role_changes (user_id);
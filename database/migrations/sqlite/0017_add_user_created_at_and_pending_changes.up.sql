@@ -0,0 +1,9 @@
+-- sqlite cannot add a column defaulting to CURRENT_TIMESTAMP; new users are
+-- given their created_at by the application and existing users the time this
+-- migration ran
+ALTER TABLE users ADD COLUMN created_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
+UPDATE users SET created_at = CURRENT_TIMESTAMP;
+
+-- profile changes waiting for the user to enter the code sent to them
+ALTER TABLE users ADD COLUMN pending_email VARCHAR(255) NULL;
+ALTER TABLE users ADD COLUMN pending_phone_no VARCHAR(20) NULL;
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/codes"
+)
+
+func (s *SQLStore) SaveCode(code codes.Code) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// a new code supersedes the earlier ones
+	_, err = tx.Exec("DELETE FROM one_time_codes WHERE purpose = ? AND email = ?", code.Purpose, code.Email)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO one_time_codes(purpose, email, code_hash, attempts, created_at, expires_at) VALUES(?, ?, ?, ?, ?, ?)",
+		code.Purpose, code.Email, code.CodeHash, 0, code.CreatedAt, code.ExpiresAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The code is locked with SELECT ... FOR UPDATE, so concurrent requests
+// with the same code are accepted at most once
+func (s *SQLStore) ConsumeCode(purpose codes.Purpose, email string, codeHashes []string, now time.Time, maxAttempts int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	code := codes.Code{}
+	err = tx.QueryRow(`
+		SELECT id, code_hash, attempts, expires_at FROM one_time_codes
+		WHERE purpose = ?
+		AND email = ?
+		ORDER BY id DESC
+		LIMIT 1`+tx.dialect.ForUpdate(),
+		purpose, email,
+	).Scan(&code.Id, &code.CodeHash, &code.Attempts, &code.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return codes.ErrInvalid
+		}
+		return err
+	}
+
+	result, consumeErr := consumeCode(code, codeHashes, now, maxAttempts)
+	if result == codeKept {
+		_, err = tx.Exec("UPDATE one_time_codes SET attempts = ? WHERE id = ?", code.Attempts+1, code.Id)
+	} else {
+		_, err = tx.Exec("DELETE FROM one_time_codes WHERE id = ?", code.Id)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	return consumeErr
+}
+
+func (s *SQLStore) DeleteExpiredCodes(now time.Time) (int, error) {
+	result, err := s.db.Exec("DELETE FROM one_time_codes WHERE expires_at <= ?", now)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
+
+type codeResult int
+
+const (
+	codeDeleted codeResult = iota // accepted, expired or guessed wrong too often
+	codeKept                      // guessed wrong; counts the attempt
+)
+
+// Decides what happens to a stored code given the hashes of a guess.
+// Shared by SQLStore and MemoryStore.
+func consumeCode(code codes.Code, codeHashes []string, now time.Time, maxAttempts int) (codeResult, error) {
+	if !code.ExpiresAt.After(now) {
+		return codeDeleted, codes.ErrExpired
+	}
+
+	for _, codeHash := range codeHashes {
+		if subtle.ConstantTimeCompare([]byte(codeHash), []byte(code.CodeHash)) == 1 {
+			return codeDeleted, nil
+		}
+	}
+
+	if code.Attempts+1 >= maxAttempts {
+		return codeDeleted, codes.ErrTooManyAttempts
+	}
+	return codeKept, codes.ErrInvalid
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+)
+
+// RoleChange is an entry in the audit trail of a user's role
+type RoleChange struct {
+	Id        int         `json:"-"`
+	UserId    int         `json:"user_id"`
+	From      domain.Role `json:"from_role"`
+	To        domain.Role `json:"to_role"`
+	Reason    string      `json:"reason"`
+	ChangedBy int         `json:"changed_by,omitempty"` // 0 for changes made from the command line
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// Returns an error if the user, now in the current role, cannot be given the new one
+func checkRoleChange(current domain.Role, change RoleChange) error {
+	if !change.To.IsValid() {
+		return domain.ErrValidationFailed.WithMessage("Invalid role %q", change.To)
+	}
+	if current == change.To {
+		return domain.ErrRoleUnchanged
+	}
+	return nil
+}
+
+// Gives a user a new role and records the change in the audit trail.
+// Returns domain.ErrUserNotFound if there is no such user and
+// domain.ErrRoleUnchanged if they already have the role.
+func (s *SQLStore) ChangeUserRole(change RoleChange) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current domain.Role
+	err = tx.QueryRow("SELECT role FROM users WHERE id = ?"+tx.dialect.ForUpdate(), change.UserId).Scan(&current)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return domain.ErrUserNotFound
+		}
+		return err
+	}
+
+	err = checkRoleChange(current, change)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE users SET role = ? WHERE id = ?", change.To, change.UserId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`
+			INSERT INTO role_changes(user_id, from_role, to_role, reason, changed_by)
+			VALUES(?, ?, ?, ?, ?)
+		`,
+		change.UserId,
+		current,
+		change.To,
+		change.Reason,
+		sql.NullInt64{Int64: int64(change.ChangedBy), Valid: change.ChangedBy != 0},
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Returns the role changes of a user, oldest first
+func (s *SQLStore) GetRoleChanges(userId int) ([]RoleChange, error) {
+	query := `
+		SELECT id, user_id, from_role, to_role, reason, changed_by, created_at
+		FROM role_changes
+		WHERE user_id = ?
+		ORDER BY id
+	`
+
+	rows, err := s.db.Query(query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []RoleChange{}
+
+	for rows.Next() {
+		change := RoleChange{}
+		changedBy := sql.NullInt64{}
+
+		err = rows.Scan(
+			&change.Id,
+			&change.UserId,
+			&change.From,
+			&change.To,
+			&change.Reason,
+			&changedBy,
+			&change.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		change.ChangedBy = int(changedBy.Int64)
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,187 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+)
+
+// Session is a login of a user on one device.
+// Access tokens name their session in the sid claim and stop working once it
+// is revoked or expires. The refresh token is only stored as a hash.
+type Session struct {
+	Id                       string       `json:"id"`
+	UserId                   int          `json:"user_id"`
+	RefreshTokenHash         string       `json:"-"`
+	PreviousRefreshTokenHash string       `json:"-"` // detects reuse of a rotated refresh token
+	UserAgent                string       `json:"user_agent"`
+	IpAddress                string       `json:"ip_address"`
+	CreatedAt                time.Time    `json:"created_at"`
+	LastUsedAt               time.Time    `json:"last_used_at"`
+	ExpiresAt                time.Time    `json:"expires_at"`
+	RevokedAt                sql.NullTime `json:"-"`
+}
+
+func (s Session) IsActive(now time.Time) bool {
+	return !s.RevokedAt.Valid && s.ExpiresAt.After(now)
+}
+
+const sessionColumns string = `
+	id, user_id, refresh_token_hash, COALESCE(previous_refresh_token_hash, ''),
+	user_agent, ip_address, created_at, last_used_at, expires_at, revoked_at
+`
+
+func (s *SQLStore) CreateSession(session Session) error {
+	query := `
+		INSERT INTO sessions(id, user_id, refresh_token_hash, user_agent, ip_address, created_at, last_used_at, expires_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := s.db.Exec(
+		query,
+		session.Id,
+		session.UserId,
+		session.RefreshTokenHash,
+		session.UserAgent,
+		session.IpAddress,
+		session.CreatedAt,
+		session.LastUsedAt,
+		session.ExpiresAt,
+	)
+	return err
+}
+
+// Returns sql.ErrNoRows if there is no session with the id
+func (s *SQLStore) GetSession(id string) (*Session, error) {
+	row := s.db.QueryRow("SELECT "+sessionColumns+" FROM sessions WHERE id = ?", id)
+	return scanSession(row)
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSession(row rowScanner) (*Session, error) {
+	session := Session{}
+	err := row.Scan(
+		&session.Id,
+		&session.UserId,
+		&session.RefreshTokenHash,
+		&session.PreviousRefreshTokenHash,
+		&session.UserAgent,
+		&session.IpAddress,
+		&session.CreatedAt,
+		&session.LastUsedAt,
+		&session.ExpiresAt,
+		&session.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Replaces the refresh token of an unrevoked session, provided it still has
+// the refresh token hashed as oldHash. Two requests racing to refresh with
+// the same token cannot both succeed; the loser gets domain.ErrRefreshTokenInvalid.
+func (s *SQLStore) RotateRefreshToken(id, oldHash, newHash string, now, expiresAt time.Time) error {
+	query := `
+		UPDATE sessions
+		SET previous_refresh_token_hash = refresh_token_hash, refresh_token_hash = ?, last_used_at = ?, expires_at = ?
+		WHERE id = ? AND refresh_token_hash = ? AND revoked_at IS NULL
+	`
+
+	result, err := s.db.Exec(query, newHash, now, expiresAt, id, oldHash)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return domain.ErrRefreshTokenInvalid
+	}
+	return nil
+}
+
+// Returns the sessions of a user that are unrevoked and unexpired at now,
+// most recently used first
+func (s *SQLStore) GetActiveSessions(userId int, now time.Time) ([]Session, error) {
+	query := "SELECT " + sessionColumns + " FROM sessions WHERE user_id = ? AND revoked_at IS NULL ORDER BY last_used_at DESC"
+
+	rows, err := s.db.Query(query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		if session.IsActive(now) {
+			sessions = append(sessions, *session)
+		}
+	}
+	return sessions, rows.Err()
+}
+
+// Revokes a session of the user.
+// Returns domain.ErrSessionNotFound if the user has no such unrevoked session.
+func (s *SQLStore) RevokeSession(userId int, id string) error {
+	query := "UPDATE sessions SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL"
+
+	result, err := s.db.Exec(query, time.Now(), id, userId)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return domain.ErrSessionNotFound
+	}
+	return nil
+}
+
+// Revokes every session of the user and returns how many were revoked
+func (s *SQLStore) RevokeAllSessions(userId int) (int, error) {
+	query := "UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL"
+
+	result, err := s.db.Exec(query, time.Now(), userId)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
+
+// Deletes the sessions that expired or were revoked before the given time
+// and returns how many were deleted
+func (s *SQLStore) DeleteStaleSessions(before time.Time) (int, error) {
+	query := "DELETE FROM sessions WHERE expires_at < ? OR revoked_at < ?"
+
+	result, err := s.db.Exec(query, before, before)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rowsAffected), nil
+}
@@ -0,0 +1,100 @@
+package database
+
+import (
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/codes"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/ledger"
+	"github.com/caleb-mwasikira/tap_gopay/lockout"
+	v "github.com/caleb-mwasikira/tap_gopay/validators"
+)
+
+type UserStore interface {
+	GetUser(email string) (*User, error)
+	GetUserById(id int) (*User, error)
+	CreateUser(user v.RegisterDto) error
+	UpdateUser(email string, patch UserPatch) error
+	ChangeUserRole(change RoleChange) error
+	GetRoleChanges(userId int) ([]RoleChange, error)
+	SearchUsers(query string, page, perPage int) ([]User, int, error)
+	SetUserSuspended(action AdminAction, suspended bool) error
+}
+
+type CardStore interface {
+	CreateCreditCard(newCreditCard v.CreditCardDto) error
+	GetCreditCardsAssocWith(phoneNos []string, now time.Time) ([]v.CreditCardDto, error)
+	GetCreditCardsFor(username string) ([]CreditCardDetails, error)
+	GetCreditCardWhere(username, cardNo string, statuses ...domain.CardStatus) (*v.CreditCardDto, error)
+	UpdateCardStatus(change CardStatusChange) error
+	CloseCard(change CardStatusChange, sweepTo string, now time.Time) (*TransferResult, error)
+	GetCardStatusHistory(cardNo string) ([]CardStatusChange, error)
+	MarkCvvRevealed(cardNo string) error
+	SetCardPin(cardNo, pinHash string) error
+	ReservePinAttempt(cardNo string, maxAttempts int) (int, error)
+	ReleasePinAttempt(cardNo string) error
+	BlockCardAfterPinAttempts(cardNo string, maxAttempts int) error
+	ResetPinAttempts(cardNo string) error
+	SetCardBlocked(action AdminAction, blocked bool) error
+}
+
+type TransactionStore interface {
+	Transfer(transaction v.SendMoneyDto, now time.Time) (*TransferResult, error)
+	GetTransactionsDetailsWhere(username string) ([]TransactionDetails, error)
+	GetJournalEntries(reference string) ([]ledger.JournalEntry, error)
+	GetTrialBalance() (*ledger.TrialBalance, error)
+}
+
+type IdempotencyStore interface {
+	CreateIdempotencyRecord(userId int, key, requestHash string) error
+	GetIdempotencyRecord(userId int, key string) (*IdempotencyRecord, error)
+	SaveIdempotencyResponse(userId int, key string, statusCode int, responseBody []byte) error
+	DeleteIdempotencyRecord(userId int, key string) error
+	DeleteIdempotencyRecordsBefore(before time.Time) (int, error)
+}
+
+type SessionStore interface {
+	CreateSession(session Session) error
+	GetSession(id string) (*Session, error)
+	RotateRefreshToken(id, oldHash, newHash string, now, expiresAt time.Time) error
+	GetActiveSessions(userId int, now time.Time) ([]Session, error)
+	RevokeSession(userId int, id string) error
+	RevokeAllSessions(userId int) (int, error)
+	DeleteStaleSessions(before time.Time) (int, error)
+}
+
+type TotpStore interface {
+	GetTotp(userId int) (*Totp, error)
+	SaveTotpSecret(userId int, secret string) error
+	EnableTotp(userId int, counter int64, recoveryCodeHashes []string) error
+	DeleteTotp(userId int) error
+	ResetTotp(action AdminAction) error
+	UseTotpCounter(userId int, counter int64) error
+	UseRecoveryCode(userId int, codeHash string, now time.Time) error
+	ReplaceRecoveryCodes(userId int, codeHashes []string) error
+	CountRecoveryCodes(userId int) (int, error)
+}
+
+type AdminActionStore interface {
+	RecordAdminAction(action AdminAction) error
+	GetAdminActions(userId int) ([]AdminAction, error)
+}
+
+// Store is implemented by every complete storage backend
+type Store interface {
+	UserStore
+	CardStore
+	codes.Store
+	TransactionStore
+	IdempotencyStore
+	SessionStore
+	TotpStore
+	AdminActionStore
+	lockout.Store
+	Close() error
+}
+
+var (
+	_ Store = (*SQLStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
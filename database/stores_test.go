@@ -0,0 +1,566 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/codes"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/ledger"
+	"github.com/caleb-mwasikira/tap_gopay/lockout"
+	"github.com/caleb-mwasikira/tap_gopay/money"
+	v "github.com/caleb-mwasikira/tap_gopay/validators"
+)
+
+var testNow = time.Date(2026, 6, 15, 12, 0, 0, 0, time.UTC)
+
+// Runs test against the MemoryStore and an SQLStore on a migrated SQLite
+// database, so that the MemoryStore keeps behaving like the real thing
+func forEachStore(t *testing.T, test func(t *testing.T, s Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		cfg := Config{Driver: "sqlite", Name: filepath.Join(t.TempDir(), "tap_gopay.db")}
+
+		migrator, err := NewMigrator(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer migrator.Close()
+
+		_, err = migrator.Up()
+		if err != nil {
+			t.Fatalf("error migrating database; %v", err)
+		}
+
+		s, err := NewSQLStore(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		test(t, s)
+	})
+}
+
+func createUser(t *testing.T, s Store, username string) *User {
+	t.Helper()
+
+	err := s.CreateUser(v.RegisterDto{
+		Username:    username,
+		Email:       username + "@example.com",
+		Password:    "hash",
+		PhoneNumber: "07" + fmt.Sprintf("%08d", len(username)),
+	})
+	if err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+
+	user, err := s.GetUser(username + "@example.com")
+	if err != nil {
+		t.Fatalf("GetUser() error = %v", err)
+	}
+	return user
+}
+
+func createCard(t *testing.T, s Store, user *User, cardNo string, deposit int64) {
+	t.Helper()
+
+	err := s.CreateCreditCard(v.CreditCardDto{
+		UserId:         user.Id,
+		CardNo:         cardNo,
+		Cvv:            "v2:1:sealed",
+		ExpiryMonth:    int(testNow.Month()),
+		ExpiryYear:     testNow.Year() + 4,
+		InitialDeposit: money.KES(deposit),
+	})
+	if err != nil {
+		t.Fatalf("CreateCreditCard() error = %v", err)
+	}
+}
+
+func balanceOf(t *testing.T, s Store, user *User, cardNo string) money.Money {
+	t.Helper()
+
+	cards, err := s.GetCreditCardsFor(user.Username)
+	if err != nil {
+		t.Fatalf("GetCreditCardsFor() error = %v", err)
+	}
+	for _, card := range cards {
+		if card.CardNo == cardNo {
+			return card.CurrentBalance
+		}
+	}
+	t.Fatalf("card %v of %v not found", cardNo, user.Username)
+	return money.Money{}
+}
+
+func TestUsers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		alice := createUser(t, s, "alice")
+		if alice.Username != "alice" || alice.Role != domain.RoleUser || alice.IsActive || alice.PhoneNumber.String == "" {
+			t.Errorf("GetUser() = %+v", alice)
+		}
+
+		byId, err := s.GetUserById(alice.Id)
+		if err != nil || byId.Email != alice.Email {
+			t.Errorf("GetUserById() = %+v, %v", byId, err)
+		}
+
+		err = s.CreateUser(v.RegisterDto{Username: "alice", Email: "alice@example.com", Password: "hash", PhoneNumber: "0700000000"})
+		if !errors.Is(err, ErrDuplicateKey) {
+			t.Errorf("CreateUser() of an existing user error = %v, want %v", err, ErrDuplicateKey)
+		}
+
+		_, err = s.GetUser("nobody@example.com")
+		if !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetUser() of a missing user error = %v, want %v", err, sql.ErrNoRows)
+		}
+	})
+}
+
+func TestTransfer(t *testing.T) {
+	const (
+		alicesCard string = "4000000000000002"
+		bobsCard   string = "4000000000000010"
+	)
+
+	transfer := func(from, to string, amount int64) v.SendMoneyDto {
+		return v.SendMoneyDto{SendersCard: from, ReceiversCard: to, Amount: money.KES(amount)}
+	}
+
+	tests := []struct {
+		name        string
+		transaction v.SendMoneyDto
+		now         time.Time
+		err         error
+		alice, bob  int64 // balances afterwards
+	}{
+		{"transfer", transfer(alicesCard, bobsCard, 250_00), testNow, nil, 750_00, 750_00},
+		{"whole balance", transfer(alicesCard, bobsCard, 1000_00), testNow, nil, 0, 1500_00},
+		{"insufficient funds", transfer(alicesCard, bobsCard, 1000_01), testNow, domain.ErrInsufficientFunds, 1000_00, 500_00},
+		{"same card", transfer(alicesCard, alicesCard, 1_00), testNow, domain.ErrSendingToSameCard, 1000_00, 500_00},
+		{"zero amount", transfer(alicesCard, bobsCard, 0), testNow, domain.ErrInvalidTransferAmount, 1000_00, 500_00},
+		{"negative amount", transfer(alicesCard, bobsCard, -1_00), testNow, domain.ErrInvalidTransferAmount, 1000_00, 500_00},
+		{"unknown senders card", transfer("4111111111111111", bobsCard, 1_00), testNow, domain.ErrInvalidSendersCard, 1000_00, 500_00},
+		{"unknown receivers card", transfer(alicesCard, "4111111111111111", 1_00), testNow, domain.ErrInvalidReceiversCard, 1000_00, 500_00},
+		{"expired cards", transfer(alicesCard, bobsCard, 1_00), testNow.AddDate(5, 0, 0), domain.ErrInvalidSendersCard, 1000_00, 500_00},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, s Store) {
+				alice, bob := createUser(t, s, "alice"), createUser(t, s, "bob")
+				createCard(t, s, alice, alicesCard, 1000_00)
+				createCard(t, s, bob, bobsCard, 500_00)
+
+				result, err := s.Transfer(test.transaction, test.now)
+				if !errors.Is(err, test.err) {
+					t.Fatalf("Transfer() error = %v, want %v", err, test.err)
+				}
+				if err == nil && (result.SendersBalance != money.KES(test.alice) || result.JournalEntryId == 0) {
+					t.Errorf("Transfer() = %+v", result)
+				}
+
+				if balance := balanceOf(t, s, alice, alicesCard); balance != money.KES(test.alice) {
+					t.Errorf("senders balance = %v, want %v", balance, money.KES(test.alice))
+				}
+				if balance := balanceOf(t, s, bob, bobsCard); balance != money.KES(test.bob) {
+					t.Errorf("receivers balance = %v, want %v", balance, money.KES(test.bob))
+				}
+
+				trialBalance, err := s.GetTrialBalance()
+				if err != nil || !trialBalance.IsBalanced() {
+					t.Errorf("GetTrialBalance() = %+v, %v", trialBalance, err)
+				}
+			})
+		})
+	}
+}
+
+// Concurrent transfers never spend the same money twice
+func TestConcurrentTransfers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		alice, bob := createUser(t, s, "alice"), createUser(t, s, "bob")
+		createCard(t, s, alice, "4000000000000002", 1000_00)
+		createCard(t, s, bob, "4000000000000010", 100_00)
+
+		var (
+			wg        sync.WaitGroup
+			mu        sync.Mutex
+			succeeded int
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				_, err := s.Transfer(v.SendMoneyDto{
+					SendersCard:   "4000000000000002",
+					ReceiversCard: "4000000000000010",
+					Amount:        money.KES(300_00),
+				}, testNow)
+				if err == nil {
+					mu.Lock()
+					succeeded++
+					mu.Unlock()
+				} else if !errors.Is(err, domain.ErrInsufficientFunds) {
+					t.Errorf("Transfer() error = %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if succeeded != 3 {
+			t.Errorf("%d transfers of 300 from 1000 succeeded, want 3", succeeded)
+		}
+		if balance := balanceOf(t, s, alice, "4000000000000002"); balance != money.KES(100_00) {
+			t.Errorf("senders balance = %v, want %v", balance, money.KES(100_00))
+		}
+
+		transactions, err := s.GetTransactionsDetailsWhere("bob")
+		if err != nil || len(transactions) != 3 {
+			t.Errorf("GetTransactionsDetailsWhere() = %d transactions, %v", len(transactions), err)
+		}
+	})
+}
+
+func TestJournalEntries(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		alice := createUser(t, s, "alice")
+		createCard(t, s, alice, "4000000000000002", 1000_00)
+
+		entries, err := s.GetJournalEntries("deposit:4000000000000002")
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("GetJournalEntries() = %+v, %v", entries, err)
+		}
+		if err := entries[0].Validate(); err != nil {
+			t.Errorf("initial deposit entry is invalid; %v", err)
+		}
+
+		postings := map[string]money.Money{}
+		for _, posting := range entries[0].Postings {
+			postings[posting.AccountCode] = posting.Amount
+		}
+		if postings[ledger.DepositsAccount] != money.KES(-1000_00) || postings[ledger.CardAccount("4000000000000002")] != money.KES(1000_00) {
+			t.Errorf("initial deposit postings = %v", postings)
+		}
+	})
+}
+
+func TestCardStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes []CardStatusChange
+		status  domain.CardStatus
+		err     error
+	}{
+		{"freeze", []CardStatusChange{{To: domain.CardFrozen}}, domain.CardFrozen, nil},
+		{"freeze and unfreeze", []CardStatusChange{{To: domain.CardFrozen}, {From: domain.CardFrozen, To: domain.CardActive}}, domain.CardActive, nil},
+		{"unfreeze active card", []CardStatusChange{{From: domain.CardFrozen, To: domain.CardActive}}, domain.CardActive, domain.ErrInvalidCardTransition},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, s Store) {
+				alice := createUser(t, s, "alice")
+				createCard(t, s, alice, "4000000000000002", 1000_00)
+
+				var err error
+				for _, change := range test.changes {
+					change.CardNo = "4000000000000002"
+					change.Reason = test.name
+					err = s.UpdateCardStatus(change)
+				}
+				if !errors.Is(err, test.err) {
+					t.Errorf("UpdateCardStatus() error = %v, want %v", err, test.err)
+				}
+
+				card, err := s.GetCreditCardWhere("alice", "4000000000000002")
+				if err != nil || card.Status != test.status {
+					t.Errorf("card = %+v, %v; want it %v", card, err, test.status)
+				}
+
+				// cards are closed with CloseCard, which sweeps their balance
+				err = s.UpdateCardStatus(CardStatusChange{CardNo: "4000000000000002", To: domain.CardClosed})
+				if err == nil {
+					t.Error("UpdateCardStatus() closed a card")
+				}
+			})
+		})
+	}
+}
+
+// Concurrent PIN attempts cannot get past the limit, and the card is blocked
+// once the last one is wrong
+func TestPinAttempts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		alice := createUser(t, s, "alice")
+		createCard(t, s, alice, "4000000000000002", 1000_00)
+
+		var (
+			wg       sync.WaitGroup
+			mu       sync.Mutex
+			reserved int
+		)
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				_, err := s.ReservePinAttempt("4000000000000002", 3)
+				if err == nil {
+					mu.Lock()
+					reserved++
+					mu.Unlock()
+				} else if !errors.Is(err, domain.ErrCardBlocked) {
+					t.Errorf("ReservePinAttempt() error = %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if reserved != 3 {
+			t.Errorf("%d concurrent PIN attempts reserved, want 3", reserved)
+		}
+
+		if err := s.ReleasePinAttempt("4000000000000002"); err != nil {
+			t.Fatal(err)
+		}
+		if attempts, err := s.ReservePinAttempt("4000000000000002", 3); err != nil || attempts != 3 {
+			t.Errorf("ReservePinAttempt() after ReleasePinAttempt() = %v, %v", attempts, err)
+		}
+
+		if err := s.BlockCardAfterPinAttempts("4000000000000002", 3); err != nil {
+			t.Fatal(err)
+		}
+		card, err := s.GetCreditCardWhere("alice", "4000000000000002")
+		if err != nil || card.Status != domain.CardBlocked || card.PinAttempts != 3 {
+			t.Errorf("card after the last wrong PIN = %+v, %v", card, err)
+		}
+
+		if err := s.ResetPinAttempts("4000000000000002"); err != nil {
+			t.Fatal(err)
+		}
+		if attempts, err := s.ReservePinAttempt("4000000000000002", 3); err != nil || attempts != 1 {
+			t.Errorf("ReservePinAttempt() after ResetPinAttempts() = %v, %v", attempts, err)
+		}
+
+		if _, err := s.ReservePinAttempt("4111111111111111", 3); !errors.Is(err, domain.ErrCardNotFound) {
+			t.Errorf("ReservePinAttempt() of a missing card error = %v, want %v", err, domain.ErrCardNotFound)
+		}
+	})
+}
+
+// Admin actions are recorded along with the change they make, and not at all
+// when the change is refused
+func TestAdminActions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		admin, alice := createUser(t, s, "admin"), createUser(t, s, "alice")
+		createCard(t, s, alice, "4000000000000002", 1000_00)
+
+		action := func(actionType AdminActionType, cardNo string) AdminAction {
+			return AdminAction{AdminId: admin.Id, Action: actionType, UserId: alice.Id, CardNo: cardNo, Reason: "test"}
+		}
+
+		tests := []struct {
+			name string
+			do   func() error
+			err  error
+		}{
+			{"suspend", func() error { return s.SetUserSuspended(action(ActionSuspendUser, ""), true) }, nil},
+			{"suspend again", func() error { return s.SetUserSuspended(action(ActionSuspendUser, ""), true) }, domain.ErrUserAlreadySuspended},
+			{"block card", func() error { return s.SetCardBlocked(action(ActionBlockCard, "4000000000000002"), true) }, nil},
+			{"block missing card", func() error { return s.SetCardBlocked(action(ActionBlockCard, "4111111111111111"), true) }, domain.ErrCardNotFound},
+			{"unblock card", func() error { return s.SetCardBlocked(action(ActionUnblockCard, "4000000000000002"), false) }, nil},
+			{"unblock active card", func() error { return s.SetCardBlocked(action(ActionUnblockCard, "4000000000000002"), false) }, domain.ErrInvalidCardTransition},
+			{"reset totp without one", func() error { return s.ResetTotp(action(ActionResetTotp, "")) }, domain.ErrTotpNotEnrolled},
+			{"reset totp", func() error {
+				if err := s.SaveTotpSecret(alice.Id, "secret"); err != nil {
+					return err
+				}
+				return s.ResetTotp(action(ActionResetTotp, ""))
+			}, nil},
+		}
+
+		recorded := 0
+		for _, test := range tests {
+			err := test.do()
+			if !errors.Is(err, test.err) || (test.err == nil && err != nil) {
+				t.Errorf("%v: error = %v, want %v", test.name, err, test.err)
+			}
+			if err == nil {
+				recorded++
+			}
+
+			actions, err := s.GetAdminActions(alice.Id)
+			if err != nil || len(actions) != recorded {
+				t.Fatalf("%v: GetAdminActions() = %d actions, %v; want %d", test.name, len(actions), err, recorded)
+			}
+		}
+
+		if _, err := s.GetTotp(alice.Id); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetTotp() after ResetTotp() error = %v, want %v", err, sql.ErrNoRows)
+		}
+	})
+}
+
+func TestIdempotencyRecords(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		user := createUser(t, s, "alice")
+
+		if err := s.CreateIdempotencyRecord(user.Id, "key", "hash"); err != nil {
+			t.Fatalf("CreateIdempotencyRecord() error = %v", err)
+		}
+		if err := s.CreateIdempotencyRecord(user.Id, "key", "other hash"); !errors.Is(err, ErrIdempotencyKeyExists) {
+			t.Errorf("CreateIdempotencyRecord() of a used key error = %v, want %v", err, ErrIdempotencyKeyExists)
+		}
+
+		record, err := s.GetIdempotencyRecord(user.Id, "key")
+		if err != nil || record.RequestHash != "hash" || record.IsComplete() {
+			t.Fatalf("GetIdempotencyRecord() = %+v, %v", record, err)
+		}
+
+		if err := s.SaveIdempotencyResponse(user.Id, "key", 201, []byte(`{"ok":true}`)); err != nil {
+			t.Fatal(err)
+		}
+		record, err = s.GetIdempotencyRecord(user.Id, "key")
+		if err != nil || record.StatusCode != 201 || string(record.ResponseBody) != `{"ok":true}` {
+			t.Errorf("GetIdempotencyRecord() after SaveIdempotencyResponse() = %+v, %v", record, err)
+		}
+
+		if err := s.DeleteIdempotencyRecord(user.Id, "key"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.GetIdempotencyRecord(user.Id, "key"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetIdempotencyRecord() after DeleteIdempotencyRecord() error = %v, want %v", err, sql.ErrNoRows)
+		}
+	})
+}
+
+func TestOneTimeCodes(t *testing.T) {
+	save := func(t *testing.T, s Store, hash string) {
+		t.Helper()
+
+		err := s.SaveCode(codes.Code{
+			Purpose:   codes.EMAIL_VERIFICATION,
+			Email:     "alice@example.com",
+			CodeHash:  hash,
+			CreatedAt: testNow,
+			ExpiresAt: testNow.Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("SaveCode() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		guesses []string // hashes tried before the right one
+		now     time.Time
+		err     error
+	}{
+		{"right code", nil, testNow, nil},
+		{"after wrong guesses", []string{"wrong", "wrong"}, testNow, nil},
+		{"after too many wrong guesses", []string{"wrong", "wrong", "wrong"}, testNow, codes.ErrInvalid},
+		{"expired", nil, testNow.Add(time.Hour), codes.ErrExpired},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			forEachStore(t, func(t *testing.T, s Store) {
+				save(t, s, "hash")
+
+				for _, guess := range test.guesses {
+					s.ConsumeCode(codes.EMAIL_VERIFICATION, "alice@example.com", []string{guess}, test.now, 3)
+				}
+
+				err := s.ConsumeCode(codes.EMAIL_VERIFICATION, "alice@example.com", []string{"other key hash", "hash"}, test.now, 3)
+				if !errors.Is(err, test.err) {
+					t.Errorf("ConsumeCode() error = %v, want %v", err, test.err)
+				}
+
+				// a code is only ever accepted once
+				err = s.ConsumeCode(codes.EMAIL_VERIFICATION, "alice@example.com", []string{"hash"}, test.now, 3)
+				if !errors.Is(err, codes.ErrInvalid) {
+					t.Errorf("ConsumeCode() of a consumed code error = %v, want %v", err, codes.ErrInvalid)
+				}
+			})
+		})
+	}
+
+	forEachStore(t, func(t *testing.T, s Store) {
+		save(t, s, "hash")
+
+		deleted, err := s.DeleteExpiredCodes(testNow.Add(time.Hour))
+		if err != nil || deleted != 1 {
+			t.Errorf("DeleteExpiredCodes() = %v, %v; want 1", deleted, err)
+		}
+	})
+}
+
+// Reservations are counted atomically, so concurrent attempts cannot all
+// get past a limit before any of them is counted
+func TestReserveAttempt(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store) {
+		allow := func(attempts lockout.Attempts) bool {
+			return attempts.Failures < 3
+		}
+
+		var (
+			wg       sync.WaitGroup
+			mu       sync.Mutex
+			reserved int
+		)
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				_, ok, err := s.ReserveAttempt("login:alice@example.com", testNow, time.Minute, allow)
+				if err != nil {
+					t.Errorf("ReserveAttempt() error = %v", err)
+				}
+				if ok {
+					mu.Lock()
+					reserved++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if reserved != 3 {
+			t.Errorf("%d concurrent attempts reserved, want 3", reserved)
+		}
+
+		if err := s.ReleaseAttempt("login:alice@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		attempts, ok, err := s.ReserveAttempt("login:alice@example.com", testNow, time.Minute, allow)
+		if err != nil || !ok || attempts.Failures != 3 {
+			t.Errorf("ReserveAttempt() after ReleaseAttempt() = %+v, %v, %v", attempts, ok, err)
+		}
+
+		// failures are forgotten once the window has passed
+		attempts, ok, err = s.ReserveAttempt("login:alice@example.com", testNow.Add(2*time.Minute), time.Minute, allow)
+		if err != nil || !ok || attempts.Failures != 1 {
+			t.Errorf("ReserveAttempt() after the window = %+v, %v, %v", attempts, ok, err)
+		}
+
+		if err := s.ResetAttempts("login:alice@example.com"); err != nil {
+			t.Fatal(err)
+		}
+		attempts, ok, err = s.ReserveAttempt("login:alice@example.com", testNow, time.Minute, allow)
+		if err != nil || !ok || attempts.Failures != 1 {
+			t.Errorf("ReserveAttempt() after ResetAttempts() = %+v, %v, %v", attempts, ok, err)
+		}
+	})
+}
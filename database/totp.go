@@ -0,0 +1,194 @@
+package database
+
+import (
+	"errors"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+)
+
+// Totp is the TOTP two-factor authentication of a user.
+// It is pending until the user confirms it with a code from their app.
+type Totp struct {
+	UserId      int
+	Secret      string // sealed; see the encryption package
+	Enabled     bool
+	LastCounter int64 // time step of the last accepted code
+	CreatedAt   time.Time
+}
+
+func (s *SQLStore) GetTotp(userId int) (*Totp, error) {
+	query := "SELECT user_id, secret, enabled, last_counter, created_at FROM user_totp WHERE user_id = ?"
+
+	totp := Totp{}
+	err := s.db.QueryRow(query, userId).Scan(
+		&totp.UserId,
+		&totp.Secret,
+		&totp.Enabled,
+		&totp.LastCounter,
+		&totp.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &totp, nil
+}
+
+// Starts a new TOTP enrolment, replacing any earlier unconfirmed one.
+// Returns domain.ErrTotpAlreadyEnabled if the user has confirmed TOTP.
+func (s *SQLStore) SaveTotpSecret(userId int, secret string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM user_totp WHERE user_id = ? AND enabled = ?", userId, false)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO user_totp(user_id, secret, enabled) VALUES(?, ?, ?)", userId, secret, false)
+	if err != nil {
+		if errors.Is(err, ErrDuplicateKey) {
+			return domain.ErrTotpAlreadyEnabled
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Confirms a pending enrolment and gives the user a set of recovery codes.
+// counter is the time step of the code the enrolment was confirmed with.
+func (s *SQLStore) EnableTotp(userId int, counter int64, recoveryCodeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"UPDATE user_totp SET enabled = ?, last_counter = ? WHERE user_id = ? AND enabled = ?",
+		true, counter, userId, false,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return domain.ErrTotpNotEnrolled
+	}
+
+	err = replaceRecoveryCodes(tx, userId, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Turns off TOTP for the user and deletes their recovery codes
+func (s *SQLStore) DeleteTotp(userId int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM user_totp WHERE user_id = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Records the time step of an accepted code.
+// Returns domain.ErrTotpInvalid if a code of that or a later time step has
+// already been accepted, so that every code can only be used once.
+func (s *SQLStore) UseTotpCounter(userId int, counter int64) error {
+	result, err := s.db.Exec(
+		"UPDATE user_totp SET last_counter = ? WHERE user_id = ? AND enabled = ? AND last_counter < ?",
+		counter, userId, true, counter,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return domain.ErrTotpInvalid.WithMessage("Two-factor authentication code has already been used. Please wait for the next one")
+	}
+	return nil
+}
+
+// Marks an unused recovery code as used at now.
+// Returns domain.ErrTotpInvalid if the user has no such unused code.
+func (s *SQLStore) UseRecoveryCode(userId int, codeHash string, now time.Time) error {
+	result, err := s.db.Exec(
+		"UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		now, userId, codeHash,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return domain.ErrTotpInvalid.WithMessage("Invalid recovery code")
+	}
+	return nil
+}
+
+func (s *SQLStore) ReplaceRecoveryCodes(userId int, codeHashes []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceRecoveryCodes(tx, userId, codeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sqlTx, userId int, codeHashes []string) error {
+	_, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	for _, codeHash := range codeHashes {
+		_, err = tx.Exec("INSERT INTO recovery_codes(user_id, code_hash) VALUES(?, ?)", userId, codeHash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns how many unused recovery codes the user has left
+func (s *SQLStore) CountRecoveryCodes(userId int) (int, error) {
+	count := 0
+	err := s.db.QueryRow("SELECT COUNT(*) FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userId).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/ledger"
+	"github.com/caleb-mwasikira/tap_gopay/money"
+	v "github.com/caleb-mwasikira/tap_gopay/validators"
+)
+
+type TransferResult struct {
+	TransactionId  int64       `json:"transaction_id"`
+	JournalEntryId int64       `json:"journal_entry_id"`
+	SendersCard    string      `json:"senders_card"`
+	ReceiversCard  string      `json:"receivers_card"`
+	Amount         money.Money `json:"amount"`
+	SendersBalance money.Money `json:"senders_balance"`
+}
+
+// Moves money from the senders card to the receivers card inside a single
+// database transaction.
+// Both cards and their ledger accounts are locked with SELECT ... FOR UPDATE
+// in a consistent order so that two opposing transfers between the same cards
+// cannot deadlock. The movement itself is recorded as a balanced journal entry.
+func (s *SQLStore) Transfer(transaction v.SendMoneyDto, now time.Time) (*TransferResult, error) {
+	if transaction.SendersCard == transaction.ReceiversCard {
+		return nil, domain.ErrSendingToSameCard
+	}
+	if !transaction.Amount.IsPositive() {
+		return nil, domain.ErrInvalidTransferAmount
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	// card rows are locked before anything else is read so that, on MySQL,
+	// the snapshot used to compute balances is taken after the locks are held
+	cards, err := lockCreditCards(tx, transaction.SendersCard, transaction.ReceiversCard)
+	if err != nil {
+		return nil, fmt.Errorf("error locking credit cards; %v", err)
+	}
+
+	sendersCard, ok := cards[transaction.SendersCard]
+	if !ok || !isCardUsable(sendersCard, now) {
+		return nil, domain.ErrInvalidSendersCard
+	}
+
+	receiversCard, ok := cards[transaction.ReceiversCard]
+	if !ok || !isCardUsable(receiversCard, now) {
+		return nil, domain.ErrInvalidReceiversCard
+	}
+
+	result, err := postTransfer(tx, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Records a transfer between two cards whose status has already been checked.
+// Callers are expected to have locked both credit cards.
+func postTransfer(tx *sqlTx, transaction v.SendMoneyDto) (*TransferResult, error) {
+	sendersAccount := ledger.CardAccount(transaction.SendersCard)
+	receiversAccount := ledger.CardAccount(transaction.ReceiversCard)
+
+	accountIds, err := lockLedgerAccounts(tx, sendersAccount, receiversAccount)
+	if err != nil {
+		return nil, fmt.Errorf("error locking ledger accounts; %v", err)
+	}
+
+	sendersBalance, err := getLedgerBalance(tx, accountIds[sendersAccount])
+	if err != nil {
+		return nil, err
+	}
+	insufficientFunds, err := sendersBalance.LessThan(transaction.Amount)
+	if err != nil {
+		return nil, err
+	}
+	if insufficientFunds {
+		return nil, domain.ErrInsufficientFunds
+	}
+
+	newBalance, err := sendersBalance.Sub(transaction.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	transactionId, err := tx.Insert(
+		"INSERT INTO transactions(senders_card, receivers_card, amount, status) VALUES(?, ?, ?, 'completed')",
+		transaction.SendersCard,
+		transaction.ReceiversCard,
+		transaction.Amount,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	entryId, err := postJournalEntry(tx, ledger.NewTransfer(
+		fmt.Sprintf("transaction:%d", transactionId),
+		fmt.Sprintf("Transfer from %v to %v", transaction.SendersCard, transaction.ReceiversCard),
+		sendersAccount,
+		receiversAccount,
+		transaction.Amount,
+	), accountIds)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TransferResult{
+		TransactionId:  transactionId,
+		JournalEntryId: entryId,
+		SendersCard:    transaction.SendersCard,
+		ReceiversCard:  transaction.ReceiversCard,
+		Amount:         transaction.Amount,
+		SendersBalance: newBalance,
+	}, nil
+}
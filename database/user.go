@@ -4,7 +4,9 @@ import (
 	"database/sql"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/caleb-mwasikira/tap_gopay/domain"
 	v "github.com/caleb-mwasikira/tap_gopay/validators"
 )
 
@@ -13,13 +15,69 @@ type User struct {
 	Username    string         `json:"username"`
 	Email       string         `json:"email"`
 	Password    string         `json:"password"`
-	IsActive    bool           `json:"is_active"`
+	IsActive    bool           `json:"is_active"` // email address verified
+	IsSuspended bool           `json:"is_suspended"`
 	PhoneNumber sql.NullString `json:"phone_no"`
+	Role        domain.Role    `json:"role"`
+	CreatedAt   time.Time      `json:"created_at"`
+
+	// new email address and phone number waiting to be verified; empty if none
+	PendingEmail       string `json:"-"`
+	PendingPhoneNumber string `json:"-"`
+}
+
+const userColumns string = `
+	id, username, email, password, is_active, is_suspended, phone_no, role, created_at,
+	COALESCE(pending_email, ''), COALESCE(pending_phone_no, '')
+`
+
+// UserPatch is a change to a user; only the fields that are not nil are
+// updated. Setting a pending field to "" clears it.
+type UserPatch struct {
+	Email              *string
+	PhoneNumber        *string
+	Password           *string
+	IsActive           *bool
+	PendingEmail       *string
+	PendingPhoneNumber *string
+}
+
+func (s *SQLStore) GetUser(email string) (*User, error) {
+	row := s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE email = ?", email)
+	return scanUser(row)
 }
 
-func GetUser(email string) (*User, error) {
-	row := db.QueryRow("SELECT * FROM users WHERE email = ?", email)
+func (s *SQLStore) GetUserById(id int) (*User, error) {
+	row := s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id)
+	return scanUser(row)
+}
 
+// UserDetails is a user as shown to admins, without their password hash
+type UserDetails struct {
+	Id          int         `json:"id"`
+	Username    string      `json:"username"`
+	Email       string      `json:"email"`
+	PhoneNumber string      `json:"phone_number"`
+	IsActive    bool        `json:"is_active"`
+	IsSuspended bool        `json:"is_suspended"`
+	Role        domain.Role `json:"role"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+func (u User) Details() UserDetails {
+	return UserDetails{
+		Id:          u.Id,
+		Username:    u.Username,
+		Email:       u.Email,
+		PhoneNumber: u.PhoneNumber.String,
+		IsActive:    u.IsActive,
+		IsSuspended: u.IsSuspended,
+		Role:        u.Role,
+		CreatedAt:   u.CreatedAt,
+	}
+}
+
+func scanUser(row rowScanner) (*User, error) {
 	dbUser := User{}
 	err := row.Scan(
 		&dbUser.Id,
@@ -27,7 +85,12 @@ func GetUser(email string) (*User, error) {
 		&dbUser.Email,
 		&dbUser.Password,
 		&dbUser.IsActive,
+		&dbUser.IsSuspended,
 		&dbUser.PhoneNumber,
+		&dbUser.Role,
+		&dbUser.CreatedAt,
+		&dbUser.PendingEmail,
+		&dbUser.PendingPhoneNumber,
 	)
 	if err != nil {
 		return nil, err
@@ -36,30 +99,103 @@ func GetUser(email string) (*User, error) {
 	return &dbUser, nil
 }
 
-func CreateUser(user v.RegisterDto) error {
-	_, err := db.Exec(
-		"INSERT INTO users(username, email, password, phone_no) VALUES(?, ?, ?, ?)",
+func (s *SQLStore) CreateUser(user v.RegisterDto) error {
+	_, err := s.db.Exec(
+		"INSERT INTO users(username, email, password, phone_no, created_at) VALUES(?, ?, ?, ?, ?)",
 		user.Username,
 		user.Email,
 		user.Password,
 		user.PhoneNumber,
+		time.Now(),
 	)
 
 	return err
 }
 
-func UpdateUser(email string, updateValues map[string]interface{}) error {
+func (s *SQLStore) UpdateUser(email string, patch UserPatch) error {
 	placeholders := []string{}
 	values := []any{}
 
-	for key, value := range updateValues {
-		placeholders = append(placeholders, fmt.Sprintf("%s = ?", key))
+	set := func(column string, value any) {
+		placeholders = append(placeholders, column+" = ?")
 		values = append(values, value)
 	}
 
+	if patch.Email != nil {
+		set("email", *patch.Email)
+	}
+	if patch.PhoneNumber != nil {
+		set("phone_no", *patch.PhoneNumber)
+	}
+	if patch.Password != nil {
+		set("password", *patch.Password)
+	}
+	if patch.IsActive != nil {
+		set("is_active", *patch.IsActive)
+	}
+	if patch.PendingEmail != nil {
+		set("pending_email", sql.NullString{String: *patch.PendingEmail, Valid: *patch.PendingEmail != ""})
+	}
+	if patch.PendingPhoneNumber != nil {
+		set("pending_phone_no", sql.NullString{String: *patch.PendingPhoneNumber, Valid: *patch.PendingPhoneNumber != ""})
+	}
+
+	if len(placeholders) == 0 {
+		return nil
+	}
+
 	values = append(values, email)
 
 	query := fmt.Sprintf("UPDATE users SET %s WHERE email = ?", strings.Join(placeholders, ", "))
-	_, err := db.Exec(query, values...)
+	_, err := s.db.Exec(query, values...)
 	return err
 }
+
+// Returns a page of the users whose username, email or phone number contains
+// query, in the order they signed up, and how many users match in total.
+// Pages are numbered from 1; an empty query matches every user.
+func (s *SQLStore) SearchUsers(query string, page, perPage int) ([]User, int, error) {
+	where := ""
+	args := []any{}
+
+	if query != "" {
+		pattern := "%" + escapeLike(strings.ToLower(query)) + "%"
+		where = `
+			WHERE LOWER(username) LIKE ? ESCAPE '!'
+			OR LOWER(email) LIKE ? ESCAPE '!'
+			OR phone_no LIKE ? ESCAPE '!'
+		`
+		args = append(args, pattern, pattern, pattern)
+	}
+
+	var total int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM users"+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := s.db.Query(
+		"SELECT "+userColumns+" FROM users"+where+" ORDER BY id LIMIT ? OFFSET ?",
+		append(args, perPage, (page-1)*perPage)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, *user)
+	}
+	return users, total, rows.Err()
+}
+
+// Escapes the wildcards of a LIKE pattern, with ! as the escape character
+func escapeLike(value string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(value)
+}
@@ -0,0 +1,63 @@
+package domain
+
+import (
+	"net/http"
+	"slices"
+	"time"
+)
+
+// CardStatus is the state of a credit card in its lifecycle.
+//
+//	active  - card can send and receive money
+//	frozen  - temporarily disabled by its owner; can be unfrozen
+//	blocked - disabled by the system e.g. after too many failed checks
+//	closed  - permanently disabled; a closed card never changes state again
+type CardStatus string
+
+const (
+	CardActive  CardStatus = "active"
+	CardFrozen  CardStatus = "frozen"
+	CardBlocked CardStatus = "blocked"
+	CardClosed  CardStatus = "closed"
+)
+
+// the states a card may move to from each state
+var cardTransitions = map[CardStatus][]CardStatus{
+	CardActive:  {CardFrozen, CardBlocked, CardClosed},
+	CardFrozen:  {CardActive, CardBlocked, CardClosed},
+	CardBlocked: {CardActive, CardClosed},
+	CardClosed:  {},
+}
+
+func (s CardStatus) IsValid() bool {
+	_, ok := cardTransitions[s]
+	return ok
+}
+
+func (s CardStatus) CanTransitionTo(to CardStatus) bool {
+	return slices.Contains(cardTransitions[s], to)
+}
+
+// Cards can be used up to and including their expiry month
+func CardExpired(expiryMonth, expiryYear int, now time.Time) bool {
+	firstInvalidDay := time.Date(expiryYear, time.Month(expiryMonth)+1, 1, 0, 0, 0, 0, now.Location())
+	return !now.Before(firstInvalidDay)
+}
+
+// card lifecycle errors
+var (
+	ErrInvalidCardTransition = New("invalid_card_transition", http.StatusConflict, "Credit card cannot be moved to the requested status")
+	ErrCardHasBalance        = New("card_has_balance", http.StatusUnprocessableEntity, "Credit card balance must be swept to another of your cards before it can be closed")
+	ErrInvalidSweepCard      = New("sweep_card_invalid", http.StatusUnprocessableEntity, "Balance can only be swept to another active credit card of yours")
+)
+
+// card security errors
+var (
+	ErrCvvRequired        = New("cvv_required", http.StatusBadRequest, "Card security code (CVV) is required for this payment")
+	ErrInvalidCvv         = New("cvv_invalid", http.StatusUnprocessableEntity, "Invalid card security code (CVV)")
+	ErrCvvAlreadyRevealed = New("cvv_already_revealed", http.StatusConflict, "Card security code (CVV) can only be revealed once")
+	ErrCardPinNotSet      = New("card_pin_not_set", http.StatusUnprocessableEntity, "Set a PIN on your credit card before sending money")
+	ErrCardPinAlreadySet  = New("card_pin_already_set", http.StatusConflict, "Credit card already has a PIN; change or reset it instead")
+	ErrInvalidCardPin     = New("card_pin_invalid", http.StatusUnprocessableEntity, "Invalid credit card PIN")
+	ErrCardBlocked        = New("card_blocked", http.StatusForbidden, "Credit card has been blocked after too many wrong PIN attempts")
+)
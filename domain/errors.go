@@ -0,0 +1,140 @@
+// Package domain defines the business rules and errors of TapGoPay.
+//
+// Every error carries a stable machine-readable code that API clients can
+// branch on and localise, along with the HTTP status it maps to.
+// Codes must never change once released; add a new error instead.
+package domain
+
+import (
+	"fmt"
+	"net/http"
+)
+
+type Error struct {
+	Code    string `json:"code"`
+	Status  int    `json:"-"`
+	Message string `json:"message"`
+	cause   error
+}
+
+func New(code string, status int, message string) *Error {
+	return &Error{Code: code, Status: status, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%v; %v", e.Message, e.cause)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Errors with the same code match each other in errors.Is,
+// regardless of their message or cause
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Returns a copy of the error that records the underlying cause for logging
+func (e *Error) Wrap(cause error) *Error {
+	err := *e
+	err.cause = cause
+	return &err
+}
+
+// Returns a copy of the error with a more specific message for the client
+func (e *Error) WithMessage(format string, args ...any) *Error {
+	err := *e
+	err.Message = fmt.Sprintf(format, args...)
+	return &err
+}
+
+// generic errors
+var (
+	ErrInternal         = New("internal_error", http.StatusInternalServerError, "Unexpected error. Please try again later")
+	ErrInvalidJson      = New("invalid_json", http.StatusBadRequest, "Invalid JSON data provided as input")
+	ErrValidationFailed = New("validation_failed", http.StatusBadRequest, "Validation errors")
+	ErrRuleViolation    = New("rule_violation", http.StatusUnprocessableEntity, "Request violates a business rule")
+	ErrConflict         = New("conflict", http.StatusConflict, "Resource already exists")
+)
+
+// authentication errors
+var (
+	ErrUnauthorized        = New("unauthorized", http.StatusUnauthorized, "Unauthorized request detected. Please login and try again")
+	ErrInvalidToken        = New("invalid_token", http.StatusUnauthorized, "Invalid Authorization token")
+	ErrInvalidCredentials  = New("invalid_credentials", http.StatusUnauthorized, "Invalid username or password")
+	ErrUserExists          = New("user_exists", http.StatusConflict, "User account already exists")
+	ErrOtpInvalid          = New("otp_invalid", http.StatusBadRequest, "Invalid email or OTP code")
+	ErrOtpExpired          = New("otp_expired", http.StatusBadRequest, "OTP code has expired. Please request a new one")
+	ErrResetTokenInvalid   = New("reset_token_invalid", http.StatusUnauthorized, "Invalid password-reset-token or email")
+	ErrResetTokenExpired   = New("reset_token_expired", http.StatusUnauthorized, "Password-reset-token has expired. Please request a new one")
+	ErrSessionExpired      = New("session_expired", http.StatusUnauthorized, "Session has expired or was logged out. Please login again")
+	ErrSessionNotFound     = New("session_not_found", http.StatusNotFound, "No active session with that id found")
+	ErrRefreshTokenInvalid = New("refresh_token_invalid", http.StatusUnauthorized, "Invalid or expired refresh token. Please login again")
+	ErrCsrfTokenInvalid    = New("csrf_token_invalid", http.StatusForbidden, "Missing or invalid CSRF token")
+	ErrTotpInvalid         = New("totp_invalid", http.StatusUnauthorized, "Invalid two-factor authentication code")
+	ErrTotpRequired        = New("totp_required", http.StatusForbidden, "A two-factor authentication code is required")
+	ErrTotpAlreadyEnabled  = New("totp_already_enabled", http.StatusConflict, "Two-factor authentication is already enabled")
+	ErrTotpNotEnrolled     = New("totp_not_enrolled", http.StatusUnprocessableEntity, "Two-factor authentication is not set up. Please enrol first")
+	ErrChallengeInvalid    = New("challenge_invalid", http.StatusUnauthorized, "Invalid or expired login challenge. Please login again")
+	ErrTooManyAttempts     = New("too_many_attempts", http.StatusTooManyRequests, "Too many failed attempts. Please wait before trying again")
+	ErrAccountLocked       = New("account_locked", http.StatusTooManyRequests, "Account temporarily locked after too many failed attempts")
+	ErrForbidden           = New("forbidden", http.StatusForbidden, "You do not have permission to do this")
+	ErrAccountSuspended    = New("account_suspended", http.StatusForbidden, "Your account has been suspended. Please contact support")
+)
+
+// user management errors
+var (
+	ErrUserNotFound         = New("user_not_found", http.StatusNotFound, "No user with that email found")
+	ErrRoleUnchanged        = New("role_unchanged", http.StatusConflict, "User already has that role")
+	ErrUserAlreadySuspended = New("user_already_suspended", http.StatusConflict, "User is already suspended")
+	ErrUserNotSuspended     = New("user_not_suspended", http.StatusConflict, "User is not suspended")
+)
+
+// credit card and transfer errors
+var (
+	ErrCardNotFound          = New("card_not_found", http.StatusNotFound, "No credit card with that number found under your name")
+	ErrCardInactive          = New("card_inactive", http.StatusUnprocessableEntity, "Credit card is not active")
+	ErrInvalidSendersCard    = New("senders_card_invalid", http.StatusUnprocessableEntity, "Invalid or deactivated senders credit card")
+	ErrInvalidReceiversCard  = New("receivers_card_invalid", http.StatusUnprocessableEntity, "Invalid or deactivated receivers credit card")
+	ErrSendingToSameCard     = New("same_card_transfer", http.StatusUnprocessableEntity, "Senders and receivers credit card cannot be the same")
+	ErrInvalidTransferAmount = New("invalid_amount", http.StatusUnprocessableEntity, "Transfer amount must be greater than zero")
+	ErrInsufficientFunds     = New("insufficient_funds", http.StatusUnprocessableEntity, "Insufficient funds to complete transaction")
+)
+
+// idempotency errors
+var (
+	ErrIdempotencyKeyTooLong = New("idempotency_key_too_long", http.StatusBadRequest, "Idempotency-Key header is too long")
+	ErrIdempotencyKeyReused  = New("idempotency_key_reused", http.StatusUnprocessableEntity, "Idempotency-Key has already been used with a different request")
+	ErrIdempotencyInProgress = New("idempotency_in_progress", http.StatusConflict, "A request with this Idempotency-Key is still being processed. Please retry later")
+	ErrRequestTooLarge       = New("request_too_large", http.StatusRequestEntityTooLarge, "Request body is too large")
+)
+
+// Returns the generic code for responses that do not come from a domain error
+func CodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "bad_request"
+	case http.StatusUnauthorized:
+		return ErrUnauthorized.Code
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusConflict:
+		return ErrConflict.Code
+	case http.StatusUnprocessableEntity:
+		return ErrRuleViolation.Code
+	case http.StatusTooManyRequests:
+		return "too_many_requests"
+	}
+
+	if status >= http.StatusInternalServerError {
+		return ErrInternal.Code
+	}
+	return ""
+}
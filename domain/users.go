@@ -0,0 +1,21 @@
+package domain
+
+// Role decides what a user is allowed to do.
+//
+//	user  - a customer managing their own cards and transfers
+//	agent - staff issuing cards, with an initial deposit, to customers
+//	admin - staff managing the accounts and roles of other users
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAgent Role = "agent"
+	RoleAdmin Role = "admin"
+)
+
+// Every role, from the least to the most privileged
+var Roles = []Role{RoleUser, RoleAgent, RoleAdmin}
+
+func (r Role) IsValid() bool {
+	return r == RoleUser || r == RoleAgent || r == RoleAdmin
+}
@@ -0,0 +1,144 @@
+// Package encryption seals small secrets such as card security codes
+// for storage, using AES-256-GCM with the data encryption keys of a keyring.
+//
+// Sealed values are text so they can be stored in ordinary string columns
+// and told apart from legacy plain text:
+//
+//	v2:<key version>:<base64 nonce+ciphertext>
+//	v1:<base64 nonce+ciphertext>                 sealed with key version 0 (CARD_KEK)
+//
+// Every value is bound to additional data (e.g. the card number it belongs to)
+// so that a sealed value copied onto another row fails to open.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/caleb-mwasikira/tap_gopay/keyring"
+)
+
+const (
+	KEY_LEN       int    = 32
+	SEALED_PREFIX string = "v2:"
+	LEGACY_PREFIX string = "v1:"
+)
+
+var (
+	ErrInvalidKey    error = errors.New("encryption key must be 32 bytes")
+	ErrNoKeys        error = errors.New("no data encryption keys")
+	ErrNotSealed     error = errors.New("value is not a sealed value")
+	ErrOpeningSealed error = errors.New("error opening sealed value; wrong key or tampered data")
+)
+
+type Cipher struct {
+	aeads   map[int]cipher.AEAD // by key version
+	primary int
+}
+
+// Creates a cipher that seals with the newest of keys
+// and opens values sealed with any of them
+func NewCipher(keys []keyring.Key) (*Cipher, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	c := &Cipher{aeads: map[int]cipher.AEAD{}, primary: keys[0].Version}
+
+	for _, key := range keys {
+		if len(key.Secret) != KEY_LEN {
+			return nil, fmt.Errorf("%v; %w", key.Id(), ErrInvalidKey)
+		}
+
+		block, err := aes.NewCipher(key.Secret)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		c.aeads[key.Version] = aead
+		c.primary = max(c.primary, key.Version)
+	}
+	return c, nil
+}
+
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, SEALED_PREFIX) || strings.HasPrefix(value, LEGACY_PREFIX)
+}
+
+// Reports whether value is plain text or sealed with a key other than the
+// primary one, and should be sealed again
+func (c *Cipher) NeedsReseal(value string) bool {
+	version, _, err := splitSealed(value)
+	return err != nil || version != c.primary
+}
+
+func (c *Cipher) Seal(plaintext, additionalData []byte) (string, error) {
+	aead := c.aeads[c.primary]
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", fmt.Errorf("error generating nonce; %v", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, plaintext, additionalData)
+	return fmt.Sprintf("%s%d:%s", SEALED_PREFIX, c.primary, base64.RawStdEncoding.EncodeToString(sealed)), nil
+}
+
+func (c *Cipher) Open(value string, additionalData []byte) ([]byte, error) {
+	version, encoded, err := splitSealed(value)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, ok := c.aeads[version]
+	if !ok {
+		return nil, fmt.Errorf("%w; data encryption key version %d", keyring.ErrKeyNotFound, version)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, ErrOpeningSealed
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrOpeningSealed
+	}
+	return plaintext, nil
+}
+
+// Returns the key version and encoded ciphertext of a sealed value
+func splitSealed(value string) (int, string, error) {
+	if strings.HasPrefix(value, LEGACY_PREFIX) {
+		return 0, strings.TrimPrefix(value, LEGACY_PREFIX), nil
+	}
+
+	if !strings.HasPrefix(value, SEALED_PREFIX) {
+		return 0, "", ErrNotSealed
+	}
+
+	version, encoded, ok := strings.Cut(strings.TrimPrefix(value, SEALED_PREFIX), ":")
+	if !ok {
+		return 0, "", ErrOpeningSealed
+	}
+
+	keyVersion, err := strconv.Atoi(version)
+	if err != nil {
+		return 0, "", ErrOpeningSealed
+	}
+	return keyVersion, encoded, nil
+}
@@ -0,0 +1,191 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/caleb-mwasikira/tap_gopay/keyring"
+)
+
+func dataKey(version int) keyring.Key {
+	return keyring.Key{
+		Purpose: keyring.DATA_ENCRYPTION,
+		Version: version,
+		Secret:  bytes.Repeat([]byte{byte('a' + version)}, KEY_LEN),
+	}
+}
+
+func newCipher(t *testing.T, keys ...keyring.Key) *Cipher {
+	t.Helper()
+
+	c, err := NewCipher(keys)
+	if err != nil {
+		t.Fatalf("NewCipher() error = %v", err)
+	}
+	return c
+}
+
+// Seals plaintext the way v1 values were sealed with CARD_KEK
+func sealV1(t *testing.T, key keyring.Key, plaintext, additionalData []byte) string {
+	t.Helper()
+
+	block, err := aes.NewCipher(key.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	sealed := aead.Seal(nonce, nonce, plaintext, additionalData)
+	return LEGACY_PREFIX + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+// Flips a bit of the ciphertext of a v2 value
+func tamper(t *testing.T, value string) string {
+	t.Helper()
+
+	prefix, encoded, _ := strings.Cut(strings.TrimPrefix(value, SEALED_PREFIX), ":")
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed[len(sealed)-1] ^= 1
+	return SEALED_PREFIX + prefix + ":" + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+func TestSealAndOpen(t *testing.T) {
+	c := newCipher(t, dataKey(1), dataKey(2))
+	cardNo := []byte("4000000000000002")
+
+	sealed, err := c.Seal([]byte("123"), cardNo)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if !strings.HasPrefix(sealed, "v2:2:") || !IsSealed(sealed) {
+		t.Errorf("Seal() = %q, want it sealed with key version 2", sealed)
+	}
+	if strings.Contains(sealed, "123") {
+		t.Errorf("Seal() = %q contains the plaintext", sealed)
+	}
+
+	other, _ := c.Seal([]byte("123"), cardNo)
+	if other == sealed {
+		t.Error("values sealed twice are equal")
+	}
+
+	opened, err := c.Open(sealed, cardNo)
+	if err != nil || string(opened) != "123" {
+		t.Errorf("Open() = %q, %v", opened, err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	legacyKey := dataKey(0)
+	c := newCipher(t, legacyKey, dataKey(1), dataKey(2))
+	cardNo := []byte("4000000000000002")
+
+	sealedV1 := sealV1(t, legacyKey, []byte("123"), cardNo)
+	sealedV2, err := newCipher(t, dataKey(1)).Seal([]byte("456"), cardNo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		value          string
+		additionalData []byte
+		want           string
+		err            error
+	}{
+		{"v1", sealedV1, cardNo, "123", nil},
+		{"v2 older key", sealedV2, cardNo, "456", nil},
+		{"v1 other row", sealedV1, []byte("4000000000000010"), "", ErrOpeningSealed},
+		{"v2 other row", sealedV2, []byte("4000000000000010"), "", ErrOpeningSealed},
+		{"v2 tampered", tamper(t, sealedV2), cardNo, "", ErrOpeningSealed},
+		{"v2 truncated", "v2:1:AAAA", cardNo, "", ErrOpeningSealed},
+		{"v2 not base64", "v2:1:!!!!", cardNo, "", ErrOpeningSealed},
+		{"v2 missing version", "v2:" + sealedV2[5:], cardNo, "", ErrOpeningSealed},
+		{"v2 unknown key", "v2:9" + sealedV2[4:], cardNo, "", keyring.ErrKeyNotFound},
+		{"plain text", "123", cardNo, "", ErrNotSealed},
+	}
+
+	for _, test := range tests {
+		opened, err := c.Open(test.value, test.additionalData)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%v: Open() error = %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if string(opened) != test.want {
+			t.Errorf("%v: Open() = %q, want %q", test.name, opened, test.want)
+		}
+	}
+
+	// v1 values need key version 0, i.e. CARD_KEK
+	_, err = newCipher(t, dataKey(1)).Open(sealedV1, cardNo)
+	if !errors.Is(err, keyring.ErrKeyNotFound) {
+		t.Errorf("Open() of v1 value without key version 0 = %v", err)
+	}
+}
+
+func TestNeedsReseal(t *testing.T) {
+	old := newCipher(t, dataKey(0), dataKey(1))
+	c := newCipher(t, dataKey(0), dataKey(1), dataKey(2))
+
+	sealedOld, _ := old.Seal([]byte("123"), nil)
+	sealedNew, _ := c.Seal([]byte("123"), nil)
+
+	tests := []struct {
+		name   string
+		value  string
+		reseal bool
+	}{
+		{"primary key", sealedNew, false},
+		{"older key", sealedOld, true},
+		{"v1", sealV1(t, dataKey(0), []byte("123"), nil), true},
+		{"plain text", "123", true},
+	}
+
+	for _, test := range tests {
+		if got := c.NeedsReseal(test.value); got != test.reseal {
+			t.Errorf("%v: NeedsReseal() = %v, want %v", test.name, got, test.reseal)
+		}
+	}
+}
+
+func TestNewCipher(t *testing.T) {
+	short := dataKey(1)
+	short.Secret = short.Secret[:16]
+
+	tests := []struct {
+		name string
+		keys []keyring.Key
+		err  error
+	}{
+		{"one key", []keyring.Key{dataKey(1)}, nil},
+		{"no keys", nil, ErrNoKeys},
+		{"short key", []keyring.Key{dataKey(2), short}, ErrInvalidKey},
+	}
+
+	for _, test := range tests {
+		_, err := NewCipher(test.keys)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%v: NewCipher() error = %v, want %v", test.name, err, test.err)
+		}
+	}
+
+	// the newest key seals whatever the order of keys
+	c := newCipher(t, dataKey(1), dataKey(3), dataKey(2))
+	sealed, _ := c.Seal([]byte("123"), nil)
+	if !strings.HasPrefix(sealed, "v2:3:") {
+		t.Errorf("Seal() = %q, want it sealed with key version 3", sealed)
+	}
+}
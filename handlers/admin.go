@@ -0,0 +1,559 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/caleb-mwasikira/tap_gopay/codes"
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/handlers/api"
+	v "github.com/caleb-mwasikira/tap_gopay/validators"
+)
+
+const (
+	DEFAULT_USERS_PER_PAGE int = 20
+	MAX_USERS_PER_PAGE     int = 100
+	MAX_USER_SEARCH_LEN    int = 255
+)
+
+type userPage struct {
+	Users   []db.UserDetails `json:"users"`
+	Page    int              `json:"page"`
+	PerPage int              `json:"per_page"`
+	Total   int              `json:"total"`
+}
+
+// a user together with the audit trail of what staff did to them
+type userHistory struct {
+	db.UserDetails
+	RoleChanges  []db.RoleChange  `json:"role_changes"`
+	AdminActions []db.AdminAction `json:"admin_actions"`
+}
+
+// Lists the users whose username, email or phone number contains the q
+// query parameter, a page at a time.
+//
+//	GET /admin/users?q=alice&page=2&per_page=20
+func (h *Handler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	query := r.URL.Query()
+	errs := map[string]string{}
+
+	search := query.Get("q")
+	if len(search) > MAX_USER_SEARCH_LEN {
+		errs["q"] = fmt.Sprintf("q must be at most %d characters long", MAX_USER_SEARCH_LEN)
+	}
+
+	page, err := queryInt(query.Get("page"), 1)
+	if err != nil || page < 1 {
+		errs["page"] = "page must be a number from 1"
+	}
+
+	perPage, err := queryInt(query.Get("per_page"), DEFAULT_USERS_PER_PAGE)
+	if err != nil || perPage < 1 || perPage > MAX_USERS_PER_PAGE {
+		errs["per_page"] = fmt.Sprintf("per_page must be a number from 1 to %d", MAX_USERS_PER_PAGE)
+	}
+
+	if len(errs) > 0 {
+		api.ValidationErrors(w, errs)
+		return
+	}
+
+	users, total, err := h.users.SearchUsers(search, page, perPage)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error searching users",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	result := userPage{
+		Users:   []db.UserDetails{},
+		Page:    page,
+		PerPage: perPage,
+		Total:   total,
+	}
+	for _, user := range users {
+		result.Users = append(result.Users, user.Details())
+	}
+
+	api.SendResponse(
+		w,
+		"Users found",
+		result,
+		nil,
+		http.StatusOK,
+	)
+}
+
+// Returns value as a number, or def if it is empty
+func queryInt(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// Shows a user with their role changes and the admin actions taken on them.
+//
+//	GET /admin/users/{id}
+func (h *Handler) GetUserHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user, ok := h.getUserFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	roleChanges, err := h.users.GetRoleChanges(user.Id)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error fetching user",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	adminActions, err := h.adminActions.GetAdminActions(user.Id)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error fetching user",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		"User found",
+		userHistory{
+			UserDetails:  user.Details(),
+			RoleChanges:  roleChanges,
+			AdminActions: adminActions,
+		},
+		nil,
+		http.StatusOK,
+	)
+}
+
+// GET /admin/users/{id}/cards
+func (h *Handler) GetUserCards(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user, ok := h.getUserFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	cards, err := h.cards.GetCreditCardsFor(user.Username)
+	if err != nil && err != sql.ErrNoRows {
+		api.Error(
+			w,
+			"Unexpected error fetching user credit cards",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	if cards == nil {
+		cards = []db.CreditCardDetails{}
+	}
+
+	api.SendResponse(
+		w,
+		"User credit cards found",
+		cards,
+		nil,
+		http.StatusOK,
+	)
+}
+
+// GET /admin/users/{id}/transactions
+func (h *Handler) GetUserTransactionsById(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user, ok := h.getUserFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	transactions, err := h.transactions.GetTransactionsDetailsWhere(user.Username)
+	if err != nil && err != sql.ErrNoRows {
+		api.Error(
+			w,
+			"Unexpected error fetching user transactions",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	if transactions == nil {
+		transactions = []db.TransactionDetails{}
+	}
+
+	api.SendResponse(
+		w,
+		"User transactions found",
+		transactions,
+		nil,
+		http.StatusOK,
+	)
+}
+
+// Stops a user from logging in and logs them out everywhere.
+// Their cards can still receive money; block them to stop that.
+func (h *Handler) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserSuspended(w, r, true)
+}
+
+// Lifts the suspension of a user. Whether their email address is verified
+// is not changed.
+func (h *Handler) ActivateUser(w http.ResponseWriter, r *http.Request) {
+	h.setUserSuspended(w, r, false)
+}
+
+func (h *Handler) setUserSuspended(w http.ResponseWriter, r *http.Request, suspended bool) {
+	w.Header().Add("Content-Type", "application/json")
+
+	admin, request, ok := getAdminRequest[v.AdminUserDto](w, r)
+	if !ok {
+		return
+	}
+
+	user, ok := h.getUserByEmail(w, request.Email)
+	if !ok {
+		return
+	}
+
+	action, verb := db.ActionActivateUser, "activating"
+	if suspended {
+		action, verb = db.ActionSuspendUser, "suspending"
+
+		// so that there is always an admin left
+		if user.Id == admin.Id {
+			api.Error(
+				w,
+				"Admins cannot suspend themselves",
+				domain.ErrRuleViolation.WithMessage("Admins cannot suspend themselves"),
+				http.StatusUnprocessableEntity,
+			)
+			return
+		}
+	}
+
+	err := h.users.SetUserSuspended(
+		db.AdminAction{
+			AdminId: admin.Id,
+			Action:  action,
+			UserId:  user.Id,
+			Reason:  request.Reason,
+		},
+		suspended,
+	)
+	if err != nil {
+		api.Error(
+			w,
+			fmt.Sprintf("Unexpected error %v user", verb),
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	if suspended {
+		_, err = h.sessions.RevokeAllSessions(user.Id)
+		if err != nil {
+			log.Printf("error logging out suspended user %v; %v\n", user.Id, err)
+		}
+	}
+
+	api.SendResponse(
+		w,
+		fmt.Sprintf("Success %v user %v", verb, user.Email),
+		nil,
+		nil,
+		http.StatusOK,
+	)
+}
+
+// Force-freezes a user's card. Blocked cards cannot send or receive money,
+// and unlike frozen cards their owner cannot unblock them.
+func (h *Handler) BlockCard(w http.ResponseWriter, r *http.Request) {
+	h.setCardBlocked(w, r, true)
+}
+
+// Unblocks a card blocked by an admin or after too many wrong PIN attempts
+func (h *Handler) UnblockCard(w http.ResponseWriter, r *http.Request) {
+	h.setCardBlocked(w, r, false)
+}
+
+func (h *Handler) setCardBlocked(w http.ResponseWriter, r *http.Request, blocked bool) {
+	w.Header().Add("Content-Type", "application/json")
+
+	admin, request, ok := getAdminRequest[v.AdminCardDto](w, r)
+	if !ok {
+		return
+	}
+
+	user, ok := h.getUserByEmail(w, request.Email)
+	if !ok {
+		return
+	}
+
+	action, verb := db.ActionUnblockCard, "unblocking"
+	if blocked {
+		action, verb = db.ActionBlockCard, "blocking"
+	}
+
+	_, err := h.cards.GetCreditCardWhere(user.Username, request.CardNo)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = domain.ErrCardNotFound.WithMessage("User has no credit card with account number %v", request.CardNo)
+		}
+
+		api.Error(
+			w,
+			fmt.Sprintf("Unexpected error %v credit card", verb),
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	err = h.cards.SetCardBlocked(
+		db.AdminAction{
+			AdminId: admin.Id,
+			Action:  action,
+			UserId:  user.Id,
+			CardNo:  request.CardNo,
+			Reason:  request.Reason,
+		},
+		blocked,
+	)
+	if err != nil {
+		api.Error(
+			w,
+			fmt.Sprintf("Unexpected error %v credit card", verb),
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		fmt.Sprintf("Success %v card %v", verb, request.CardNo),
+		nil,
+		nil,
+		http.StatusOK,
+	)
+}
+
+// Turns off two-factor authentication for a user who lost their
+// authenticator app and recovery codes. They can log in with their password
+// and enrol again.
+func (h *Handler) ResetUserTotp(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	admin, request, ok := getAdminRequest[v.AdminUserDto](w, r)
+	if !ok {
+		return
+	}
+
+	user, ok := h.getUserByEmail(w, request.Email)
+	if !ok {
+		return
+	}
+
+	err := h.totps.ResetTotp(db.AdminAction{
+		AdminId: admin.Id,
+		Action:  db.ActionResetTotp,
+		UserId:  user.Id,
+		Reason:  request.Reason,
+	})
+	if err != nil {
+		if err == domain.ErrTotpNotEnrolled {
+			err = domain.ErrTotpNotEnrolled.WithMessage("User has not set up two-factor authentication")
+		}
+
+		api.Error(
+			w,
+			"Unexpected error resetting two-factor authentication",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		fmt.Sprintf("Success resetting two-factor authentication of user %v", user.Email),
+		nil,
+		nil,
+		http.StatusOK,
+	)
+}
+
+// Sends a new email verification code to a user who has not verified
+// their email address yet
+func (h *Handler) ResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	admin, request, ok := getAdminRequest[v.AdminUserDto](w, r)
+	if !ok {
+		return
+	}
+
+	user, ok := h.getUserByEmail(w, request.Email)
+	if !ok {
+		return
+	}
+
+	if user.IsActive {
+		api.Error(
+			w,
+			"Email address is already verified",
+			domain.ErrConflict.WithMessage("Email address of user %v is already verified", user.Email),
+			http.StatusConflict,
+		)
+		return
+	}
+
+	// recorded first, as sending the email cannot be undone
+	ok = h.recordAdminAction(w, db.AdminAction{
+		AdminId: admin.Id,
+		Action:  db.ActionSendVerification,
+		UserId:  user.Id,
+		Reason:  request.Reason,
+	})
+	if !ok {
+		return
+	}
+
+	otp, err := h.codes.Issue(codes.EMAIL_VERIFICATION, user.Email)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error sending verification email",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	err = h.sendOtpEmail(user.Email, otp)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error sending verification email",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		fmt.Sprintf("Verification email sent to %v", user.Email),
+		nil,
+		nil,
+		http.StatusOK,
+	)
+}
+
+// Returns the logged in admin and the validated request body.
+// Writes an error response and returns false if either is missing.
+func getAdminRequest[T any](w http.ResponseWriter, r *http.Request) (*db.User, T, bool) {
+	var request T
+
+	admin := getLoggedInUser(r.Context())
+	if admin == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return nil, request, false
+	}
+
+	request, ok := v.GetValidJsonInput[T](w, r.Body)
+	if !ok {
+		return nil, request, false
+	}
+	return admin, request, true
+}
+
+// Fetches the user named by the {id} path value.
+// Writes an error response and returns false if there is no such user.
+func (h *Handler) getUserFromPath(w http.ResponseWriter, r *http.Request) (*db.User, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		api.ValidationErrors(w, map[string]string{"id": "id must be a user id"})
+		return nil, false
+	}
+
+	user, err := h.users.GetUserById(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = domain.ErrUserNotFound.WithMessage("No user with id %v found", id)
+		}
+
+		api.Error(
+			w,
+			"Unexpected error fetching user",
+			err,
+			http.StatusInternalServerError,
+		)
+		return nil, false
+	}
+	return user, true
+}
+
+// Fetches a user by email.
+// Writes an error response and returns false if there is no such user.
+func (h *Handler) getUserByEmail(w http.ResponseWriter, email string) (*db.User, bool) {
+	user, err := h.users.GetUser(email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = domain.ErrUserNotFound
+		}
+
+		api.Error(
+			w,
+			"Unexpected error fetching user",
+			err,
+			http.StatusInternalServerError,
+		)
+		return nil, false
+	}
+	return user, true
+}
+
+// Records an action before it is taken, so that no action goes unrecorded.
+// Writes an error response and returns false if it could not be recorded.
+func (h *Handler) recordAdminAction(w http.ResponseWriter, action db.AdminAction) bool {
+	err := h.adminActions.RecordAdminAction(action)
+	if err != nil {
+		api.Error(
+			w,
+			fmt.Sprintf("Unexpected error recording admin action %v", action.Action),
+			err,
+			http.StatusInternalServerError,
+		)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+)
+
+// Every admin action that is taken leaves a row in the audit trail, and
+// actions that fail leave none
+func TestAdminActionsAreAudited(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.createUser(t, "admin", domain.RoleAdmin)
+	bob := s.createUser(t, "bob", domain.RoleUser)
+	tokens := s.login(t, admin)
+
+	w := s.do(t, "POST", "/new-credit-card", fmt.Sprintf(`{"owner_email":%q,"initial_deposit":"100.00"}`, bob.Email), tokens.AccessToken)
+	var card struct {
+		CardNo string `json:"card_no"`
+	}
+	decodeResponse(t, w, &card)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /new-credit-card = %v, want %v", w.Code, http.StatusCreated)
+	}
+
+	userBody := fmt.Sprintf(`{"email":%q,"reason":"audit test"}`, bob.Email)
+	cardBody := fmt.Sprintf(`{"email":%q,"card_no":%q,"reason":"audit test"}`, bob.Email, card.CardNo)
+
+	tests := []struct {
+		path   string
+		body   string
+		setup  func()
+		status int
+		action db.AdminActionType // recorded by the request; none when empty
+	}{
+		{path: "/admin/suspend-user", body: userBody, status: http.StatusOK, action: db.ActionSuspendUser},
+		{path: "/admin/suspend-user", body: userBody, status: http.StatusConflict},
+		{path: "/admin/activate-user", body: userBody, status: http.StatusOK, action: db.ActionActivateUser},
+		{path: "/admin/activate-user", body: userBody, status: http.StatusConflict},
+		{path: "/admin/block-card", body: cardBody, status: http.StatusOK, action: db.ActionBlockCard},
+		{path: "/admin/block-card", body: cardBody, status: http.StatusConflict},
+		{path: "/admin/unblock-card", body: cardBody, status: http.StatusOK, action: db.ActionUnblockCard},
+		{path: "/admin/reset-totp", body: userBody, status: http.StatusUnprocessableEntity},
+		{
+			path: "/admin/reset-totp",
+			body: userBody,
+			setup: func() {
+				s.store.SaveTotpSecret(bob.Id, "sealed secret")
+				s.store.EnableTotp(bob.Id, 0, nil)
+			},
+			status: http.StatusOK,
+			action: db.ActionResetTotp,
+		},
+		// recorded before the email is sent, which fails without a mail server
+		{path: "/admin/send-verification-email", body: userBody, status: http.StatusInternalServerError, action: db.ActionSendVerification},
+	}
+
+	recorded := 0
+	for _, test := range tests {
+		if test.setup != nil {
+			test.setup()
+		}
+
+		w := s.do(t, "POST", test.path, test.body, tokens.AccessToken)
+		if w.Code != test.status {
+			resp := decodeResponse(t, w, nil)
+			t.Errorf("POST %v = %v %v, want %v", test.path, w.Code, resp.Code, test.status)
+		}
+
+		if test.action != "" {
+			recorded++
+		}
+
+		actions, err := s.store.GetAdminActions(bob.Id)
+		if err != nil || len(actions) != recorded {
+			t.Fatalf("POST %v: GetAdminActions() = %d actions, %v; want %d", test.path, len(actions), err, recorded)
+		}
+		if test.action == "" {
+			continue
+		}
+
+		last := actions[len(actions)-1]
+		if last.Action != test.action || last.AdminId != admin.Id || last.Reason != "audit test" {
+			t.Errorf("POST %v recorded %+v, want %v by admin %v", test.path, last, test.action, admin.Id)
+		}
+		if isCardAction := test.body == cardBody; isCardAction != (last.CardNo == card.CardNo) {
+			t.Errorf("POST %v recorded card %q", test.path, last.CardNo)
+		}
+	}
+}
+
+// Role changes are recorded in their own audit trail
+func TestSetUserRoleIsAudited(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.createUser(t, "admin", domain.RoleAdmin)
+	bob := s.createUser(t, "bob", domain.RoleUser)
+	tokens := s.login(t, admin)
+
+	body := fmt.Sprintf(`{"email":%q,"role":"agent","reason":"audit test"}`, bob.Email)
+	if w := s.do(t, "POST", "/admin/set-role", body, tokens.AccessToken); w.Code != http.StatusOK {
+		t.Fatalf("POST /admin/set-role = %v, want %v", w.Code, http.StatusOK)
+	}
+	if w := s.do(t, "POST", "/admin/set-role", body, tokens.AccessToken); w.Code != http.StatusConflict {
+		t.Errorf("POST /admin/set-role to the same role = %v, want %v", w.Code, http.StatusConflict)
+	}
+
+	changes, err := s.store.GetRoleChanges(bob.Id)
+	if err != nil || len(changes) != 1 {
+		t.Fatalf("GetRoleChanges() = %+v, %v; want one change", changes, err)
+	}
+
+	change := changes[0]
+	if change.From != domain.RoleUser || change.To != domain.RoleAgent || change.ChangedBy != admin.Id || change.Reason != "audit test" {
+		t.Errorf("POST /admin/set-role recorded %+v", change)
+	}
+}
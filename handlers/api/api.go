@@ -2,14 +2,15 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
-	"strings"
 
-	"github.com/go-sql-driver/mysql"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
 )
 
 type Response[T any] struct {
+	Code    string            `json:"code,omitempty"`
 	Message string            `json:"message"`
 	Data    T                 `json:"data"`
 	Errs    map[string]string `json:"errors,omitempty"`
@@ -36,29 +37,39 @@ func SendResponse(w http.ResponseWriter, message string, data any, errs map[stri
 	}
 }
 
+// Writes an error response.
+// When err is (or wraps) a domain error, its status, code and message are
+// sent to the client. Any other error is only logged and the client gets
+// errMsg with the given status code and a generic code for that status.
 func Error(w http.ResponseWriter, errMsg string, err error, code int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
+	errCode := domain.CodeForStatus(code)
 
-	log.Printf("[%v] %v; %v\n", code, errMsg, err)
+	var domainErr *domain.Error
+	if errors.As(err, &domainErr) {
+		errCode = domainErr.Code
+		errMsg = domainErr.Message
+		code = domainErr.Status
+	}
 
-	// check if the error is a MySQL error
-	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+	log.Printf("[%v] %v; %v\n", code, errMsg, err)
 
-		// handle user-defined error (1644, which comes from SIGNAL SQLSTATE '45000')
-		// https://fromdual.com/mysql-error-codes-and-messages-1600-1649
-		if mysqlErr.Number == 1644 {
-			fields := strings.SplitAfter(mysqlErr.Message, ":")
+	writeError(w, code, Response[any]{
+		Code:    errCode,
+		Message: errMsg,
+	})
+}
 
-			if len(fields) > 0 {
-				errMsg = strings.TrimSpace(fields[len(fields)-1])
-			}
-		}
-	}
+// Writes a 400 response listing the validation error of each invalid field
+func ValidationErrors(w http.ResponseWriter, errs map[string]string) {
+	writeError(w, domain.ErrValidationFailed.Status, Response[any]{
+		Code:    domain.ErrValidationFailed.Code,
+		Message: domain.ErrValidationFailed.Message,
+		Errs:    errs,
+	})
+}
 
-	resp := Response[any]{
-		Message: errMsg,
-		Data:    nil,
-	}
+func writeError(w http.ResponseWriter, code int, resp Response[any]) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(resp)
 }
@@ -2,40 +2,29 @@ package handlers
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/rand"
-	"crypto/sha256"
 	"database/sql"
-	"encoding/base64"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/caleb-mwasikira/tap_gopay/codes"
 	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
 	"github.com/caleb-mwasikira/tap_gopay/handlers/api"
-	"github.com/caleb-mwasikira/tap_gopay/utils"
 	v "github.com/caleb-mwasikira/tap_gopay/validators"
 	"github.com/golang-jwt/jwt"
 )
 
 var (
-	secretKey string
-
 	ErrInvalidPasswordFormat error = errors.New("invalid password format stored in database")
 )
 
-func init() {
-	utils.LoadEnvVariables()
-	secretKey = os.Getenv("SECRET_KEY")
-}
-
-func HandleSignUp(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) HandleSignUp(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 
 	user, ok := v.GetValidJsonInput[v.RegisterDto](w, r.Body)
@@ -44,10 +33,20 @@ func HandleSignUp(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// hash user password
-	user.Password = hashPassword(user.Password, nil)
+	hashedPassword, err := h.passwords.Hash(user.Password)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error registering user",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	user.Password = hashedPassword
 
 	// check if account already exists
-	dbUser, err := db.GetUser(user.Email)
+	dbUser, err := h.users.GetUser(user.Email)
 	if err != nil && err != sql.ErrNoRows {
 		api.Error(
 			w,
@@ -62,14 +61,14 @@ func HandleSignUp(w http.ResponseWriter, r *http.Request) {
 		api.Error(
 			w,
 			"User account already exists",
-			nil,
+			domain.ErrUserExists,
 			http.StatusConflict,
 		)
 		return
 	}
 
 	// create new user account
-	err = db.CreateUser(user)
+	err = h.users.CreateUser(user)
 	if err != nil {
 		api.Error(
 			w,
@@ -84,7 +83,7 @@ func HandleSignUp(w http.ResponseWriter, r *http.Request) {
 	// crucial to the registration process but merely a side-effect
 	// of it.
 	go func() {
-		err = sendWelcomeEmail(user.Email)
+		err = h.sendWelcomeEmail(user.Email)
 		if err != nil {
 			log.Printf("error sending welcome email; %v\n", err)
 		}
@@ -98,7 +97,7 @@ func HandleSignUp(w http.ResponseWriter, r *http.Request) {
 	)
 }
 
-func HandleLogin(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 
 	user, ok := v.GetValidJsonInput[v.LoginDto](w, r.Body)
@@ -106,14 +105,24 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	attempt, ok := h.allowAttempt(w, r, accountAttemptKey(LOGIN_ATTEMPT, user.Email))
+	if !ok {
+		return
+	}
+	defer attempt.release()
+
 	// fetch database user
-	dbUser, err := db.GetUser(user.Email)
+	dbUser, err := h.users.GetUser(user.Email)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			api.SendResponse(
+			// counted the same as a wrong password so that locking out
+			// does not give away which accounts exist
+			attempt.fail("")
+
+			api.Error(
 				w,
 				"Invalid username or password",
-				nil, nil,
+				domain.ErrInvalidCredentials,
 				http.StatusUnauthorized,
 			)
 			return
@@ -128,18 +137,40 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	passwordMatch := verifyPassword(user.Password, dbUser.Password)
+	passwordMatch, err := h.passwords.Verify(user.Password, dbUser.Password)
+	if err != nil {
+		log.Printf("error verifying password of user %v; %v\n", dbUser.Id, err)
+	}
 	if !passwordMatch {
+		attempt.fail(dbUser.Email)
+
 		api.Error(
 			w,
 			"Invalid username or password",
-			err,
+			domain.ErrInvalidCredentials,
 			http.StatusUnauthorized,
 		)
 		return
 	}
 
-	signedToken, err := createToken(*dbUser)
+	attempt.succeed()
+
+	if !h.allowUnsuspended(w, dbUser) {
+		return
+	}
+
+	// upgrade hashes made with an older algorithm or weaker parameters
+	// while the plain text password is at hand
+	if h.passwords.NeedsRehash(dbUser.Password) {
+		h.rehashPassword(dbUser.Email, user.Password)
+	}
+
+	// users with TOTP on finish logging in at POST /login-totp
+	if h.sendLoginChallenge(w, *dbUser) {
+		return
+	}
+
+	tokens, err := h.startSession(r, *dbUser)
 	if err != nil {
 		api.Error(
 			w,
@@ -150,25 +181,27 @@ func HandleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// set JWT as cookie in response
-	http.SetCookie(w, &http.Cookie{
-		Name:     "login",
-		Value:    signedToken,
-		Path:     "/",
-		HttpOnly: true,  // prevents JS access
-		Secure:   false, // send only over HTTPs; TODO: set to true in production
-		MaxAge:   int(24 * time.Nanosecond),
-	})
+	// log browsers in with cookies
+	err = h.setSessionCookies(w, tokens)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error loggin in user",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
 
 	api.SendResponse(
 		w,
 		"Login successful",
-		signedToken, nil,
+		tokens, nil,
 		http.StatusOK,
 	)
 }
 
-func SendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) SendVerificationEmail(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 
 	user, ok := v.GetValidJsonInput[v.EmailDto](w, r.Body)
@@ -176,8 +209,7 @@ func SendVerificationEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// save generated otp to database
-	otp, err := db.GenerateAndSaveOtp(user.Email)
+	otp, err := h.codes.Issue(codes.EMAIL_VERIFICATION, user.Email)
 	if err != nil {
 		api.Error(
 			w,
@@ -188,7 +220,7 @@ func SendVerificationEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = sendOtpEmail(user.Email, otp)
+	err = h.sendOtpEmail(user.Email, otp)
 	if err != nil {
 		api.Error(
 			w,
@@ -207,7 +239,7 @@ func SendVerificationEmail(w http.ResponseWriter, r *http.Request) {
 	)
 }
 
-func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 
 	user, ok := v.GetValidJsonInput[v.VerifyEmailDto](w, r.Body)
@@ -215,17 +247,16 @@ func VerifyEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// check if otp code exists in database
-	_, err := db.GetOtpRecord(user.Email, user.Otp)
+	attempt, ok := h.allowAttempt(w, r, accountAttemptKey(VERIFY_EMAIL_ATTEMPT, user.Email))
+	if !ok {
+		return
+	}
+	defer attempt.release()
+
+	err := h.codes.Consume(codes.EMAIL_VERIFICATION, user.Email, user.Otp)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			api.SendResponse(
-				w,
-				"Invalid email or OTP code",
-				nil, nil,
-				http.StatusBadRequest,
-			)
-			return
+		if errors.Is(err, domain.ErrOtpInvalid) {
+			attempt.fail(user.Email)
 		}
 
 		api.Error(
@@ -237,12 +268,10 @@ func VerifyEmail(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = db.UpdateUser(
-		user.Email,
-		map[string]any{
-			"is_active": true,
-		},
-	)
+	attempt.succeed()
+
+	active := true
+	err = h.users.UpdateUser(user.Email, db.UserPatch{IsActive: &active})
 	if err != nil {
 		api.Error(
 			w,
@@ -262,7 +291,7 @@ func VerifyEmail(w http.ResponseWriter, r *http.Request) {
 	)
 }
 
-func RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 
 	user, ok := v.GetValidJsonInput[v.EmailDto](w, r.Body)
@@ -270,7 +299,7 @@ func RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := db.GenerateAndSavePasswordToken(user.Email)
+	token, err := h.codes.Issue(codes.PASSWORD_RESET, user.Email)
 	if err != nil {
 		api.Error(
 			w,
@@ -281,7 +310,7 @@ func RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = sendPasswordResetEmail(user.Email, token)
+	err = h.sendPasswordResetEmail(user.Email, token)
 	if err != nil {
 		api.Error(
 			w,
@@ -301,7 +330,7 @@ func RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
 	)
 }
 
-func ResetPassword(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 
 	request, ok := v.GetValidJsonInput[v.ResetPasswordDto](w, r.Body)
@@ -309,16 +338,16 @@ func ResetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := db.GetPasswordResetToken(request.Email, request.PasswordResetToken)
+	attempt, ok := h.allowAttempt(w, r, accountAttemptKey(RESET_PASSWORD_ATTEMPT, request.Email))
+	if !ok {
+		return
+	}
+	defer attempt.release()
+
+	err := h.codes.Consume(codes.PASSWORD_RESET, request.Email, request.PasswordResetToken)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			api.SendResponse(
-				w,
-				"Invalid password-reset-token or email",
-				nil, nil,
-				http.StatusUnauthorized,
-			)
-			return
+		if errors.Is(err, domain.ErrResetTokenInvalid) {
+			attempt.fail(request.Email)
 		}
 
 		api.Error(
@@ -330,14 +359,9 @@ func ResetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashedPassword := hashPassword(request.NewPassword, nil)
+	attempt.succeed()
 
-	err = db.UpdateUser(
-		request.Email,
-		map[string]any{
-			"password": hashedPassword,
-		},
-	)
+	hashedPassword, err := h.passwords.Hash(request.NewPassword)
 	if err != nil {
 		api.Error(
 			w,
@@ -348,6 +372,26 @@ func ResetPassword(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	err = h.users.UpdateUser(request.Email, db.UserPatch{Password: &hashedPassword})
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error reseting user password",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	// log out every device that may have been logged in with the old password
+	user, err := h.users.GetUser(request.Email)
+	if err == nil {
+		_, err = h.sessions.RevokeAllSessions(user.Id)
+	}
+	if err != nil {
+		log.Printf("error revoking sessions after password reset; %v\n", err)
+	}
+
 	api.SendResponse(
 		w,
 		"Success resetting account password",
@@ -374,7 +418,7 @@ func extractJwtFromHeaders(r *http.Request) (string, error) {
 }
 
 func extractJwtFromCookies(r *http.Request) (string, error) {
-	cookie, err := r.Cookie("login")
+	cookie, err := r.Cookie(LOGIN_COOKIE)
 	if err != nil {
 		return "", err
 	}
@@ -382,120 +426,190 @@ func extractJwtFromCookies(r *http.Request) (string, error) {
 	return cookie.Value, nil
 }
 
-func AuthMiddleware(next http.Handler) http.Handler {
+func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var (
 			token string
 			err   error
 		)
 
+		// cookies are sent by the browser on its own, even on requests made
+		// by other sites, so cookie authenticated requests need a CSRF token
+		handler := next
+
 		token, err = extractJwtFromHeaders(r)
 		if err != nil {
 			errMsg := fmt.Errorf("%v", err)
+			handler = CsrfMiddleware(next)
 
 			token, err = extractJwtFromCookies(r)
 			if err != nil {
 				api.Error(
 					w,
 					"Unauthorized request detected. Please login and try again",
-					fmt.Errorf("%v; %v", errMsg, err),
+					domain.ErrUnauthorized.Wrap(fmt.Errorf("%v; %v", errMsg, err)),
 					http.StatusUnauthorized,
 				)
 				return
 			}
 		}
 
-		claims, err := verifyToken(token)
+		claims, err := h.verifyToken(token)
 		if err != nil {
 			api.Error(
 				w,
 				"Invalid Authorization token",
-				err,
+				domain.ErrInvalidToken.Wrap(err),
 				http.StatusUnauthorized,
 			)
 			return
 		}
 
-		user, err := extractFromClaims[db.User](claims)
+		accessClaims, err := parseAccessClaims(claims)
 		if err != nil {
 			api.Error(
 				w,
 				"Invalid Authorization token",
-				fmt.Errorf("error extracting user from JWT token; %v", err),
+				domain.ErrInvalidToken.Wrap(err),
+				http.StatusUnauthorized,
+			)
+			return
+		}
+
+		// tokens stop working as soon as their session is logged out
+		session, err := h.sessions.GetSession(accessClaims.SessionId)
+		if err != nil && err != sql.ErrNoRows {
+			api.Error(
+				w,
+				"Unexpected error authenticating request",
+				err,
+				http.StatusInternalServerError,
+			)
+			return
+		}
+		if session == nil || session.UserId != accessClaims.UserId || !session.IsActive(h.now()) {
+			api.Error(
+				w,
+				"Session has expired or was logged out",
+				domain.ErrSessionExpired,
+				http.StatusUnauthorized,
+			)
+			return
+		}
+
+		// the token only names the user; their current details, such as
+		// their role, are always taken from the store
+		user, err := h.users.GetUserById(accessClaims.UserId)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				api.Error(
+					w,
+					"Invalid Authorization token",
+					domain.ErrInvalidToken.Wrap(fmt.Errorf("user %v no longer exists", accessClaims.UserId)),
+					http.StatusUnauthorized,
+				)
+				return
+			}
+
+			api.Error(
+				w,
+				"Unexpected error authenticating request",
+				err,
 				http.StatusInternalServerError,
 			)
 			return
 		}
 
+		if !h.allowUnsuspended(w, user) {
+			return
+		}
+
 		// set user object in request context
 		ctx := context.WithValue(r.Context(), "user", user)
+		ctx = context.WithValue(ctx, "session", session)
 		new_req := r.WithContext(ctx)
 
-		next.ServeHTTP(w, new_req)
+		handler.ServeHTTP(w, new_req)
 	})
 }
 
-func createToken(user db.User) (string, error) {
-	data, err := json.Marshal(user)
-	if err != nil {
-		return "", err
+// Writes an error response and returns false if the user has been suspended.
+// Suspending a user logs them out, so this only stops them logging in again
+// and requests made before their cached copy expires.
+func (h *Handler) allowUnsuspended(w http.ResponseWriter, user *db.User) bool {
+	if !user.IsSuspended {
+		return true
 	}
 
-	aud := base64.RawStdEncoding.EncodeToString(data)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub": user.Email,                            // Subject (user identifier)
-		"iss": "tap_gopay",                           // Issuer
-		"aud": aud,                                   // Audience (user data)
-		"exp": time.Now().Add(24 * time.Hour).Unix(), // Expiration time
-		"iat": time.Now().Unix(),                     // Issued at
-	})
-
-	signedToken, err := token.SignedString([]byte(secretKey))
-	if err != nil {
-		return "", fmt.Errorf("error signing JWT; %v", err)
-	}
+	api.Error(
+		w,
+		"Account has been suspended",
+		domain.ErrAccountSuspended,
+		http.StatusForbidden,
+	)
+	return false
+}
 
-	return signedToken, nil
+// accessClaims are the claims of an access token.
+// The role is for services that verify tokens without a user store;
+// this server reads the role of the user from the store instead.
+type accessClaims struct {
+	UserId    int         // sub
+	Role      domain.Role // role
+	SessionId string      // sid
 }
 
-func verifyToken(tokenString string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenString, func(*jwt.Token) (interface{}, error) {
-		return []byte(secretKey), nil
-	})
-	if err != nil {
-		return nil, err
+func (h *Handler) createToken(user db.User, sessionId string) (string, error) {
+	claims := jwt.MapClaims{
+		"sub":  strconv.Itoa(user.Id),                   // Subject (user id)
+		"role": user.Role,                               // Role of the user when the token was issued
+		"sid":  sessionId,                               // Session id
+		"iss":  "tap_gopay",                             // Issuer
+		"exp":  time.Now().Add(h.accessTokenTTL).Unix(), // Expiration time
+		"iat":  time.Now().Unix(),                       // Issued at
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return claims, nil
-	} else {
-		return nil, fmt.Errorf("invalid JWT token")
-	}
+	// tokens are signed with the newest JWT key and name it in
+	// their kid header, so they can still be verified after rotation
+	return h.tokenSigner.Sign(claims)
 }
 
-func extractFromClaims[T any](claims jwt.MapClaims) (*T, error) {
-	audVal, ok := claims["aud"]
-	if !ok {
-		return nil, fmt.Errorf("missing 'aud' key in JWT claims")
-	}
+func (h *Handler) verifyToken(tokenString string) (jwt.MapClaims, error) {
+	return h.tokenVerifier.Verify(tokenString)
+}
 
-	b64EncodedData, ok := audVal.(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid type on 'aud' key in JWT claims")
-	}
+// Publishes the public keys access tokens are verified with as a JSON Web Key Set.
+// Only EdDSA and ES256 keys are listed; HS256 keys are secret.
+func (h *Handler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
 
-	data, err := base64.RawStdEncoding.DecodeString(b64EncodedData)
+	// served as is rather than in an api.Response, as verifiers expect
+	err := json.NewEncoder(w).Encode(h.jwks)
 	if err != nil {
-		return nil, err
+		log.Printf("error writing JWKS; %v\n", err)
 	}
+}
 
-	var result T
-	err = json.Unmarshal(data, &result)
+func parseAccessClaims(claims jwt.MapClaims) (accessClaims, error) {
+	sub, _ := claims["sub"].(string)
+	userId, err := strconv.Atoi(sub)
 	if err != nil {
-		return nil, err
+		return accessClaims{}, fmt.Errorf("invalid 'sub' claim %q in JWT", sub)
 	}
 
-	return &result, nil
+	sid, _ := claims["sid"].(string)
+	if sid == "" {
+		return accessClaims{}, fmt.Errorf("missing 'sid' claim in JWT")
+	}
+
+	role, _ := claims["role"].(string)
+
+	return accessClaims{
+		UserId:    userId,
+		Role:      domain.Role(role),
+		SessionId: sid,
+	}, nil
 }
 
 func getLoggedInUser(ctx context.Context) *db.User {
@@ -503,69 +617,17 @@ func getLoggedInUser(ctx context.Context) *db.User {
 	return user
 }
 
-/*
-hashes a password using HMAC + SHA256 alg and returns hashed result
-in the format $id$salt$hashed.
-
-$id is the algoirthm prefix used on Linux as follows:
-
-	$1$ is MD5
-	$2a$ is Blowfish
-	$2y$ is Blowfish
-	$5$ is SHA-256
-	$6$ is SHA-512
-	$y$ is yescrypt
-*/
-func hashPassword(password string, salt []byte) string {
-	if len(salt) == 0 {
-		salt = generateSalt(32)
-	}
-
-	saltAndPassword := fmt.Sprintf("%s.%s", hex.EncodeToString(salt), password)
-	h := hmac.New(sha256.New, []byte(secretKey))
-
-	_, err := h.Write([]byte(saltAndPassword))
-	if err != nil {
-		log.Fatalf("error hashing password: %v\n", err)
-	}
-
-	return fmt.Sprintf("$5$%x$%x", salt, h.Sum(nil))
-}
-
-func verifyPassword(password, dbPassword string) bool {
-	// extract salt and HMAC from dbPassword
-	dbPassword = strings.Trim(dbPassword, "$")
-	fields := strings.Split(dbPassword, "$")
-	if len(fields) != 3 {
-		log.Println("Invalid password format")
-		return false
-	}
-
-	expectedHmac, err := hex.DecodeString(fields[2]) // decode stored HMAC from hex
+// Replaces the stored hash of a user's password with one made with the current
+// parameters. Failures are only logged as the old hash keeps working.
+func (h *Handler) rehashPassword(email, password string) {
+	hashedPassword, err := h.passwords.Hash(password)
 	if err != nil {
-		log.Println("Invalid HMAC encoding")
-		return false
-	}
-
-	// compute HMAC with the same salt
-	// use original hex-encoded salt string
-	saltAndPassword := fmt.Sprintf("%s.%s", fields[1], password)
-	h := hmac.New(sha256.New, []byte(secretKey))
-
-	_, err = h.Write([]byte(saltAndPassword))
-	if err != nil {
-		log.Fatalf("error hashing password: %v\n", err)
+		log.Printf("error rehashing user password; %v\n", err)
+		return
 	}
 
-	actualHmac := h.Sum(nil)
-	return hmac.Equal(expectedHmac, actualHmac)
-}
-
-func generateSalt(length int) []byte {
-	salt := make([]byte, length)
-	_, err := rand.Read(salt)
+	err = h.users.UpdateUser(email, db.UserPatch{Password: &hashedPassword})
 	if err != nil {
-		log.Fatalf("error reading random value into bytes; %v\n", err)
+		log.Printf("error saving rehashed user password; %v\n", err)
 	}
-	return salt
 }
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/caleb-mwasikira/tap_gopay/codes"
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/handlers/api"
+	v "github.com/caleb-mwasikira/tap_gopay/validators"
+)
+
+const (
+	MAX_CARD_PIN_ATTEMPTS int = 3
+)
+
+// Emails an OTP code to the logged in user for setting or resetting a card PIN
+func (h *Handler) SendCardPinOtp(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user := getLoggedInUser(r.Context())
+	if user == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	otp, err := h.codes.Issue(codes.PIN_RESET, user.Email)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error sending OTP code",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	err = h.sendOtpEmail(user.Email, otp)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error sending OTP code",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		"OTP code sent. Please check your email",
+		nil, nil,
+		http.StatusOK,
+	)
+}
+
+// Sets the PIN of a card that does not have one yet
+func (h *Handler) SetCardPin(w http.ResponseWriter, r *http.Request) {
+	h.setCardPinWithOtp(w, r, false)
+}
+
+// Replaces a forgotten PIN.
+// Cards blocked after too many wrong PIN attempts are unblocked.
+func (h *Handler) ResetCardPin(w http.ResponseWriter, r *http.Request) {
+	h.setCardPinWithOtp(w, r, true)
+}
+
+func (h *Handler) setCardPinWithOtp(w http.ResponseWriter, r *http.Request, reset bool) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user := getLoggedInUser(r.Context())
+	if user == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	request, ok := v.GetValidJsonInput[v.SetCardPinDto](w, r.Body)
+	if !ok {
+		return
+	}
+
+	card, ok := h.getOwnCreditCard(w, user, request.CardNo)
+	if !ok {
+		return
+	}
+
+	if card.Status == domain.CardClosed {
+		api.Error(
+			w,
+			"Credit card is not active",
+			domain.ErrCardInactive,
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+
+	if card.PinHash != "" && !reset {
+		api.Error(
+			w,
+			"Credit card already has a PIN",
+			domain.ErrCardPinAlreadySet,
+			http.StatusConflict,
+		)
+		return
+	}
+
+	// checked last as the OTP code is used up once checked
+	err := h.codes.Consume(codes.PIN_RESET, user.Email, request.Otp)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error setting credit card PIN",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	// PINs are hashed the same way as passwords
+	pinHash, err := h.passwords.Hash(request.Pin)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error setting credit card PIN",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	err = h.cards.SetCardPin(card.CardNo, pinHash)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error setting credit card PIN",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	if reset && card.Status == domain.CardBlocked && card.PinAttempts >= MAX_CARD_PIN_ATTEMPTS {
+		err = h.cards.UpdateCardStatus(db.CardStatusChange{
+			CardNo:    card.CardNo,
+			From:      domain.CardBlocked,
+			To:        domain.CardActive,
+			Reason:    "card PIN reset",
+			ChangedBy: user.Id,
+		})
+		if err != nil {
+			api.Error(
+				w,
+				"Unexpected error unblocking credit card",
+				err,
+				http.StatusInternalServerError,
+			)
+			return
+		}
+	}
+
+	api.SendResponse(
+		w,
+		"Credit card PIN set successfully",
+		nil, nil,
+		http.StatusOK,
+	)
+}
+
+func (h *Handler) ChangeCardPin(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user := getLoggedInUser(r.Context())
+	if user == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	request, ok := v.GetValidJsonInput[v.ChangeCardPinDto](w, r.Body)
+	if !ok {
+		return
+	}
+
+	card, ok := h.getOwnCreditCard(w, user, request.CardNo)
+	if !ok {
+		return
+	}
+
+	switch card.Status {
+	case domain.CardClosed:
+		api.Error(
+			w,
+			"Credit card is not active",
+			domain.ErrCardInactive,
+			http.StatusUnprocessableEntity,
+		)
+		return
+
+	case domain.CardBlocked:
+		err := domain.ErrCardBlocked.WithMessage("Credit card is blocked; reset its PIN to unblock it")
+
+		// cards blocked by an admin are only unblocked by an admin
+		if card.PinAttempts < MAX_CARD_PIN_ATTEMPTS {
+			err = domain.ErrCardBlocked.WithMessage("Credit card has been blocked. Please contact support")
+		}
+
+		api.Error(
+			w,
+			"Credit card is blocked",
+			err,
+			http.StatusForbidden,
+		)
+		return
+	}
+
+	err := h.verifyCardSecrets(card, request.OldPin, "")
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error changing credit card PIN",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	pinHash, err := h.passwords.Hash(request.NewPin)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error changing credit card PIN",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	err = h.cards.SetCardPin(card.CardNo, pinHash)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error changing credit card PIN",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		"Credit card PIN changed successfully",
+		nil, nil,
+		http.StatusOK,
+	)
+}
+
+// Checks pin, and cvv when given, against the card.
+// An attempt is reserved against the card before either is checked, so that
+// concurrent requests cannot get past MAX_CARD_PIN_ATTEMPTS. A wrong PIN or
+// CVV leaves the attempt counted and blocks the card once the limit is
+// reached; getting them right clears the count.
+func (h *Handler) verifyCardSecrets(card *v.CreditCardDto, pin, cvv string) error {
+	if card.PinHash == "" {
+		return domain.ErrCardPinNotSet
+	}
+
+	attempts, err := h.cards.ReservePinAttempt(card.CardNo, MAX_CARD_PIN_ATTEMPTS)
+	if err != nil {
+		return err
+	}
+
+	pinMatch, err := h.passwords.Verify(pin, card.PinHash)
+	if err != nil {
+		h.releasePinAttempt(card.CardNo)
+		return err
+	}
+	if !pinMatch {
+		return h.failCardSecret(card.CardNo, attempts, domain.ErrInvalidCardPin.WithMessage("Invalid credit card PIN; %d attempt(s) left", MAX_CARD_PIN_ATTEMPTS-attempts))
+	}
+
+	if cvv != "" {
+		cvvMatch, err := h.verifyCvv(card, cvv)
+		if err != nil {
+			h.releasePinAttempt(card.CardNo)
+			return err
+		}
+		if !cvvMatch {
+			return h.failCardSecret(card.CardNo, attempts, domain.ErrInvalidCvv.WithMessage("Invalid card security code (CVV); %d attempt(s) left", MAX_CARD_PIN_ATTEMPTS-attempts))
+		}
+	}
+
+	// setting the PIN again also clears the attempts
+	if h.passwords.NeedsRehash(card.PinHash) {
+		pinHash, err := h.passwords.Hash(pin)
+		if err != nil {
+			return err
+		}
+		return h.cards.SetCardPin(card.CardNo, pinHash)
+	}
+	return h.cards.ResetPinAttempts(card.CardNo)
+}
+
+// Leaves a wrong attempt counted, blocking the card on the last one
+func (h *Handler) failCardSecret(cardNo string, attempts int, err error) error {
+	if attempts < MAX_CARD_PIN_ATTEMPTS {
+		return err
+	}
+
+	blockErr := h.cards.BlockCardAfterPinAttempts(cardNo, MAX_CARD_PIN_ATTEMPTS)
+	if blockErr != nil {
+		return blockErr
+	}
+	return domain.ErrCardBlocked
+}
+
+// Gives back an attempt that could not be checked. Errors are only logged.
+func (h *Handler) releasePinAttempt(cardNo string) {
+	err := h.cards.ReleasePinAttempt(cardNo)
+	if err != nil {
+		log.Printf("error releasing PIN attempt of card %v; %v\n", cardNo, err)
+	}
+}
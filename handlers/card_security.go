@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/encryption"
+	"github.com/caleb-mwasikira/tap_gopay/handlers/api"
+	v "github.com/caleb-mwasikira/tap_gopay/validators"
+)
+
+type RevealedCvv struct {
+	CardNo      string `json:"card_no"`
+	Cvv         string `json:"cvv"`
+	ExpiryMonth int    `json:"expiry_month"`
+	ExpiryYear  int    `json:"expiry_year"`
+}
+
+// Shows the CVV of one of the logged in user's cards.
+// Each CVV can only be revealed once; it is never returned again afterwards.
+func (h *Handler) RevealCvv(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	user := getLoggedInUser(r.Context())
+	if user == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	request, ok := v.GetValidJsonInput[v.CardNoDto](w, r.Body)
+	if !ok {
+		return
+	}
+
+	card, ok := h.getOwnCreditCard(w, user, request.CardNo)
+	if !ok {
+		return
+	}
+
+	if card.Status == domain.CardClosed {
+		api.Error(
+			w,
+			"Credit card is not active",
+			domain.ErrCardInactive,
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+
+	// decrypt before marking the CVV as revealed so that
+	// a decryption failure does not lose it for good
+	cvv, err := h.openCvv(card)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error revealing card security code",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	err = h.cards.MarkCvvRevealed(card.CardNo)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error revealing card security code",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		"Card security code revealed. It will not be shown again",
+		RevealedCvv{
+			CardNo:      card.CardNo,
+			Cvv:         cvv,
+			ExpiryMonth: card.ExpiryMonth,
+			ExpiryYear:  card.ExpiryYear,
+		},
+		nil,
+		http.StatusOK,
+	)
+}
+
+func (h *Handler) openCvv(card *v.CreditCardDto) (string, error) {
+	cvv, err := h.cvvCipher.Open(card.Cvv, []byte(card.CardNo))
+	if errors.Is(err, encryption.ErrNotSealed) {
+		return "", fmt.Errorf("CVV of card %v is not encrypted; run the encrypt-cvvs command", card.CardNo)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(cvv), nil
+}
+
+// Compares cvv with the card's stored CVV in constant time
+func (h *Handler) verifyCvv(card *v.CreditCardDto, cvv string) (bool, error) {
+	storedCvv, err := h.openCvv(card)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare([]byte(storedCvv), []byte(cvv)) == 1, nil
+}
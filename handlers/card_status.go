@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/handlers/api"
+	v "github.com/caleb-mwasikira/tap_gopay/validators"
+)
+
+// Temporarily disables one of the logged in user's cards.
+// Also served on the deprecated POST /deactivate-card route.
+func (h *Handler) FreezeCard(w http.ResponseWriter, r *http.Request) {
+	h.changeCardStatus(w, r, domain.CardActive, domain.CardFrozen, "freezing")
+}
+
+func (h *Handler) UnfreezeCard(w http.ResponseWriter, r *http.Request) {
+	h.changeCardStatus(w, r, domain.CardFrozen, domain.CardActive, "unfreezing")
+}
+
+func (h *Handler) changeCardStatus(w http.ResponseWriter, r *http.Request, from, to domain.CardStatus, action string) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user := getLoggedInUser(r.Context())
+	if user == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	request, ok := v.GetValidJsonInput[v.CardStatusDto](w, r.Body)
+	if !ok {
+		return
+	}
+
+	_, ok = h.getOwnCreditCard(w, user, request.CardNo)
+	if !ok {
+		return
+	}
+
+	err := h.cards.UpdateCardStatus(db.CardStatusChange{
+		CardNo:    request.CardNo,
+		From:      from,
+		To:        to,
+		Reason:    request.Reason,
+		ChangedBy: user.Id,
+	})
+	if err != nil {
+		api.Error(
+			w,
+			fmt.Sprintf("Unexpected error %v credit card", action),
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		fmt.Sprintf("Success %v card %v", action, request.CardNo),
+		nil,
+		nil,
+		http.StatusOK,
+	)
+}
+
+// Permanently closes one of the logged in user's cards.
+// Any balance left on the card is swept to another of the user's cards.
+func (h *Handler) CloseCard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user := getLoggedInUser(r.Context())
+	if user == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	request, ok := v.GetValidJsonInput[v.CloseCardDto](w, r.Body)
+	if !ok {
+		return
+	}
+
+	card, ok := h.getOwnCreditCard(w, user, request.CardNo)
+	if !ok {
+		return
+	}
+
+	// a blocked card could otherwise be emptied by whoever got it blocked
+	if card.Status == domain.CardBlocked {
+		api.Error(
+			w,
+			"Blocked credit cards cannot be closed",
+			domain.ErrInvalidCardTransition.WithMessage("Blocked credit cards cannot be closed. Please contact support"),
+			http.StatusConflict,
+		)
+		return
+	}
+
+	sweep, err := h.cards.CloseCard(
+		db.CardStatusChange{
+			CardNo:    request.CardNo,
+			Reason:    request.Reason,
+			ChangedBy: user.Id,
+		},
+		request.SweepTo,
+		h.now(),
+	)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error closing credit card",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		fmt.Sprintf("Success closing card %v", request.CardNo),
+		sweep,
+		nil,
+		http.StatusOK,
+	)
+}
+
+func (h *Handler) CardStatusHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user := getLoggedInUser(r.Context())
+	if user == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	request, ok := v.GetValidJsonInput[v.CardNoDto](w, r.Body)
+	if !ok {
+		return
+	}
+
+	_, ok = h.getOwnCreditCard(w, user, request.CardNo)
+	if !ok {
+		return
+	}
+
+	history, err := h.cards.GetCardStatusHistory(request.CardNo)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error fetching credit card history",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		"Credit card history found",
+		history,
+		nil,
+		http.StatusOK,
+	)
+}
+
+// Fetches a card of the logged in user, whatever its status.
+// Writes an error response and returns false if the card is not theirs.
+func (h *Handler) getOwnCreditCard(w http.ResponseWriter, user *db.User, cardNo string) (*v.CreditCardDto, bool) {
+	card, err := h.cards.GetCreditCardWhere(user.Username, cardNo)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			api.Error(
+				w,
+				"No credit card with that number found under your name",
+				domain.ErrCardNotFound.WithMessage("No credit card with account number %v found under your name", cardNo),
+				http.StatusNotFound,
+			)
+			return nil, false
+		}
+
+		api.Error(
+			w,
+			"Unexpected error fetching credit card",
+			err,
+			http.StatusInternalServerError,
+		)
+		return nil, false
+	}
+
+	return card, true
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/handlers/api"
+)
+
+// Browsers are logged in with cookies, as an alternative to sending
+// the access token in an Authorization: Bearer header
+const (
+	LOGIN_COOKIE   string = "login"         // access token; HttpOnly
+	REFRESH_COOKIE string = "refresh_token" // refresh token; HttpOnly
+	CSRF_COOKIE    string = "csrf_token"    // readable by scripts, which echo it in CSRF_HEADER
+	CSRF_HEADER    string = "X-CSRF-Token"
+)
+
+// CookieConfig holds the attributes of the session cookies
+type CookieConfig struct {
+	// Only send cookies over HTTPS. ConfigFromEnv enables it unless COOKIE_SECURE=false.
+	Secure bool
+
+	// http.SameSiteLaxMode when left empty
+	SameSite http.SameSite
+
+	// Domain the cookies are sent to, including its subdomains.
+	// Only the exact host that set them when left empty.
+	Domain string
+}
+
+func cookieConfigFromEnv() (CookieConfig, error) {
+	cfg := CookieConfig{
+		Secure: true,
+		Domain: os.Getenv("COOKIE_DOMAIN"),
+	}
+
+	secure := os.Getenv("COOKIE_SECURE")
+	if secure != "" {
+		var err error
+		cfg.Secure, err = strconv.ParseBool(secure)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid COOKIE_SECURE %q; must be true or false", secure)
+		}
+	}
+
+	sameSite := os.Getenv("COOKIE_SAMESITE")
+	switch strings.ToLower(sameSite) {
+	case "", "lax":
+		cfg.SameSite = http.SameSiteLaxMode
+	case "strict":
+		cfg.SameSite = http.SameSiteStrictMode
+	case "none":
+		cfg.SameSite = http.SameSiteNoneMode
+	default:
+		return cfg, fmt.Errorf("invalid COOKIE_SAMESITE %q; must be lax, strict or none", sameSite)
+	}
+
+	return cfg, nil
+}
+
+func (cfg CookieConfig) validate() error {
+	// browsers drop SameSite=None cookies that are not Secure
+	if cfg.SameSite == http.SameSiteNoneMode && !cfg.Secure {
+		return fmt.Errorf("SameSite=None cookies must be Secure; set COOKIE_SECURE=true")
+	}
+	return nil
+}
+
+func (h *Handler) newCookie(name, value string, lifetime time.Duration, httpOnly bool) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   h.cookies.Domain,
+		MaxAge:   int(lifetime.Seconds()),
+		Expires:  time.Now().Add(lifetime),
+		Secure:   h.cookies.Secure,
+		HttpOnly: httpOnly,
+		SameSite: h.cookies.SameSite,
+	}
+}
+
+// Logs the browser in with the token pair.
+// The login cookie lasts as long as the access token, and the refresh and
+// CSRF cookies as long as the session.
+func (h *Handler) setSessionCookies(w http.ResponseWriter, tokens *TokenPair) error {
+	csrfToken, err := newCsrfToken()
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, h.newCookie(LOGIN_COOKIE, tokens.AccessToken, h.accessTokenTTL, true))
+	http.SetCookie(w, h.newCookie(REFRESH_COOKIE, tokens.RefreshToken, h.refreshTokenTTL, true))
+	http.SetCookie(w, h.newCookie(CSRF_COOKIE, csrfToken, h.refreshTokenTTL, false))
+	return nil
+}
+
+func (h *Handler) clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{LOGIN_COOKIE, REFRESH_COOKIE, CSRF_COOKIE} {
+		cookie := h.newCookie(name, "", 0, name != CSRF_COOKIE)
+		cookie.MaxAge = -1
+		cookie.Expires = time.Unix(0, 0)
+		http.SetCookie(w, cookie)
+	}
+}
+
+func newCsrfToken() (string, error) {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", fmt.Errorf("error generating CSRF token; %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// Double-submit check: another site can make the browser send our cookies,
+// but cannot read the CSRF cookie to copy it into the CSRF header
+func verifyCsrfToken(r *http.Request) error {
+	cookie, err := r.Cookie(CSRF_COOKIE)
+	if err != nil || cookie.Value == "" {
+		return domain.ErrCsrfTokenInvalid.Wrap(fmt.Errorf("missing %v cookie", CSRF_COOKIE))
+	}
+
+	header := r.Header.Get(CSRF_HEADER)
+	if subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) != 1 {
+		return domain.ErrCsrfTokenInvalid.Wrap(fmt.Errorf("%v header does not match %v cookie", CSRF_HEADER, CSRF_COOKIE))
+	}
+	return nil
+}
+
+// Rejects state changing requests without a valid CSRF token.
+// Only needed for requests authenticated with cookies; an Authorization
+// header is never added by the browser on its own.
+func CsrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isSafeMethod(r.Method) {
+			err := verifyCsrfToken(r)
+			if err != nil {
+				api.Error(
+					w,
+					"Invalid CSRF token",
+					err,
+					http.StatusForbidden,
+				)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
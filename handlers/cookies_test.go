@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+)
+
+// Logs the user in with cookies, as HandleLogin does for browsers
+func (s *testServer) loginCookies(t *testing.T, tokens *TokenPair) []*http.Cookie {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	err := s.h.setSessionCookies(w, tokens)
+	if err != nil {
+		t.Fatalf("setSessionCookies() error = %v", err)
+	}
+	return w.Result().Cookies()
+}
+
+// Sends a request with the cookies, and the CSRF header when csrfToken is set
+func (s *testServer) doCookies(t *testing.T, method, path, body string, cookies []*http.Cookie, csrfToken string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	if csrfToken != "" {
+		r.Header.Set(CSRF_HEADER, csrfToken)
+	}
+
+	w := httptest.NewRecorder()
+	s.mux.ServeHTTP(w, r)
+	return w
+}
+
+func cookieValue(cookies []*http.Cookie, name string) string {
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// Requests authenticated with cookies only change anything with the
+// CSRF cookie copied into the CSRF header
+func TestCsrf(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		body      string
+		csrfToken func(cookies []*http.Cookie) string
+		status    int
+	}{
+		{
+			name:      "safe method",
+			method:    "GET",
+			path:      "/my-profile",
+			csrfToken: func(cookies []*http.Cookie) string { return "" },
+			status:    http.StatusOK,
+		},
+		{
+			name:      "no token",
+			method:    "POST",
+			path:      "/logout",
+			csrfToken: func(cookies []*http.Cookie) string { return "" },
+			status:    http.StatusForbidden,
+		},
+		{
+			name:      "wrong token",
+			method:    "POST",
+			path:      "/logout",
+			csrfToken: func(cookies []*http.Cookie) string { return "wrong" },
+			status:    http.StatusForbidden,
+		},
+		{
+			name:      "token of the cookie",
+			method:    "POST",
+			path:      "/logout",
+			csrfToken: func(cookies []*http.Cookie) string { return cookieValue(cookies, CSRF_COOKIE) },
+			status:    http.StatusOK,
+		},
+		{
+			name:      "refresh without token",
+			method:    "POST",
+			path:      "/refresh",
+			body:      "{}",
+			csrfToken: func(cookies []*http.Cookie) string { return "" },
+			status:    http.StatusForbidden,
+		},
+		{
+			name:      "refresh with token",
+			method:    "POST",
+			path:      "/refresh",
+			body:      "{}",
+			csrfToken: func(cookies []*http.Cookie) string { return cookieValue(cookies, CSRF_COOKIE) },
+			status:    http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		s := newTestServer(t)
+		alice := s.createUser(t, "alice", domain.RoleUser)
+		cookies := s.loginCookies(t, s.login(t, alice))
+
+		w := s.doCookies(t, test.method, test.path, test.body, cookies, test.csrfToken(cookies))
+		resp := decodeResponse(t, w, nil)
+		if w.Code != test.status {
+			t.Errorf("%v: %v %v = %v %v, want %v", test.name, test.method, test.path, w.Code, resp.Code, test.status)
+		}
+		if test.status == http.StatusForbidden && resp.Code != domain.ErrCsrfTokenInvalid.Code {
+			t.Errorf("%v: %v %v error = %v, want %v", test.name, test.method, test.path, resp.Code, domain.ErrCsrfTokenInvalid.Code)
+		}
+	}
+}
+
+// An Authorization header is never added by the browser on its own, so
+// requests carrying one need no CSRF token
+func TestCsrfNotNeededWithAuthorizationHeader(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.createUser(t, "alice", domain.RoleUser)
+	tokens := s.login(t, alice)
+
+	if w := s.do(t, "POST", "/logout", "", tokens.AccessToken); w.Code != http.StatusOK {
+		t.Errorf("POST /logout with an Authorization header = %v, want %v", w.Code, http.StatusOK)
+	}
+}
+
+// Browsers refreshing with the refresh cookie get new cookies, and no
+// refresh token readable by scripts
+func TestRefreshTokenCookies(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.createUser(t, "alice", domain.RoleUser)
+	cookies := s.loginCookies(t, s.login(t, alice))
+
+	w := s.doCookies(t, "POST", "/refresh", "{}", cookies, cookieValue(cookies, CSRF_COOKIE))
+	var tokens TokenPair
+	decodeResponse(t, w, &tokens)
+	if w.Code != http.StatusOK || tokens.RefreshToken != "" {
+		t.Fatalf("POST /refresh with cookies = %v, refresh token %q; want none in the body", w.Code, tokens.RefreshToken)
+	}
+
+	// access tokens issued within the same second are the same
+	refreshed := w.Result().Cookies()
+	if cookieValue(refreshed, LOGIN_COOKIE) == "" {
+		t.Errorf("POST /refresh did not set cookie %v", LOGIN_COOKIE)
+	}
+	for _, name := range []string{REFRESH_COOKIE, CSRF_COOKIE} {
+		value := cookieValue(refreshed, name)
+		if value == "" || value == cookieValue(cookies, name) {
+			t.Errorf("POST /refresh set cookie %v to %q, want a new value", name, value)
+		}
+	}
+
+	// the new CSRF token replaces the old one
+	if w := s.doCookies(t, "POST", "/logout", "", refreshed, cookieValue(cookies, CSRF_COOKIE)); w.Code != http.StatusForbidden {
+		t.Errorf("POST /logout with the old CSRF token = %v, want %v", w.Code, http.StatusForbidden)
+	}
+	if w := s.doCookies(t, "POST", "/logout", "", refreshed, cookieValue(refreshed, CSRF_COOKIE)); w.Code != http.StatusOK {
+		t.Errorf("POST /logout with the new CSRF token = %v, want %v", w.Code, http.StatusOK)
+	}
+}
@@ -2,22 +2,29 @@ package handlers
 
 import (
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 
 	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
 	"github.com/caleb-mwasikira/tap_gopay/handlers/api"
+	"github.com/caleb-mwasikira/tap_gopay/luhn"
 	"github.com/caleb-mwasikira/tap_gopay/utils"
 	v "github.com/caleb-mwasikira/tap_gopay/validators"
 )
 
 const (
-	CREDIT_CARD_NO_LEN int = 14
-	CVV_LEN            int = 4
+	CREDIT_CARD_NO_LEN   int = v.CARD_NO_LEN
+	CVV_LEN              int = 4
+	MAX_CARD_NO_ATTEMPTS int = 5
+	CARD_VALIDITY_YEARS  int = 4
 )
 
-func NewCreditCard(w http.ResponseWriter, r *http.Request) {
+// Issues a credit card with an initial deposit to the user named by owner_email,
+// or to the logged in agent or admin
+func (h *Handler) NewCreditCard(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 
 	user := getLoggedInUser(r.Context())
@@ -25,34 +32,58 @@ func NewCreditCard(w http.ResponseWriter, r *http.Request) {
 		api.Error(
 			w,
 			"Unauthorized action detected",
-			nil,
+			domain.ErrUnauthorized,
 			http.StatusUnauthorized,
 		)
 		return
 	}
 
-	newCreditCard, ok := v.GetValidJsonInput[v.CreditCardDto](w, r.Body)
+	request, ok := v.GetValidJsonInput[v.NewCreditCardDto](w, r.Body)
 	if !ok {
 		return
 	}
 
-	newCardNo := utils.RandNumbers(CREDIT_CARD_NO_LEN)
+	owner := user
+	if request.OwnerEmail != "" {
+		var err error
+
+		owner, err = h.users.GetUser(request.OwnerEmail)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				err = domain.ErrUserNotFound
+			}
+
+			api.Error(
+				w,
+				"Unexpected error generating new credit card",
+				err,
+				http.StatusInternalServerError,
+			)
+			return
+		}
+	}
+
 	newCvv := utils.RandNumbers(CVV_LEN)
-	if newCardNo == "" || newCvv == "" {
+	if newCvv == "" {
 		api.Error(
 			w,
 			"Unexpected error generating new credit card",
-			fmt.Errorf("error generating card number or card cvv value"),
+			fmt.Errorf("error generating card cvv value"),
 			http.StatusInternalServerError,
 		)
 		return
 	}
 
-	newCreditCard.UserId = user.Id
-	newCreditCard.CardNo = newCardNo
-	newCreditCard.Cvv = newCvv
+	// cards are valid until the end of the same month, CARD_VALIDITY_YEARS from now
+	now := h.now()
+	newCreditCard := v.CreditCardDto{
+		UserId:         owner.Id,
+		InitialDeposit: request.InitialDeposit,
+		ExpiryMonth:    int(now.Month()),
+		ExpiryYear:     now.Year() + CARD_VALIDITY_YEARS,
+	}
 
-	err := db.CreateCreditCard(newCreditCard)
+	err := h.createCreditCard(&newCreditCard, newCvv)
 	if err != nil {
 		api.Error(
 			w,
@@ -72,7 +103,33 @@ func NewCreditCard(w http.ResponseWriter, r *http.Request) {
 	)
 }
 
-func SearchCreditCard(w http.ResponseWriter, r *http.Request) {
+// Issues a new card number to the credit card and saves it with its CVV encrypted.
+// Card numbers are random, so a number that is already taken
+// is replaced with a fresh one and the card saved again.
+func (h *Handler) createCreditCard(newCreditCard *v.CreditCardDto, cvv string) error {
+	for attempt := 1; ; attempt++ {
+		cardNo, err := luhn.Generate(h.cardBinPrefix, CREDIT_CARD_NO_LEN)
+		if err != nil {
+			return fmt.Errorf("error generating card number; %v", err)
+		}
+		newCreditCard.CardNo = cardNo
+
+		// the encrypted CVV is bound to its card number
+		newCreditCard.Cvv, err = h.cvvCipher.Seal([]byte(cvv), []byte(cardNo))
+		if err != nil {
+			return fmt.Errorf("error encrypting card cvv; %v", err)
+		}
+
+		err = h.cards.CreateCreditCard(*newCreditCard)
+		if errors.Is(err, db.ErrDuplicateKey) && attempt < MAX_CARD_NO_ATTEMPTS {
+			log.Printf("card number collision on attempt %d; retrying\n", attempt)
+			continue
+		}
+		return err
+	}
+}
+
+func (h *Handler) SearchCreditCard(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 
 	contacts, ok := v.GetValidJsonInput[[]v.ContactDto](w, r.Body)
@@ -85,7 +142,7 @@ func SearchCreditCard(w http.ResponseWriter, r *http.Request) {
 		phoneNos = append(phoneNos, contact.PhoneNo)
 	}
 
-	creditCards, err := db.GetCreditCardsAssocWith(phoneNos)
+	creditCards, err := h.cards.GetCreditCardsAssocWith(phoneNos, h.now())
 	if err != nil {
 		if err == sql.ErrNoRows {
 			api.SendResponse(
@@ -116,7 +173,7 @@ func SearchCreditCard(w http.ResponseWriter, r *http.Request) {
 	)
 }
 
-func MyCreditCards(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) MyCreditCards(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 
 	user := getLoggedInUser(r.Context())
@@ -124,13 +181,13 @@ func MyCreditCards(w http.ResponseWriter, r *http.Request) {
 		api.Error(
 			w,
 			"Unauthorized action detected",
-			nil,
+			domain.ErrUnauthorized,
 			http.StatusUnauthorized,
 		)
 		return
 	}
 
-	dbCreditCards, err := db.GetCreditCardsFor(user.Username)
+	dbCreditCards, err := h.cards.GetCreditCardsFor(user.Username)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			api.SendResponse(
@@ -159,116 +216,77 @@ func MyCreditCards(w http.ResponseWriter, r *http.Request) {
 	)
 }
 
-func DeactivateCard(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) SendMoney(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 
 	user := getLoggedInUser(r.Context())
 	if user == nil {
 		api.Error(
 			w,
-			"Unauthorized action detected",
-			nil,
+			"Unauthorized action detected. Please login and try again",
+			domain.ErrUnauthorized,
 			http.StatusUnauthorized,
 		)
 		return
 	}
 
-	card := v.CardNoDto{}
-	err := json.NewDecoder(r.Body).Decode(&card)
-	if err != nil {
-		api.Error(
-			w,
-			"Invalid JSON data provided as input",
-			err,
-			http.StatusBadRequest,
-		)
+	request, ok := v.GetValidJsonInput[v.SendMoneyDto](w, r.Body)
+	if !ok {
 		return
 	}
 
-	_, err = db.GetCreditCardWhere(user.Username, card.CardNo, true)
+	// check if senders_card number belongs to the logged in user
+	sendersCard, err := h.cards.GetCreditCardWhere(user.Username, request.SendersCard, domain.CardActive)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			api.SendResponse(
+			api.Error(
 				w,
-				fmt.Sprintf("No credit card with account number %v found under your name", card.CardNo),
-				nil, nil,
-				http.StatusNoContent,
+				"Invalid or deactivated senders credit card",
+				domain.ErrInvalidSendersCard,
+				http.StatusUnprocessableEntity,
 			)
 			return
 		}
 
 		api.Error(
 			w,
-			"Unexpected error freezing credit card",
-			err,
-			http.StatusInternalServerError,
-		)
-		return
-	}
-
-	err = db.DeactivateCard(card.CardNo)
-	if err != nil {
-		api.Error(
-			w,
-			"Unexpected error freezing card",
+			"Unexpected error sending money across credit cards",
 			err,
 			http.StatusInternalServerError,
 		)
 		return
 	}
 
-	api.SendResponse(
-		w,
-		fmt.Sprintf("Success freezing card %v", card.CardNo),
-		nil,
-		nil,
-		http.StatusOK,
-	)
-}
-
-func SendMoney(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Content-Type", "application/json")
-
-	user := getLoggedInUser(r.Context())
-	if user == nil {
+	if request.Cvv == "" && h.requireCvv {
 		api.Error(
 			w,
-			"Unauthorized action detected. Please login and try again",
-			nil,
-			http.StatusUnauthorized,
+			"Card security code (CVV) is required",
+			domain.ErrCvvRequired,
+			http.StatusBadRequest,
 		)
 		return
 	}
 
-	request, ok := v.GetValidJsonInput[v.SendMoneyDto](w, r.Body)
-	if !ok {
-		return
-	}
-
-	// check if senders_card number belongs to the logged in user
-	_, err := db.GetCreditCardWhere(user.Username, request.SendersCard, true)
+	// wrong PINs and CVVs count against the same attempts
+	err = h.verifyCardSecrets(sendersCard, request.Pin, request.Cvv)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			api.SendResponse(
-				w,
-				"Invalid or deactivated senders credit card",
-				nil, nil,
-				http.StatusNoContent,
-			)
-			return
-		}
-
 		api.Error(
 			w,
-			"Unexpected error sending money across credit cards",
+			fmt.Sprintf("Unexpected error sending money to %v", request.ReceiversCard),
 			err,
 			http.StatusInternalServerError,
 		)
 		return
 	}
 
-	err = db.CreateTransaction(request)
+	if !h.allowTransferStepUp(w, r, user, request) {
+		return
+	}
+
+	result, err := h.transactions.Transfer(request, h.now())
 	if err != nil {
+		// business rule violations are domain errors and
+		// carry their own status code and message
 		api.Error(
 			w,
 			fmt.Sprintf("Unexpected error sending money to %v", request.ReceiversCard),
@@ -280,13 +298,13 @@ func SendMoney(w http.ResponseWriter, r *http.Request) {
 
 	api.SendResponse(
 		w,
-		fmt.Sprintf("KSH %.2f sent successfully to %v", request.Amount, request.ReceiversCard),
-		nil, nil,
+		fmt.Sprintf("%v sent successfully to %v", request.Amount, request.ReceiversCard),
+		result, nil,
 		http.StatusOK,
 	)
 }
 
-func GetUserTransactions(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) GetUserTransactions(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
 
 	user := getLoggedInUser(r.Context())
@@ -294,13 +312,13 @@ func GetUserTransactions(w http.ResponseWriter, r *http.Request) {
 		api.Error(
 			w,
 			"Unauthorized action detected. Please login and try again",
-			nil,
+			domain.ErrUnauthorized,
 			http.StatusUnauthorized,
 		)
 		return
 	}
 
-	transactions, err := db.GetTransactionsDetailsWhere(user.Username)
+	transactions, err := h.transactions.GetTransactionsDetailsWhere(user.Username)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			api.SendResponse(
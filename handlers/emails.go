@@ -38,14 +38,14 @@ func sendEmail(email, subject string, body []byte) error {
 	return d.DialAndSend(m)
 }
 
-func sendOtpEmail(email, otp string) error {
+func (h *Handler) sendOtpEmail(email, otp string) error {
 	tmplFile := filepath.Join(utils.EmailViewsDir, "otp_email.html")
 	t, err := template.ParseFiles(tmplFile)
 	if err != nil {
 		return err
 	}
 
-	user, err := db.GetUser(email)
+	user, err := h.users.GetUser(email)
 	if err != nil {
 		return fmt.Errorf("user does not exist in database")
 	}
@@ -70,8 +70,41 @@ func sendOtpEmail(email, otp string) error {
 	return err
 }
 
-func sendWelcomeEmail(email string) error {
-	user, err := db.GetUser(email)
+// Emails the code confirming a change to the user's profile to the address
+// given; the new address for email changes, their current one otherwise
+func (h *Handler) sendProfileChangeEmail(to string, user db.User, change, newValue, otp string) error {
+	tmplPath := filepath.Join(utils.EmailViewsDir, "profile_change.html")
+	t, err := template.ParseFiles(tmplPath)
+	if err != nil {
+		return err
+	}
+
+	tmplData := struct {
+		Name        string
+		Change      string
+		NewValue    string
+		Otp         []string
+		CurrentYear int
+	}{
+		Name:        user.Username,
+		Change:      change,
+		NewValue:    newValue,
+		Otp:         utils.StringToRuneSlice(otp),
+		CurrentYear: time.Now().Year(),
+	}
+
+	var buff bytes.Buffer
+	err = t.Execute(&buff, tmplData)
+	if err != nil {
+		return err
+	}
+
+	err = sendEmail(to, "TapGoPay Profile Change", buff.Bytes())
+	return err
+}
+
+func (h *Handler) sendWelcomeEmail(email string) error {
+	user, err := h.users.GetUser(email)
 	if err != nil {
 		return fmt.Errorf("user does not exist in database")
 	}
@@ -100,14 +133,14 @@ func sendWelcomeEmail(email string) error {
 	return err
 }
 
-func sendPasswordResetEmail(email, token string) error {
+func (h *Handler) sendPasswordResetEmail(email, token string) error {
 	tmplPath := filepath.Join(utils.EmailViewsDir, "password_reset.html")
 	t, err := template.ParseFiles(tmplPath)
 	if err != nil {
 		return err
 	}
 
-	user, err := db.GetUser(email)
+	user, err := h.users.GetUser(email)
 	if err != nil {
 		return fmt.Errorf("user does not exist in database")
 	}
@@ -131,3 +164,37 @@ func sendPasswordResetEmail(email, token string) error {
 	err = sendEmail(email, "TapGoPay Password Reset Email", buff.Bytes())
 	return err
 }
+
+func (h *Handler) sendLockoutEmail(email, ipAddress string) error {
+	tmplPath := filepath.Join(utils.EmailViewsDir, "account_locked.html")
+	t, err := template.ParseFiles(tmplPath)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.users.GetUser(email)
+	if err != nil {
+		return fmt.Errorf("user does not exist in database")
+	}
+
+	tmplData := struct {
+		Name        string
+		IpAddress   string
+		Minutes     int
+		CurrentYear int
+	}{
+		Name:        user.Username,
+		IpAddress:   ipAddress,
+		Minutes:     int(h.accountLockout.LockoutDuration.Minutes()),
+		CurrentYear: time.Now().Year(),
+	}
+
+	var buff bytes.Buffer
+	err = t.Execute(&buff, tmplData)
+	if err != nil {
+		return err
+	}
+
+	err = sendEmail(email, "TapGoPay Account Locked", buff.Bytes())
+	return err
+}
@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/codes"
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/encryption"
+	"github.com/caleb-mwasikira/tap_gopay/keyring"
+	"github.com/caleb-mwasikira/tap_gopay/lockout"
+	"github.com/caleb-mwasikira/tap_gopay/luhn"
+	"github.com/caleb-mwasikira/tap_gopay/passwords"
+	"github.com/caleb-mwasikira/tap_gopay/tokens"
+	v "github.com/caleb-mwasikira/tap_gopay/validators"
+)
+
+const (
+	DEFAULT_CARD_BIN_PREFIX string = "539983"
+	MAX_CARD_BIN_PREFIX_LEN int    = 8
+
+	DEFAULT_ACCESS_TOKEN_TTL  time.Duration = 15 * time.Minute
+	DEFAULT_REFRESH_TOKEN_TTL time.Duration = 30 * 24 * time.Hour
+)
+
+// Config holds the settings of the HTTP handlers
+type Config struct {
+	// Keys for signing tokens, peppering passwords and encrypting data.
+	// Built from SecretKey and CardKek alone when nil.
+	Keyring *keyring.Keyring
+
+	// Single keys from before the keyring; kept as version 0 of the
+	// jwt and data_encryption keys. SecretKey also verifies legacy password hashes.
+	SecretKey string
+	CardKek   string
+
+	// Issuer prefix (BIN/IIN) every new card number starts with
+	CardBinPrefix string
+
+	// Whether SendMoney requires the senders card CVV
+	RequireCvv bool
+
+	// Cost parameters of new password hashes; DefaultParams when left empty
+	Passwords passwords.Params
+
+	// How long access tokens are valid for, and how long a session lasts
+	// without being refreshed. Defaults are used when left empty.
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// Attributes of the cookies browsers are logged in with
+	Cookies CookieConfig
+
+	// Decides which transfers need a TOTP code on top of the card PIN.
+	// Read from TOTP_TRANSFER_THRESHOLD; no transfer needs one when nil.
+	TransferStepUp StepUpPolicy
+
+	// How quickly failed logins and code guesses slow down and lock out an
+	// account or IP address. Defaults are used when left empty.
+	AccountLockout lockout.Policy
+	IpLockout      lockout.Policy
+
+	// Current time of TOTP checks, login challenges, one-time codes, failed attempt counters, sessions, recovery codes and card expiry; time.Now when nil.
+	// Tests replace it with a fake clock.
+	Clock func() time.Time
+}
+
+func ConfigFromEnv() (Config, error) {
+	requireCvv, _ := strconv.ParseBool(os.Getenv("REQUIRE_CVV"))
+
+	cfg := Config{
+		SecretKey:     os.Getenv("SECRET_KEY"),
+		CardKek:       os.Getenv("CARD_KEK"),
+		CardBinPrefix: os.Getenv("CARD_BIN_PREFIX"),
+		RequireCvv:    requireCvv,
+		Passwords:     passwords.ParamsFromEnv(),
+	}
+
+	var err error
+
+	cfg.AccessTokenTTL, err = durationFromEnv("ACCESS_TOKEN_TTL")
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.RefreshTokenTTL, err = durationFromEnv("REFRESH_TOKEN_TTL")
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.Cookies, err = cookieConfigFromEnv()
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.TransferStepUp, err = stepUpPolicyFromEnv(os.Getenv("TOTP_TRANSFER_THRESHOLD"))
+	if err != nil {
+		return cfg, err
+	}
+
+	kr, err := keyring.FromEnv()
+	if err != nil {
+		return cfg, fmt.Errorf("error loading keyring; %v", err)
+	}
+
+	err = addLegacyKeys(kr, cfg.SecretKey, cfg.CardKek)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.Keyring = kr
+	return cfg, nil
+}
+
+// Reads a duration such as 15m or 720h; zero when unset
+func durationFromEnv(name string) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid %v %q; must be a positive duration such as 15m", name, value)
+	}
+	return duration, nil
+}
+
+// Adds SECRET_KEY and CARD_KEK to the keyring as version 0 of their purpose
+func addLegacyKeys(kr *keyring.Keyring, secretKey, cardKek string) error {
+	if secretKey != "" {
+		err := kr.Add(keyring.Key{Purpose: keyring.JWT, Version: 0, Secret: []byte(secretKey)})
+		if err != nil {
+			return fmt.Errorf("error adding SECRET_KEY to keyring; %v", err)
+		}
+	}
+
+	if cardKek != "" {
+		secret, err := keyring.DecodeSecret(cardKek)
+		if err != nil {
+			return fmt.Errorf("invalid CARD_KEK; %v", err)
+		}
+
+		err = kr.Add(keyring.Key{Purpose: keyring.DATA_ENCRYPTION, Version: 0, Secret: secret})
+		if err != nil {
+			return fmt.Errorf("error adding CARD_KEK to keyring; %v", err)
+		}
+	}
+	return nil
+}
+
+// Returns the JWT keys of the keyring, newest first.
+// Tokens without a kid header were signed with SECRET_KEY, key version 0.
+func jwtKeys(kr *keyring.Keyring) ([]*tokens.Key, error) {
+	keys := []*tokens.Key{}
+
+	for _, key := range kr.Keys(keyring.JWT) {
+		tokenKey, err := tokens.NewKey(key.Id(), key.Algorithm, key.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT key; %v", err)
+		}
+		keys = append(keys, tokenKey)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no JWT signing key; set KEYRING_JWT or SECRET_KEY")
+	}
+	return keys, nil
+}
+
+// Stores groups the storage backends the HTTP handlers depend on
+type Stores struct {
+	Users        db.UserStore
+	Cards        db.CardStore
+	Codes        codes.Store
+	Transactions db.TransactionStore
+	Idempotency  db.IdempotencyStore
+	Sessions     db.SessionStore
+	Totps        db.TotpStore
+	AdminActions db.AdminActionStore
+
+	// Failed attempt counters; a lockout.MemoryStore keeps them in process
+	// instead of sharing them between server instances
+	Attempts lockout.Store
+}
+
+// Handler holds the dependencies shared by all HTTP handlers
+type Handler struct {
+	users        db.UserStore
+	cards        db.CardStore
+	transactions db.TransactionStore
+	idempotency  db.IdempotencyStore
+	sessions     db.SessionStore
+	totps        db.TotpStore
+	adminActions db.AdminActionStore
+
+	tokenSigner   *tokens.Key
+	tokenVerifier *tokens.Verifier
+	jwks          tokens.JWKSet
+	cardBinPrefix string
+	cvvCipher     *encryption.Cipher
+	passwords     *passwords.Hasher
+	requireCvv    bool
+
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	cookies         CookieConfig
+	codes           *codes.Service
+	transferStepUp  StepUpPolicy
+	accountLimiter  *lockout.Limiter
+	ipLimiter       *lockout.Limiter
+	accountLockout  lockout.Policy
+	clock           func() time.Time
+}
+
+// Uses the same store for every dependency, as with a SQLStore or MemoryStore
+func StoresFrom(store db.Store) Stores {
+	return Stores{
+		Users:        store,
+		Cards:        store,
+		Codes:        store,
+		Transactions: store,
+		Idempotency:  store,
+		Sessions:     store,
+		Totps:        store,
+		AdminActions: store,
+		Attempts:     store,
+	}
+}
+
+func NewHandler(stores Stores, cfg Config) (*Handler, error) {
+	if cfg.CardBinPrefix == "" {
+		cfg.CardBinPrefix = DEFAULT_CARD_BIN_PREFIX
+	}
+	if !luhn.IsNumeric(cfg.CardBinPrefix) || len(cfg.CardBinPrefix) > MAX_CARD_BIN_PREFIX_LEN {
+		return nil, fmt.Errorf("invalid CARD_BIN_PREFIX %q; must be at most %d digits", cfg.CardBinPrefix, MAX_CARD_BIN_PREFIX_LEN)
+	}
+	v.SetCardBinPrefix(cfg.CardBinPrefix)
+
+	kr := cfg.Keyring
+	if kr == nil {
+		kr = keyring.New()
+
+		err := addLegacyKeys(kr, cfg.SecretKey, cfg.CardKek)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tokenKeys, err := jwtKeys(kr)
+	if err != nil {
+		return nil, err
+	}
+
+	cvvCipher, err := encryption.NewCipher(kr.Keys(keyring.DATA_ENCRYPTION))
+	if err != nil {
+		return nil, fmt.Errorf("invalid data encryption keys; set KEYRING_DATA_ENCRYPTION or CARD_KEK; %v", err)
+	}
+
+	if cfg.AccessTokenTTL == 0 {
+		cfg.AccessTokenTTL = DEFAULT_ACCESS_TOKEN_TTL
+	}
+	if cfg.RefreshTokenTTL == 0 {
+		cfg.RefreshTokenTTL = DEFAULT_REFRESH_TOKEN_TTL
+	}
+
+	if cfg.Cookies.SameSite == 0 {
+		cfg.Cookies.SameSite = http.SameSiteLaxMode
+	}
+	err = cfg.Cookies.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.AccountLockout == (lockout.Policy{}) {
+		cfg.AccountLockout = DEFAULT_ACCOUNT_LOCKOUT
+	}
+	if cfg.IpLockout == (lockout.Policy{}) {
+		cfg.IpLockout = DEFAULT_IP_LOCKOUT
+	}
+
+	if cfg.Clock == nil {
+		cfg.Clock = time.Now
+	}
+
+	// codes are hashed with a key derived from the data encryption keys
+	codeService, err := codes.NewService(stores.Codes, kr.Keys(keyring.DATA_ENCRYPTION), cfg.Clock)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Passwords == (passwords.Params{}) {
+		cfg.Passwords = passwords.DefaultParams()
+	}
+
+	// the secret key is only needed to verify legacy password hashes
+	hasher, err := passwords.NewHasher(cfg.Passwords, kr.Keys(keyring.PASSWORD_PEPPER), cfg.SecretKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid password hashing parameters; %v", err)
+	}
+
+	return &Handler{
+		users:           newUserCache(stores.Users, USER_CACHE_TTL),
+		cards:           stores.Cards,
+		transactions:    stores.Transactions,
+		idempotency:     stores.Idempotency,
+		sessions:        stores.Sessions,
+		totps:           stores.Totps,
+		adminActions:    stores.AdminActions,
+		codes:           codeService,
+		tokenSigner:     tokenKeys[0],
+		tokenVerifier:   tokens.NewVerifier(tokenKeys, keyring.Key{Purpose: keyring.JWT}.Id()),
+		jwks:            tokens.PublicKeySet(tokenKeys),
+		cardBinPrefix:   cfg.CardBinPrefix,
+		cvvCipher:       cvvCipher,
+		passwords:       hasher,
+		requireCvv:      cfg.RequireCvv,
+		accessTokenTTL:  cfg.AccessTokenTTL,
+		refreshTokenTTL: cfg.RefreshTokenTTL,
+		cookies:         cfg.Cookies,
+		transferStepUp:  cfg.TransferStepUp,
+		accountLimiter:  lockout.New(stores.Attempts, cfg.AccountLockout),
+		ipLimiter:       lockout.New(stores.Attempts, cfg.IpLockout),
+		accountLockout:  cfg.AccountLockout,
+		clock:           cfg.Clock,
+	}, nil
+}
+
+func (h *Handler) now() time.Time {
+	return h.clock()
+}
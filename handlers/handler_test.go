@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	v "github.com/caleb-mwasikira/tap_gopay/validators"
+)
+
+func TestMain(m *testing.M) {
+	// emails fail straight away instead of reaching a real mail server
+	os.Setenv("SMTP_HOST", "127.0.0.1")
+	os.Setenv("SMTP_PORT", "1")
+
+	os.Exit(m.Run())
+}
+
+// testServer serves the routes of main.go from a MemoryStore, with a clock
+// tests move forward by hand
+type testServer struct {
+	h     *Handler
+	store *db.MemoryStore
+	mux   *http.ServeMux
+	now   time.Time
+	users int // created so far, numbering their phone numbers
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	s := &testServer{
+		store: db.NewMemoryStore(),
+		now:   time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	var err error
+	s.h, err = NewHandler(StoresFrom(s.store), Config{
+		SecretKey: "secret",
+		CardKek:   "0000000000000000000000000000000000000000000000000000000000000007",
+		Clock:     func() time.Time { return s.now },
+	})
+	if err != nil {
+		t.Fatalf("NewHandler() error = %v", err)
+	}
+
+	h := s.h
+	anyRole := h.RequireRole(ANY_ROLE...)
+	staff := h.RequireRole(STAFF_ROLES...)
+	admins := h.RequireRole(ADMIN_ROLES...)
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("POST /refresh", h.RefreshToken)
+	s.mux.Handle("POST /logout", h.AuthMiddleware(anyRole(http.HandlerFunc(h.Logout))))
+	s.mux.Handle("GET /my-profile", h.AuthMiddleware(anyRole(http.HandlerFunc(h.MyProfile))))
+	s.mux.Handle("POST /update-profile", h.AuthMiddleware(anyRole(http.HandlerFunc(h.UpdateProfile))))
+	s.mux.Handle("POST /confirm-email-change", h.AuthMiddleware(anyRole(http.HandlerFunc(h.ConfirmEmailChange))))
+	s.mux.Handle("POST /confirm-phone-change", h.AuthMiddleware(anyRole(http.HandlerFunc(h.ConfirmPhoneChange))))
+	s.mux.Handle("POST /new-credit-card", h.AuthMiddleware(staff(h.IdempotencyMiddleware(http.HandlerFunc(h.NewCreditCard)))))
+	s.mux.Handle("GET /admin/users", h.AuthMiddleware(admins(http.HandlerFunc(h.ListUsers))))
+	s.mux.Handle("POST /admin/set-role", h.AuthMiddleware(admins(http.HandlerFunc(h.SetUserRole))))
+	s.mux.Handle("POST /admin/suspend-user", h.AuthMiddleware(admins(http.HandlerFunc(h.SuspendUser))))
+	s.mux.Handle("POST /admin/activate-user", h.AuthMiddleware(admins(http.HandlerFunc(h.ActivateUser))))
+	s.mux.Handle("POST /admin/block-card", h.AuthMiddleware(admins(http.HandlerFunc(h.BlockCard))))
+	s.mux.Handle("POST /admin/unblock-card", h.AuthMiddleware(admins(http.HandlerFunc(h.UnblockCard))))
+	s.mux.Handle("POST /admin/reset-totp", h.AuthMiddleware(admins(http.HandlerFunc(h.ResetUserTotp))))
+	s.mux.Handle("POST /admin/send-verification-email", h.AuthMiddleware(admins(http.HandlerFunc(h.ResendVerificationEmail))))
+
+	return s
+}
+
+// Creates a user with the role, straight in the store
+func (s *testServer) createUser(t *testing.T, name string, role domain.Role) *db.User {
+	t.Helper()
+
+	s.users++
+	err := s.store.CreateUser(v.RegisterDto{
+		Username:    name,
+		Email:       name + "@example.com",
+		Password:    "not used; tests log in with startSession",
+		PhoneNumber: fmt.Sprintf("07%08d", s.users),
+	})
+	if err != nil {
+		t.Fatalf("CreateUser(%v) error = %v", name, err)
+	}
+
+	user, err := s.store.GetUser(name + "@example.com")
+	if err != nil {
+		t.Fatalf("GetUser(%v) error = %v", name, err)
+	}
+
+	if role != user.Role {
+		err = s.store.ChangeUserRole(db.RoleChange{UserId: user.Id, To: role, Reason: "test"})
+		if err != nil {
+			t.Fatalf("ChangeUserRole(%v) error = %v", name, err)
+		}
+		user.Role = role
+	}
+	return user
+}
+
+// Logs the user in as HandleLogin does once their password is checked
+func (s *testServer) login(t *testing.T, user *db.User) *TokenPair {
+	t.Helper()
+
+	tokens, err := s.h.startSession(httptest.NewRequest("POST", "/login", nil), *user)
+	if err != nil {
+		t.Fatalf("startSession() error = %v", err)
+	}
+	return tokens
+}
+
+// Sends a request with the access token, if any, in an Authorization header.
+// headers are pairs of names and values.
+func (s *testServer) do(t *testing.T, method, path, body, accessToken string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if accessToken != "" {
+		r.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		r.Header.Set(headers[i], headers[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	s.mux.ServeHTTP(w, r)
+	return w
+}
+
+type testResponse struct {
+	Code    string          `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Decodes the body of the response, and its data into data when not nil
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder, data any) testResponse {
+	t.Helper()
+
+	body, _ := io.ReadAll(w.Result().Body)
+
+	var resp testResponse
+	err := json.Unmarshal(body, &resp)
+	if err != nil {
+		t.Fatalf("error decoding response %q; %v", body, err)
+	}
+
+	if data != nil {
+		err = json.Unmarshal(resp.Data, data)
+		if err != nil {
+			t.Fatalf("error decoding response data %q; %v", resp.Data, err)
+		}
+	}
+	return resp
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/handlers/api"
+)
+
+const (
+	IDEMPOTENCY_KEY_HEADER     string = "Idempotency-Key"
+	IDEMPOTENCY_REPLAY_HEADER  string = "Idempotent-Replayed"
+	MAX_IDEMPOTENCY_KEY_LEN    int    = 255
+	MAX_IDEMPOTENT_REQUEST_LEN int64  = 1 << 20
+)
+
+// recordingWriter passes the response through to the client
+// while keeping a copy of the status code and body
+type recordingWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	rw.statusCode = code
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(data []byte) (int, error) {
+	if rw.statusCode == 0 {
+		rw.statusCode = http.StatusOK
+	}
+	rw.body.Write(data)
+	return rw.ResponseWriter.Write(data)
+}
+
+// Makes POST requests safe to retry.
+// The first request carrying an Idempotency-Key header is processed normally
+// and its response recorded against the logged in user. Repeats of the request
+// with the same key get the recorded response replayed instead of being
+// processed again.
+// Must be wrapped by AuthMiddleware as keys are scoped per user.
+func (h *Handler) IdempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get(IDEMPOTENCY_KEY_HEADER))
+		if key == "" {
+			// idempotency keys are optional
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > MAX_IDEMPOTENCY_KEY_LEN {
+			api.Error(
+				w,
+				"Idempotency-Key header is too long",
+				domain.ErrIdempotencyKeyTooLong.WithMessage("%v header cannot be more than %v characters long", IDEMPOTENCY_KEY_HEADER, MAX_IDEMPOTENCY_KEY_LEN),
+				http.StatusBadRequest,
+			)
+			return
+		}
+
+		user := getLoggedInUser(r.Context())
+		if user == nil {
+			api.Error(
+				w,
+				"Unauthorized action detected",
+				domain.ErrUnauthorized,
+				http.StatusUnauthorized,
+			)
+			return
+		}
+
+		// the whole body is hashed, so it is rejected rather than cut short
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MAX_IDEMPOTENT_REQUEST_LEN))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				api.Error(
+					w,
+					"Request body is too large",
+					domain.ErrRequestTooLarge.WithMessage("Request body cannot be more than %v bytes long", MAX_IDEMPOTENT_REQUEST_LEN),
+					http.StatusRequestEntityTooLarge,
+				)
+				return
+			}
+
+			api.Error(
+				w,
+				"Error reading request body",
+				err,
+				http.StatusBadRequest,
+			)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		requestHash := hashRequest(r, body)
+
+		err = h.idempotency.CreateIdempotencyRecord(user.Id, key, requestHash)
+		if err != nil {
+			if err == db.ErrIdempotencyKeyExists {
+				h.replayIdempotentResponse(w, user.Id, key, requestHash)
+				return
+			}
+
+			api.Error(
+				w,
+				"Unexpected error processing request",
+				err,
+				http.StatusInternalServerError,
+			)
+			return
+		}
+
+		rw := &recordingWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+
+		// server errors are not recorded so that the client can retry the request
+		if rw.statusCode == 0 || rw.statusCode >= http.StatusInternalServerError {
+			err = h.idempotency.DeleteIdempotencyRecord(user.Id, key)
+			if err != nil {
+				log.Printf("error releasing idempotency key; %v\n", err)
+			}
+			return
+		}
+
+		err = h.idempotency.SaveIdempotencyResponse(user.Id, key, rw.statusCode, rw.body.Bytes())
+		if err != nil {
+			log.Printf("error saving idempotent response; %v\n", err)
+		}
+	})
+}
+
+func (h *Handler) replayIdempotentResponse(w http.ResponseWriter, userId int, key, requestHash string) {
+	record, err := h.idempotency.GetIdempotencyRecord(userId, key)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error processing request",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	if record.RequestHash != requestHash {
+		api.Error(
+			w,
+			fmt.Sprintf("%v has already been used with a different request", IDEMPOTENCY_KEY_HEADER),
+			domain.ErrIdempotencyKeyReused,
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+
+	if !record.IsComplete() {
+		api.Error(
+			w,
+			"A request with this Idempotency-Key is still being processed. Please retry later",
+			domain.ErrIdempotencyInProgress,
+			http.StatusConflict,
+		)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(IDEMPOTENCY_REPLAY_HEADER, "true")
+	w.WriteHeader(record.StatusCode)
+	w.Write(record.ResponseBody)
+}
+
+// The request method and path are part of the hash so that a key
+// cannot be replayed against a different endpoint
+func hashRequest(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte(r.URL.Path))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+)
+
+const newCardBody string = `{"initial_deposit":"100.00"}`
+
+// Repeats of a request with the same Idempotency-Key get the first response,
+// without creating another card
+func TestIdempotencyReplay(t *testing.T) {
+	s := newTestServer(t)
+	agent := s.createUser(t, "agent", domain.RoleAgent)
+	tokens := s.login(t, agent)
+
+	first := s.do(t, "POST", "/new-credit-card", newCardBody, tokens.AccessToken, IDEMPOTENCY_KEY_HEADER, "card-1")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("POST /new-credit-card = %v, want %v", first.Code, http.StatusCreated)
+	}
+
+	for i := 0; i < 2; i++ {
+		replay := s.do(t, "POST", "/new-credit-card", newCardBody, tokens.AccessToken, IDEMPOTENCY_KEY_HEADER, "card-1")
+		if replay.Code != first.Code || replay.Body.String() != first.Body.String() {
+			t.Errorf("replay %d = %v %q, want %v %q", i, replay.Code, replay.Body, first.Code, first.Body)
+		}
+		if replay.Header().Get(IDEMPOTENCY_REPLAY_HEADER) != "true" {
+			t.Errorf("replay %d is missing the %v header", i, IDEMPOTENCY_REPLAY_HEADER)
+		}
+	}
+
+	// other keys, and requests without one, are processed again
+	if w := s.do(t, "POST", "/new-credit-card", newCardBody, tokens.AccessToken, IDEMPOTENCY_KEY_HEADER, "card-2"); w.Code != http.StatusCreated || w.Header().Get(IDEMPOTENCY_REPLAY_HEADER) != "" {
+		t.Errorf("POST /new-credit-card with another key = %v, replayed %q", w.Code, w.Header().Get(IDEMPOTENCY_REPLAY_HEADER))
+	}
+	if w := s.do(t, "POST", "/new-credit-card", newCardBody, tokens.AccessToken); w.Code != http.StatusCreated {
+		t.Errorf("POST /new-credit-card without a key = %v, want %v", w.Code, http.StatusCreated)
+	}
+
+	cards, err := s.store.GetCreditCardsFor(agent.Username)
+	if err != nil || len(cards) != 3 {
+		t.Errorf("GetCreditCardsFor() = %d cards, %v; want 3", len(cards), err)
+	}
+
+	// keys are scoped per user
+	other := s.createUser(t, "other", domain.RoleAgent)
+	w := s.do(t, "POST", "/new-credit-card", newCardBody, s.login(t, other).AccessToken, IDEMPOTENCY_KEY_HEADER, "card-1")
+	if w.Code != http.StatusCreated || w.Header().Get(IDEMPOTENCY_REPLAY_HEADER) != "" {
+		t.Errorf("POST /new-credit-card with the key of another user = %v, replayed %q", w.Code, w.Header().Get(IDEMPOTENCY_REPLAY_HEADER))
+	}
+}
+
+func TestIdempotencyConflicts(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		body   string
+		setup  func(s *testServer, userId int)
+		status int
+		code   string
+	}{
+		{
+			name: "same key, other body",
+			key:  "card-1",
+			body: `{"initial_deposit":"200.00"}`,
+			setup: func(s *testServer, userId int) {
+				s.h.idempotency.CreateIdempotencyRecord(userId, "card-1", requestHash("POST", "/new-credit-card", newCardBody))
+				s.h.idempotency.SaveIdempotencyResponse(userId, "card-1", http.StatusCreated, []byte("{}"))
+			},
+			status: http.StatusUnprocessableEntity,
+			code:   domain.ErrIdempotencyKeyReused.Code,
+		},
+		{
+			name: "first request still running",
+			key:  "card-1",
+			body: newCardBody,
+			setup: func(s *testServer, userId int) {
+				s.h.idempotency.CreateIdempotencyRecord(userId, "card-1", requestHash("POST", "/new-credit-card", newCardBody))
+			},
+			status: http.StatusConflict,
+			code:   domain.ErrIdempotencyInProgress.Code,
+		},
+		{
+			name:   "body too large",
+			key:    "card-1",
+			body:   `{"initial_deposit":"100.00","padding":"` + strings.Repeat("x", int(MAX_IDEMPOTENT_REQUEST_LEN)) + `"}`,
+			status: http.StatusRequestEntityTooLarge,
+			code:   domain.ErrRequestTooLarge.Code,
+		},
+		{
+			name:   "key too long",
+			key:    strings.Repeat("k", MAX_IDEMPOTENCY_KEY_LEN+1),
+			body:   newCardBody,
+			status: http.StatusBadRequest,
+			code:   domain.ErrIdempotencyKeyTooLong.Code,
+		},
+	}
+
+	for _, test := range tests {
+		s := newTestServer(t)
+		agent := s.createUser(t, "agent", domain.RoleAgent)
+		if test.setup != nil {
+			test.setup(s, agent.Id)
+		}
+
+		w := s.do(t, "POST", "/new-credit-card", test.body, s.login(t, agent).AccessToken, IDEMPOTENCY_KEY_HEADER, test.key)
+		resp := decodeResponse(t, w, nil)
+		if w.Code != test.status || resp.Code != test.code {
+			t.Errorf("%v: POST /new-credit-card = %v %v, want %v %v", test.name, w.Code, resp.Code, test.status, test.code)
+		}
+
+		// the request never reaches NewCreditCard
+		cards, err := s.store.GetCreditCardsFor(agent.Username)
+		if err != nil || len(cards) != 0 {
+			t.Errorf("%v: GetCreditCardsFor() = %d cards, %v; want none", test.name, len(cards), err)
+		}
+	}
+}
+
+func requestHash(method, path, body string) string {
+	return hashRequest(httptest.NewRequest(method, path, nil), []byte(body))
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/handlers/api"
+	"github.com/caleb-mwasikira/tap_gopay/lockout"
+)
+
+// Kinds of attempts, each counted separately per account
+const (
+	LOGIN_ATTEMPT          string = "login"
+	LOGIN_TOTP_ATTEMPT     string = "login-totp" // and every other endpoint taking a TOTP code
+	VERIFY_EMAIL_ATTEMPT   string = "verify-email"
+	RESET_PASSWORD_ATTEMPT string = "reset-password"
+
+	CONFIRM_PROFILE_CHANGE_ATTEMPT string = "confirm-profile-change"
+)
+
+// Failed attempts are counted per account on each endpoint, and per
+// IP address across all of them. IP addresses get more attempts as
+// many users may share one.
+var (
+	DEFAULT_ACCOUNT_LOCKOUT = lockout.Policy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: 15 * time.Minute,
+	}
+
+	DEFAULT_IP_LOCKOUT = lockout.Policy{
+		FreeAttempts:    20,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    100,
+		LockoutDuration: 15 * time.Minute,
+	}
+)
+
+func accountAttemptKey(kind, account string) string {
+	return kind + ":" + strings.ToLower(strings.TrimSpace(account))
+}
+
+func ipAttemptKey(r *http.Request) string {
+	return "ip:" + clientIp(r)
+}
+
+// reservedAttempt is an attempt at a password or code, reserved against an account
+// and the IP address of the request. It counts as failed until it is settled
+// with succeed, or given back with release.
+type reservedAttempt struct {
+	h          *Handler
+	r          *http.Request
+	accountKey string
+	failures   int // recent failures of the account, this attempt included
+	settled    bool
+}
+
+// Reserves an attempt of the account and the IP address of the request
+// before the password or code is checked, so that concurrent requests cannot
+// all get past the limits before any of them fails.
+// Returns false, after writing the error, while either has to wait.
+// Callers defer release, which gives the attempt back if it was not settled.
+func (h *Handler) allowAttempt(w http.ResponseWriter, r *http.Request, accountKey string) (*reservedAttempt, bool) {
+	now := h.now()
+
+	_, ipErr := h.ipLimiter.Reserve(ipAttemptKey(r), now)
+	err := ipErr
+
+	failures := 0
+	if err == nil {
+		failures, err = h.accountLimiter.Reserve(accountKey, now)
+		if err != nil {
+			h.releaseAttempt(h.ipLimiter, ipAttemptKey(r))
+		}
+	}
+	if err == nil {
+		return &reservedAttempt{h: h, r: r, accountKey: accountKey, failures: failures}, true
+	}
+
+	var lockoutErr *lockout.Error
+	if !errors.As(err, &lockoutErr) {
+		api.Error(
+			w,
+			"Unexpected error checking failed attempts",
+			err,
+			http.StatusInternalServerError,
+		)
+		return nil, false
+	}
+
+	// many users may share an IP address, so only accounts are reported as locked
+	domainErr := domain.ErrTooManyAttempts
+	if lockoutErr.Locked && ipErr == nil {
+		domainErr = domain.ErrAccountLocked
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(lockoutErr.RetryAfter.Seconds())))
+	api.Error(
+		w,
+		"Too many failed attempts",
+		domainErr.Wrap(err),
+		http.StatusTooManyRequests,
+	)
+	return nil, false
+}
+
+// Leaves the attempt counted as failed against the account and the IP address.
+// The user is emailed when this attempt locks their account; pass an empty
+// email when the account does not exist.
+func (a *reservedAttempt) fail(email string) {
+	a.settled = true
+
+	if a.h.accountLimiter.Locks(a.failures) && email != "" {
+		// not crucial to the request, which has failed anyway
+		ip := clientIp(a.r)
+		go func() {
+			err := a.h.sendLockoutEmail(email, ip)
+			if err != nil {
+				log.Printf("error sending lockout email; %v\n", err)
+			}
+		}()
+	}
+}
+
+// Forgets the failed attempts of the account once it gets one right.
+// The IP address only gets this attempt back, so that logging in to an
+// account of their own does not let anyone guess at others for longer.
+func (a *reservedAttempt) succeed() {
+	a.settled = true
+
+	err := a.h.accountLimiter.Reset(a.accountKey)
+	if err != nil {
+		log.Printf("error resetting failed attempts of %v; %v\n", a.accountKey, err)
+	}
+	a.h.releaseAttempt(a.h.ipLimiter, ipAttemptKey(a.r))
+}
+
+// Gives the attempt back unless it was settled, e.g. when the code had
+// expired or checking it failed. Errors are only logged.
+func (a *reservedAttempt) release() {
+	if a.settled {
+		return
+	}
+	a.settled = true
+
+	a.h.releaseAttempt(a.h.accountLimiter, a.accountKey)
+	a.h.releaseAttempt(a.h.ipLimiter, ipAttemptKey(a.r))
+}
+
+func (h *Handler) releaseAttempt(limiter *lockout.Limiter, key string) {
+	err := limiter.Release(key)
+	if err != nil {
+		log.Printf("error releasing attempt of %v; %v\n", key, err)
+	}
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/caleb-mwasikira/tap_gopay/codes"
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/handlers/api"
+	v "github.com/caleb-mwasikira/tap_gopay/validators"
+)
+
+type profile struct {
+	db.UserDetails
+
+	// changes requested at POST /update-profile and not yet confirmed
+	PendingEmail       string `json:"pending_email,omitempty"`
+	PendingPhoneNumber string `json:"pending_phone_number,omitempty"`
+}
+
+func profileOf(user db.User) profile {
+	return profile{
+		UserDetails:        user.Details(),
+		PendingEmail:       user.PendingEmail,
+		PendingPhoneNumber: user.PendingPhoneNumber,
+	}
+}
+
+func (h *Handler) MyProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user := getLoggedInUser(r.Context())
+	if user == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		"Profile found",
+		profileOf(*user),
+		nil,
+		http.StatusOK,
+	)
+}
+
+// Requests a change of email address, phone number or both. Nothing changes
+// until the user enters the code emailed for each change:
+//   - email: the code is sent to the new address, proving the user owns it
+//   - phone number: the code is sent to the current address, proving the
+//     account holder asked for it, as the server cannot send text messages
+func (h *Handler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user := getLoggedInUser(r.Context())
+	if user == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	request, ok := v.GetValidJsonInput[v.UpdateProfileDto](w, r.Body)
+	if !ok {
+		return
+	}
+
+	errs := map[string]string{}
+	if request.Email == "" && request.PhoneNumber == "" {
+		errs["email"] = "email or phone_number is required"
+		errs["phone_number"] = "email or phone_number is required"
+	}
+	if request.Email != "" && request.Email == user.Email {
+		errs["email"] = "email is already the email address of your account"
+	}
+	if request.PhoneNumber != "" && request.PhoneNumber == user.PhoneNumber.String {
+		errs["phone_number"] = "phone_number is already the phone number of your account"
+	}
+	if len(errs) > 0 {
+		api.ValidationErrors(w, errs)
+		return
+	}
+
+	if request.Email != "" {
+		existing, err := h.users.GetUser(request.Email)
+		if err != nil && err != sql.ErrNoRows {
+			api.Error(
+				w,
+				"Unexpected error updating profile",
+				err,
+				http.StatusInternalServerError,
+			)
+			return
+		}
+		if existing != nil {
+			api.Error(
+				w,
+				"User account already exists",
+				domain.ErrUserExists.WithMessage("An account with that email address already exists"),
+				http.StatusConflict,
+			)
+			return
+		}
+	}
+
+	patch := db.UserPatch{}
+	if request.Email != "" {
+		patch.PendingEmail = &request.Email
+	}
+	if request.PhoneNumber != "" {
+		patch.PendingPhoneNumber = &request.PhoneNumber
+	}
+
+	err := h.users.UpdateUser(user.Email, patch)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error updating profile",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	if request.Email != "" {
+		err = h.sendProfileChangeCode(*user, codes.EMAIL_CHANGE, request.Email)
+		if err != nil {
+			api.Error(
+				w,
+				"Unexpected error sending email change code",
+				err,
+				http.StatusInternalServerError,
+			)
+			return
+		}
+	}
+
+	if request.PhoneNumber != "" {
+		err = h.sendProfileChangeCode(*user, codes.PHONE_CHANGE, request.PhoneNumber)
+		if err != nil {
+			api.Error(
+				w,
+				"Unexpected error sending phone number change code",
+				err,
+				http.StatusInternalServerError,
+			)
+			return
+		}
+	}
+
+	api.SendResponse(
+		w,
+		"Please enter the codes emailed to you to confirm the changes",
+		nil,
+		nil,
+		http.StatusAccepted,
+	)
+}
+
+// Codes of both purposes are issued for the current email address of the user
+func (h *Handler) sendProfileChangeCode(user db.User, purpose codes.Purpose, newValue string) error {
+	otp, err := h.codes.Issue(purpose, user.Email)
+	if err != nil {
+		return err
+	}
+
+	if purpose == codes.EMAIL_CHANGE {
+		return h.sendProfileChangeEmail(newValue, user, "email address", newValue, otp)
+	}
+	return h.sendProfileChangeEmail(user.Email, user, "phone number", newValue, otp)
+}
+
+// Changes the email address of the user to the one requested at
+// POST /update-profile. A phone number change waiting to be confirmed is
+// cancelled, as its code was issued for the old address.
+func (h *Handler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	h.confirmProfileChange(w, r, codes.EMAIL_CHANGE)
+}
+
+// Changes the phone number of the user to the one requested at
+// POST /update-profile
+func (h *Handler) ConfirmPhoneChange(w http.ResponseWriter, r *http.Request) {
+	h.confirmProfileChange(w, r, codes.PHONE_CHANGE)
+}
+
+func (h *Handler) confirmProfileChange(w http.ResponseWriter, r *http.Request, purpose codes.Purpose) {
+	w.Header().Add("Content-Type", "application/json")
+
+	loggedIn := getLoggedInUser(r.Context())
+	if loggedIn == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	request, ok := v.GetValidJsonInput[v.OtpDto](w, r.Body)
+	if !ok {
+		return
+	}
+
+	// the pending change may have been requested through another server
+	// instance, whose change the cached user would not show yet
+	user, err := h.users.GetUser(loggedIn.Email)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error confirming profile change",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	change, pending := "email address", user.PendingEmail
+	if purpose == codes.PHONE_CHANGE {
+		change, pending = "phone number", user.PendingPhoneNumber
+	}
+
+	if pending == "" {
+		api.Error(
+			w,
+			fmt.Sprintf("No %v change to confirm", change),
+			domain.ErrRuleViolation.WithMessage("No %v change to confirm. Please request one at /update-profile", change),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+
+	attempt, ok := h.allowAttempt(w, r, accountAttemptKey(CONFIRM_PROFILE_CHANGE_ATTEMPT, user.Email))
+	if !ok {
+		return
+	}
+	defer attempt.release()
+
+	err = h.codes.Consume(purpose, user.Email, request.Otp)
+	if err != nil {
+		if errors.Is(err, domain.ErrOtpInvalid) {
+			attempt.fail(user.Email)
+		}
+
+		api.Error(
+			w,
+			fmt.Sprintf("Unexpected error confirming %v change", change),
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	attempt.succeed()
+
+	none := ""
+	patch := db.UserPatch{}
+
+	switch purpose {
+	case codes.EMAIL_CHANGE:
+		// the code sent to the new address verifies it
+		verified := true
+		patch = db.UserPatch{
+			Email:              &pending,
+			IsActive:           &verified,
+			PendingEmail:       &none,
+			PendingPhoneNumber: &none,
+		}
+	case codes.PHONE_CHANGE:
+		patch = db.UserPatch{
+			PhoneNumber:        &pending,
+			PendingPhoneNumber: &none,
+		}
+	}
+
+	err = h.users.UpdateUser(user.Email, patch)
+	if err != nil {
+		if errors.Is(err, db.ErrDuplicateKey) {
+			err = domain.ErrUserExists.WithMessage("An account with that email address already exists")
+		}
+
+		api.Error(
+			w,
+			fmt.Sprintf("Unexpected error confirming %v change", change),
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		fmt.Sprintf("Your %v has been changed to %v", change, pending),
+		nil,
+		nil,
+		http.StatusOK,
+	)
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/codes"
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+)
+
+const (
+	newEmail       string = "alice.new@example.com"
+	newPhoneNumber string = "0799999999"
+)
+
+// Requests the changes as UpdateProfile does, returning the codes it
+// would have emailed
+func (s *testServer) requestProfileChange(t *testing.T, user *db.User) (emailCode, phoneCode string) {
+	t.Helper()
+
+	email, phoneNumber := newEmail, newPhoneNumber
+	err := s.h.users.UpdateUser(user.Email, db.UserPatch{PendingEmail: &email, PendingPhoneNumber: &phoneNumber})
+	if err != nil {
+		t.Fatalf("UpdateUser() error = %v", err)
+	}
+
+	emailCode, err = s.h.codes.Issue(codes.EMAIL_CHANGE, user.Email)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	phoneCode, err = s.h.codes.Issue(codes.PHONE_CHANGE, user.Email)
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	return emailCode, phoneCode
+}
+
+func otpBody(code string) string {
+	return fmt.Sprintf(`{"otp":%q}`, code)
+}
+
+// Returns a code of the same length that is not code
+func wrongCode(code string) string {
+	if code[0] == '0' {
+		return "1" + code[1:]
+	}
+	return "0" + code[1:]
+}
+
+func TestConfirmEmailChange(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.createUser(t, "alice", domain.RoleUser)
+	tokens := s.login(t, alice)
+	emailCode, phoneCode := s.requestProfileChange(t, alice)
+
+	tests := []struct {
+		name   string
+		path   string
+		code   string
+		status int
+	}{
+		{"wrong code", "/confirm-email-change", wrongCode(emailCode), http.StatusBadRequest},
+		{"code of the phone number change", "/confirm-email-change", phoneCode, http.StatusBadRequest},
+		{"right code", "/confirm-email-change", emailCode, http.StatusOK},
+		{"nothing left to confirm", "/confirm-email-change", emailCode, http.StatusUnprocessableEntity},
+		{"phone number change cancelled", "/confirm-phone-change", phoneCode, http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range tests {
+		w := s.do(t, "POST", test.path, otpBody(test.code), tokens.AccessToken)
+		if w.Code != test.status {
+			resp := decodeResponse(t, w, nil)
+			t.Errorf("%v: POST %v = %v %v, want %v", test.name, test.path, w.Code, resp.Code, test.status)
+		}
+	}
+
+	user, err := s.store.GetUserById(alice.Id)
+	if err != nil {
+		t.Fatalf("GetUserById() error = %v", err)
+	}
+	// the code sent to the new address verifies it
+	if user.Email != newEmail || !user.IsActive || user.PendingEmail != "" || user.PendingPhoneNumber != "" || user.PhoneNumber != alice.PhoneNumber {
+		t.Errorf("user after confirming the email change = %+v", user)
+	}
+
+	// sessions started with the old address carry on
+	var profile profile
+	w := s.do(t, "GET", "/my-profile", "", tokens.AccessToken)
+	decodeResponse(t, w, &profile)
+	if w.Code != http.StatusOK || profile.Email != newEmail {
+		t.Errorf("GET /my-profile = %v, email %q; want %q", w.Code, profile.Email, newEmail)
+	}
+}
+
+func TestConfirmPhoneChange(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.createUser(t, "alice", domain.RoleUser)
+	tokens := s.login(t, alice)
+	emailCode, phoneCode := s.requestProfileChange(t, alice)
+
+	tests := []struct {
+		name   string
+		code   string
+		status int
+	}{
+		{"wrong code", wrongCode(phoneCode), http.StatusBadRequest},
+		{"code of the email change", emailCode, http.StatusBadRequest},
+		{"right code", phoneCode, http.StatusOK},
+		{"nothing left to confirm", phoneCode, http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range tests {
+		w := s.do(t, "POST", "/confirm-phone-change", otpBody(test.code), tokens.AccessToken)
+		if w.Code != test.status {
+			resp := decodeResponse(t, w, nil)
+			t.Errorf("%v: POST /confirm-phone-change = %v %v, want %v", test.name, w.Code, resp.Code, test.status)
+		}
+	}
+
+	// the email change is still waiting to be confirmed
+	user, err := s.store.GetUserById(alice.Id)
+	if err != nil {
+		t.Fatalf("GetUserById() error = %v", err)
+	}
+	if user.PhoneNumber.String != newPhoneNumber || user.PendingPhoneNumber != "" || user.Email != alice.Email || user.PendingEmail != newEmail {
+		t.Errorf("user after confirming the phone number change = %+v", user)
+	}
+}
+
+// Wrong codes slow down, then lock out, further guesses
+func TestConfirmProfileChangeWrongCodes(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.createUser(t, "alice", domain.RoleUser)
+	tokens := s.login(t, alice)
+	emailCode, _ := s.requestProfileChange(t, alice)
+
+	// the first wrong code past FreeAttempts is still checked
+	wrongCodes := DEFAULT_ACCOUNT_LOCKOUT.FreeAttempts + 1
+	for i := 0; i < wrongCodes; i++ {
+		w := s.do(t, "POST", "/confirm-email-change", otpBody(wrongCode(emailCode)), tokens.AccessToken)
+		if resp := decodeResponse(t, w, nil); w.Code != http.StatusBadRequest || resp.Code != domain.ErrOtpInvalid.Code {
+			t.Errorf("wrong code %d: POST /confirm-email-change = %v %v, want %v %v", i, w.Code, resp.Code, http.StatusBadRequest, domain.ErrOtpInvalid.Code)
+		}
+	}
+
+	// even the right code has to wait
+	w := s.do(t, "POST", "/confirm-email-change", otpBody(emailCode), tokens.AccessToken)
+	resp := decodeResponse(t, w, nil)
+	if w.Code != http.StatusTooManyRequests || resp.Code != domain.ErrTooManyAttempts.Code {
+		t.Fatalf("POST /confirm-email-change after %d wrong codes = %v %v, want %v %v", wrongCodes, w.Code, resp.Code, http.StatusTooManyRequests, domain.ErrTooManyAttempts.Code)
+	}
+
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retryAfter <= 0 {
+		t.Fatalf("Retry-After = %q, want a number of seconds", w.Header().Get("Retry-After"))
+	}
+
+	s.now = s.now.Add(time.Duration(retryAfter) * time.Second)
+	if w := s.do(t, "POST", "/confirm-email-change", otpBody(emailCode), tokens.AccessToken); w.Code != http.StatusOK {
+		t.Errorf("POST /confirm-email-change after waiting %vs = %v, want %v", retryAfter, w.Code, http.StatusOK)
+	}
+}
+
+// Accounts get locked after LockoutAfter wrong codes, whichever code is guessed
+func TestConfirmProfileChangeLockout(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.createUser(t, "alice", domain.RoleUser)
+	tokens := s.login(t, alice)
+
+	var w *httptest.ResponseRecorder
+	for i := 0; i < DEFAULT_ACCOUNT_LOCKOUT.LockoutAfter; i++ {
+		// a code wrongly guessed MAX_ATTEMPTS times is deleted
+		emailCode, _ := s.requestProfileChange(t, alice)
+
+		w = s.do(t, "POST", "/confirm-email-change", otpBody(wrongCode(emailCode)), tokens.AccessToken)
+		s.now = s.now.Add(DEFAULT_ACCOUNT_LOCKOUT.MaxDelay)
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("wrong code %d: POST /confirm-email-change = %v, want %v", DEFAULT_ACCOUNT_LOCKOUT.LockoutAfter, w.Code, http.StatusBadRequest)
+	}
+
+	emailCode, _ := s.requestProfileChange(t, alice)
+	w = s.do(t, "POST", "/confirm-email-change", otpBody(emailCode), tokens.AccessToken)
+	if resp := decodeResponse(t, w, nil); w.Code != http.StatusTooManyRequests || resp.Code != domain.ErrAccountLocked.Code {
+		t.Errorf("POST /confirm-email-change once locked = %v %v, want %v %v", w.Code, resp.Code, http.StatusTooManyRequests, domain.ErrAccountLocked.Code)
+	}
+
+	s.now = s.now.Add(DEFAULT_ACCOUNT_LOCKOUT.LockoutDuration)
+	if w := s.do(t, "POST", "/confirm-email-change", otpBody(emailCode), tokens.AccessToken); w.Code != http.StatusOK {
+		t.Errorf("POST /confirm-email-change after the lockout = %v, want %v", w.Code, http.StatusOK)
+	}
+}
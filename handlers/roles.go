@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/handlers/api"
+	v "github.com/caleb-mwasikira/tap_gopay/validators"
+)
+
+// Role sets of the permission matrix in main.go
+var (
+	ANY_ROLE    = domain.Roles
+	STAFF_ROLES = []domain.Role{domain.RoleAgent, domain.RoleAdmin}
+	ADMIN_ROLES = []domain.Role{domain.RoleAdmin}
+)
+
+// Only lets users with one of the roles through.
+// Must be wrapped by AuthMiddleware, which loads the current role of the user
+// from the store on every request.
+//
+//	mux.Handle("POST /admin/set-role", h.AuthMiddleware(
+//		h.RequireRole(domain.RoleAdmin)(http.HandlerFunc(h.SetUserRole)),
+//	))
+func (h *Handler) RequireRole(roles ...domain.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := getLoggedInUser(r.Context())
+			if user == nil {
+				api.Error(
+					w,
+					"Unauthorized action detected",
+					domain.ErrUnauthorized,
+					http.StatusUnauthorized,
+				)
+				return
+			}
+
+			if !slices.Contains(roles, user.Role) {
+				api.Error(
+					w,
+					"Forbidden action detected",
+					domain.ErrForbidden,
+					http.StatusForbidden,
+				)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Gives a user another role; admins only.
+// The user is logged out everywhere, as their access tokens name their old role.
+func (h *Handler) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	admin, request, ok := getAdminRequest[v.SetRoleDto](w, r)
+	if !ok {
+		return
+	}
+
+	user, ok := h.getUserByEmail(w, request.Email)
+	if !ok {
+		return
+	}
+
+	// so that there is always an admin left
+	if user.Id == admin.Id {
+		api.Error(
+			w,
+			"Admins cannot change their own role",
+			domain.ErrRuleViolation.WithMessage("Admins cannot change their own role"),
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+
+	change := db.RoleChange{
+		UserId:    user.Id,
+		From:      user.Role,
+		To:        domain.Role(request.Role),
+		Reason:    request.Reason,
+		ChangedBy: admin.Id,
+	}
+
+	err := h.users.ChangeUserRole(change)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error changing user role",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	_, err = h.sessions.RevokeAllSessions(user.Id)
+	if err != nil {
+		log.Printf("error logging out user %v after a role change; %v\n", user.Id, err)
+	}
+
+	api.SendResponse(
+		w,
+		fmt.Sprintf("Success changing role of %v from %v to %v", user.Email, change.From, change.To),
+		nil,
+		nil,
+		http.StatusOK,
+	)
+}
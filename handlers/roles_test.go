@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+)
+
+// Routes of the permission matrix of main.go, and the roles allowed on them
+var roleRoutes = []struct {
+	method string
+	path   string
+	roles  []domain.Role
+}{
+	{"POST", "/new-credit-card", STAFF_ROLES},
+	{"GET", "/admin/users", ADMIN_ROLES},
+	{"POST", "/admin/set-role", ADMIN_ROLES},
+	{"POST", "/admin/suspend-user", ADMIN_ROLES},
+	{"POST", "/admin/activate-user", ADMIN_ROLES},
+	{"POST", "/admin/block-card", ADMIN_ROLES},
+	{"POST", "/admin/unblock-card", ADMIN_ROLES},
+	{"POST", "/admin/reset-totp", ADMIN_ROLES},
+	{"POST", "/admin/send-verification-email", ADMIN_ROLES},
+	{"GET", "/my-profile", ANY_ROLE},
+}
+
+func TestRequireRole(t *testing.T) {
+	s := newTestServer(t)
+
+	tokens := map[domain.Role]string{}
+	for _, role := range domain.Roles {
+		user := s.createUser(t, string(role), role)
+		tokens[role] = s.login(t, user).AccessToken
+	}
+
+	for _, route := range roleRoutes {
+		for _, role := range domain.Roles {
+			allowed := slices.Contains(route.roles, role)
+
+			// allowed requests get past RequireRole, though the empty
+			// body may fail validation
+			w := s.do(t, route.method, route.path, "{}", tokens[role])
+			resp := decodeResponse(t, w, nil)
+			forbidden := w.Code == http.StatusForbidden && resp.Code == domain.ErrForbidden.Code
+			if forbidden == allowed {
+				t.Errorf("%v %v as %v = %v %v, allowed %v", route.method, route.path, role, w.Code, resp.Code, allowed)
+			}
+		}
+	}
+
+	if w := s.do(t, "GET", "/admin/users", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /admin/users without logging in = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+}
+
+// The role is read from the store on every request, so a user loses access
+// as soon as their role is taken away, without logging in again
+func TestRequireRoleAfterRoleChange(t *testing.T) {
+	s := newTestServer(t)
+	agent := s.createUser(t, "agent", domain.RoleAgent)
+	tokens := s.login(t, agent)
+
+	if w := s.do(t, "POST", "/new-credit-card", newCardBody, tokens.AccessToken); w.Code != http.StatusCreated {
+		t.Fatalf("POST /new-credit-card as agent = %v, want %v", w.Code, http.StatusCreated)
+	}
+
+	err := s.h.users.ChangeUserRole(db.RoleChange{UserId: agent.Id, To: domain.RoleUser, Reason: "test"})
+	if err != nil {
+		t.Fatalf("ChangeUserRole() error = %v", err)
+	}
+
+	if w := s.do(t, "POST", "/new-credit-card", newCardBody, tokens.AccessToken); w.Code != http.StatusForbidden {
+		t.Errorf("POST /new-credit-card after losing the agent role = %v, want %v", w.Code, http.StatusForbidden)
+	}
+}
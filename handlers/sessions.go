@@ -0,0 +1,470 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/handlers/api"
+	v "github.com/caleb-mwasikira/tap_gopay/validators"
+)
+
+const (
+	MAX_USER_AGENT_LEN int = 255
+)
+
+// TokenPair is sent to the client on login and on every refresh
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // seconds until the access token expires
+}
+
+// SessionInfo describes a logged in device in GET /sessions
+type SessionInfo struct {
+	Id         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IpAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // the session of the request
+}
+
+// Starts a session for the user on the requesting device
+func (h *Handler) startSession(r *http.Request, user db.User) (*TokenPair, error) {
+	sessionId, err := newSessionId()
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := newRefreshToken(sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	userAgent := r.UserAgent()
+	if len(userAgent) > MAX_USER_AGENT_LEN {
+		userAgent = userAgent[:MAX_USER_AGENT_LEN]
+	}
+
+	now := h.now()
+	err = h.sessions.CreateSession(db.Session{
+		Id:               sessionId,
+		UserId:           user.Id,
+		RefreshTokenHash: hashRefreshToken(refreshToken),
+		UserAgent:        userAgent,
+		IpAddress:        clientIp(r),
+		CreatedAt:        now,
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(h.refreshTokenTTL),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating session; %v", err)
+	}
+
+	return h.tokenPair(user, sessionId, refreshToken)
+}
+
+func (h *Handler) tokenPair(user db.User, sessionId, refreshToken string) (*TokenPair, error) {
+	accessToken, err := h.createToken(user, sessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(h.accessTokenTTL.Seconds()),
+	}, nil
+}
+
+// Returns a random id naming a login session
+func newSessionId() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", fmt.Errorf("error generating session id; %v", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Refresh tokens are <session id>.<random secret>, so the session of a
+// token can be found without storing the token itself
+func newRefreshToken(sessionId string) (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", fmt.Errorf("error generating refresh token; %v", err)
+	}
+	return sessionId + "." + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// Refresh tokens are random enough that a plain SHA-256 hash cannot be reversed
+func hashRefreshToken(refreshToken string) string {
+	hash := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(hash[:])
+}
+
+// Returns the IP address of the client, without its port
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Exchanges a refresh token for a new access token and refresh token.
+// Each refresh token can only be used once; using one again logs out
+// its session, as the token has most likely been stolen.
+func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	request, ok := v.GetValidJsonInput[v.RefreshTokenDto](w, r.Body)
+	if !ok {
+		return
+	}
+
+	// browsers send the refresh token in its cookie instead
+	refreshToken := request.RefreshToken
+	fromCookie := false
+
+	if refreshToken == "" {
+		cookie, err := r.Cookie(REFRESH_COOKIE)
+		if err != nil || cookie.Value == "" {
+			api.Error(
+				w,
+				"Invalid refresh token",
+				domain.ErrRefreshTokenInvalid.WithMessage("Missing refresh token. Please login again"),
+				http.StatusUnauthorized,
+			)
+			return
+		}
+
+		err = verifyCsrfToken(r)
+		if err != nil {
+			api.Error(
+				w,
+				"Invalid CSRF token",
+				err,
+				http.StatusForbidden,
+			)
+			return
+		}
+
+		refreshToken = cookie.Value
+		fromCookie = true
+	}
+
+	sessionId, _, _ := strings.Cut(refreshToken, ".")
+	session, err := h.sessions.GetSession(sessionId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			api.Error(
+				w,
+				"Invalid refresh token",
+				domain.ErrRefreshTokenInvalid,
+				http.StatusUnauthorized,
+			)
+			return
+		}
+
+		api.Error(
+			w,
+			"Unexpected error refreshing token",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	tokenHash := hashRefreshToken(refreshToken)
+
+	now := h.now()
+	if !session.IsActive(now) {
+		api.Error(
+			w,
+			"Invalid refresh token",
+			domain.ErrRefreshTokenInvalid.Wrap(fmt.Errorf("session %v is no longer active", session.Id)),
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(tokenHash), []byte(session.PreviousRefreshTokenHash)) == 1 {
+		err = h.sessions.RevokeSession(session.UserId, session.Id)
+		if err != nil {
+			log.Printf("error revoking session %v after refresh token reuse; %v\n", session.Id, err)
+		}
+
+		api.Error(
+			w,
+			"Invalid refresh token",
+			domain.ErrRefreshTokenInvalid.Wrap(fmt.Errorf("refresh token of session %v was reused; session revoked", session.Id)),
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(tokenHash), []byte(session.RefreshTokenHash)) != 1 {
+		api.Error(
+			w,
+			"Invalid refresh token",
+			domain.ErrRefreshTokenInvalid,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	user, err := h.users.GetUserById(session.UserId)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error refreshing token",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	if !h.allowUnsuspended(w, user) {
+		return
+	}
+
+	newRefreshToken, err := newRefreshToken(session.Id)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error refreshing token",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	// fails if another request has just used the same refresh token
+	err = h.sessions.RotateRefreshToken(session.Id, tokenHash, hashRefreshToken(newRefreshToken), now, now.Add(h.refreshTokenTTL))
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error refreshing token",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	tokens, err := h.tokenPair(*user, session.Id, newRefreshToken)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error refreshing token",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	if fromCookie {
+		err = h.setSessionCookies(w, tokens)
+		if err != nil {
+			api.Error(
+				w,
+				"Unexpected error refreshing token",
+				err,
+				http.StatusInternalServerError,
+			)
+			return
+		}
+
+		// keep the refresh token out of reach of scripts
+		tokens.RefreshToken = ""
+	}
+
+	api.SendResponse(
+		w,
+		"Token refreshed successfully",
+		tokens, nil,
+		http.StatusOK,
+	)
+}
+
+// Ends the session of the request
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user := getLoggedInUser(r.Context())
+	session := getCurrentSession(r.Context())
+	if user == nil || session == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	err := h.sessions.RevokeSession(user.Id, session.Id)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error logging out",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	h.clearSessionCookies(w)
+
+	api.SendResponse(
+		w,
+		"Logout successful",
+		nil, nil,
+		http.StatusOK,
+	)
+}
+
+// Ends every session of the logged in user, including the one of the request
+func (h *Handler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user := getLoggedInUser(r.Context())
+	if user == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	revoked, err := h.sessions.RevokeAllSessions(user.Id)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error logging out",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	h.clearSessionCookies(w)
+
+	api.SendResponse(
+		w,
+		fmt.Sprintf("Logged out of %d session(s)", revoked),
+		map[string]int{"revoked": revoked}, nil,
+		http.StatusOK,
+	)
+}
+
+// Lists the devices the logged in user is logged in on
+func (h *Handler) MySessions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user := getLoggedInUser(r.Context())
+	current := getCurrentSession(r.Context())
+	if user == nil || current == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	sessions, err := h.sessions.GetActiveSessions(user.Id, h.now())
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error fetching sessions",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	infos := []SessionInfo{}
+	for _, session := range sessions {
+		infos = append(infos, SessionInfo{
+			Id:         session.Id,
+			UserAgent:  session.UserAgent,
+			IpAddress:  session.IpAddress,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.Id == current.Id,
+		})
+	}
+
+	api.SendResponse(
+		w,
+		"Fetched active sessions",
+		infos, nil,
+		http.StatusOK,
+	)
+}
+
+// Logs out one of the logged in user's devices
+func (h *Handler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user := getLoggedInUser(r.Context())
+	if user == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	request, ok := v.GetValidJsonInput[v.SessionIdDto](w, r.Body)
+	if !ok {
+		return
+	}
+
+	err := h.sessions.RevokeSession(user.Id, request.SessionId)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error revoking session",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		"Session revoked successfully",
+		nil, nil,
+		http.StatusOK,
+	)
+}
+
+func getCurrentSession(ctx context.Context) *db.Session {
+	session, _ := ctx.Value("session").(*db.Session)
+	return session
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+)
+
+func refreshBody(refreshToken string) string {
+	return fmt.Sprintf(`{"refresh_token":%q}`, refreshToken)
+}
+
+// Each refresh hands out a new refresh token, and using an old one again
+// logs out the session
+func TestRefreshTokenRotation(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.createUser(t, "alice", domain.RoleUser)
+	first := s.login(t, alice)
+
+	w := s.do(t, "POST", "/refresh", refreshBody(first.RefreshToken), "")
+	var second TokenPair
+	decodeResponse(t, w, &second)
+	if w.Code != http.StatusOK || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("POST /refresh = %v, refresh token %q; want a new one", w.Code, second.RefreshToken)
+	}
+
+	if w := s.do(t, "GET", "/my-profile", "", second.AccessToken); w.Code != http.StatusOK {
+		t.Errorf("GET /my-profile with the refreshed access token = %v, want %v", w.Code, http.StatusOK)
+	}
+
+	// the old token was most likely stolen
+	w = s.do(t, "POST", "/refresh", refreshBody(first.RefreshToken), "")
+	if resp := decodeResponse(t, w, nil); w.Code != http.StatusUnauthorized || resp.Code != domain.ErrRefreshTokenInvalid.Code {
+		t.Errorf("POST /refresh with a used refresh token = %v %v, want %v %v", w.Code, resp.Code, http.StatusUnauthorized, domain.ErrRefreshTokenInvalid.Code)
+	}
+
+	// which revoked the session, and every token of it
+	if w := s.do(t, "POST", "/refresh", refreshBody(second.RefreshToken), ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("POST /refresh with the latest refresh token of a revoked session = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+	for _, accessToken := range []string{first.AccessToken, second.AccessToken} {
+		w := s.do(t, "GET", "/my-profile", "", accessToken)
+		if resp := decodeResponse(t, w, nil); w.Code != http.StatusUnauthorized || resp.Code != domain.ErrSessionExpired.Code {
+			t.Errorf("GET /my-profile after the session was revoked = %v %v, want %v %v", w.Code, resp.Code, http.StatusUnauthorized, domain.ErrSessionExpired.Code)
+		}
+	}
+
+	// other sessions of the user are not logged out
+	if w := s.do(t, "POST", "/refresh", refreshBody(s.login(t, alice).RefreshToken), ""); w.Code != http.StatusOK {
+		t.Errorf("POST /refresh of another session = %v, want %v", w.Code, http.StatusOK)
+	}
+}
+
+// Sessions end RefreshTokenTTL after they were last refreshed
+func TestRefreshTokenExpiry(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.createUser(t, "alice", domain.RoleUser)
+	tokens := s.login(t, alice)
+
+	almost := DEFAULT_REFRESH_TOKEN_TTL - time.Minute
+	for i := 0; i < 2; i++ {
+		s.now = s.now.Add(almost)
+
+		w := s.do(t, "POST", "/refresh", refreshBody(tokens.RefreshToken), "")
+		tokens = &TokenPair{}
+		decodeResponse(t, w, tokens)
+		if w.Code != http.StatusOK {
+			t.Fatalf("POST /refresh %v after the last one = %v, want %v", almost, w.Code, http.StatusOK)
+		}
+	}
+
+	s.now = s.now.Add(DEFAULT_REFRESH_TOKEN_TTL)
+
+	w := s.do(t, "POST", "/refresh", refreshBody(tokens.RefreshToken), "")
+	if resp := decodeResponse(t, w, nil); w.Code != http.StatusUnauthorized || resp.Code != domain.ErrRefreshTokenInvalid.Code {
+		t.Errorf("POST /refresh of an expired session = %v %v, want %v %v", w.Code, resp.Code, http.StatusUnauthorized, domain.ErrRefreshTokenInvalid.Code)
+	}
+	if w := s.do(t, "GET", "/my-profile", "", tokens.AccessToken); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /my-profile in an expired session = %v, want %v", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,672 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/handlers/api"
+	"github.com/caleb-mwasikira/tap_gopay/money"
+	"github.com/caleb-mwasikira/tap_gopay/totp"
+	v "github.com/caleb-mwasikira/tap_gopay/validators"
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	TOTP_ISSUER string = "TapGoPay" // shown next to the account in authenticator apps
+
+	RECOVERY_CODE_COUNT int = 10
+	RECOVERY_CODE_LEN   int = 16 // base32 characters, shown in groups of four
+
+	// How long the password step of a two-step login is remembered
+	CHALLENGE_TOKEN_TTL time.Duration = 5 * time.Minute
+	CHALLENGE_TOKEN_USE string        = "2fa"
+)
+
+// StepUpPolicy decides whether a transfer needs a fresh TOTP code
+// on top of the card PIN
+type StepUpPolicy func(user *db.User, transfer v.SendMoneyDto) bool
+
+// Requires a TOTP code on transfers of more than threshold.
+// Transfers in another currency always require one, as they cannot be compared.
+func AmountAbove(threshold money.Money) StepUpPolicy {
+	return func(user *db.User, transfer v.SendMoneyDto) bool {
+		cmp, err := transfer.Amount.Cmp(threshold)
+		return err != nil || cmp > 0
+	}
+}
+
+// Reads TOTP_TRANSFER_THRESHOLD, e.g. 10000 or "USD 100"; nil when unset
+func stepUpPolicyFromEnv(value string) (StepUpPolicy, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	threshold, err := money.Parse(value, money.DefaultCurrency)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP_TRANSFER_THRESHOLD %q; %v", value, err)
+	}
+	return AmountAbove(threshold), nil
+}
+
+// TotpEnrolment is shown once when the user starts setting up TOTP.
+// Apps enrol by scanning OtpauthUri as a QR code, or by typing in Secret.
+type TotpEnrolment struct {
+	Secret     string `json:"secret"`
+	OtpauthUri string `json:"otpauth_uri"`
+}
+
+// LoginChallenge is sent instead of a token pair when the password
+// was correct but the user has TOTP enabled
+type LoginChallenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int    `json:"expires_in"` // seconds
+}
+
+// Secrets are sealed with the user id as additional data, so a sealed
+// secret copied onto another user cannot be opened
+func totpAdditionalData(userId int) []byte {
+	return []byte("totp:" + strconv.Itoa(userId))
+}
+
+// Returns whether the user has confirmed TOTP
+func (h *Handler) totpEnabled(userId int) (bool, error) {
+	record, err := h.totps.GetTotp(userId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return record.Enabled, nil
+}
+
+func (h *Handler) totpSecret(record *db.Totp) ([]byte, error) {
+	secret, err := h.cvvCipher.Open(record.Secret, totpAdditionalData(record.UserId))
+	if err != nil {
+		return nil, fmt.Errorf("error opening TOTP secret of user %v; %v", record.UserId, err)
+	}
+	return secret, nil
+}
+
+// Checks a code from the user's authenticator app.
+// Each code is accepted only once, even within its 30 seconds.
+func (h *Handler) verifyTotp(userId int, code string) error {
+	record, err := h.totps.GetTotp(userId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return domain.ErrTotpNotEnrolled
+		}
+		return err
+	}
+	if !record.Enabled {
+		return domain.ErrTotpNotEnrolled
+	}
+
+	secret, err := h.totpSecret(record)
+	if err != nil {
+		return err
+	}
+
+	counter, ok := totp.Validate(secret, code, h.now())
+	if !ok {
+		return domain.ErrTotpInvalid
+	}
+	return h.totps.UseTotpCounter(userId, counter)
+}
+
+// Wrong TOTP codes of a user are counted against one key, whichever endpoint
+// they are entered at
+func totpAttemptKey(userId int) string {
+	return accountAttemptKey(LOGIN_TOTP_ATTEMPT, strconv.Itoa(userId))
+}
+
+// Checks a code from the authenticator app of the logged in user, counting
+// wrong codes like LoginTotp does so that a stolen session cannot guess them.
+// Returns false after writing the error.
+func (h *Handler) allowTotp(w http.ResponseWriter, r *http.Request, user *db.User, code string) bool {
+	attempt, ok := h.allowAttempt(w, r, totpAttemptKey(user.Id))
+	if !ok {
+		return false
+	}
+	defer attempt.release()
+
+	err := h.verifyTotp(user.Id, code)
+	if err != nil {
+		if errors.Is(err, domain.ErrTotpInvalid) {
+			attempt.fail(user.Email)
+		}
+
+		api.Error(
+			w,
+			"Invalid two-factor authentication code",
+			err,
+			http.StatusUnauthorized,
+		)
+		return false
+	}
+
+	attempt.succeed()
+	return true
+}
+
+// Returns new recovery codes and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := []string{}
+	hashes := []string{}
+
+	for i := 0; i < RECOVERY_CODE_COUNT; i++ {
+		random := make([]byte, RECOVERY_CODE_LEN*5/8)
+		_, err := rand.Read(random)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error generating recovery codes; %v", err)
+		}
+
+		encoded := base32.StdEncoding.EncodeToString(random)
+		groups := []string{}
+		for j := 0; j < len(encoded); j += 4 {
+			groups = append(groups, encoded[j:j+4])
+		}
+
+		code := strings.ToLower(strings.Join(groups, "-"))
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// Recovery codes carry 80 random bits, so a plain SHA-256 hash cannot be reversed.
+// Dashes, spaces and case are ignored.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	code = strings.ReplaceAll(code, " ", "")
+
+	hash := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(hash[:])
+}
+
+// Signs a token proving the user got the password of a two-step login right.
+// It has no session id, so it is never accepted as an access token.
+func (h *Handler) createChallengeToken(user db.User) (string, error) {
+	now := h.now()
+
+	claims := jwt.MapClaims{
+		"sub": strconv.Itoa(user.Id),
+		"use": CHALLENGE_TOKEN_USE,
+		"iss": "tap_gopay",
+		"exp": now.Add(CHALLENGE_TOKEN_TTL).Unix(),
+		"iat": now.Unix(),
+	}
+	return h.tokenSigner.Sign(claims)
+}
+
+// Returns the id of the user a challenge token was issued to
+func (h *Handler) verifyChallengeToken(token string) (int, error) {
+	claims, err := h.verifyToken(token)
+	if err != nil {
+		return 0, domain.ErrChallengeInvalid.Wrap(err)
+	}
+
+	use, _ := claims["use"].(string)
+	if use != CHALLENGE_TOKEN_USE {
+		return 0, domain.ErrChallengeInvalid.Wrap(fmt.Errorf("not a challenge token"))
+	}
+
+	// checked against the handler clock as well as by the JWT library
+	exp, _ := claims["exp"].(float64)
+	if h.now().Unix() > int64(exp) {
+		return 0, domain.ErrChallengeInvalid.Wrap(fmt.Errorf("challenge token expired"))
+	}
+
+	sub, _ := claims["sub"].(string)
+	userId, err := strconv.Atoi(sub)
+	if err != nil {
+		return 0, domain.ErrChallengeInvalid.Wrap(fmt.Errorf("invalid 'sub' claim %q in challenge token", sub))
+	}
+	return userId, nil
+}
+
+// Sends a challenge token when the user has TOTP enabled.
+// Returns false when the user can be logged in with their password alone.
+func (h *Handler) sendLoginChallenge(w http.ResponseWriter, user db.User) bool {
+	enabled, err := h.totpEnabled(user.Id)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error loggin in user",
+			err,
+			http.StatusInternalServerError,
+		)
+		return true
+	}
+	if !enabled {
+		return false
+	}
+
+	challengeToken, err := h.createChallengeToken(user)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error loggin in user",
+			err,
+			http.StatusInternalServerError,
+		)
+		return true
+	}
+
+	api.SendResponse(
+		w,
+		"Enter the code from your authenticator app to finish logging in",
+		LoginChallenge{
+			TwoFactorRequired: true,
+			ChallengeToken:    challengeToken,
+			ExpiresIn:         int(CHALLENGE_TOKEN_TTL.Seconds()),
+		}, nil,
+		http.StatusOK,
+	)
+	return true
+}
+
+// Second step of logging in with TOTP enabled.
+// Takes the challenge token from POST /login and either a TOTP code or a recovery code.
+func (h *Handler) LoginTotp(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	request, ok := v.GetValidJsonInput[v.LoginTotpDto](w, r.Body)
+	if !ok {
+		return
+	}
+
+	userId, err := h.verifyChallengeToken(request.ChallengeToken)
+	if err != nil {
+		api.Error(
+			w,
+			"Invalid or expired login challenge. Please login again",
+			err,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	user, err := h.users.GetUserById(userId)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error loggin in user",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	attempt, ok := h.allowAttempt(w, r, totpAttemptKey(userId))
+	if !ok {
+		return
+	}
+	defer attempt.release()
+
+	switch {
+	case request.Code != "":
+		err = h.verifyTotp(userId, request.Code)
+	case request.RecoveryCode != "":
+		err = h.totps.UseRecoveryCode(userId, hashRecoveryCode(request.RecoveryCode), h.now())
+	default:
+		err = domain.ErrTotpRequired.WithMessage("Enter a code from your authenticator app or a recovery code")
+	}
+	if err != nil {
+		if errors.Is(err, domain.ErrTotpInvalid) {
+			attempt.fail(user.Email)
+		}
+
+		api.Error(
+			w,
+			"Invalid two-factor authentication code",
+			err,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	attempt.succeed()
+
+	if !h.allowUnsuspended(w, user) {
+		return
+	}
+
+	tokens, err := h.startSession(r, *user)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error loggin in user",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	err = h.setSessionCookies(w, tokens)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error loggin in user",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		"Login successful",
+		tokens, nil,
+		http.StatusOK,
+	)
+}
+
+// Starts setting up TOTP for the logged in user.
+// TOTP is only turned on once a code is confirmed with POST /confirm-totp.
+func (h *Handler) EnrollTotp(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user := getLoggedInUser(r.Context())
+	if user == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error setting up two-factor authentication",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	sealedSecret, err := h.cvvCipher.Seal(secret, totpAdditionalData(user.Id))
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error setting up two-factor authentication",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	err = h.totps.SaveTotpSecret(user.Id, sealedSecret)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error setting up two-factor authentication",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		"Add this account to your authenticator app, then confirm it with a code",
+		TotpEnrolment{
+			Secret:     totp.EncodeSecret(secret),
+			OtpauthUri: totp.URI(TOTP_ISSUER, user.Email, secret),
+		}, nil,
+		http.StatusOK,
+	)
+}
+
+// Turns on TOTP once the user proves their app generates the right codes.
+// Responds with recovery codes, which are never shown again.
+func (h *Handler) ConfirmTotp(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user := getLoggedInUser(r.Context())
+	if user == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	request, ok := v.GetValidJsonInput[v.TotpCodeDto](w, r.Body)
+	if !ok {
+		return
+	}
+
+	record, err := h.totps.GetTotp(user.Id)
+	if err != nil && err != sql.ErrNoRows {
+		api.Error(
+			w,
+			"Unexpected error confirming two-factor authentication",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+	if record == nil {
+		api.Error(
+			w,
+			"Two-factor authentication has not been set up",
+			domain.ErrTotpNotEnrolled,
+			http.StatusUnprocessableEntity,
+		)
+		return
+	}
+	if record.Enabled {
+		api.Error(
+			w,
+			"Two-factor authentication is already enabled",
+			domain.ErrTotpAlreadyEnabled,
+			http.StatusConflict,
+		)
+		return
+	}
+
+	secret, err := h.totpSecret(record)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error confirming two-factor authentication",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	counter, ok := totp.Validate(secret, request.Code, h.now())
+	if !ok {
+		api.Error(
+			w,
+			"Invalid two-factor authentication code",
+			domain.ErrTotpInvalid,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error confirming two-factor authentication",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	err = h.totps.EnableTotp(user.Id, counter, hashes)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error confirming two-factor authentication",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		"Two-factor authentication enabled. Keep these recovery codes somewhere safe",
+		map[string][]string{"recovery_codes": codes}, nil,
+		http.StatusOK,
+	)
+}
+
+// Turns off TOTP; needs a current code so a stolen session cannot do it
+func (h *Handler) DisableTotp(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user := getLoggedInUser(r.Context())
+	if user == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	request, ok := v.GetValidJsonInput[v.TotpCodeDto](w, r.Body)
+	if !ok {
+		return
+	}
+
+	if !h.allowTotp(w, r, user, request.Code) {
+		return
+	}
+
+	err := h.totps.DeleteTotp(user.Id)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error disabling two-factor authentication",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		"Two-factor authentication disabled",
+		nil, nil,
+		http.StatusOK,
+	)
+}
+
+// Replaces the recovery codes of the logged in user, e.g. after using some
+func (h *Handler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json")
+
+	user := getLoggedInUser(r.Context())
+	if user == nil {
+		api.Error(
+			w,
+			"Unauthorized action detected",
+			domain.ErrUnauthorized,
+			http.StatusUnauthorized,
+		)
+		return
+	}
+
+	request, ok := v.GetValidJsonInput[v.TotpCodeDto](w, r.Body)
+	if !ok {
+		return
+	}
+
+	if !h.allowTotp(w, r, user, request.Code) {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error generating recovery codes",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	err = h.totps.ReplaceRecoveryCodes(user.Id, hashes)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error generating recovery codes",
+			err,
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	api.SendResponse(
+		w,
+		"New recovery codes generated. Your old codes no longer work",
+		map[string][]string{"recovery_codes": codes}, nil,
+		http.StatusOK,
+	)
+}
+
+// Applies the step-up policy to a transfer.
+// Returns false, after writing the error, when the transfer needs a TOTP code
+// that the request lacks or gets wrong.
+func (h *Handler) allowTransferStepUp(w http.ResponseWriter, r *http.Request, user *db.User, request v.SendMoneyDto) bool {
+	if h.transferStepUp == nil || !h.transferStepUp(user, request) {
+		return true
+	}
+
+	enabled, err := h.totpEnabled(user.Id)
+	if err != nil {
+		api.Error(
+			w,
+			"Unexpected error checking two-factor authentication",
+			err,
+			http.StatusInternalServerError,
+		)
+		return false
+	}
+
+	if !enabled || request.Totp == "" {
+		err = domain.ErrTotpRequired
+		if !enabled {
+			err = domain.ErrTotpRequired.WithMessage("Transfers of this amount require two-factor authentication. Please enable it first")
+		}
+
+		api.Error(
+			w,
+			"Two-factor authentication required",
+			err,
+			http.StatusForbidden,
+		)
+		return false
+	}
+
+	return h.allowTotp(w, r, user, request.Totp)
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+)
+
+const (
+	// How long AuthMiddleware may use a user loaded by an earlier request.
+	// Changes made through this server take effect at once; changes made
+	// elsewhere (another instance, the command line) within this time.
+	USER_CACHE_TTL time.Duration = 10 * time.Second
+)
+
+type cachedUser struct {
+	user     db.User
+	loadedAt time.Time
+}
+
+// userCache is a UserStore that remembers users loaded by id for USER_CACHE_TTL.
+// Updates made through it drop the cached copy of the user.
+type userCache struct {
+	db.UserStore
+
+	mu    sync.Mutex
+	users map[int]cachedUser
+	ttl   time.Duration
+}
+
+func newUserCache(store db.UserStore, ttl time.Duration) *userCache {
+	return &userCache{
+		UserStore: store,
+		users:     map[int]cachedUser{},
+		ttl:       ttl,
+	}
+}
+
+func (c *userCache) GetUserById(id int) (*db.User, error) {
+	c.mu.Lock()
+	cached, ok := c.users[id]
+	c.mu.Unlock()
+
+	if ok && time.Since(cached.loadedAt) < c.ttl {
+		user := cached.user
+		return &user, nil
+	}
+
+	user, err := c.UserStore.GetUserById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.users[id] = cachedUser{user: *user, loadedAt: time.Now()}
+	c.mu.Unlock()
+
+	return user, nil
+}
+
+func (c *userCache) UpdateUser(email string, patch db.UserPatch) error {
+	err := c.UserStore.UpdateUser(email, patch)
+	c.forget(email)
+	return err
+}
+
+func (c *userCache) ChangeUserRole(change db.RoleChange) error {
+	err := c.UserStore.ChangeUserRole(change)
+
+	c.mu.Lock()
+	delete(c.users, change.UserId)
+	c.mu.Unlock()
+
+	return err
+}
+
+func (c *userCache) SetUserSuspended(action db.AdminAction, suspended bool) error {
+	err := c.UserStore.SetUserSuspended(action, suspended)
+
+	c.mu.Lock()
+	delete(c.users, action.UserId)
+	c.mu.Unlock()
+
+	return err
+}
+
+func (c *userCache) forget(email string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, cached := range c.users {
+		if cached.user.Email == email {
+			delete(c.users, id)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/jobs"
+)
+
+const (
+	// Revoked and expired sessions are kept this long, e.g. to look into
+	// suspicious activity on an account
+	SESSION_RETENTION time.Duration = 7 * 24 * time.Hour
+
+	// Idempotency keys can be reused once their record is this old
+	IDEMPOTENCY_RECORD_TTL time.Duration = 24 * time.Hour
+)
+
+// The jobs run by the server, and from the command line with tap_gopay jobs run
+func janitorJobs(store db.Store) []jobs.Job {
+	return []jobs.Job{
+		{
+			Name:        "purge-expired-codes",
+			Description: "delete expired one-time codes",
+			Interval:    time.Hour,
+			Reports:     "expired codes deleted",
+			Run: func(ctx context.Context, now time.Time) (int, error) {
+				return store.DeleteExpiredCodes(now)
+			},
+		},
+		{
+			Name:        "purge-stale-sessions",
+			Description: "delete sessions that expired or were revoked over 7 days ago",
+			Interval:    6 * time.Hour,
+			Reports:     "stale sessions deleted",
+			Run: func(ctx context.Context, now time.Time) (int, error) {
+				return store.DeleteStaleSessions(now.Add(-SESSION_RETENTION))
+			},
+		},
+		{
+			Name:        "purge-idempotency-records",
+			Description: "delete idempotency records older than 24 hours",
+			Interval:    time.Hour,
+			Reports:     "idempotency records deleted",
+			Run: func(ctx context.Context, now time.Time) (int, error) {
+				return store.DeleteIdempotencyRecordsBefore(now.Add(-IDEMPOTENCY_RECORD_TTL))
+			},
+		},
+	}
+}
+
+func newJobRunner(store db.Store) *jobs.Runner {
+	runner, err := jobs.NewRunner(janitorJobs(store), time.Now)
+	if err != nil {
+		log.Fatalf("error creating job runner; %v\n", err)
+	}
+	return runner
+}
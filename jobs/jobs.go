@@ -0,0 +1,219 @@
+// Package jobs runs background jobs, such as purging expired rows, on a
+// schedule inside the server.
+//
+// Every job runs on its own interval, randomly shifted by up to JITTER of the
+// interval so that server instances started together do not all run a job at
+// once. A job never runs twice at the same time in one Runner; a run that comes
+// due while the previous one is still going is skipped.
+//
+//	runner, err := jobs.NewRunner([]jobs.Job{{
+//		Name:     "purge-expired-codes",
+//		Interval: time.Hour,
+//		Reports:  "expired codes deleted",
+//		Run: func(ctx context.Context, now time.Time) (int, error) {
+//			return store.DeleteExpiredCodes(now)
+//		},
+//	}}, time.Now)
+//
+//	runner.Start()
+//	...
+//	err = runner.Stop(ctx) // waits for running jobs until ctx is done
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	// Largest random shift of a job's interval, as a fraction of it
+	JITTER float64 = 0.1
+
+	// Jobs first run within this long of Start, so that servers restarted
+	// more often than a job's interval still run it
+	MAX_FIRST_RUN_DELAY time.Duration = time.Minute
+)
+
+var (
+	ErrUnknownJob     error = errors.New("unknown job")
+	ErrAlreadyRunning error = errors.New("job is already running")
+	ErrStopped        error = errors.New("job runner has been stopped")
+)
+
+type Job struct {
+	Name        string
+	Description string
+	Interval    time.Duration
+
+	// What the count returned by Run is, e.g. "expired codes deleted"
+	Reports string
+
+	// Does the work of the job and returns how many items it handled.
+	// ctx is cancelled when the runner is stopped and cannot wait any longer.
+	Run func(ctx context.Context, now time.Time) (int, error)
+}
+
+type Runner struct {
+	jobs []Job
+	now  func() time.Time
+
+	mu      sync.Mutex
+	running map[string]bool
+
+	// stopping cancels the schedule; cancelRuns cancels jobs still running
+	stopping   context.Context
+	stop       context.CancelFunc
+	runs       context.Context
+	cancelRuns context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+func NewRunner(jobs []Job, now func() time.Time) (*Runner, error) {
+	names := map[string]bool{}
+	for _, job := range jobs {
+		if job.Name == "" || job.Run == nil || job.Interval <= 0 {
+			return nil, fmt.Errorf("job %q needs a name, an interval and a Run function", job.Name)
+		}
+		if names[job.Name] {
+			return nil, fmt.Errorf("duplicate job %q", job.Name)
+		}
+		names[job.Name] = true
+	}
+
+	if now == nil {
+		now = time.Now
+	}
+
+	stopping, stop := context.WithCancel(context.Background())
+	runs, cancelRuns := context.WithCancel(context.Background())
+
+	return &Runner{
+		jobs:       jobs,
+		now:        now,
+		running:    map[string]bool{},
+		stopping:   stopping,
+		stop:       stop,
+		runs:       runs,
+		cancelRuns: cancelRuns,
+	}, nil
+}
+
+// Returns the jobs of the runner in the order they were given
+func (r *Runner) Jobs() []Job {
+	return r.jobs
+}
+
+// Schedules every job until Stop is called
+func (r *Runner) Start() {
+	for _, job := range r.jobs {
+		r.wg.Add(1)
+		go r.schedule(job)
+	}
+}
+
+func (r *Runner) schedule(job Job) {
+	defer r.wg.Done()
+
+	wait := rand.N(min(job.Interval, MAX_FIRST_RUN_DELAY))
+	for {
+		timer := time.NewTimer(wait)
+		select {
+		case <-r.stopping.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		_, err := r.Run(job.Name)
+		if errors.Is(err, ErrStopped) {
+			return
+		}
+		if errors.Is(err, ErrAlreadyRunning) {
+			log.Printf("skipping job %v; the previous run has not finished\n", job.Name)
+		}
+
+		wait = jitter(job.Interval)
+	}
+}
+
+// Runs a job now, unless it is already running.
+// The result is logged as well as returned.
+func (r *Runner) Run(name string) (int, error) {
+	job, ok := r.find(name)
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrUnknownJob, name)
+	}
+
+	r.mu.Lock()
+	if r.stopping.Err() != nil {
+		r.mu.Unlock()
+		return 0, ErrStopped
+	}
+	if r.running[name] {
+		r.mu.Unlock()
+		return 0, ErrAlreadyRunning
+	}
+	r.running[name] = true
+	r.wg.Add(1)
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.running, name)
+		r.mu.Unlock()
+		r.wg.Done()
+	}()
+
+	start := time.Now()
+	count, err := job.Run(r.runs, r.now())
+	if err != nil {
+		log.Printf("job %v failed after %v; %v\n", name, time.Since(start), err)
+		return count, err
+	}
+
+	log.Printf("job %v: %d %v in %v\n", name, count, job.Reports, time.Since(start))
+	return count, nil
+}
+
+// Stops scheduling jobs and waits for running ones to finish.
+// Once ctx is done the running jobs are cancelled and ctx.Err() is returned.
+func (r *Runner) Stop(ctx context.Context) error {
+	// under the lock so that no run starts once waiting has begun
+	r.mu.Lock()
+	r.stop()
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		r.cancelRuns()
+		return ctx.Err()
+	}
+}
+
+func (r *Runner) find(name string) (Job, bool) {
+	for _, job := range r.jobs {
+		if job.Name == name {
+			return job, true
+		}
+	}
+	return Job{}, false
+}
+
+// Returns the interval shifted by a random amount of up to JITTER of it
+func jitter(interval time.Duration) time.Duration {
+	shift := time.Duration(float64(interval) * JITTER * (2*rand.Float64() - 1))
+	return interval + shift
+}
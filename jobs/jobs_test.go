@@ -0,0 +1,236 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// blockingJob runs until it is released, and reports when it has started
+type blockingJob struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingJob() *blockingJob {
+	return &blockingJob{started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (j *blockingJob) job(name string) Job {
+	return Job{
+		Name:     name,
+		Interval: time.Hour,
+		Run: func(ctx context.Context, now time.Time) (int, error) {
+			j.started <- struct{}{}
+			select {
+			case <-j.release:
+				return 1, nil
+			case <-ctx.Done():
+				return 0, ctx.Err()
+			}
+		},
+	}
+}
+
+func newRunner(t *testing.T, jobs ...Job) *Runner {
+	t.Helper()
+
+	runner, err := NewRunner(jobs, func() time.Time { return testNow })
+	if err != nil {
+		t.Fatalf("NewRunner() error = %v", err)
+	}
+	return runner
+}
+
+func TestNewRunner(t *testing.T) {
+	run := func(ctx context.Context, now time.Time) (int, error) { return 0, nil }
+
+	tests := []struct {
+		name  string
+		jobs  []Job
+		valid bool
+	}{
+		{"no jobs", nil, true},
+		{"job", []Job{{Name: "a", Interval: time.Hour, Run: run}}, true},
+		{"no name", []Job{{Interval: time.Hour, Run: run}}, false},
+		{"no interval", []Job{{Name: "a", Run: run}}, false},
+		{"no run", []Job{{Name: "a", Interval: time.Hour}}, false},
+		{"duplicate", []Job{{Name: "a", Interval: time.Hour, Run: run}, {Name: "a", Interval: time.Minute, Run: run}}, false},
+	}
+
+	for _, test := range tests {
+		_, err := NewRunner(test.jobs, nil)
+		if (err == nil) != test.valid {
+			t.Errorf("%v: NewRunner() error = %v", test.name, err)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	var ran time.Time
+	runner := newRunner(t,
+		Job{Name: "count", Interval: time.Hour, Run: func(ctx context.Context, now time.Time) (int, error) {
+			ran = now
+			return 3, nil
+		}},
+		Job{Name: "fail", Interval: time.Hour, Run: func(ctx context.Context, now time.Time) (int, error) {
+			return 1, errors.New("failed")
+		}},
+	)
+
+	count, err := runner.Run("count")
+	if err != nil || count != 3 || !ran.Equal(testNow) {
+		t.Errorf("Run() = %v, %v; ran at %v", count, err, ran)
+	}
+
+	if count, err := runner.Run("fail"); err == nil || count != 1 {
+		t.Errorf("Run() of a failing job = %v, %v", count, err)
+	}
+
+	if _, err := runner.Run("missing"); !errors.Is(err, ErrUnknownJob) {
+		t.Errorf("Run() of an unknown job error = %v, want %v", err, ErrUnknownJob)
+	}
+}
+
+// A job never runs twice at the same time, though other jobs still run
+func TestRunSingleFlight(t *testing.T) {
+	slow := newBlockingJob()
+	runner := newRunner(t, slow.job("slow"), Job{
+		Name:     "other",
+		Interval: time.Hour,
+		Run:      func(ctx context.Context, now time.Time) (int, error) { return 1, nil },
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := runner.Run("slow"); err != nil {
+			t.Errorf("first Run() error = %v", err)
+		}
+	}()
+	<-slow.started
+
+	for i := 0; i < 3; i++ {
+		if _, err := runner.Run("slow"); !errors.Is(err, ErrAlreadyRunning) {
+			t.Errorf("Run() while running error = %v, want %v", err, ErrAlreadyRunning)
+		}
+	}
+	if _, err := runner.Run("other"); err != nil {
+		t.Errorf("Run() of another job error = %v", err)
+	}
+
+	slow.release <- struct{}{}
+	wg.Wait()
+
+	// finished jobs can run again
+	go func() { slow.release <- struct{}{} }()
+	if count, err := runner.Run("slow"); err != nil || count != 1 {
+		t.Errorf("Run() after the first run finished = %v, %v", count, err)
+	}
+}
+
+func TestStopWaitsForRunningJobs(t *testing.T) {
+	slow := newBlockingJob()
+	runner := newRunner(t, slow.job("slow"))
+
+	done := make(chan error)
+	go func() {
+		_, err := runner.Run("slow")
+		done <- err
+	}()
+	<-slow.started
+
+	stopped := make(chan error)
+	go func() {
+		stopped <- runner.Stop(context.Background())
+	}()
+
+	select {
+	case err := <-stopped:
+		t.Fatalf("Stop() = %v before the running job finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(slow.release)
+	if err := <-done; err != nil {
+		t.Errorf("Run() error = %v", err)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("Stop() error = %v", err)
+	}
+
+	if _, err := runner.Run("slow"); !errors.Is(err, ErrStopped) {
+		t.Errorf("Run() after Stop() error = %v, want %v", err, ErrStopped)
+	}
+}
+
+// Jobs still running when Stop gives up are cancelled
+func TestStopCancelsRunningJobs(t *testing.T) {
+	slow := newBlockingJob()
+	runner := newRunner(t, slow.job("slow"))
+
+	done := make(chan error)
+	go func() {
+		_, err := runner.Run("slow")
+		done <- err
+	}()
+	<-slow.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := runner.Stop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Stop() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() of a cancelled job error = %v, want %v", err, context.Canceled)
+	}
+}
+
+// Started runners run their jobs within MAX_FIRST_RUN_DELAY, and stop
+// scheduling them once stopped
+func TestStartAndStop(t *testing.T) {
+	ran := make(chan struct{}, 10)
+	runner := newRunner(t, Job{
+		Name:     "fast",
+		Interval: 10 * time.Millisecond,
+		Run: func(ctx context.Context, now time.Time) (int, error) {
+			ran <- struct{}{}
+			return 1, nil
+		},
+	})
+
+	runner.Start()
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Fatal("job did not run after Start()")
+	}
+
+	if err := runner.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+
+	// drain runs that finished before Stop
+	for len(ran) > 0 {
+		<-ran
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(ran) != 0 {
+		t.Errorf("job ran %d times after Stop()", len(ran))
+	}
+}
+
+func TestJitter(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		wait := jitter(time.Hour)
+		if wait < 54*time.Minute || wait > 66*time.Minute {
+			t.Fatalf("jitter(1h) = %v, want within %v of it", wait, JITTER)
+		}
+	}
+}
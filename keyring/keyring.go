@@ -0,0 +1,244 @@
+// Package keyring holds the secret keys of TapGoPay, grouped by the purpose
+// they are used for and numbered by version.
+//
+// The newest (highest) version of a purpose is its primary key and is the
+// only one used to sign or encrypt. Older versions stay in the keyring for
+// verifying and decrypting what they produced, so a key is rotated by adding
+// a new version and removing the old one once nothing depends on it.
+//
+// Version 0 is reserved for keys carried over from single-key settings such
+// as SECRET_KEY, and is exempt from the minimum key length.
+package keyring
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type Purpose string
+
+const (
+	JWT             Purpose = "jwt"
+	PASSWORD_PEPPER Purpose = "password_pepper"
+	DATA_ENCRYPTION Purpose = "data_encryption"
+)
+
+var Purposes = []Purpose{JWT, PASSWORD_PEPPER, DATA_ENCRYPTION}
+
+const (
+	MIN_KEY_LEN int = 32
+)
+
+var (
+	ErrKeyNotFound   error = errors.New("key not found in keyring")
+	ErrInvalidKeyId  error = errors.New("invalid key id")
+	ErrInvalidSecret error = errors.New("key secret must be hex or base64 encoded")
+)
+
+type Key struct {
+	Purpose Purpose
+	Version int
+	Secret  []byte
+
+	// Algorithm the key is used with e.g. EdDSA for a jwt key.
+	// Empty for the default algorithm of its purpose.
+	Algorithm string
+}
+
+// Id names the key in tokens and stored values e.g. jwt-2
+func (k Key) Id() string {
+	return fmt.Sprintf("%s-%d", k.Purpose, k.Version)
+}
+
+// Splits a key id made by Key.Id into its purpose and version
+func ParseKeyId(kid string) (Purpose, int, error) {
+	i := strings.LastIndex(kid, "-")
+	if i < 0 {
+		return "", 0, fmt.Errorf("%w %q", ErrInvalidKeyId, kid)
+	}
+
+	version, err := strconv.Atoi(kid[i+1:])
+	if err != nil || version < 0 {
+		return "", 0, fmt.Errorf("%w %q", ErrInvalidKeyId, kid)
+	}
+	return Purpose(kid[:i]), version, nil
+}
+
+type Keyring struct {
+	keys map[Purpose][]Key // newest version first
+}
+
+func New() *Keyring {
+	return &Keyring{keys: map[Purpose][]Key{}}
+}
+
+func (kr *Keyring) Add(key Key) error {
+	if !slices.Contains(Purposes, key.Purpose) {
+		return fmt.Errorf("unknown key purpose %q", key.Purpose)
+	}
+	if key.Version < 0 {
+		return fmt.Errorf("invalid version %d for %v key", key.Version, key.Purpose)
+	}
+	if key.Version > 0 && len(key.Secret) < MIN_KEY_LEN {
+		return fmt.Errorf("key %v must be at least %d bytes long", key.Id(), MIN_KEY_LEN)
+	}
+
+	_, err := kr.Get(key.Purpose, key.Version)
+	if err == nil {
+		return fmt.Errorf("duplicate key %v", key.Id())
+	}
+
+	keys := append(kr.keys[key.Purpose], key)
+	slices.SortFunc(keys, func(a, b Key) int {
+		return b.Version - a.Version
+	})
+	kr.keys[key.Purpose] = keys
+	return nil
+}
+
+// Returns the key new values of the purpose are signed or encrypted with
+func (kr *Keyring) Primary(purpose Purpose) (Key, error) {
+	keys := kr.keys[purpose]
+	if len(keys) == 0 {
+		return Key{}, fmt.Errorf("%w; no %v key", ErrKeyNotFound, purpose)
+	}
+	return keys[0], nil
+}
+
+func (kr *Keyring) Get(purpose Purpose, version int) (Key, error) {
+	for _, key := range kr.keys[purpose] {
+		if key.Version == version {
+			return key, nil
+		}
+	}
+	return Key{}, fmt.Errorf("%w; no %v key version %d", ErrKeyNotFound, purpose, version)
+}
+
+// Returns every key of the purpose, newest version first
+func (kr *Keyring) Keys(purpose Purpose) []Key {
+	return slices.Clone(kr.keys[purpose])
+}
+
+// Decodes a key secret given as hex or standard base64
+func DecodeSecret(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+
+	secret, err := hex.DecodeString(encoded)
+	if err != nil {
+		secret, err = base64.StdEncoding.DecodeString(encoded)
+	}
+	if err != nil || len(secret) == 0 {
+		return nil, ErrInvalidSecret
+	}
+	return secret, nil
+}
+
+type fileKey struct {
+	Version   int    `json:"version"`
+	Secret    string `json:"secret"`
+	Algorithm string `json:"algorithm"`
+}
+
+// Loads keys from a JSON file of the form
+//
+//	{
+//	    "jwt": [{ "version": 2, "algorithm": "EdDSA", "secret": "<base64>" }, { "version": 1, "secret": "<base64>" }],
+//	    "data_encryption": [{ "version": 1, "secret": "<hex>" }]
+//	}
+func LoadFile(kr *Keyring, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading keyring file; %v", err)
+	}
+
+	file := map[Purpose][]fileKey{}
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return fmt.Errorf("error parsing keyring file; %v", err)
+	}
+
+	for purpose, keys := range file {
+		for _, key := range keys {
+			secret, err := DecodeSecret(key.Secret)
+			if err != nil {
+				return fmt.Errorf("%v-%d; %v", purpose, key.Version, err)
+			}
+
+			err = kr.Add(Key{Purpose: purpose, Version: key.Version, Secret: secret, Algorithm: key.Algorithm})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Loads keys from the file named by KEYRING_FILE, if set, and from
+// KEYRING_JWT, KEYRING_PASSWORD_PEPPER and KEYRING_DATA_ENCRYPTION
+// which hold comma separated <version>:[<algorithm>:]<secret> keys e.g.
+//
+//	KEYRING_JWT=2:EdDSA:<base64 secret>,1:<base64 secret>
+func FromEnv() (*Keyring, error) {
+	kr := New()
+
+	path := os.Getenv("KEYRING_FILE")
+	if path != "" {
+		err := LoadFile(kr, path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, purpose := range Purposes {
+		name := "KEYRING_" + strings.ToUpper(string(purpose))
+		value := strings.TrimSpace(os.Getenv(name))
+		if value == "" {
+			continue
+		}
+
+		for _, pair := range strings.Split(value, ",") {
+			version, encoded, ok := strings.Cut(strings.TrimSpace(pair), ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid %v; keys must be given as <version>:[<algorithm>:]<secret>", name)
+			}
+
+			key := Key{Purpose: purpose}
+			key.Version, ok = parseVersion(version)
+			if !ok {
+				return nil, fmt.Errorf("invalid %v; invalid key version %q", name, version)
+			}
+
+			// neither hex nor base64 secrets contain a colon
+			algorithm, secretPart, hasAlgorithm := strings.Cut(encoded, ":")
+			if hasAlgorithm {
+				key.Algorithm = algorithm
+				encoded = secretPart
+			}
+
+			secret, err := DecodeSecret(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %v; %v", name, err)
+			}
+			key.Secret = secret
+
+			err = kr.Add(key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %v; %v", name, err)
+			}
+		}
+	}
+
+	return kr, nil
+}
+
+func parseVersion(s string) (int, bool) {
+	version, err := strconv.Atoi(strings.TrimSpace(s))
+	return version, err == nil && version >= 0
+}
@@ -0,0 +1,169 @@
+package keyring
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func secret(b byte) []byte {
+	return bytes.Repeat([]byte{b}, MIN_KEY_LEN)
+}
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   Key
+		valid bool
+	}{
+		{"jwt key", Key{Purpose: JWT, Version: 1, Secret: secret(1)}, true},
+		{"short legacy key", Key{Purpose: JWT, Version: 0, Secret: []byte("secret")}, true},
+		{"duplicate version", Key{Purpose: JWT, Version: 1, Secret: secret(2)}, false},
+		{"same version of another purpose", Key{Purpose: DATA_ENCRYPTION, Version: 1, Secret: secret(3)}, true},
+		{"short key", Key{Purpose: JWT, Version: 2, Secret: secret(1)[:MIN_KEY_LEN-1]}, false},
+		{"negative version", Key{Purpose: JWT, Version: -1, Secret: secret(1)}, false},
+		{"unknown purpose", Key{Purpose: "signing", Version: 1, Secret: secret(1)}, false},
+	}
+
+	kr := New()
+	for _, test := range tests {
+		err := kr.Add(test.key)
+		if (err == nil) != test.valid {
+			t.Errorf("%v: Add() error = %v", test.name, err)
+		}
+	}
+}
+
+func TestPrimaryAndGet(t *testing.T) {
+	kr := New()
+	for _, version := range []int{2, 0, 3, 1} {
+		err := kr.Add(Key{Purpose: JWT, Version: version, Secret: secret(byte(version))})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	primary, err := kr.Primary(JWT)
+	if err != nil || primary.Version != 3 {
+		t.Errorf("Primary() = %v, %v; want version 3", primary.Id(), err)
+	}
+
+	versions := []int{}
+	for _, key := range kr.Keys(JWT) {
+		versions = append(versions, key.Version)
+	}
+	if len(versions) != 4 || versions[0] != 3 || versions[3] != 0 {
+		t.Errorf("Keys() versions = %v, want newest first", versions)
+	}
+
+	key, err := kr.Get(JWT, 1)
+	if err != nil || !bytes.Equal(key.Secret, secret(1)) {
+		t.Errorf("Get(1) = %v, %v", key.Id(), err)
+	}
+
+	_, err = kr.Get(JWT, 4)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get(4) error = %v, want %v", err, ErrKeyNotFound)
+	}
+	_, err = kr.Primary(PASSWORD_PEPPER)
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Primary() of an empty purpose error = %v, want %v", err, ErrKeyNotFound)
+	}
+}
+
+func TestParseKeyId(t *testing.T) {
+	tests := []struct {
+		kid     string
+		purpose Purpose
+		version int
+		err     error
+	}{
+		{"jwt-2", JWT, 2, nil},
+		{"data_encryption-0", DATA_ENCRYPTION, 0, nil},
+		{"password_pepper-10", PASSWORD_PEPPER, 10, nil},
+		{"jwt", "", 0, ErrInvalidKeyId},
+		{"jwt-", "", 0, ErrInvalidKeyId},
+		{"jwt-x", "", 0, ErrInvalidKeyId},
+		{"jwt-1.5", "", 0, ErrInvalidKeyId},
+	}
+
+	for _, test := range tests {
+		purpose, version, err := ParseKeyId(test.kid)
+		if !errors.Is(err, test.err) || purpose != test.purpose || version != test.version {
+			t.Errorf("ParseKeyId(%q) = %v, %v, %v", test.kid, purpose, version, err)
+		}
+	}
+
+	key := Key{Purpose: DATA_ENCRYPTION, Version: 7}
+	purpose, version, err := ParseKeyId(key.Id())
+	if err != nil || purpose != key.Purpose || version != key.Version {
+		t.Errorf("ParseKeyId(%q) = %v, %v, %v", key.Id(), purpose, version, err)
+	}
+}
+
+func TestDecodeSecret(t *testing.T) {
+	raw := secret(0xab)
+
+	tests := []struct {
+		name    string
+		encoded string
+		err     error
+	}{
+		{"hex", hex.EncodeToString(raw), nil},
+		{"base64", base64.StdEncoding.EncodeToString(raw), nil},
+		{"surrounding spaces", " " + hex.EncodeToString(raw) + "\n", nil},
+		{"empty", "", ErrInvalidSecret},
+		{"neither", "not a secret!", ErrInvalidSecret},
+	}
+
+	for _, test := range tests {
+		decoded, err := DecodeSecret(test.encoded)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%v: DecodeSecret() error = %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err == nil && !bytes.Equal(decoded, raw) {
+			t.Errorf("%v: DecodeSecret() = %x", test.name, decoded)
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keyring.json")
+	err := os.WriteFile(file, []byte(`{"data_encryption": [{"version": 1, "secret": "`+hex.EncodeToString(secret(1))+`"}]}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("KEYRING_FILE", file)
+	t.Setenv("KEYRING_JWT", "2:EdDSA:"+base64.StdEncoding.EncodeToString(secret(2))+", 1:"+hex.EncodeToString(secret(1)))
+	t.Setenv("KEYRING_PASSWORD_PEPPER", "")
+	t.Setenv("KEYRING_DATA_ENCRYPTION", "2:"+hex.EncodeToString(secret(2)))
+
+	kr, err := FromEnv()
+	if err != nil {
+		t.Fatalf("FromEnv() error = %v", err)
+	}
+
+	primary, _ := kr.Primary(JWT)
+	if primary.Version != 2 || primary.Algorithm != "EdDSA" || !bytes.Equal(primary.Secret, secret(2)) {
+		t.Errorf("jwt primary = %+v", primary)
+	}
+	if len(kr.Keys(DATA_ENCRYPTION)) != 2 || len(kr.Keys(PASSWORD_PEPPER)) != 0 {
+		t.Errorf("keys = %v data encryption, %v password pepper", len(kr.Keys(DATA_ENCRYPTION)), len(kr.Keys(PASSWORD_PEPPER)))
+	}
+
+	for _, value := range []string{"1", "x:" + hex.EncodeToString(secret(1)), "1:zz", "1:" + hex.EncodeToString(secret(1)[:8])} {
+		t.Setenv("KEYRING_FILE", "")
+		t.Setenv("KEYRING_JWT", value)
+
+		_, err := FromEnv()
+		if err == nil {
+			t.Errorf("FromEnv() with KEYRING_JWT=%q succeeded", value)
+		}
+	}
+}
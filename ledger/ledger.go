@@ -0,0 +1,133 @@
+// Package ledger describes the double-entry bookkeeping model behind every
+// money movement in TapGoPay.
+//
+// Each movement is recorded as a journal entry made up of two or more postings.
+// A posting adds a signed amount to a single account; positive amounts credit
+// the account (money in) while negative amounts debit it (money out).
+// The postings of a journal entry must always sum to zero, which means the sum
+// of all account balances (the trial balance) is zero as well.
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/caleb-mwasikira/tap_gopay/money"
+)
+
+type AccountKind string
+
+const (
+	KindCard   AccountKind = "card"
+	KindSystem AccountKind = "system"
+)
+
+// System accounts are owned by TapGoPay itself and act as the counterparty
+// for money entering or leaving the customer accounts.
+const (
+	DepositsAccount string = "system:deposits" // source of all money deposited onto cards
+	FeesAccount     string = "system:fees"     // destination of transaction fees charged
+	SuspenseAccount string = "system:suspense" // holds money that cannot yet be reconciled
+)
+
+var (
+	SystemAccounts = []string{DepositsAccount, FeesAccount, SuspenseAccount}
+
+	ErrTooFewPostings    error = errors.New("journal entry must have at least two postings")
+	ErrZeroPosting       error = errors.New("journal entry postings cannot have a zero amount")
+	ErrUnbalancedEntry   error = errors.New("journal entry postings must sum to zero")
+	ErrMissingAccount    error = errors.New("journal entry posting is missing an account")
+	ErrDuplicatePostings error = errors.New("journal entry has more than one posting to the same account")
+)
+
+type Account struct {
+	Id        int64       `json:"id"`
+	Code      string      `json:"code"`
+	Kind      AccountKind `json:"kind"`
+	CardNo    string      `json:"card_no,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+type Posting struct {
+	AccountCode string      `json:"account"`
+	Amount      money.Money `json:"amount"`
+}
+
+type JournalEntry struct {
+	Id          int64     `json:"id"`
+	Reference   string    `json:"reference"`
+	Description string    `json:"description"`
+	Postings    []Posting `json:"postings"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type AccountBalance struct {
+	AccountCode string      `json:"account"`
+	Kind        AccountKind `json:"kind"`
+	Balance     money.Money `json:"balance"`
+}
+
+type TrialBalance struct {
+	Accounts []AccountBalance `json:"accounts"`
+	Total    money.Money      `json:"total"`
+}
+
+func CardAccount(cardNo string) string {
+	return fmt.Sprintf("%s:%s", KindCard, cardNo)
+}
+
+func IsSystemAccount(code string) bool {
+	return strings.HasPrefix(code, string(KindSystem)+":")
+}
+
+// Builds a journal entry moving amount from one account to another
+func NewTransfer(reference, description, from, to string, amount money.Money) JournalEntry {
+	return JournalEntry{
+		Reference:   reference,
+		Description: description,
+		Postings: []Posting{
+			{AccountCode: from, Amount: amount.Neg()},
+			{AccountCode: to, Amount: amount},
+		},
+	}
+}
+
+func (entry JournalEntry) Validate() error {
+	if len(entry.Postings) < 2 {
+		return ErrTooFewPostings
+	}
+
+	sum := money.Zero(entry.Postings[0].Amount.Currency)
+	seen := map[string]bool{}
+
+	for _, posting := range entry.Postings {
+		if strings.TrimSpace(posting.AccountCode) == "" {
+			return ErrMissingAccount
+		}
+		if seen[posting.AccountCode] {
+			return ErrDuplicatePostings
+		}
+		seen[posting.AccountCode] = true
+
+		if posting.Amount.IsZero() {
+			return ErrZeroPosting
+		}
+
+		var err error
+		sum, err = sum.Add(posting.Amount)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !sum.IsZero() {
+		return ErrUnbalancedEntry
+	}
+	return nil
+}
+
+func (tb TrialBalance) IsBalanced() bool {
+	return tb.Total.IsZero()
+}
@@ -0,0 +1,138 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/caleb-mwasikira/tap_gopay/money"
+)
+
+func TestValidate(t *testing.T) {
+	card := CardAccount("4000000000000002")
+	other := CardAccount("4000000000000010")
+
+	tests := []struct {
+		name     string
+		postings []Posting
+		err      error
+	}{
+		{
+			name: "transfer",
+			postings: []Posting{
+				{card, money.KES(-500)},
+				{other, money.KES(500)},
+			},
+		},
+		{
+			name: "transfer with fee",
+			postings: []Posting{
+				{card, money.KES(-530)},
+				{other, money.KES(500)},
+				{FeesAccount, money.KES(30)},
+			},
+		},
+		{
+			name:     "no postings",
+			postings: nil,
+			err:      ErrTooFewPostings,
+		},
+		{
+			name:     "single posting",
+			postings: []Posting{{card, money.KES(500)}},
+			err:      ErrTooFewPostings,
+		},
+		{
+			name: "unbalanced",
+			postings: []Posting{
+				{card, money.KES(-500)},
+				{other, money.KES(400)},
+			},
+			err: ErrUnbalancedEntry,
+		},
+		{
+			name: "zero amount",
+			postings: []Posting{
+				{card, money.KES(0)},
+				{other, money.KES(0)},
+			},
+			err: ErrZeroPosting,
+		},
+		{
+			name: "missing account",
+			postings: []Posting{
+				{" ", money.KES(-500)},
+				{other, money.KES(500)},
+			},
+			err: ErrMissingAccount,
+		},
+		{
+			name: "same account twice",
+			postings: []Posting{
+				{card, money.KES(-500)},
+				{card, money.KES(500)},
+			},
+			err: ErrDuplicatePostings,
+		},
+		{
+			name: "mixed currencies",
+			postings: []Posting{
+				{card, money.KES(-500)},
+				{other, money.New(500, "USD")},
+			},
+			err: money.ErrCurrencyMismatch,
+		},
+		{
+			name: "overflow",
+			postings: []Posting{
+				{card, money.KES(1 << 62)},
+				{other, money.KES(1 << 62)},
+				{DepositsAccount, money.KES(-1)},
+			},
+			err: money.ErrOverflow,
+		},
+	}
+
+	for _, test := range tests {
+		entry := JournalEntry{Reference: test.name, Postings: test.postings}
+		err := entry.Validate()
+		if !errors.Is(err, test.err) {
+			t.Errorf("%v: Validate() = %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestNewTransfer(t *testing.T) {
+	from, to := CardAccount("4000000000000002"), CardAccount("4000000000000010")
+	entry := NewTransfer("ref", "description", from, to, money.KES(1250))
+
+	if err := entry.Validate(); err != nil {
+		t.Fatalf("Validate() = %v", err)
+	}
+
+	want := []Posting{{from, money.KES(-1250)}, {to, money.KES(1250)}}
+	for i, posting := range entry.Postings {
+		if posting != want[i] {
+			t.Errorf("posting %d = %#v, want %#v", i, posting, want[i])
+		}
+	}
+}
+
+func TestIsSystemAccount(t *testing.T) {
+	tests := []struct {
+		code   string
+		system bool
+	}{
+		{DepositsAccount, true},
+		{FeesAccount, true},
+		{SuspenseAccount, true},
+		{CardAccount("4000000000000002"), false},
+		{"system", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if got := IsSystemAccount(test.code); got != test.system {
+			t.Errorf("IsSystemAccount(%q) = %v, want %v", test.code, got, test.system)
+		}
+	}
+}
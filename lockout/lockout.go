@@ -0,0 +1,176 @@
+// Package lockout slows down and then stops repeated guessing of passwords
+// and one-time codes.
+//
+// Failed attempts are counted per key, such as an account or an IP address.
+// After a few free attempts every further failure doubles the time the key
+// has to wait before its next attempt, and too many failures lock the key
+// for a while:
+//
+//	limiter := lockout.New(store, lockout.Policy{
+//		FreeAttempts:    3,
+//		BaseDelay:       time.Second,
+//		MaxDelay:        time.Minute,
+//		LockoutAfter:    10,
+//		LockoutDuration: 15 * time.Minute,
+//	})
+//
+//	failures, err := limiter.Reserve("login:alice@example.com", now) // *lockout.Error while waiting
+//	...
+//	if passwordMatch {
+//		limiter.Reset("login:alice@example.com")
+//	} else if limiter.Locks(failures) {
+//		// tell the account holder
+//	}
+//
+// An attempt counts as failed from the moment it is reserved, so that
+// concurrent attempts cannot all get past the check before any of them fails.
+// Attempts that turn out not to have failed are given back with Release.
+//
+// Counters are kept in a Store; MemoryStore keeps them in process, and a
+// Store backed by a database shares them between server instances.
+package lockout
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// Longest wait between attempts when a Policy sets no MaxDelay
+	MAX_DELAY time.Duration = time.Hour
+)
+
+// Attempts are the recent failed attempts of a key
+type Attempts struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// Store keeps failure counters.
+type Store interface {
+	// Counts a failure of the key at now, unless allow rejects the attempts
+	// the key has so far. Failures older than window are forgotten first.
+	// Returns the attempts of the key and whether the failure was counted.
+	// Implementations must check and count as one atomic step.
+	ReserveAttempt(key string, now time.Time, window time.Duration, allow func(Attempts) bool) (Attempts, bool, error)
+
+	// Takes back one failure counted by ReserveAttempt
+	ReleaseAttempt(key string) error
+
+	ResetAttempts(key string) error
+}
+
+// Policy sets how quickly failures slow a key down
+type Policy struct {
+	// Failures allowed before any waiting
+	FreeAttempts int
+
+	// Wait after the first failure past FreeAttempts; doubled with every further failure
+	BaseDelay time.Duration
+	MaxDelay  time.Duration // MAX_DELAY when left empty
+
+	// Failures that lock the key, and for how long.
+	// Failures are forgotten LockoutDuration after the last one.
+	LockoutAfter    int
+	LockoutDuration time.Duration
+}
+
+// Error is returned by Reserve while a key has to wait
+type Error struct {
+	Locked     bool          // locked rather than backing off
+	RetryAfter time.Duration // rounded up to a whole second
+}
+
+func (e *Error) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("locked out after too many failed attempts; retry after %v", e.RetryAfter)
+	}
+	return fmt.Sprintf("too many failed attempts; retry after %v", e.RetryAfter)
+}
+
+type Limiter struct {
+	store  Store
+	policy Policy
+}
+
+func New(store Store, policy Policy) *Limiter {
+	return &Limiter{store: store, policy: policy}
+}
+
+// Reserves an attempt of the key, which counts as failed until it is
+// released or the key reset. Returns the recent failures of the key, this
+// attempt included, or an *Error, without reserving, while the key has to wait.
+func (l *Limiter) Reserve(key string, now time.Time) (int, error) {
+	allow := func(attempts Attempts) bool {
+		_, retryAfter := l.retryAfter(attempts, now)
+		return retryAfter == 0
+	}
+
+	attempts, reserved, err := l.store.ReserveAttempt(key, now, l.policy.LockoutDuration, allow)
+	if err != nil {
+		return 0, fmt.Errorf("error reserving attempt; %v", err)
+	}
+
+	if !reserved {
+		locked, retryAfter := l.retryAfter(attempts, now)
+		return attempts.Failures, &Error{
+			Locked:     locked,
+			RetryAfter: ceilSeconds(retryAfter),
+		}
+	}
+	return attempts.Failures, nil
+}
+
+// Takes back an attempt reserved with Reserve that did not fail after all
+func (l *Limiter) Release(key string) error {
+	return l.store.ReleaseAttempt(key)
+}
+
+// Reports whether the failed attempt that brought the key to failures locked it
+func (l *Limiter) Locks(failures int) bool {
+	return l.policy.LockoutAfter > 0 && failures == l.policy.LockoutAfter
+}
+
+// Forgets the failed attempts of the key, e.g. after a successful login
+func (l *Limiter) Reset(key string) error {
+	return l.store.ResetAttempts(key)
+}
+
+// Returns how long from now the key has to wait before its next attempt,
+// zero if it does not, and whether it is locked rather than backing off
+func (l *Limiter) retryAfter(attempts Attempts, now time.Time) (bool, time.Duration) {
+	locked, wait := l.wait(attempts)
+	retryAt := attempts.LastFailure.Add(wait)
+	if wait == 0 || !now.Before(retryAt) {
+		return false, 0
+	}
+	return locked, retryAt.Sub(now)
+}
+
+// Returns how long after its last failure the key has to wait
+func (l *Limiter) wait(attempts Attempts) (bool, time.Duration) {
+	if l.policy.LockoutAfter > 0 && attempts.Failures >= l.policy.LockoutAfter {
+		return true, l.policy.LockoutDuration
+	}
+
+	extra := attempts.Failures - l.policy.FreeAttempts
+	if extra <= 0 || l.policy.BaseDelay <= 0 {
+		return false, 0
+	}
+
+	maxDelay := l.policy.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = MAX_DELAY
+	}
+
+	delay := l.policy.BaseDelay
+	for i := 1; i < extra && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return false, min(delay, maxDelay)
+}
+
+// Retry-After headers are whole seconds; never tell clients to retry too early
+func ceilSeconds(d time.Duration) time.Duration {
+	return (d + time.Second - 1).Truncate(time.Second)
+}
@@ -0,0 +1,214 @@
+package lockout
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	FreeAttempts:    3,
+	BaseDelay:       time.Second,
+	MaxDelay:        8 * time.Second,
+	LockoutAfter:    10,
+	LockoutDuration: 15 * time.Minute,
+}
+
+func TestWait(t *testing.T) {
+	tests := []struct {
+		policy   Policy
+		failures int
+		locked   bool
+		wait     time.Duration
+	}{
+		{testPolicy, 0, false, 0},
+		{testPolicy, 3, false, 0},
+		{testPolicy, 4, false, time.Second},
+		{testPolicy, 5, false, 2 * time.Second},
+		{testPolicy, 6, false, 4 * time.Second},
+		{testPolicy, 7, false, 8 * time.Second},
+		{testPolicy, 9, false, 8 * time.Second},
+		{testPolicy, 10, true, 15 * time.Minute},
+		{testPolicy, 25, true, 15 * time.Minute},
+		{Policy{FreeAttempts: 0, BaseDelay: time.Minute}, 100, false, MAX_DELAY},
+		{Policy{FreeAttempts: 3}, 9, false, 0},
+		{Policy{LockoutAfter: 2, LockoutDuration: time.Minute}, 2, true, time.Minute},
+	}
+
+	for _, test := range tests {
+		l := New(NewMemoryStore(), test.policy)
+
+		locked, wait := l.wait(Attempts{Failures: test.failures})
+		if locked != test.locked || wait != test.wait {
+			t.Errorf("wait(%d) with %+v = %v, %v; want %v, %v", test.failures, test.policy, locked, wait, test.locked, test.wait)
+		}
+	}
+}
+
+// Attempts made as soon as they are allowed back off and then lock the key
+func TestReserve(t *testing.T) {
+	l := New(NewMemoryStore(), testPolicy)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		wait   time.Duration // before the attempt is allowed
+		locked bool
+	}{
+		{0, false}, {0, false}, {0, false}, {0, false},
+		{time.Second, false},
+		{2 * time.Second, false},
+		{4 * time.Second, false},
+		{8 * time.Second, false},
+		{8 * time.Second, false},
+		{8 * time.Second, false},
+		{15 * time.Minute, true},
+	}
+
+	for i, test := range tests {
+		if test.wait > 0 {
+			_, err := l.Reserve("key", now)
+
+			var lockoutErr *Error
+			if !errors.As(err, &lockoutErr) || lockoutErr.Locked != test.locked || lockoutErr.RetryAfter != test.wait {
+				t.Fatalf("attempt %d: Reserve() error = %v, want to wait %v", i+1, err, test.wait)
+			}
+
+			now = now.Add(test.wait - time.Millisecond)
+			if _, err := l.Reserve("key", now); err == nil {
+				t.Fatalf("attempt %d: Reserve() succeeded before the wait was over", i+1)
+			}
+			now = now.Add(time.Millisecond)
+		}
+
+		failures, err := l.Reserve("key", now)
+		if err != nil || failures != i+1 {
+			t.Fatalf("attempt %d: Reserve() = %v, %v", i+1, failures, err)
+		}
+		if l.Locks(failures) != (failures == testPolicy.LockoutAfter) {
+			t.Errorf("attempt %d: Locks(%d) = %v", i+1, failures, l.Locks(failures))
+		}
+	}
+
+	// other keys are not affected
+	if _, err := l.Reserve("other key", now); err != nil {
+		t.Errorf("Reserve() of another key error = %v", err)
+	}
+}
+
+func TestRetryAfterRoundsUp(t *testing.T) {
+	l := New(NewMemoryStore(), Policy{BaseDelay: 2 * time.Second, LockoutDuration: time.Hour})
+	now := time.Now()
+
+	l.Reserve("key", now)
+	_, err := l.Reserve("key", now.Add(300*time.Millisecond))
+
+	var lockoutErr *Error
+	if !errors.As(err, &lockoutErr) || lockoutErr.RetryAfter != 2*time.Second {
+		t.Errorf("Reserve() error = %v, want to retry after 2s", err)
+	}
+}
+
+func TestReleaseAndReset(t *testing.T) {
+	l := New(NewMemoryStore(), Policy{FreeAttempts: 2, BaseDelay: time.Minute, LockoutDuration: time.Hour})
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if _, err := l.Reserve("key", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := l.Reserve("key", now); err == nil {
+		t.Fatal("Reserve() past the free attempts succeeded")
+	}
+
+	// an attempt given back can be made again
+	if err := l.Release("key"); err != nil {
+		t.Fatal(err)
+	}
+	if failures, err := l.Reserve("key", now); err != nil || failures != 3 {
+		t.Errorf("Reserve() after Release() = %v, %v", failures, err)
+	}
+
+	if err := l.Reset("key"); err != nil {
+		t.Fatal(err)
+	}
+	if failures, err := l.Reserve("key", now); err != nil || failures != 1 {
+		t.Errorf("Reserve() after Reset() = %v, %v", failures, err)
+	}
+
+	// releasing more than was reserved is harmless
+	for i := 0; i < 3; i++ {
+		if err := l.Release("key"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if failures, err := l.Reserve("key", now); err != nil || failures != 1 {
+		t.Errorf("Reserve() after releasing every attempt = %v, %v", failures, err)
+	}
+}
+
+func TestFailuresExpire(t *testing.T) {
+	l := New(NewMemoryStore(), testPolicy)
+	now := time.Now()
+
+	for i := 0; i < testPolicy.LockoutAfter; i++ {
+		now = now.Add(time.Minute)
+		l.Reserve("key", now)
+	}
+	if _, err := l.Reserve("key", now.Add(testPolicy.LockoutDuration-time.Second)); err == nil {
+		t.Fatal("Reserve() of a locked key succeeded")
+	}
+
+	failures, err := l.Reserve("key", now.Add(testPolicy.LockoutDuration+time.Second))
+	if err != nil || failures != 1 {
+		t.Errorf("Reserve() after the lockout = %v, %v; want 1 failure", failures, err)
+	}
+}
+
+// Concurrent attempts cannot all get past the check before any of them fails
+func TestReserveConcurrently(t *testing.T) {
+	l := New(NewMemoryStore(), testPolicy)
+	now := time.Now()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		reserved int
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := l.Reserve("key", now)
+			if err == nil {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the free attempts, and the first one past them
+	if reserved != testPolicy.FreeAttempts+1 {
+		t.Errorf("%d concurrent attempts reserved, want %d", reserved, testPolicy.FreeAttempts+1)
+	}
+}
+
+func TestMemoryStoreForgetsStaleKeys(t *testing.T) {
+	s := NewMemoryStore()
+	allow := func(Attempts) bool { return true }
+	now := time.Now()
+
+	for i := 0; i < MAX_MEMORY_KEYS; i++ {
+		s.ReserveAttempt(strconv.Itoa(i), now, time.Minute, allow)
+	}
+	s.ReserveAttempt("fresh", now.Add(2*time.Minute), time.Minute, allow)
+
+	if len(s.attempts) != 1 {
+		t.Errorf("store holds %d keys, want only the fresh one", len(s.attempts))
+	}
+}
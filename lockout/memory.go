@@ -0,0 +1,80 @@
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// MemoryStore forgets stale counters once it holds this many
+	MAX_MEMORY_KEYS int = 10_000
+)
+
+// MemoryStore keeps failure counters in process.
+// Counters are lost on restart and not shared between server instances.
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempts
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		attempts: map[string]Attempts{},
+	}
+}
+
+func (s *MemoryStore) ReserveAttempt(key string, now time.Time, window time.Duration, allow func(Attempts) bool) (Attempts, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.attempts) >= MAX_MEMORY_KEYS {
+		s.forgetStale(now, window)
+	}
+
+	attempts := s.attempts[key]
+	if now.Sub(attempts.LastFailure) > window {
+		attempts = Attempts{}
+	}
+	if !allow(attempts) {
+		return attempts, false, nil
+	}
+
+	attempts.Failures++
+	attempts.LastFailure = now
+	s.attempts[key] = attempts
+	return attempts, true, nil
+}
+
+func (s *MemoryStore) ReleaseAttempt(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.attempts[key]
+	if !ok {
+		return nil
+	}
+
+	attempts.Failures--
+	if attempts.Failures <= 0 {
+		delete(s.attempts, key)
+		return nil
+	}
+	s.attempts[key] = attempts
+	return nil
+}
+
+func (s *MemoryStore) ResetAttempts(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+func (s *MemoryStore) forgetStale(now time.Time, window time.Duration) {
+	for key, attempts := range s.attempts {
+		if now.Sub(attempts.LastFailure) > window {
+			delete(s.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Package luhn implements the Luhn (mod 10) checksum used by card numbers.
+//
+// The last digit of a card number is a check digit chosen so that the
+// checksum of the whole number is a multiple of 10. This catches every
+// single-digit typo and most transpositions of adjacent digits.
+package luhn
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var (
+	ErrNotNumeric    error = errors.New("value must contain digits only")
+	ErrInvalidLength error = errors.New("invalid card number length")
+)
+
+// Reports whether number is made up of digits and passes the Luhn check
+func Valid(number string) bool {
+	if len(number) < 2 {
+		return false
+	}
+
+	sum, err := checksum(number, false)
+	return err == nil && sum%10 == 0
+}
+
+// Returns the check digit that makes payload followed by it a valid number
+func CheckDigit(payload string) (byte, error) {
+	if payload == "" {
+		return 0, ErrInvalidLength
+	}
+
+	// the check digit will take the rightmost position,
+	// so the rightmost digit of the payload is the first one doubled
+	sum, err := checksum(payload, true)
+	if err != nil {
+		return 0, err
+	}
+	return byte('0' + (10-sum%10)%10), nil
+}
+
+// Generates a random number of the given length that starts with prefix
+// and ends with a valid check digit
+func Generate(prefix string, length int) (string, error) {
+	if !IsNumeric(prefix) {
+		return "", fmt.Errorf("invalid prefix %q; %w", prefix, ErrNotNumeric)
+	}
+	if len(prefix) >= length-1 {
+		return "", fmt.Errorf("%w; prefix %q leaves no room for random digits in %d", ErrInvalidLength, prefix, length)
+	}
+
+	var builder strings.Builder
+	builder.WriteString(prefix)
+
+	for builder.Len() < length-1 {
+		digit, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", fmt.Errorf("error generating random digit; %v", err)
+		}
+		builder.WriteByte(byte('0' + digit.Int64()))
+	}
+
+	checkDigit, err := CheckDigit(builder.String())
+	if err != nil {
+		return "", err
+	}
+	builder.WriteByte(checkDigit)
+
+	return builder.String(), nil
+}
+
+func IsNumeric(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, char := range s {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Sums the digits from right to left, doubling every second digit.
+// double says whether the rightmost digit is doubled.
+func checksum(digits string, double bool) (int, error) {
+	sum := 0
+
+	for i := len(digits) - 1; i >= 0; i-- {
+		char := digits[i]
+		if char < '0' || char > '9' {
+			return 0, ErrNotNumeric
+		}
+
+		digit := int(char - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+
+		sum += digit
+		double = !double
+	}
+
+	return sum, nil
+}
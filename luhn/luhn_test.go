@@ -0,0 +1,97 @@
+package luhn
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		number string
+		valid  bool
+	}{
+		{"79927398713", true},
+		{"4111111111111111", true},
+		{"4000000000000002", true},
+		{"378282246310005", true},
+		{"5555555555554444", true},
+		{"00", true},
+		{"18", true},
+
+		{"79927398710", false},
+		{"4111111111111112", false},
+		{"4111111111111121", false}, // transposed digits
+		{"4111 1111 1111 1111", false},
+		{"4111-1111-1111-1111", false},
+		{"0", false},
+		{"", false},
+		{"abcdefgh", false},
+	}
+
+	for _, test := range tests {
+		if got := Valid(test.number); got != test.valid {
+			t.Errorf("Valid(%q) = %v, want %v", test.number, got, test.valid)
+		}
+	}
+}
+
+func TestCheckDigit(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    byte
+		err     error
+	}{
+		{"7992739871", '3', nil},
+		{"411111111111111", '1', nil},
+		{"400000000000000", '2', nil},
+		{"1", '8', nil},
+		{"0", '0', nil},
+		{"", 0, ErrInvalidLength},
+		{"12a4", 0, ErrNotNumeric},
+	}
+
+	for _, test := range tests {
+		got, err := CheckDigit(test.payload)
+		if !errors.Is(err, test.err) {
+			t.Errorf("CheckDigit(%q) error = %v, want %v", test.payload, err, test.err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("CheckDigit(%q) = %q, want %q", test.payload, got, test.want)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		prefix string
+		length int
+		err    error
+	}{
+		{"4", 16, nil},
+		{"400000", 16, nil},
+		{"37", 15, nil},
+		{"", 16, ErrNotNumeric},
+		{"4a", 16, ErrNotNumeric},
+		{"40000000000000", 16, nil},
+		{"400000000000000", 16, ErrInvalidLength},
+		{"4000000000000000", 16, ErrInvalidLength},
+	}
+
+	for _, test := range tests {
+		for i := 0; i < 20; i++ {
+			number, err := Generate(test.prefix, test.length)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Generate(%q, %d) error = %v, want %v", test.prefix, test.length, err, test.err)
+			}
+			if err != nil {
+				break
+			}
+
+			if len(number) != test.length || !strings.HasPrefix(number, test.prefix) || !Valid(number) {
+				t.Fatalf("Generate(%q, %d) = %q", test.prefix, test.length, number)
+			}
+		}
+	}
+}
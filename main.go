@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	h "github.com/caleb-mwasikira/tap_gopay/handlers"
+	db "github.com/caleb-mwasikira/tap_gopay/database"
+	"github.com/caleb-mwasikira/tap_gopay/handlers"
+	"github.com/caleb-mwasikira/tap_gopay/lockout"
 	"github.com/caleb-mwasikira/tap_gopay/utils"
 )
 
+const (
+	// How long requests and background jobs get to finish on shutdown
+	SHUTDOWN_TIMEOUT time.Duration = 30 * time.Second
+)
+
 // responseWriter is a wrapper to capture the response status
 type responseWriter struct {
 	http.ResponseWriter
@@ -40,41 +51,211 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 }
 
 func main() {
+	cfg := db.ConfigFromEnv()
+
+	if len(os.Args) > 1 {
+		runCommand(cfg, os.Args[1:])
+		return
+	}
+
+	store := openStore(cfg)
+	defer store.Close()
+
+	log.Println("connected to database successfuly")
+
+	handlersCfg, err := handlers.ConfigFromEnv()
+	if err != nil {
+		log.Fatalf("error configuring handlers; %v\n", err)
+	}
+
+	stores := handlers.StoresFrom(store)
+
+	// failed login and code attempts are counted in the database, so every
+	// server instance sees them, unless LOCKOUT_STORE=memory
+	switch os.Getenv("LOCKOUT_STORE") {
+	case "", "database":
+	case "memory":
+		stores.Attempts = lockout.NewMemoryStore()
+	default:
+		log.Fatalf("invalid LOCKOUT_STORE %q; must be database or memory\n", os.Getenv("LOCKOUT_STORE"))
+	}
+
+	h, err := handlers.NewHandler(stores, handlersCfg)
+	if err != nil {
+		log.Fatalf("error configuring handlers; %v\n", err)
+	}
+
 	mux := http.NewServeMux()
 
+	// permission matrix of the routes behind AuthMiddleware; see Roles in the docs
+	anyRole := h.RequireRole(handlers.ANY_ROLE...)
+	staff := h.RequireRole(handlers.STAFF_ROLES...)
+	admins := h.RequireRole(handlers.ADMIN_ROLES...)
+
 	mux.HandleFunc("POST /signup", h.HandleSignUp)
 	mux.HandleFunc("POST /login", h.HandleLogin)
 	mux.HandleFunc("POST /send-verification-email", h.SendVerificationEmail)
 	mux.HandleFunc("POST /verify-email", h.VerifyEmail)
 	mux.HandleFunc("POST /request-password-reset", h.RequestPasswordReset)
 	mux.HandleFunc("POST /reset-password", h.ResetPassword)
+	mux.HandleFunc("POST /login-totp", h.LoginTotp)
+	mux.HandleFunc("POST /refresh", h.RefreshToken)
+	mux.HandleFunc("GET /.well-known/jwks.json", h.JWKS)
+
+	mux.Handle("POST /logout", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.Logout),
+	)))
+	mux.Handle("POST /logout-all", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.LogoutAll),
+	)))
+	mux.Handle("GET /sessions", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.MySessions),
+	)))
+	mux.Handle("POST /revoke-session", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.RevokeSession),
+	)))
+
+	mux.Handle("GET /my-profile", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.MyProfile),
+	)))
+	mux.Handle("POST /update-profile", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.UpdateProfile),
+	)))
+	mux.Handle("POST /confirm-email-change", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.ConfirmEmailChange),
+	)))
+	mux.Handle("POST /confirm-phone-change", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.ConfirmPhoneChange),
+	)))
+
+	mux.Handle("POST /enroll-totp", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.EnrollTotp),
+	)))
+	mux.Handle("POST /confirm-totp", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.ConfirmTotp),
+	)))
+	mux.Handle("POST /disable-totp", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.DisableTotp),
+	)))
+	mux.Handle("POST /regenerate-recovery-codes", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.RegenerateRecoveryCodes),
+	)))
 
-	mux.Handle("POST /new-credit-card", h.AuthMiddleware(
-		http.HandlerFunc(h.NewCreditCard),
-	))
-	mux.Handle("GET /my-credit-cards", h.AuthMiddleware(
+	mux.Handle("POST /new-credit-card", h.AuthMiddleware(staff(
+		h.IdempotencyMiddleware(http.HandlerFunc(h.NewCreditCard)),
+	)))
+	mux.Handle("GET /my-credit-cards", h.AuthMiddleware(anyRole(
 		http.HandlerFunc(h.MyCreditCards),
-	))
-	mux.Handle("POST /search-credit-cards", h.AuthMiddleware(
+	)))
+	mux.Handle("POST /search-credit-cards", h.AuthMiddleware(anyRole(
 		http.HandlerFunc(h.SearchCreditCard),
-	))
-	mux.Handle("POST /deactivate-card", h.AuthMiddleware(
-		http.HandlerFunc(h.DeactivateCard),
-	))
-	mux.Handle("POST /send-money", h.AuthMiddleware(
-		http.HandlerFunc(h.SendMoney),
-	))
-	mux.Handle("POST /get-transactions", h.AuthMiddleware(
+	)))
+	mux.Handle("POST /freeze-card", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.FreezeCard),
+	)))
+	// deprecated; kept for older clients, use /freeze-card instead
+	mux.Handle("POST /deactivate-card", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.FreezeCard),
+	)))
+	mux.Handle("POST /unfreeze-card", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.UnfreezeCard),
+	)))
+	mux.Handle("POST /close-card", h.AuthMiddleware(anyRole(
+		h.IdempotencyMiddleware(http.HandlerFunc(h.CloseCard)),
+	)))
+	mux.Handle("POST /card-status-history", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.CardStatusHistory),
+	)))
+	mux.Handle("POST /send-card-pin-otp", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.SendCardPinOtp),
+	)))
+	mux.Handle("POST /set-card-pin", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.SetCardPin),
+	)))
+	mux.Handle("POST /change-card-pin", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.ChangeCardPin),
+	)))
+	mux.Handle("POST /reset-card-pin", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.ResetCardPin),
+	)))
+	mux.Handle("POST /reveal-cvv", h.AuthMiddleware(anyRole(
+		http.HandlerFunc(h.RevealCvv),
+	)))
+	mux.Handle("POST /send-money", h.AuthMiddleware(anyRole(
+		h.IdempotencyMiddleware(http.HandlerFunc(h.SendMoney)),
+	)))
+	mux.Handle("POST /get-transactions", h.AuthMiddleware(anyRole(
 		http.HandlerFunc(h.GetUserTransactions),
-	))
+	)))
+
+	mux.Handle("GET /admin/users", h.AuthMiddleware(admins(
+		http.HandlerFunc(h.ListUsers),
+	)))
+	mux.Handle("GET /admin/users/{id}", h.AuthMiddleware(admins(
+		http.HandlerFunc(h.GetUserHistory),
+	)))
+	mux.Handle("GET /admin/users/{id}/cards", h.AuthMiddleware(admins(
+		http.HandlerFunc(h.GetUserCards),
+	)))
+	mux.Handle("GET /admin/users/{id}/transactions", h.AuthMiddleware(admins(
+		http.HandlerFunc(h.GetUserTransactionsById),
+	)))
+	mux.Handle("POST /admin/set-role", h.AuthMiddleware(admins(
+		http.HandlerFunc(h.SetUserRole),
+	)))
+	mux.Handle("POST /admin/suspend-user", h.AuthMiddleware(admins(
+		http.HandlerFunc(h.SuspendUser),
+	)))
+	mux.Handle("POST /admin/activate-user", h.AuthMiddleware(admins(
+		http.HandlerFunc(h.ActivateUser),
+	)))
+	mux.Handle("POST /admin/block-card", h.AuthMiddleware(admins(
+		http.HandlerFunc(h.BlockCard),
+	)))
+	mux.Handle("POST /admin/unblock-card", h.AuthMiddleware(admins(
+		http.HandlerFunc(h.UnblockCard),
+	)))
+	mux.Handle("POST /admin/reset-totp", h.AuthMiddleware(admins(
+		http.HandlerFunc(h.ResetUserTotp),
+	)))
+	mux.Handle("POST /admin/send-verification-email", h.AuthMiddleware(admins(
+		http.HandlerFunc(h.ResendVerificationEmail),
+	)))
 
 	loggedMux := LoggingMiddleware(mux)
 
+	runner := newJobRunner(store)
+	runner.Start()
+
 	address := "localhost:8080"
-	log.Printf("starting HTTP server on %v\n", address)
+	server := &http.Server{Addr: address, Handler: loggedMux}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		log.Printf("starting HTTP server on %v\n", address)
+
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("error starting HTTP server; %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("shutting down")
+
+	// finish in-flight requests and jobs before closing the database
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+	defer cancel()
+
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Printf("error shutting down HTTP server; %v\n", err)
+	}
 
-	err := http.ListenAndServe(address, loggedMux)
+	err = runner.Stop(shutdownCtx)
 	if err != nil {
-		log.Fatalf("error starting HTTP server; %v", err)
+		log.Printf("error stopping background jobs; %v\n", err)
 	}
 }
@@ -0,0 +1,333 @@
+// Package money provides an exact representation of monetary amounts.
+//
+// Amounts are held as an integer number of minor units (e.g. cents) together
+// with an ISO 4217 currency code, so arithmetic never suffers from the
+// rounding drift of floating point numbers.
+package money
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultCurrency string = "KES"
+)
+
+// number of digits after the decimal point for each supported currency
+var minorUnits = map[string]int{
+	"KES": 2,
+	"UGX": 0,
+	"TZS": 2,
+	"RWF": 0,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+}
+
+var (
+	ErrCurrencyMismatch    error = errors.New("cannot combine amounts in different currencies")
+	ErrOverflow            error = errors.New("monetary amount out of range")
+	ErrUnsupportedCurrency error = errors.New("unsupported currency")
+	ErrInvalidAmount       error = errors.New("invalid monetary amount")
+)
+
+type Money struct {
+	Amount   int64  // amount in minor units e.g. cents
+	Currency string // ISO 4217 currency code
+}
+
+// Creates a Money value from an amount in minor units
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Creates a Money value from a whole number of major units e.g. shillings
+func FromMajor(amount int64, currency string) (Money, error) {
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+
+	scale, err := scaleOf(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	if amount > math.MaxInt64/scale || amount < math.MinInt64/scale {
+		return Money{}, ErrOverflow
+	}
+	return New(amount*scale, currency), nil
+}
+
+// Creates a Money value in the default currency from an amount in minor units
+func KES(amount int64) Money {
+	return New(amount, DefaultCurrency)
+}
+
+func Zero(currency string) Money {
+	return New(0, currency)
+}
+
+// Parses a decimal string such as "100.50", "-3" or "KES 1250.75".
+// When the string carries no currency code, the given currency is used.
+func Parse(s, currency string) (Money, error) {
+	s = strings.TrimSpace(s)
+
+	if code, amount, found := strings.Cut(s, " "); found {
+		currency = strings.ToUpper(code)
+		s = strings.TrimSpace(amount)
+	}
+
+	digits, err := digitsOf(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	negative := false
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		negative = s[0] == '-'
+		s = s[1:]
+	}
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" && fraction == "" {
+		return Money{}, fmt.Errorf("%w %q", ErrInvalidAmount, s)
+	}
+	if len(fraction) > digits {
+		// tolerate trailing zeros e.g. MySQL DECIMAL(15,4) columns
+		trimmed := strings.TrimRight(fraction[digits:], "0")
+		if trimmed != "" {
+			return Money{}, fmt.Errorf("%w; %v allows at most %d decimal places", ErrInvalidAmount, currency, digits)
+		}
+		fraction = fraction[:digits]
+	}
+	fraction += strings.Repeat("0", digits-len(fraction))
+
+	if whole == "" {
+		whole = "0"
+	}
+
+	for _, part := range []string{whole, fraction} {
+		for _, char := range part {
+			if char < '0' || char > '9' {
+				return Money{}, fmt.Errorf("%w %q", ErrInvalidAmount, s)
+			}
+		}
+	}
+
+	amount, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return Money{}, ErrOverflow
+	}
+	if negative {
+		amount = -amount
+	}
+
+	return New(amount, currency), nil
+}
+
+func MustParse(s, currency string) Money {
+	m, err := Parse(s, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func (m Money) Add(other Money) (Money, error) {
+	if err := m.checkCurrency(other); err != nil {
+		return Money{}, err
+	}
+
+	sum := m.Amount + other.Amount
+	// overflow happened if both operands share a sign the sum does not
+	if (m.Amount > 0 && other.Amount > 0 && sum < 0) || (m.Amount < 0 && other.Amount < 0 && sum >= 0) {
+		return Money{}, ErrOverflow
+	}
+	return New(sum, m.currency()), nil
+}
+
+func (m Money) Sub(other Money) (Money, error) {
+	if other.Amount == math.MinInt64 {
+		return Money{}, ErrOverflow
+	}
+	return m.Add(other.Neg())
+}
+
+func (m Money) Neg() Money {
+	return New(-m.Amount, m.Currency)
+}
+
+// Returns -1, 0 or +1 depending on whether m is less than,
+// equal to or greater than other
+func (m Money) Cmp(other Money) (int, error) {
+	if err := m.checkCurrency(other); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case m.Amount < other.Amount:
+		return -1, nil
+	case m.Amount > other.Amount:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+func (m Money) LessThan(other Money) (bool, error) {
+	cmp, err := m.Cmp(other)
+	return cmp < 0, err
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// Formats the amount as a plain decimal string e.g. "1250.75"
+func (m Money) Decimal() string {
+	digits, err := digitsOf(m.currency())
+	if err != nil {
+		digits = 2
+	}
+
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+	}
+
+	// use unsigned arithmetic so that math.MinInt64 is formatted correctly
+	abs := uint64(amount)
+	if amount < 0 {
+		abs = uint64(-(amount + 1)) + 1
+	}
+
+	str := strconv.FormatUint(abs, 10)
+	if digits == 0 {
+		return sign + str
+	}
+
+	if len(str) <= digits {
+		str = strings.Repeat("0", digits-len(str)+1) + str
+	}
+	return fmt.Sprintf("%s%s.%s", sign, str[:len(str)-digits], str[len(str)-digits:])
+}
+
+// Formats the amount with its currency code e.g. "KES 1250.75"
+func (m Money) String() string {
+	return fmt.Sprintf("%s %s", m.currency(), m.Decimal())
+}
+
+// Money is marshalled as a decimal string so that clients
+// never parse it into a floating point number by accident
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.Decimal())
+}
+
+// Accepts both decimal strings ("100.50") and JSON numbers (100.50).
+// Numbers are parsed from their literal text and never go through float64.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	str := strings.TrimSpace(string(data))
+	if str == "null" {
+		return nil
+	}
+
+	if strings.HasPrefix(str, `"`) {
+		err := json.Unmarshal(data, &str)
+		if err != nil {
+			return err
+		}
+	}
+
+	parsed, err := Parse(str, m.currency())
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+// Amounts are stored in the database as exact DECIMAL values
+func (m Money) Value() (driver.Value, error) {
+	return m.Decimal(), nil
+}
+
+func (m *Money) Scan(src any) error {
+	var (
+		parsed Money
+		err    error
+	)
+
+	currency := m.currency()
+
+	switch value := src.(type) {
+	case nil:
+		parsed = Zero(currency)
+	case []byte:
+		parsed, err = Parse(string(value), currency)
+	case string:
+		parsed, err = Parse(value, currency)
+	case int64:
+		parsed, err = FromMajor(value, currency)
+	case float64:
+		// some drivers return DECIMAL columns as floats; round to the nearest minor unit
+		parsed, err = Parse(strconv.FormatFloat(value, 'f', 6, 64), currency)
+		if err != nil {
+			scale, _ := scaleOf(currency)
+			parsed, err = New(int64(math.Round(value*float64(scale))), currency), nil
+		}
+	default:
+		err = fmt.Errorf("cannot scan %T into money.Money", src)
+	}
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+func (m Money) checkCurrency(other Money) error {
+	if m.currency() != other.currency() {
+		return fmt.Errorf("%w; %v and %v", ErrCurrencyMismatch, m.currency(), other.currency())
+	}
+	return nil
+}
+
+func (m Money) currency() string {
+	if m.Currency == "" {
+		return DefaultCurrency
+	}
+	return m.Currency
+}
+
+func digitsOf(currency string) (int, error) {
+	digits, ok := minorUnits[currency]
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrUnsupportedCurrency, currency)
+	}
+	return digits, nil
+}
+
+func scaleOf(currency string) (int64, error) {
+	digits, err := digitsOf(currency)
+	if err != nil {
+		return 0, err
+	}
+	return int64(math.Pow10(digits)), nil
+}
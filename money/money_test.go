@@ -0,0 +1,223 @@
+package money
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		currency string
+		want     Money
+		err      error
+	}{
+		{"100", "KES", New(10000, "KES"), nil},
+		{"100.5", "KES", New(10050, "KES"), nil},
+		{"100.50", "KES", New(10050, "KES"), nil},
+		{".75", "KES", New(75, "KES"), nil},
+		{"-3", "KES", New(-300, "KES"), nil},
+		{"+3", "KES", New(300, "KES"), nil},
+		{"  12.5  ", "KES", New(1250, "KES"), nil},
+		{"KES 1250.75", "USD", New(125075, "KES"), nil},
+		{"usd 1", "KES", New(100, "USD"), nil},
+		{"1500", "UGX", New(1500, "UGX"), nil},
+		{"12.5000", "KES", New(1250, "KES"), nil}, // DECIMAL(15,4) columns
+		{"92233720368547758.07", "KES", New(math.MaxInt64, "KES"), nil},
+
+		{"", "KES", Money{}, ErrInvalidAmount},
+		{"-", "KES", Money{}, ErrInvalidAmount},
+		{"1.2.3", "KES", Money{}, ErrInvalidAmount},
+		{"12a", "KES", Money{}, ErrInvalidAmount},
+		{"1e3", "KES", Money{}, ErrInvalidAmount},
+		{"1.005", "KES", Money{}, ErrInvalidAmount},
+		{"1.5", "UGX", Money{}, ErrInvalidAmount},
+		{"100", "XYZ", Money{}, ErrUnsupportedCurrency},
+		{"XYZ 100", "KES", Money{}, ErrUnsupportedCurrency},
+		{"92233720368547758.08", "KES", Money{}, ErrOverflow},
+		{"99999999999999999999", "UGX", Money{}, ErrOverflow},
+	}
+
+	for _, test := range tests {
+		got, err := Parse(test.input, test.currency)
+		if !errors.Is(err, test.err) {
+			t.Errorf("Parse(%q, %q) error = %v, want %v", test.input, test.currency, err, test.err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Parse(%q, %q) = %#v, want %#v", test.input, test.currency, got, test.want)
+		}
+	}
+}
+
+func TestFromMajor(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		currency string
+		want     Money
+		err      error
+	}{
+		{100, "KES", New(10000, "KES"), nil},
+		{100, "", New(10000, "KES"), nil},
+		{100, "UGX", New(100, "UGX"), nil},
+		{-5, "USD", New(-500, "USD"), nil},
+		{math.MaxInt64 / 100, "KES", New(math.MaxInt64/100*100, "KES"), nil},
+		{math.MaxInt64/100 + 1, "KES", Money{}, ErrOverflow},
+		{math.MinInt64/100 - 1, "KES", Money{}, ErrOverflow},
+		{math.MaxInt64, "UGX", New(math.MaxInt64, "UGX"), nil},
+		{1, "XYZ", Money{}, ErrUnsupportedCurrency},
+	}
+
+	for _, test := range tests {
+		got, err := FromMajor(test.amount, test.currency)
+		if !errors.Is(err, test.err) {
+			t.Errorf("FromMajor(%d, %q) error = %v, want %v", test.amount, test.currency, err, test.err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("FromMajor(%d, %q) = %#v, want %#v", test.amount, test.currency, got, test.want)
+		}
+	}
+}
+
+func TestAddSub(t *testing.T) {
+	tests := []struct {
+		name string
+		op   func(Money, Money) (Money, error)
+		a, b Money
+		want Money
+		err  error
+	}{
+		{"add", Money.Add, KES(150), KES(50), KES(200), nil},
+		{"add negative", Money.Add, KES(150), KES(-200), KES(-50), nil},
+		{"add default currency", Money.Add, New(1, ""), KES(1), KES(2), nil},
+		{"add overflow", Money.Add, KES(math.MaxInt64), KES(1), Money{}, ErrOverflow},
+		{"add underflow", Money.Add, KES(math.MinInt64), KES(-1), Money{}, ErrOverflow},
+		{"add mismatch", Money.Add, KES(1), New(1, "USD"), Money{}, ErrCurrencyMismatch},
+		{"sub", Money.Sub, KES(150), KES(50), KES(100), nil},
+		{"sub to negative", Money.Sub, KES(50), KES(150), KES(-100), nil},
+		{"sub overflow", Money.Sub, KES(math.MaxInt64), KES(-1), Money{}, ErrOverflow},
+		{"sub min int", Money.Sub, KES(0), KES(math.MinInt64), Money{}, ErrOverflow},
+		{"sub mismatch", Money.Sub, KES(1), New(1, "USD"), Money{}, ErrCurrencyMismatch},
+	}
+
+	for _, test := range tests {
+		got, err := test.op(test.a, test.b)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%v: error = %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%v: got %#v, want %#v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCmp(t *testing.T) {
+	tests := []struct {
+		a, b Money
+		want int
+		err  error
+	}{
+		{KES(1), KES(2), -1, nil},
+		{KES(2), KES(1), 1, nil},
+		{KES(2), KES(2), 0, nil},
+		{KES(-5), KES(0), -1, nil},
+		{New(100, ""), KES(100), 0, nil},
+		{KES(math.MinInt64), KES(math.MaxInt64), -1, nil},
+		{KES(1), New(1, "USD"), 0, ErrCurrencyMismatch},
+	}
+
+	for _, test := range tests {
+		got, err := test.a.Cmp(test.b)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%v.Cmp(%v) error = %v, want %v", test.a, test.b, err, test.err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%v.Cmp(%v) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	tests := []struct {
+		money Money
+		want  string
+	}{
+		{KES(0), "0.00"},
+		{KES(5), "0.05"},
+		{KES(125075), "1250.75"},
+		{KES(-125075), "-1250.75"},
+		{KES(-5), "-0.05"},
+		{New(1500, "UGX"), "1500"},
+		{KES(math.MaxInt64), "92233720368547758.07"},
+		{KES(math.MinInt64), "-92233720368547758.08"},
+	}
+
+	for _, test := range tests {
+		got := test.money.Decimal()
+		if got != test.want {
+			t.Errorf("%#v.Decimal() = %q, want %q", test.money, got, test.want)
+		}
+
+		parsed, err := Parse(got, test.money.Currency)
+		if err == nil && parsed != test.money {
+			t.Errorf("Parse(%q) = %#v, want %#v", got, parsed, test.money)
+		}
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Money
+		err   bool
+	}{
+		{`"100.50"`, KES(10050), false},
+		{`100.50`, KES(10050), false},
+		{`0.1`, KES(10), false},
+		{`"USD 3"`, New(300, "USD"), false},
+		{`null`, Money{}, false},
+		{`1.001`, Money{}, true},
+		{`"abc"`, Money{}, true},
+	}
+
+	for _, test := range tests {
+		var got Money
+		err := got.UnmarshalJSON([]byte(test.input))
+		if (err != nil) != test.err {
+			t.Errorf("UnmarshalJSON(%v) error = %v", test.input, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("UnmarshalJSON(%v) = %#v, want %#v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	tests := []struct {
+		src  any
+		want Money
+	}{
+		{nil, KES(0)},
+		{[]byte("12.3400"), KES(1234)},
+		{"7.5", KES(750)},
+		{int64(3), KES(300)},
+		{float64(0.1) + float64(0.2), KES(30)},
+	}
+
+	for _, test := range tests {
+		got := Money{Currency: "KES"}
+		err := got.Scan(test.src)
+		if err != nil {
+			t.Errorf("Scan(%#v) error = %v", test.src, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Scan(%#v) = %#v, want %#v", test.src, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+// Package passwords hashes and verifies user passwords.
+//
+// New hashes are argon2id (the default) or bcrypt strings:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>           PHC string format, unpadded base64
+//	$argon2id$v=19$m=65536,t=3,p=2,keyid=1$<salt>$<hash>   peppered with password_pepper key version 1
+//	$2a$12$<salt and hash>                                  bcrypt's own modular crypt format
+//
+// When the keyring holds password_pepper keys, argon2id hashes are made from
+// an HMAC-SHA256 of the password keyed with the newest pepper, so a leaked
+// database alone is not enough to brute-force them. bcrypt hashes have no
+// room to record a pepper version and are never peppered.
+//
+// Hashes in the legacy $5$<salt>$<hmac> format (HMAC-SHA256 keyed with
+// SECRET_KEY) can still be verified so that existing users can log in;
+// NeedsRehash reports them so they can be upgraded on the next login.
+package passwords
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/caleb-mwasikira/tap_gopay/keyring"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	ARGON2ID string = "argon2id"
+	BCRYPT   string = "bcrypt"
+
+	SALT_LEN        int = 16
+	ARGON2_KEY_LEN  int = 32
+	MAX_BCRYPT_COST int = bcrypt.MaxCost
+
+	// pepper version of hashes made without a pepper
+	NO_PEPPER int = -1
+)
+
+var (
+	ErrUnknownFormat error = errors.New("unknown password hash format")
+	ErrInvalidHash   error = errors.New("invalid password hash")
+)
+
+// Params are the cost parameters of new hashes.
+// Raising any of them makes existing hashes due for a rehash.
+type Params struct {
+	Algorithm string
+
+	Argon2Time    uint32 // number of passes over the memory
+	Argon2Memory  uint32 // memory used in KiB
+	Argon2Threads uint8
+
+	BcryptCost int
+}
+
+// Defaults follow the OWASP recommendations for argon2id and bcrypt
+func DefaultParams() Params {
+	return Params{
+		Algorithm:     ARGON2ID,
+		Argon2Time:    3,
+		Argon2Memory:  64 * 1024,
+		Argon2Threads: 2,
+		BcryptCost:    12,
+	}
+}
+
+func (p Params) Validate() error {
+	switch p.Algorithm {
+	case ARGON2ID:
+		if p.Argon2Time < 1 {
+			return fmt.Errorf("argon2 time must be at least 1")
+		}
+		if p.Argon2Threads < 1 {
+			return fmt.Errorf("argon2 threads must be at least 1")
+		}
+		if p.Argon2Memory < 8*uint32(p.Argon2Threads) {
+			return fmt.Errorf("argon2 memory must be at least 8 KiB per thread")
+		}
+
+	case BCRYPT:
+		if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > MAX_BCRYPT_COST {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, MAX_BCRYPT_COST)
+		}
+
+	default:
+		return fmt.Errorf("unsupported password hashing algorithm %q", p.Algorithm)
+	}
+	return nil
+}
+
+func (p Params) String() string {
+	if p.Algorithm == BCRYPT {
+		return fmt.Sprintf("bcrypt cost=%d", p.BcryptCost)
+	}
+	return fmt.Sprintf("%s m=%d,t=%d,p=%d", p.Algorithm, p.Argon2Memory, p.Argon2Time, p.Argon2Threads)
+}
+
+type Hasher struct {
+	params  Params
+	peppers []keyring.Key // newest version first
+
+	// key of legacy $5$ hashes
+	legacyKey []byte
+}
+
+// peppers are the password_pepper keys of the keyring and may be empty.
+// legacyKey is the SECRET_KEY legacy $5$ hashes were made with;
+// legacy hashes cannot be verified without it.
+func NewHasher(params Params, peppers []keyring.Key, legacyKey string) (*Hasher, error) {
+	err := params.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	peppers = slices.Clone(peppers)
+	slices.SortFunc(peppers, func(a, b keyring.Key) int {
+		return b.Version - a.Version
+	})
+
+	return &Hasher{params: params, peppers: peppers, legacyKey: []byte(legacyKey)}, nil
+}
+
+func (h *Hasher) Params() Params {
+	return h.params
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	if h.params.Algorithm == BCRYPT {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.params.BcryptCost)
+		if err != nil {
+			return "", fmt.Errorf("error hashing password; %v", err)
+		}
+		return string(hash), nil
+	}
+
+	salt := make([]byte, SALT_LEN)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", fmt.Errorf("error generating salt; %v", err)
+	}
+
+	input := []byte(password)
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", h.params.Argon2Memory, h.params.Argon2Time, h.params.Argon2Threads)
+
+	if len(h.peppers) > 0 {
+		pepper := h.peppers[0]
+		input = applyPepper(pepper, password)
+		params += fmt.Sprintf(",keyid=%d", pepper.Version)
+	}
+
+	hash := argon2.IDKey(
+		input,
+		salt,
+		h.params.Argon2Time,
+		h.params.Argon2Memory,
+		h.params.Argon2Threads,
+		uint32(ARGON2_KEY_LEN),
+	)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$%s$%s$%s",
+		argon2.Version,
+		params,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash),
+	), nil
+}
+
+func applyPepper(pepper keyring.Key, password string) []byte {
+	mac := hmac.New(sha256.New, pepper.Secret)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+// Reports whether password matches the encoded hash.
+// An error is only returned for hashes that cannot be checked at all.
+func (h *Hasher) Verify(password, encoded string) (bool, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		hash, err := parseArgon2id(encoded)
+		if err != nil {
+			return false, err
+		}
+
+		input := []byte(password)
+		if hash.pepperVersion != NO_PEPPER {
+			pepper, err := h.pepper(hash.pepperVersion)
+			if err != nil {
+				return false, err
+			}
+			input = applyPepper(pepper, password)
+		}
+
+		actual := argon2.IDKey(
+			input,
+			hash.salt,
+			hash.params.Argon2Time,
+			hash.params.Argon2Memory,
+			hash.params.Argon2Threads,
+			uint32(len(hash.key)),
+		)
+		return subtle.ConstantTimeCompare(hash.key, actual) == 1, nil
+
+	case isBcrypt(encoded):
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("%w; %v", ErrInvalidHash, err)
+		}
+		return true, nil
+
+	case strings.HasPrefix(encoded, "$5$"):
+		return h.verifyLegacy(password, encoded)
+	}
+
+	return false, ErrUnknownFormat
+}
+
+// Reports whether the encoded hash should be replaced with a new hash of
+// the same password; true for legacy hashes, hashes made with another
+// algorithm and hashes made with other cost parameters.
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	switch h.params.Algorithm {
+	case ARGON2ID:
+		hash, err := parseArgon2id(encoded)
+		if err != nil {
+			return true
+		}
+
+		pepperVersion := NO_PEPPER
+		if len(h.peppers) > 0 {
+			pepperVersion = h.peppers[0].Version
+		}
+
+		return hash.params.Argon2Time != h.params.Argon2Time ||
+			hash.params.Argon2Memory != h.params.Argon2Memory ||
+			hash.params.Argon2Threads != h.params.Argon2Threads ||
+			hash.pepperVersion != pepperVersion ||
+			len(hash.key) != ARGON2_KEY_LEN
+
+	case BCRYPT:
+		if !isBcrypt(encoded) {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost != h.params.BcryptCost
+	}
+	return true
+}
+
+func (h *Hasher) pepper(version int) (keyring.Key, error) {
+	for _, pepper := range h.peppers {
+		if pepper.Version == version {
+			return pepper, nil
+		}
+	}
+	return keyring.Key{}, fmt.Errorf("%w; no password pepper version %d", keyring.ErrKeyNotFound, version)
+}
+
+type argon2idHash struct {
+	params        Params
+	pepperVersion int
+	salt          []byte
+	key           []byte
+}
+
+// Parses $argon2id$v=19$m=<memory>,t=<time>,p=<threads>[,keyid=<pepper version>]$<salt>$<hash>
+func parseArgon2id(encoded string) (*argon2idHash, error) {
+	fields := strings.Split(encoded, "$")
+	if len(fields) != 6 || fields[1] != ARGON2ID {
+		return nil, ErrInvalidHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(fields[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("%w; unsupported argon2 version %q", ErrInvalidHash, fields[2])
+	}
+
+	hash := argon2idHash{params: Params{Algorithm: ARGON2ID}, pepperVersion: NO_PEPPER}
+
+	params, keyId, _ := strings.Cut(fields[3], ",keyid=")
+
+	_, err = fmt.Sscanf(
+		params,
+		"m=%d,t=%d,p=%d",
+		&hash.params.Argon2Memory,
+		&hash.params.Argon2Time,
+		&hash.params.Argon2Threads,
+	)
+	if err != nil || hash.params.Validate() != nil {
+		return nil, fmt.Errorf("%w; invalid argon2 parameters %q", ErrInvalidHash, fields[3])
+	}
+
+	if keyId != "" {
+		hash.pepperVersion, err = strconv.Atoi(keyId)
+		if err != nil || hash.pepperVersion < 0 {
+			return nil, fmt.Errorf("%w; invalid pepper version %q", ErrInvalidHash, keyId)
+		}
+	}
+
+	hash.salt, err = base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil {
+		return nil, fmt.Errorf("%w; invalid salt encoding", ErrInvalidHash)
+	}
+
+	hash.key, err = base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil || len(hash.key) == 0 {
+		return nil, fmt.Errorf("%w; invalid hash encoding", ErrInvalidHash)
+	}
+
+	return &hash, nil
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") ||
+		strings.HasPrefix(encoded, "$2b$") ||
+		strings.HasPrefix(encoded, "$2y$")
+}
+
+// Verifies a legacy $5$<hex salt>$<hex hmac> hash, where the HMAC-SHA256
+// is computed over "<hex salt>.<password>"
+func (h *Hasher) verifyLegacy(password, encoded string) (bool, error) {
+	fields := strings.Split(strings.Trim(encoded, "$"), "$")
+	if len(fields) != 3 {
+		return false, ErrInvalidHash
+	}
+
+	expectedHmac, err := hex.DecodeString(fields[2])
+	if err != nil {
+		return false, fmt.Errorf("%w; invalid HMAC encoding", ErrInvalidHash)
+	}
+
+	mac := hmac.New(sha256.New, h.legacyKey)
+	mac.Write([]byte(fmt.Sprintf("%s.%s", fields[1], password)))
+
+	return hmac.Equal(expectedHmac, mac.Sum(nil)), nil
+}
+
+// Reads Params from the environment, falling back to DefaultParams for unset values:
+//
+//	PASSWORD_HASH_ALGORITHM  argon2id or bcrypt
+//	ARGON2_TIME, ARGON2_MEMORY_KIB, ARGON2_THREADS
+//	BCRYPT_COST
+//
+// Values that are not numbers are read as 0 and rejected by Validate.
+func ParamsFromEnv() Params {
+	params := DefaultParams()
+
+	if algorithm := os.Getenv("PASSWORD_HASH_ALGORITHM"); algorithm != "" {
+		params.Algorithm = strings.ToLower(algorithm)
+	}
+	if value, ok := envUint("ARGON2_TIME", 32); ok {
+		params.Argon2Time = uint32(value)
+	}
+	if value, ok := envUint("ARGON2_MEMORY_KIB", 32); ok {
+		params.Argon2Memory = uint32(value)
+	}
+	if value, ok := envUint("ARGON2_THREADS", 8); ok {
+		params.Argon2Threads = uint8(value)
+	}
+	if value, ok := envUint("BCRYPT_COST", 8); ok {
+		params.BcryptCost = int(value)
+	}
+	return params
+}
+
+func envUint(name string, bitSize int) (uint64, bool) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, false
+	}
+
+	parsed, err := strconv.ParseUint(value, 10, bitSize)
+	if err != nil {
+		return 0, true
+	}
+	return parsed, true
+}
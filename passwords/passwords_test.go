@@ -0,0 +1,292 @@
+package passwords
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/caleb-mwasikira/tap_gopay/keyring"
+)
+
+const (
+	legacyKey string = "secret"
+	password  string = "Passw0rd!"
+)
+
+// cheap parameters so the tests run fast
+func testParams(algorithm string) Params {
+	return Params{
+		Algorithm:     algorithm,
+		Argon2Time:    1,
+		Argon2Memory:  64,
+		Argon2Threads: 1,
+		BcryptCost:    4,
+	}
+}
+
+func pepperKey(version int) keyring.Key {
+	return keyring.Key{
+		Purpose: keyring.PASSWORD_PEPPER,
+		Version: version,
+		Secret:  bytes.Repeat([]byte{byte(version)}, keyring.MIN_KEY_LEN),
+	}
+}
+
+// Makes a hash in the format passwords were stored in before argon2id
+func legacyHash(salt, password string) string {
+	mac := hmac.New(sha256.New, []byte(legacyKey))
+	mac.Write([]byte(fmt.Sprintf("%s.%s", salt, password)))
+	return fmt.Sprintf("$5$%s$%s", salt, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func newHasher(t testing.TB, params Params, peppers ...keyring.Key) *Hasher {
+	t.Helper()
+
+	hasher, err := NewHasher(params, peppers, legacyKey)
+	if err != nil {
+		t.Fatalf("NewHasher() error = %v", err)
+	}
+	return hasher
+}
+
+func TestHashAndVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  Params
+		peppers []keyring.Key
+		prefix  string
+	}{
+		{"argon2id", testParams(ARGON2ID), nil, "$argon2id$v=19$m=64,t=1,p=1$"},
+		{"argon2id peppered", testParams(ARGON2ID), []keyring.Key{pepperKey(1), pepperKey(2)}, "$argon2id$v=19$m=64,t=1,p=1,keyid=2$"},
+		{"bcrypt", testParams(BCRYPT), nil, "$2a$04$"},
+		{"bcrypt ignores peppers", testParams(BCRYPT), []keyring.Key{pepperKey(1)}, "$2a$04$"},
+	}
+
+	for _, test := range tests {
+		hasher := newHasher(t, test.params, test.peppers...)
+
+		hash, err := hasher.Hash(password)
+		if err != nil {
+			t.Fatalf("%v: Hash() error = %v", test.name, err)
+		}
+		if !strings.HasPrefix(hash, test.prefix) {
+			t.Errorf("%v: Hash() = %q, want prefix %q", test.name, hash, test.prefix)
+		}
+
+		other, _ := hasher.Hash(password)
+		if other == hash {
+			t.Errorf("%v: hashes of the same password are equal", test.name)
+		}
+
+		for input, want := range map[string]bool{password: true, "passw0rd!": false, "": false} {
+			match, err := hasher.Verify(input, hash)
+			if err != nil || match != want {
+				t.Errorf("%v: Verify(%q) = %v, %v; want %v", test.name, input, match, err, want)
+			}
+		}
+
+		if hasher.NeedsRehash(hash) {
+			t.Errorf("%v: NeedsRehash() of a new hash = true", test.name)
+		}
+	}
+}
+
+func TestVerifyLegacy(t *testing.T) {
+	hasher := newHasher(t, testParams(ARGON2ID))
+	hash := legacyHash("a1b2c3d4", password)
+
+	tests := []struct {
+		name     string
+		password string
+		hash     string
+		match    bool
+		err      error
+	}{
+		{"right password", password, hash, true, nil},
+		{"wrong password", "Passw0rd", hash, false, nil},
+		{"other salt", password, strings.Replace(hash, "a1b2c3d4", "00000000", 1), false, nil},
+		{"missing hmac", password, "$5$a1b2c3d4", false, ErrInvalidHash},
+		{"hmac not hex", password, "$5$a1b2c3d4$zz", false, ErrInvalidHash},
+	}
+
+	for _, test := range tests {
+		match, err := hasher.Verify(test.password, test.hash)
+		if !errors.Is(err, test.err) || match != test.match {
+			t.Errorf("%v: Verify() = %v, %v; want %v, %v", test.name, match, err, test.match, test.err)
+		}
+	}
+
+	other, err := NewHasher(testParams(ARGON2ID), nil, "another secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match, _ := other.Verify(password, hash); match {
+		t.Error("legacy hash verified with another SECRET_KEY")
+	}
+}
+
+// Existing users log in with their legacy hash, which is then replaced
+func TestRehashLegacy(t *testing.T) {
+	hasher := newHasher(t, testParams(ARGON2ID), pepperKey(1))
+	hash := legacyHash("0badc0de", password)
+
+	match, err := hasher.Verify(password, hash)
+	if err != nil || !match {
+		t.Fatalf("Verify() of legacy hash = %v, %v", match, err)
+	}
+	if !hasher.NeedsRehash(hash) {
+		t.Fatal("NeedsRehash() of legacy hash = false")
+	}
+
+	rehashed, err := hasher.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	match, err = hasher.Verify(password, rehashed)
+	if err != nil || !match {
+		t.Fatalf("Verify() of rehashed password = %v, %v", match, err)
+	}
+	if hasher.NeedsRehash(rehashed) {
+		t.Error("NeedsRehash() of rehashed password = true")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	hashOf := func(params Params, peppers ...keyring.Key) string {
+		hash, err := newHasher(t, params, peppers...).Hash(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	argon2id := testParams(ARGON2ID)
+	moreMemory := argon2id
+	moreMemory.Argon2Memory *= 2
+	moreTime := argon2id
+	moreTime.Argon2Time++
+	bcryptCost := testParams(BCRYPT)
+	bcryptCost.BcryptCost++
+
+	tests := []struct {
+		name    string
+		hash    string
+		params  Params
+		peppers []keyring.Key
+		rehash  bool
+	}{
+		{"same argon2id params", hashOf(argon2id), argon2id, nil, false},
+		{"more memory", hashOf(argon2id), moreMemory, nil, true},
+		{"more time", hashOf(argon2id), moreTime, nil, true},
+		{"pepper added", hashOf(argon2id), argon2id, []keyring.Key{pepperKey(1)}, true},
+		{"pepper rotated", hashOf(argon2id, pepperKey(1)), argon2id, []keyring.Key{pepperKey(1), pepperKey(2)}, true},
+		{"bcrypt to argon2id", hashOf(testParams(BCRYPT)), argon2id, nil, true},
+		{"argon2id to bcrypt", hashOf(argon2id), testParams(BCRYPT), nil, true},
+		{"same bcrypt cost", hashOf(testParams(BCRYPT)), testParams(BCRYPT), nil, false},
+		{"higher bcrypt cost", hashOf(testParams(BCRYPT)), bcryptCost, nil, true},
+		{"legacy", legacyHash("00", password), argon2id, nil, true},
+		{"garbage", "not a hash", argon2id, nil, true},
+	}
+
+	for _, test := range tests {
+		hasher := newHasher(t, test.params, test.peppers...)
+		if got := hasher.NeedsRehash(test.hash); got != test.rehash {
+			t.Errorf("%v: NeedsRehash() = %v, want %v", test.name, got, test.rehash)
+		}
+	}
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	peppered := newHasher(t, testParams(ARGON2ID), pepperKey(1))
+	hash, err := peppered.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		hash string
+		err  error
+	}{
+		{"unknown format", "plaintext", ErrUnknownFormat},
+		{"sha512 crypt", "$6$salt$hash", ErrUnknownFormat},
+		{"argon2id fields", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA", ErrInvalidHash},
+		{"argon2 version", "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$aGFzaA", ErrInvalidHash},
+		{"argon2 params", "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$aGFzaA", ErrInvalidHash},
+		{"pepper version", "$argon2id$v=19$m=64,t=1,p=1,keyid=x$c2FsdA$aGFzaA", ErrInvalidHash},
+		{"salt encoding", "$argon2id$v=19$m=64,t=1,p=1$!!$aGFzaA", ErrInvalidHash},
+		{"bcrypt", "$2a$04$short", ErrInvalidHash},
+		{"missing pepper", hash, keyring.ErrKeyNotFound},
+	}
+
+	hasher := newHasher(t, testParams(ARGON2ID))
+	for _, test := range tests {
+		match, err := hasher.Verify(password, test.hash)
+		if match || !errors.Is(err, test.err) {
+			t.Errorf("%v: Verify() = %v, %v; want %v", test.name, match, err, test.err)
+		}
+	}
+}
+
+func TestParamsValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Params)
+		valid  bool
+	}{
+		{"defaults", func(p *Params) {}, true},
+		{"bcrypt", func(p *Params) { p.Algorithm = BCRYPT }, true},
+		{"unknown algorithm", func(p *Params) { p.Algorithm = "scrypt" }, false},
+		{"no time", func(p *Params) { p.Argon2Time = 0 }, false},
+		{"no threads", func(p *Params) { p.Argon2Threads = 0 }, false},
+		{"too little memory", func(p *Params) { p.Argon2Memory = 8*uint32(p.Argon2Threads) - 1 }, false},
+		{"bcrypt cost too low", func(p *Params) { p.Algorithm, p.BcryptCost = BCRYPT, 3 }, false},
+		{"bcrypt cost too high", func(p *Params) { p.Algorithm, p.BcryptCost = BCRYPT, MAX_BCRYPT_COST+1 }, false},
+	}
+
+	for _, test := range tests {
+		params := DefaultParams()
+		test.modify(&params)
+
+		err := params.Validate()
+		if (err == nil) != test.valid {
+			t.Errorf("%v: Validate() = %v", test.name, err)
+		}
+	}
+}
+
+func TestParamsFromEnv(t *testing.T) {
+	t.Setenv("PASSWORD_HASH_ALGORITHM", "BCRYPT")
+	t.Setenv("BCRYPT_COST", "11")
+	t.Setenv("ARGON2_TIME", "x")
+
+	params := ParamsFromEnv()
+	if params.Algorithm != BCRYPT || params.BcryptCost != 11 || params.Argon2Time != 0 {
+		t.Errorf("ParamsFromEnv() = %+v", params)
+	}
+	if params.Argon2Memory != DefaultParams().Argon2Memory {
+		t.Errorf("ParamsFromEnv() memory = %d, want the default", params.Argon2Memory)
+	}
+}
+
+// Measures the cost of hashing with the default parameters, which is what a
+// login costs the server
+func BenchmarkHash(b *testing.B) {
+	for _, params := range []Params{DefaultParams(), {Algorithm: BCRYPT, BcryptCost: DefaultParams().BcryptCost}} {
+		hasher := newHasher(b, params, pepperKey(1))
+
+		b.Run(params.String(), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := hasher.Hash(password)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package tokens
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+)
+
+// JWK is the public half of a signing key as described by RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	X   string `json:"x"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Returns the key set of the asymmetric keys; HS256 keys are left out
+func PublicKeySet(keys []*Key) JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+
+	for _, key := range keys {
+		switch public := key.public.(type) {
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Crv: "Ed25519",
+				Alg: EDDSA,
+				Use: "sig",
+				Kid: key.Id,
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+
+		case *ecdsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "EC",
+				Crv: "P-256",
+				Alg: ES256,
+				Use: "sig",
+				Kid: key.Id,
+				X:   base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, 32))),
+				Y:   base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, 32))),
+			})
+		}
+	}
+
+	return set
+}
+
+// Converts a published key back into a verification key
+func (jwk JWK) Key() (*Key, error) {
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWK %v; %v", jwk.Kid, err)
+	}
+
+	switch {
+	case jwk.Kty == "OKP" && jwk.Crv == "Ed25519":
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid JWK %v; wrong Ed25519 key size", jwk.Kid)
+		}
+		return &Key{Id: jwk.Kid, Algorithm: EDDSA, public: ed25519.PublicKey(x)}, nil
+
+	case jwk.Kty == "EC" && jwk.Crv == "P-256":
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWK %v; %v", jwk.Kid, err)
+		}
+
+		public := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !public.Curve.IsOnCurve(public.X, public.Y) {
+			return nil, fmt.Errorf("invalid JWK %v; point is not on P-256", jwk.Kid)
+		}
+		return &Key{Id: jwk.Kid, Algorithm: ES256, public: public}, nil
+	}
+
+	return nil, fmt.Errorf("%w; JWK %v has kty %q and crv %q", ErrUnsupportedAlgorithm, jwk.Kid, jwk.Kty, jwk.Crv)
+}
+
+// Creates a verifier for the published keys.
+// Tokens without a kid header are rejected.
+func NewJWKSVerifier(set *JWKSet) (*Verifier, error) {
+	keys := []*Key{}
+	for _, jwk := range set.Keys {
+		key, err := jwk.Key()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return NewVerifier(keys, ""), nil
+}
+
+// Downloads a JSON Web Key Set such as /.well-known/jwks.json
+func FetchJWKS(client *http.Client, url string) (*JWKSet, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching JWKS; %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching JWKS; %v", resp.Status)
+	}
+
+	set := JWKSet{}
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing JWKS; %v", err)
+	}
+	return &set, nil
+}
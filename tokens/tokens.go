@@ -0,0 +1,181 @@
+// Package tokens signs and verifies TapGoPay access tokens (JWTs).
+//
+// Tokens are signed with one of
+//
+//	HS256 - HMAC-SHA256 with a shared secret; only the issuer can verify
+//	EdDSA - Ed25519; the key secret is the 32 byte private key seed
+//	ES256 - ECDSA P-256 with SHA-256; the key secret is the 32 byte private scalar
+//
+// and name their key in the kid header. The public halves of EdDSA and ES256
+// keys are published as a JSON Web Key Set at /.well-known/jwks.json, so other
+// services can verify tokens with a Verifier without being able to mint them:
+//
+//	keys, err := tokens.FetchJWKS(http.DefaultClient, "https://tapgopay.example/.well-known/jwks.json")
+//	verifier, err := tokens.NewJWKSVerifier(keys)
+//	claims, err := verifier.Verify(accessToken)
+package tokens
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt"
+)
+
+const (
+	HS256 string = "HS256"
+	EDDSA string = "EdDSA"
+	ES256 string = "ES256"
+)
+
+var (
+	ErrUnsupportedAlgorithm error = errors.New("unsupported token signing algorithm")
+	ErrUnknownKey           error = errors.New("unknown token signing key")
+)
+
+// Key is a token signing key, or a verification key when it has no private half
+type Key struct {
+	Id        string
+	Algorithm string
+
+	private crypto.PrivateKey // []byte for HS256
+	public  crypto.PublicKey  // []byte for HS256
+}
+
+// Derives the key pair of the algorithm from a secret.
+// An empty algorithm means HS256.
+func NewKey(kid, algorithm string, secret []byte) (*Key, error) {
+	key := Key{Id: kid, Algorithm: algorithm}
+
+	switch algorithm {
+	case HS256, "":
+		key.Algorithm = HS256
+		key.private = secret
+		key.public = secret
+
+	case EDDSA:
+		if len(secret) != ed25519.SeedSize {
+			return nil, fmt.Errorf("%v; EdDSA keys must be %d bytes", kid, ed25519.SeedSize)
+		}
+		private := ed25519.NewKeyFromSeed(secret)
+		key.private = private
+		key.public = private.Public()
+
+	case ES256:
+		private, err := p256PrivateKey(secret)
+		if err != nil {
+			return nil, fmt.Errorf("%v; %v", kid, err)
+		}
+		key.private = private
+		key.public = &private.PublicKey
+
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedAlgorithm, algorithm)
+	}
+
+	return &key, nil
+}
+
+func p256PrivateKey(secret []byte) (*ecdsa.PrivateKey, error) {
+	// crypto/ecdh checks the scalar is in range and derives the public point
+	ecdhKey, err := ecdh.P256().NewPrivateKey(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ES256 key; %v", err)
+	}
+
+	// uncompressed point: 0x04 || X || Y
+	point := ecdhKey.PublicKey().Bytes()
+
+	return &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(point[1:33]),
+			Y:     new(big.Int).SetBytes(point[33:]),
+		},
+		D: new(big.Int).SetBytes(secret),
+	}, nil
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+// Reports whether the key can be published; HS256 keys are secret
+func (k *Key) IsAsymmetric() bool {
+	return k.Algorithm != HS256
+}
+
+// Signs the claims, naming the key in the kid header
+func (k *Key) Sign(claims jwt.Claims) (string, error) {
+	if k.private == nil {
+		return "", fmt.Errorf("key %v cannot sign tokens", k.Id)
+	}
+
+	token := jwt.NewWithClaims(k.method(), claims)
+	token.Header["kid"] = k.Id
+
+	signedToken, err := token.SignedString(k.private)
+	if err != nil {
+		return "", fmt.Errorf("error signing JWT; %v", err)
+	}
+	return signedToken, nil
+}
+
+// Verifier checks token signatures and expiry against a set of keys
+type Verifier struct {
+	keys map[string]*Key
+
+	// key of tokens without a kid header; none when empty
+	defaultKid string
+}
+
+// defaultKid names the key that verifies tokens without a kid header,
+// and may be left empty to reject such tokens
+func NewVerifier(keys []*Key, defaultKid string) *Verifier {
+	verifier := Verifier{keys: map[string]*Key{}, defaultKid: defaultKid}
+	for _, key := range keys {
+		verifier.keys[key.Id] = key
+	}
+	return &verifier
+}
+
+func (v *Verifier) Verify(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			kid = v.defaultKid
+		}
+
+		key, ok := v.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+		}
+
+		// a token may only be verified with the algorithm of its key,
+		// so a public key can never be used as an HMAC secret
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("%w; key %v is for %v, not %v", ErrUnsupportedAlgorithm, kid, key.Algorithm, token.Method.Alg())
+		}
+		return key.public, nil
+	})
+	if err != nil {
+		// jwt v3 does not unwrap errors returned by the key func
+		var validationErr *jwt.ValidationError
+		if errors.As(err, &validationErr) && validationErr.Inner != nil {
+			return nil, validationErr.Inner
+		}
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid JWT token")
+	}
+	return claims, nil
+}
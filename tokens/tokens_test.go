@@ -0,0 +1,255 @@
+package tokens
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// private key seed and public key of RFC 8037, appendix A
+const (
+	rfc8037Seed string = "9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60"
+	rfc8037X    string = "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"
+)
+
+func newKey(t *testing.T, kid, algorithm string, fill byte) *Key {
+	t.Helper()
+
+	key, err := NewKey(kid, algorithm, bytes.Repeat([]byte{fill}, 32))
+	if err != nil {
+		t.Fatalf("NewKey(%v) error = %v", algorithm, err)
+	}
+	return key
+}
+
+func claims(expiresIn time.Duration) jwt.MapClaims {
+	return jwt.MapClaims{"sub": "42", "exp": time.Now().Add(expiresIn).Unix()}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	hs256 := newKey(t, "jwt-0", HS256, 1)
+	eddsa := newKey(t, "jwt-1", EDDSA, 2)
+	es256 := newKey(t, "jwt-2", ES256, 3)
+	verifier := NewVerifier([]*Key{hs256, eddsa, es256}, "jwt-0")
+
+	for _, key := range []*Key{hs256, eddsa, es256} {
+		token, err := key.Sign(claims(time.Minute))
+		if err != nil {
+			t.Fatalf("%v: Sign() error = %v", key.Algorithm, err)
+		}
+
+		parsed, _, err := new(jwt.Parser).ParseUnverified(token, jwt.MapClaims{})
+		if err != nil || parsed.Header["kid"] != key.Id || parsed.Header["alg"] != key.Algorithm {
+			t.Errorf("%v: header = %v, %v", key.Algorithm, parsed.Header, err)
+		}
+
+		verified, err := verifier.Verify(token)
+		if err != nil || verified["sub"] != "42" {
+			t.Errorf("%v: Verify() = %v, %v", key.Algorithm, verified, err)
+		}
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	hs256 := newKey(t, "jwt-0", HS256, 1)
+	eddsa := newKey(t, "jwt-1", EDDSA, 2)
+	es256 := newKey(t, "jwt-2", ES256, 3)
+	verifier := NewVerifier([]*Key{hs256, eddsa, es256}, "")
+
+	sign := func(key *Key, claims jwt.Claims) string {
+		token, err := key.Sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	// a token signed with the other key of a kid
+	impostor := newKey(t, "jwt-1", EDDSA, 9)
+
+	// an HS256 token using the published key of jwt-1 as its secret
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(time.Minute))
+	confused.Header["kid"] = eddsa.Id
+	confusedToken, err := confused.SignedString([]byte(eddsa.public.(ed25519.PublicKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noKid := jwt.NewWithClaims(jwt.SigningMethodHS256, claims(time.Minute))
+	noKidToken, err := noKid.SignedString(hs256.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"expired", sign(es256, claims(-time.Minute)), nil},
+		{"unknown kid", sign(newKey(t, "jwt-7", EDDSA, 2), claims(time.Minute)), ErrUnknownKey},
+		{"wrong key", sign(impostor, claims(time.Minute)), nil},
+		{"algorithm of another key", confusedToken, ErrUnsupportedAlgorithm},
+		{"no kid without a default key", noKidToken, ErrUnknownKey},
+		{"garbage", "not.a.token", nil},
+	}
+
+	for _, test := range tests {
+		_, err := verifier.Verify(test.token)
+		if err == nil {
+			t.Errorf("%v: Verify() succeeded", test.name)
+			continue
+		}
+		if test.err != nil && !errors.Is(err, test.err) {
+			t.Errorf("%v: Verify() error = %v, want %v", test.name, err, test.err)
+		}
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+		secret    []byte
+		valid     bool
+	}{
+		{"default algorithm", "", []byte("secret"), true},
+		{"EdDSA", EDDSA, make([]byte, 32), true},
+		{"EdDSA wrong size", EDDSA, make([]byte, 64), false},
+		{"ES256", ES256, bytes.Repeat([]byte{1}, 32), true},
+		{"ES256 zero scalar", ES256, make([]byte, 32), false},
+		{"ES256 scalar out of range", ES256, bytes.Repeat([]byte{0xff}, 32), false},
+		{"ES256 wrong size", ES256, make([]byte, 31), false},
+		{"RS256", "RS256", make([]byte, 32), false},
+	}
+
+	for _, test := range tests {
+		key, err := NewKey("jwt-1", test.algorithm, test.secret)
+		if (err == nil) != test.valid {
+			t.Errorf("%v: NewKey() error = %v", test.name, err)
+		}
+		if err == nil && key.IsAsymmetric() != (test.algorithm != "") {
+			t.Errorf("%v: IsAsymmetric() = %v", test.name, key.IsAsymmetric())
+		}
+	}
+
+	_, err := NewKey("jwt-1", "RS256", nil)
+	if !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("NewKey(RS256) error = %v, want %v", err, ErrUnsupportedAlgorithm)
+	}
+}
+
+func TestPublicKeySet(t *testing.T) {
+	seed, _ := hex.DecodeString(rfc8037Seed)
+	eddsa, err := NewKey("jwt-1", EDDSA, seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	es256 := newKey(t, "jwt-2", ES256, 3)
+
+	set := PublicKeySet([]*Key{newKey(t, "jwt-0", HS256, 1), eddsa, es256})
+	if len(set.Keys) != 2 {
+		t.Fatalf("PublicKeySet() has %d keys, want the 2 asymmetric ones", len(set.Keys))
+	}
+
+	want := JWK{Kty: "OKP", Crv: "Ed25519", Alg: EDDSA, Use: "sig", Kid: "jwt-1", X: rfc8037X}
+	if set.Keys[0] != want {
+		t.Errorf("Ed25519 JWK = %+v, want %+v", set.Keys[0], want)
+	}
+
+	ec := set.Keys[1]
+	if ec.Kty != "EC" || ec.Crv != "P-256" || ec.Alg != ES256 || ec.Kid != "jwt-2" || len(ec.X) != 43 || len(ec.Y) != 43 {
+		t.Errorf("P-256 JWK = %+v", ec)
+	}
+
+	// private halves are never published
+	data, _ := json.Marshal(set)
+	if bytes.Contains(data, []byte(`"d"`)) {
+		t.Errorf("JWKS = %s", data)
+	}
+}
+
+func TestJWKKey(t *testing.T) {
+	set := PublicKeySet([]*Key{newKey(t, "jwt-1", EDDSA, 2), newKey(t, "jwt-2", ES256, 3)})
+	ed, ec := set.Keys[0], set.Keys[1]
+
+	offCurve := ec
+	offCurve.Y = ec.X
+
+	tests := []struct {
+		name  string
+		jwk   JWK
+		valid bool
+	}{
+		{"Ed25519", ed, true},
+		{"P-256", ec, true},
+		{"short Ed25519 key", JWK{Kty: "OKP", Crv: "Ed25519", X: ed.X[:20]}, false},
+		{"x not base64url", JWK{Kty: "OKP", Crv: "Ed25519", X: "+/+/"}, false},
+		{"point off the curve", offCurve, false},
+		{"RSA", JWK{Kty: "RSA", X: ed.X}, false},
+	}
+
+	for _, test := range tests {
+		_, err := test.jwk.Key()
+		if (err == nil) != test.valid {
+			t.Errorf("%v: Key() error = %v", test.name, err)
+		}
+	}
+}
+
+// Other services verify tokens with the published keys only
+func TestJWKSVerifier(t *testing.T) {
+	hs256 := newKey(t, "jwt-0", HS256, 1)
+	eddsa := newKey(t, "jwt-1", EDDSA, 2)
+	es256 := newKey(t, "jwt-2", ES256, 3)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/.well-known/jwks.json" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(PublicKeySet([]*Key{hs256, eddsa, es256}))
+	}))
+	defer server.Close()
+
+	_, err := FetchJWKS(server.Client(), server.URL+"/jwks.json")
+	if err == nil {
+		t.Error("FetchJWKS() of a missing page succeeded")
+	}
+
+	set, err := FetchJWKS(server.Client(), server.URL+"/.well-known/jwks.json")
+	if err != nil {
+		t.Fatalf("FetchJWKS() error = %v", err)
+	}
+
+	verifier, err := NewJWKSVerifier(set)
+	if err != nil {
+		t.Fatalf("NewJWKSVerifier() error = %v", err)
+	}
+
+	for _, key := range []*Key{eddsa, es256} {
+		token, _ := key.Sign(claims(time.Minute))
+		if _, err := verifier.Verify(token); err != nil {
+			t.Errorf("%v: Verify() error = %v", key.Algorithm, err)
+		}
+	}
+
+	token, _ := hs256.Sign(claims(time.Minute))
+	if _, err := verifier.Verify(token); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("HS256: Verify() error = %v, want %v", err, ErrUnknownKey)
+	}
+
+	// verification keys cannot mint tokens
+	published, _ := set.Keys[0].Key()
+	if _, err := published.Sign(claims(time.Minute)); err == nil {
+		t.Error("Sign() with a published key succeeded")
+	}
+}
@@ -0,0 +1,119 @@
+// Package totp implements time-based one-time passwords (RFC 6238) as used
+// by authenticator apps: 6 digit HMAC-SHA1 codes that change every 30 seconds.
+//
+// Functions take the current time as an argument so they can be driven by
+// a fake clock.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DIGITS     int           = 6
+	PERIOD     time.Duration = 30 * time.Second
+	SECRET_LEN int           = 20 // 160 bits, as recommended by RFC 4226
+
+	// Codes of this many periods before or after the current one are
+	// accepted, allowing for clock drift and slow typing
+	SKEW int64 = 1
+)
+
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, SECRET_LEN)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, fmt.Errorf("error generating TOTP secret; %v", err)
+	}
+	return secret, nil
+}
+
+// Encodes a secret in base32, the form authenticator apps accept for manual entry
+func EncodeSecret(secret []byte) string {
+	return secretEncoding.EncodeToString(secret)
+}
+
+func DecodeSecret(encoded string) ([]byte, error) {
+	secret, err := secretEncoding.DecodeString(strings.ToUpper(strings.TrimSpace(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP secret; %v", err)
+	}
+	return secret, nil
+}
+
+// Returns the otpauth:// URI authenticator apps enrol with, usually shown as a QR code
+func URI(issuer, account string, secret []byte) string {
+	query := url.Values{}
+	query.Set("secret", EncodeSecret(secret))
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(DIGITS))
+	query.Set("period", fmt.Sprint(int(PERIOD.Seconds())))
+
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+	return uri.String()
+}
+
+// Returns the number of the period t falls in
+func Counter(t time.Time) int64 {
+	return t.Unix() / int64(PERIOD.Seconds())
+}
+
+// Returns the code of the period t falls in
+func Code(secret []byte, t time.Time) string {
+	return codeAt(secret, Counter(t))
+}
+
+// HOTP (RFC 4226) of the counter
+func codeAt(secret []byte, counter int64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < DIGITS; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", DIGITS, value%modulo)
+}
+
+// Checks a code against the periods around t.
+// Returns the counter of the matching period, which callers should record
+// and refuse to accept again so that a code cannot be replayed.
+func Validate(secret []byte, code string, t time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != DIGITS {
+		return 0, false
+	}
+
+	current := Counter(t)
+	for counter := current - SKEW; counter <= current+SKEW; counter++ {
+		if subtle.ConstantTimeCompare([]byte(codeAt(secret, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
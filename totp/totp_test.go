@@ -0,0 +1,172 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// shared secret of the SHA1 test vectors of RFC 4226 and RFC 6238
+var rfcSecret = []byte("12345678901234567890")
+
+// fakeClock hands out the time it is set to
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// RFC 4226, appendix D
+func TestHOTP(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	for counter, code := range want {
+		if got := codeAt(rfcSecret, int64(counter)); got != code {
+			t.Errorf("codeAt(%d) = %v, want %v", counter, got, code)
+		}
+	}
+}
+
+// RFC 6238, appendix B; the SHA1 codes there have 8 digits, of which
+// 6 digit codes are the last 6
+func TestCode(t *testing.T) {
+	tests := []struct {
+		unix    int64
+		counter int64
+		code    string
+	}{
+		{59, 0x1, "287082"},
+		{1111111109, 0x23523EC, "081804"},
+		{1111111111, 0x23523ED, "050471"},
+		{1234567890, 0x273EF07, "005924"},
+		{2000000000, 0x3F940AA, "279037"},
+		{20000000000, 0x27BC86AA, "353130"},
+	}
+
+	for _, test := range tests {
+		clock := fakeClock{now: time.Unix(test.unix, 0).UTC()}
+
+		if got := Counter(clock.Now()); got != test.counter {
+			t.Errorf("Counter(%v) = %X, want %X", test.unix, got, test.counter)
+		}
+		if got := Code(rfcSecret, clock.Now()); got != test.code {
+			t.Errorf("Code(%v) = %v, want %v", test.unix, got, test.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	clock := fakeClock{now: time.Unix(1111111111, 0)}
+	code := Code(rfcSecret, clock.Now())
+	counter := Counter(clock.Now())
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		code    string
+		valid   bool
+		counter int64
+	}{
+		{"current period", 0, code, true, counter},
+		{"spaces", 0, "050 471 ", true, counter},
+		{"one period later", PERIOD, code, true, counter},
+		{"one period earlier", -PERIOD, code, true, counter},
+		{"two periods later", 2 * PERIOD, code, false, 0},
+		{"two periods earlier", -2 * PERIOD, code, false, 0},
+		{"wrong code", 0, "123456", false, 0},
+		{"too short", 0, code[:5], false, 0},
+		{"8 digits", 0, "14050471", false, 0},
+		{"empty", 0, "", false, 0},
+	}
+
+	for _, test := range tests {
+		clock := fakeClock{now: clock.Now()}
+		clock.Advance(test.advance)
+
+		matched, valid := Validate(rfcSecret, test.code, clock.Now())
+		if valid != test.valid || matched != test.counter {
+			t.Errorf("%v: Validate() = %v, %v; want %v, %v", test.name, matched, valid, test.counter, test.valid)
+		}
+	}
+}
+
+// A code stays valid for SKEW periods after its own, and no longer
+func TestValidateOverTime(t *testing.T) {
+	clock := fakeClock{now: time.Unix(1234567890, 0)}
+	code, counter := Code(rfcSecret, clock.Now()), Counter(clock.Now())
+
+	for period := int64(0); period < 5; period++ {
+		matched, valid := Validate(rfcSecret, code, clock.Now())
+
+		if want := period <= SKEW; valid != want || (valid && matched != counter) {
+			t.Errorf("%d periods later: Validate() = %v, %v; want %v", period, matched, valid, want)
+		}
+		clock.Advance(PERIOD)
+	}
+}
+
+func TestSecretEncoding(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != SECRET_LEN {
+		t.Errorf("GenerateSecret() has %d bytes, want %d", len(secret), SECRET_LEN)
+	}
+
+	if got := EncodeSecret(rfcSecret); got != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" {
+		t.Errorf("EncodeSecret() = %v", got)
+	}
+
+	tests := []struct {
+		encoded string
+		valid   bool
+	}{
+		{"GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", true},
+		{"gezdgnbvgy3tqojqgezdgnbvgy3tqojq", true},
+		{" GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ\n", true},
+		{"GEZDGNBVGY3TQOJ1", false},
+		{"not a secret", false},
+	}
+
+	for _, test := range tests {
+		decoded, err := DecodeSecret(test.encoded)
+		if (err == nil) != test.valid {
+			t.Errorf("DecodeSecret(%q) error = %v", test.encoded, err)
+		}
+		if err == nil && string(decoded) != string(rfcSecret[:len(decoded)]) {
+			t.Errorf("DecodeSecret(%q) = %q", test.encoded, decoded)
+		}
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("TapGoPay", "alice@example.com", rfcSecret))
+	if err != nil {
+		t.Fatalf("URI() is not a URL; %v", err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/TapGoPay:alice@example.com" {
+		t.Errorf("URI() = %v", uri)
+	}
+
+	query := uri.Query()
+	want := map[string]string{
+		"secret":    "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+		"issuer":    "TapGoPay",
+		"algorithm": "SHA1",
+		"digits":    "6",
+		"period":    "30",
+	}
+	for name, value := range want {
+		if got := query.Get(name); got != value {
+			t.Errorf("URI() %v = %q, want %q", name, got, value)
+		}
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"reflect"
 	"time"
 
+	"github.com/caleb-mwasikira/tap_gopay/domain"
 	"github.com/caleb-mwasikira/tap_gopay/handlers/api"
+	"github.com/caleb-mwasikira/tap_gopay/money"
 )
 
 type LoginDto struct {
@@ -31,6 +33,28 @@ type VerifyEmailDto struct {
 	Otp   string `json:"otp" validate:"required,min=4"`
 }
 
+// RefreshToken may be left empty by browsers, which send it as a cookie
+type RefreshTokenDto struct {
+	RefreshToken string `json:"refresh_token" validate:"max=255"`
+}
+
+type SessionIdDto struct {
+	SessionId string `json:"session_id" validate:"required,max=64"`
+}
+
+// Code is the current code of the user's authenticator app
+type TotpCodeDto struct {
+	Code string `json:"code" validate:"required,max=10"`
+}
+
+// Second step of a login with two-factor authentication on.
+// Takes either a code from the authenticator app or a recovery code.
+type LoginTotpDto struct {
+	ChallengeToken string `json:"challenge_token" validate:"required,max=1024"`
+	Code           string `json:"code" validate:"max=10"`
+	RecoveryCode   string `json:"recovery_code" validate:"max=32"`
+}
+
 type ResetPasswordDto struct {
 	PasswordResetToken string `json:"password_reset_token" validate:"min=6"`
 	Email              string `json:"email" validate:"email"`
@@ -38,13 +62,27 @@ type ResetPasswordDto struct {
 }
 
 type CreditCardDto struct {
-	Id             int       `json:"id"`
-	UserId         int       `json:"user_id"`
-	CardNo         string    `json:"card_no"`
-	Cvv            string    `json:"-"`
-	InitialDeposit float64   `json:"initial_deposit,omitempty" validate:"min=100"`
-	IsActive       bool      `json:"is_active,omitempty"`
-	CreatedAt      time.Time `json:"created_at"`
+	Id             int               `json:"id"`
+	UserId         int               `json:"user_id"`
+	CardNo         string            `json:"card_no"`
+	Cvv            string            `json:"-"` // encrypted; see the encryption package
+	ExpiryMonth    int               `json:"expiry_month,omitempty"`
+	ExpiryYear     int               `json:"expiry_year,omitempty"`
+	PinHash        string            `json:"-"` // empty until the owner sets a PIN
+	PinAttempts    int               `json:"-"` // wrong PIN attempts since the last correct one
+	InitialDeposit money.Money       `json:"initial_deposit,omitempty" validate:"min=100"`
+	Status         domain.CardStatus `json:"status,omitempty"`
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+// OwnerEmail is the user the card is issued to; the logged in user when empty
+type NewCreditCardDto struct {
+	OwnerEmail     string      `json:"owner_email" validate:"max=255"`
+	InitialDeposit money.Money `json:"initial_deposit" validate:"min=100,currency"`
+}
+
+func (c CreditCardDto) IsExpired(now time.Time) bool {
+	return domain.CardExpired(c.ExpiryMonth, c.ExpiryYear, now)
 }
 
 type ContactDto struct {
@@ -53,13 +91,45 @@ type ContactDto struct {
 }
 
 type CardNoDto struct {
-	CardNo string `json:"card_no" validate:"min=10"`
+	CardNo string `json:"card_no" validate:"min=10,luhn"`
+}
+
+type CardStatusDto struct {
+	CardNo string `json:"card_no" validate:"min=10,luhn"`
+	Reason string `json:"reason" validate:"max=255"`
+}
+
+// SweepTo is required when the card being closed still holds a balance
+type CloseCardDto struct {
+	CardNo  string `json:"card_no" validate:"min=10,luhn"`
+	SweepTo string `json:"sweep_to" validate:"luhn"`
+	Reason  string `json:"reason" validate:"max=255"`
 }
 
 type SendMoneyDto struct {
-	SendersCard   string  `json:"senders_card" validate:"min=10"`
-	ReceiversCard string  `json:"receivers_card" validate:"min=10"`
-	Amount        float64 `json:"amount" validate:"min=1"`
+	SendersCard   string      `json:"senders_card" validate:"min=10,luhn"`
+	ReceiversCard string      `json:"receivers_card" validate:"min=10,luhn"`
+	Amount        money.Money `json:"amount" validate:"min=1,currency"`
+	Pin           string      `json:"pin" validate:"required,pin"`
+
+	// senders card security code for card-not-present payments
+	Cvv string `json:"cvv,omitempty" validate:"max=4"`
+
+	// authenticator app code, for transfers the step-up policy applies to
+	Totp string `json:"totp,omitempty" validate:"max=10"`
+}
+
+// Otp is the code emailed by POST /send-card-pin-otp
+type SetCardPinDto struct {
+	CardNo string `json:"card_no" validate:"min=10,luhn"`
+	Otp    string `json:"otp" validate:"required,min=4"`
+	Pin    string `json:"pin" validate:"required,pin"`
+}
+
+type ChangeCardPinDto struct {
+	CardNo string `json:"card_no" validate:"min=10,luhn"`
+	OldPin string `json:"old_pin" validate:"required,pin"`
+	NewPin string `json:"new_pin" validate:"required,pin"`
 }
 
 // Gets valid JSON input from request body.
@@ -76,7 +146,7 @@ func GetValidJsonInput[T any](w http.ResponseWriter, body io.ReadCloser) (T, boo
 		api.Error(
 			w,
 			"Invalid JSON data provided as input",
-			err,
+			domain.ErrInvalidJson.Wrap(err),
 			http.StatusBadRequest,
 		)
 		return errValue, false
@@ -89,7 +159,7 @@ func GetValidJsonInput[T any](w http.ResponseWriter, body io.ReadCloser) (T, boo
 		api.Error(
 			w,
 			"Invalid JSON structure",
-			err,
+			domain.ErrInvalidJson.WithMessage("Invalid JSON structure").Wrap(err),
 			http.StatusBadRequest,
 		)
 		return errValue, false
@@ -118,15 +188,37 @@ func GetValidJsonInput[T any](w http.ResponseWriter, body io.ReadCloser) (T, boo
 	}
 
 	if len(validationErrors) > 0 {
-		api.SendResponse(
-			w,
-			"Validation errors",
-			nil,
-			validationErrors,
-			http.StatusBadRequest,
-		)
+		api.ValidationErrors(w, validationErrors)
 		return errValue, false
 	}
 
 	return obj, true
 }
+
+type SetRoleDto struct {
+	Email  string `json:"email" validate:"email"`
+	Role   string `json:"role" validate:"account_type"`
+	Reason string `json:"reason" validate:"required,max=255"`
+}
+
+// AdminUserDto names the user an admin action is taken on, and why
+type AdminUserDto struct {
+	Email  string `json:"email" validate:"email"`
+	Reason string `json:"reason" validate:"required,max=255"`
+}
+
+type AdminCardDto struct {
+	Email  string `json:"email" validate:"email"`
+	CardNo string `json:"card_no" validate:"min=10,luhn"`
+	Reason string `json:"reason" validate:"required,max=255"`
+}
+
+// Fields left out are not changed
+type UpdateProfileDto struct {
+	Email       string `json:"email" validate:"omitempty,email,max=255"`
+	PhoneNumber string `json:"phone_number" validate:"omitempty,min=9,max=20"`
+}
+
+type OtpDto struct {
+	Otp string `json:"otp" validate:"required,min=4"`
+}
@@ -7,8 +7,34 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+
+	"github.com/caleb-mwasikira/tap_gopay/domain"
+	"github.com/caleb-mwasikira/tap_gopay/luhn"
+	"github.com/caleb-mwasikira/tap_gopay/money"
+)
+
+const (
+	PIN_LEN     int = 4
+	CARD_NO_LEN int = 16
 )
 
+var moneyType = reflect.TypeOf(money.Money{})
+
+// Issuer prefix of the cards this server issues; set by SetCardBinPrefix
+var cardBinPrefix string
+
+// Sets the issuer prefix that card numbers with a Luhn check digit start
+// with. Called once at startup, before any request is validated.
+func SetCardBinPrefix(prefix string) {
+	cardBinPrefix = prefix
+}
+
+// Older cards have shorter numbers without a check digit, so only numbers
+// of the current length or with our issuer prefix are checked against it
+func hasCheckDigit(cardNo string) bool {
+	return len(cardNo) == CARD_NO_LEN || (cardBinPrefix != "" && strings.HasPrefix(cardNo, cardBinPrefix))
+}
+
 func validateStruct(obj interface{}) map[string]string {
 	obj_value := reflect.ValueOf(obj)
 	obj_type := reflect.TypeOf(obj)
@@ -34,6 +60,12 @@ func validateStruct(obj interface{}) map[string]string {
 
 		// split validateTag values
 		rules := strings.Split(validateTag, ",")
+
+		// optional fields are only checked when given
+		if slices.Contains(rules, "omitempty") && value.IsZero() {
+			continue
+		}
+
 		for _, rule := range rules {
 			switch {
 			case rule == "required":
@@ -41,6 +73,10 @@ func validateStruct(obj interface{}) map[string]string {
 					errs[fieldName] = fmt.Sprintf("%v is required", fieldName)
 				}
 
+				if value.Type() == moneyType && value.Interface().(money.Money).IsZero() {
+					errs[fieldName] = fmt.Sprintf("%v is required", fieldName)
+				}
+
 			case strings.HasPrefix(rule, "min="):
 				min_value, err := strconv.Atoi(strings.TrimPrefix(rule, "min="))
 				if err != nil {
@@ -55,6 +91,13 @@ func validateStruct(obj interface{}) map[string]string {
 					errs[fieldName] = fmt.Sprintf("%v must be at least %v", fieldName, min_value)
 				}
 
+				if value.Type() == moneyType {
+					err := compareMoney(value.Interface().(money.Money), int64(min_value), -1)
+					if err != nil {
+						errs[fieldName] = fmt.Sprintf("%v must be at least %v", fieldName, min_value)
+					}
+				}
+
 				if value.Kind() == reflect.String && len(strings.Trim(value.String(), " ")) < min_value {
 					errs[fieldName] = fmt.Sprintf("%v must be at least %v characters long", fieldName, min_value)
 				}
@@ -73,6 +116,13 @@ func validateStruct(obj interface{}) map[string]string {
 					errs[fieldName] = fmt.Sprintf("%v must be at most %v", fieldName, max_value)
 				}
 
+				if value.Type() == moneyType {
+					err := compareMoney(value.Interface().(money.Money), int64(max_value), 1)
+					if err != nil {
+						errs[fieldName] = fmt.Sprintf("%v must be at most %v", fieldName, max_value)
+					}
+				}
+
 				if value.Kind() == reflect.String && len(strings.Trim(value.String(), " ")) > max_value {
 					errs[fieldName] = fmt.Sprintf("%v must be at most %v characters long", fieldName, max_value)
 				}
@@ -99,15 +149,50 @@ func validateStruct(obj interface{}) map[string]string {
 					errs[fieldName] = err.Error()
 				}
 
+			case rule == "luhn":
+				if value.Kind() != reflect.String {
+					errs[fieldName] = fmt.Sprintf("%v must be a string", fieldName)
+					continue
+				}
+
+				// empty values are left to the required and min= rules
+				cardNo := value.String()
+				if cardNo != "" && hasCheckDigit(cardNo) && !luhn.Valid(cardNo) {
+					errs[fieldName] = fmt.Sprintf("%v is not a valid card number", fieldName)
+				}
+
+			case rule == "currency":
+				if value.Type() != moneyType {
+					errs[fieldName] = fmt.Sprintf("%v must be an amount", fieldName)
+					continue
+				}
+
+				// cards hold money in the system currency only
+				currency := value.Interface().(money.Money).Currency
+				if currency != "" && currency != money.DefaultCurrency {
+					errs[fieldName] = fmt.Sprintf("%v must be in %v", fieldName, money.DefaultCurrency)
+				}
+
+			case rule == "pin":
+				if value.Kind() != reflect.String {
+					errs[fieldName] = fmt.Sprintf("%v must be a string", fieldName)
+					continue
+				}
+
+				// empty values are left to the required rule
+				if value.String() != "" && (len(value.String()) != PIN_LEN || !luhn.IsNumeric(value.String())) {
+					errs[fieldName] = fmt.Sprintf("%v must be a %d digit PIN", fieldName, PIN_LEN)
+				}
+
 			case rule == "account_type":
 				if value.Kind() != reflect.String {
 					errs[fieldName] = fmt.Sprintf("%v must be a string", fieldName)
 					continue
 				}
 
-				validAccountTypes := []string{"user", "agent", "admin"}
-				if !slices.Contains(validAccountTypes, value.String()) {
-					errs[fieldName] = "Invalid account type. Valid account types include: ['user','agent','admin']"
+				// account types are the roles of users
+				if !domain.Role(value.String()).IsValid() {
+					errs[fieldName] = fmt.Sprintf("Invalid account type. Valid account types include: %v", domain.Roles)
 				}
 			}
 		}
@@ -115,3 +200,23 @@ func validateStruct(obj interface{}) map[string]string {
 
 	return errs
 }
+
+// Returns an error if amount compares to limit (given in major units
+// of the amount's currency) as the disallowed ordering.
+// disallowed is -1 for a min= rule and +1 for a max= rule.
+func compareMoney(amount money.Money, limit int64, disallowed int) error {
+	limitAmount, err := money.FromMajor(limit, amount.Currency)
+	if err != nil {
+		return err
+	}
+
+	cmp, err := amount.Cmp(limitAmount)
+	if err != nil {
+		return err
+	}
+
+	if cmp == disallowed {
+		return fmt.Errorf("amount out of range")
+	}
+	return nil
+}
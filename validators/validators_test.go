@@ -0,0 +1,77 @@
+package validators
+
+import (
+	"testing"
+
+	"github.com/caleb-mwasikira/tap_gopay/money"
+)
+
+func TestLuhnRule(t *testing.T) {
+	SetCardBinPrefix("539983")
+	defer SetCardBinPrefix("")
+
+	tests := []struct {
+		name   string
+		cardNo string
+		valid  bool
+	}{
+		{"valid 16 digits", "4000000000000002", true},
+		{"bad check digit", "4000000000000003", false},
+		{"bad check digit with our prefix", "5399830000000000", false},
+		{"valid with our prefix", "5399830000000008", true},
+		{"legacy 14 digits", "12345678901234", true},
+		{"legacy with our prefix", "53998300000001", false},
+		{"not digits", "400000000000000x", false},
+	}
+
+	for _, test := range tests {
+		errs := validateStruct(CardNoDto{CardNo: test.cardNo})
+		if _, invalid := errs["card_no"]; invalid == test.valid {
+			t.Errorf("%v: validateStruct(%q) = %v, want valid %v", test.name, test.cardNo, errs, test.valid)
+		}
+	}
+
+	// both cards of a transfer are checked
+	errs := validateStruct(SendMoneyDto{
+		SendersCard:   "4000000000000002",
+		ReceiversCard: "4000000000000010",
+		Amount:        money.KES(1_00),
+		Pin:           "1234",
+	})
+	if len(errs) != 0 {
+		t.Errorf("validateStruct() of a valid transfer = %v", errs)
+	}
+
+	errs = validateStruct(SendMoneyDto{
+		SendersCard:   "4000000000000002",
+		ReceiversCard: "4000000000000011",
+		Amount:        money.KES(1_00),
+		Pin:           "1234",
+	})
+	if _, invalid := errs["receivers_card"]; !invalid || len(errs) != 1 {
+		t.Errorf("validateStruct() of a transfer to a mistyped card = %v", errs)
+	}
+}
+
+func TestCurrencyRule(t *testing.T) {
+	tests := []struct {
+		amount money.Money
+		valid  bool
+	}{
+		{money.KES(1_00), true},
+		{money.Money{Amount: 1_00}, true},
+		{money.Money{Amount: 1_00, Currency: "USD"}, false},
+	}
+
+	for _, test := range tests {
+		errs := validateStruct(SendMoneyDto{
+			SendersCard:   "4000000000000002",
+			ReceiversCard: "4000000000000010",
+			Amount:        test.amount,
+			Pin:           "1234",
+		})
+		if _, invalid := errs["amount"]; invalid == test.valid {
+			t.Errorf("validateStruct() of %v = %v, want valid %v", test.amount, errs, test.valid)
+		}
+	}
+}